@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AudioAlert plays a sound when motion starts, either by running a command
+// (e.g. "paplay alert.wav") or, if Command is empty, by ringing the terminal
+// bell. Sounds are played in the background, and at most once per Cooldown.
+type AudioAlert struct {
+	Command  string
+	Cooldown time.Duration
+
+	mu      sync.Mutex
+	last    time.Time
+	playing bool
+}
+
+// Play plays the alert, unless it was played within the cooldown or is still
+// playing. It never blocks.
+func (a *AudioAlert) Play() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.playing || (!a.last.IsZero() && now.Sub(a.last) < a.Cooldown) {
+		return
+	}
+	a.last = now
+
+	if a.Command == "" {
+		fmt.Fprint(os.Stderr, "\a")
+		return
+	}
+	args := strings.Fields(a.Command)
+	a.playing = true
+	go func() {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			logError("Playing audio alert failed", "error", err, "output", strings.TrimSpace(string(out)))
+		}
+		a.mu.Lock()
+		a.playing = false
+		a.mu.Unlock()
+	}()
+}
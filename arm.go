@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sources of changes to an ArmState, as recorded in its state file and logs.
+const (
+	ArmSourceAPI  = "api"
+	ArmSourceMQTT = "mqtt"
+)
+
+// ArmStatus is whether detection is armed, and when and how that last
+// changed, as persisted in the state file and served by /api/arm.
+type ArmStatus struct {
+	Armed   bool      `json:"armed"`
+	Changed time.Time `json:"changed,omitempty"`
+	Source  string    `json:"source,omitempty"`
+}
+
+// ArmState is whether motion detection is armed, for alarm systems and home
+// automation to disarm it while someone's home. Unlike a camera's
+// DetectionEnabled, toggled from the keyboard, it applies to all cameras, and
+// detection keeps running while disarmed, so that the background model stays
+// up to date, but motion doesn't produce events, so nothing is recorded or
+// notified. It's saved to a small state file on each change, so that it
+// survives restarts. It is safe for concurrent use, and a nil *ArmState is
+// always armed.
+type ArmState struct {
+	// Path is the state file.
+	Path string
+
+	mu       sync.Mutex
+	status   ArmStatus
+	watchers []func(status ArmStatus)
+}
+
+// OpenArmState loads the state saved at path, or, if there's none, starts
+// out armed.
+func OpenArmState(path string) (*ArmState, error) {
+	a := &ArmState{Path: path, status: ArmStatus{Armed: true}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &a.status); err != nil {
+		return nil, err
+	}
+	if !a.status.Armed {
+		logInfo("Detection is disarmed", "since", a.status.Changed.Local().Format(time.RFC1123), "by", a.status.Source)
+	}
+	return a, nil
+}
+
+// Armed returns whether detection is armed.
+func (a *ArmState) Armed() bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status.Armed
+}
+
+// Status returns the current state.
+func (a *ArmState) Status() ArmStatus {
+	if a == nil {
+		return ArmStatus{Armed: true}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+// Watch registers fn to be called, with the new state, after each change.
+func (a *ArmState) Watch(fn func(status ArmStatus)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.watchers = append(a.watchers, fn)
+}
+
+// Set arms or disarms detection, recording the source of the change, and
+// saves the new state. Setting the current state does nothing. If saving
+// fails, the state is changed anyway, and the error returned.
+func (a *ArmState) Set(armed bool, source string) (ArmStatus, error) {
+	a.mu.Lock()
+	if a.status.Armed == armed {
+		defer a.mu.Unlock()
+		return a.status, nil
+	}
+	a.status = ArmStatus{Armed: armed, Changed: time.Now(), Source: source}
+	status, watchers := a.status, a.watchers
+	err := a.save()
+	a.mu.Unlock()
+
+	if armed {
+		logInfo("Detection armed", "by", source)
+	} else {
+		logInfo("Detection disarmed", "by", source)
+	}
+	for _, fn := range watchers {
+		fn(status)
+	}
+	return status, err
+}
+
+// save writes the state file, replacing it only once it's been written in
+// full. a.mu must be held.
+func (a *ArmState) save() error {
+	b, err := json.Marshal(&a.status)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(a.Path), "."+filepath.Base(a.Path))
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.Path)
+}
+
+// HandleArm registers /api/arm with http.DefaultServeMux. GET returns the
+// ArmStatus, and POST, with a body such as {"armed": false}, changes it,
+// returning the new status.
+func HandleArm(a *ArmState) {
+	http.HandleFunc("/api/arm", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			writeJSON(w, http.StatusOK, a.Status())
+		case http.MethodPost:
+			var change struct {
+				Armed *bool `json:"armed"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&change); err != nil || change.Armed == nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"armed": true} or {"armed": false}`})
+				return
+			}
+			status, err := a.Set(*change.Armed, ArmSourceAPI)
+			if err != nil {
+				logError("Saving arm state failed", "path", a.Path, "error", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// captureBackends are the capture backends accepted by -backend, and the
+// platforms on which OpenCV usually has them. Which are actually available
+// depends on how OpenCV was built.
+var captureBackends = map[string]struct {
+	api       gocv.VideoCaptureAPI
+	platforms string
+}{
+	"any":          {gocv.VideoCaptureAny, "all (OpenCV picks)"},
+	"v4l2":         {gocv.VideoCaptureV4L2, "Linux"},
+	"gstreamer":    {gocv.VideoCaptureGstreamer, "Linux, macOS, Windows (if built with GStreamer)"},
+	"ffmpeg":       {gocv.VideoCaptureFFmpeg, "all (files and streams)"},
+	"dshow":        {gocv.VideoCaptureDshow, "Windows"},
+	"msmf":         {gocv.VideoCaptureMSMF, "Windows"},
+	"avfoundation": {gocv.VideoCaptureAVFoundation, "macOS, iOS"},
+	"images":       {gocv.VideoCaptureImages, "all (image sequences, e.g. img_%02d.jpg)"},
+}
+
+// backendNames returns the names accepted by -backend, sorted.
+func backendNames() []string {
+	names := make([]string, 0, len(captureBackends))
+	for name := range captureBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseBackend returns the capture API with the given name.
+func parseBackend(name string) (gocv.VideoCaptureAPI, error) {
+	b, ok := captureBackends[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown backend %q, expected one of %s", name, strings.Join(backendNames(), ", "))
+	}
+	return b.api, nil
+}
@@ -2,25 +2,42 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"gocv.io/x/gocv"
 )
 
 // MatBuffer is a matrix ring buffer, which stores the last frames added to it.
+// Its duration is measured with the monotonic clock, so that it's unaffected
+// by the wall clock stepping.
 type MatBuffer struct {
 	imgs   []*gocv.Mat
 	times  []time.Time
+	monos  []time.Duration
 	writes int
+	clock  Clock
 }
 
+// minBufferFrames is the fewest frames a MatBuffer holds, however short its
+// duration, so that it always has room for a frame and the one before it.
+const minBufferFrames = 2
+
 // NewMatBuffer creates a new MatBuffer with enough frames to store the given
-// duration at the given FPS.
+// duration at the given FPS, and at least minBufferFrames.
 func NewMatBuffer(duration time.Duration, fps float64) *MatBuffer {
 	frames := int(fps * duration.Seconds())
+	if frames < minBufferFrames {
+		frames = minBufferFrames
+	}
 	b := MatBuffer{
 		imgs:  make([]*gocv.Mat, frames),
 		times: make([]time.Time, frames),
+		monos: make([]time.Duration, frames),
+		clock: hostSystemClock,
 	}
 	for i := range b.imgs {
 		m := gocv.NewMat()
@@ -47,13 +64,49 @@ func (b *MatBuffer) Add(img *gocv.Mat, t time.Time) {
 	i := b.writes % len(b.imgs)
 	img.CopyTo(b.imgs[i])
 	b.times[i] = t
+	b.monos[i] = b.clock.Mono()
 	b.writes++
 }
 
-// Duration returns the duration between the first and last frame added.
+// Take removes the frames added after since from the buffer, and returns them
+// oldest first, along with their timestamps. Ownership of the returned matrices
+// passes to the caller, who must close them. The buffer is left empty.
+func (b *MatBuffer) Take(since time.Time) ([]*gocv.Mat, []time.Time) {
+	var (
+		n     = b.Len()
+		first = 0
+		imgs  = make([]*gocv.Mat, 0, n)
+		times = make([]time.Time, 0, n)
+	)
+	if b.writes > len(b.imgs) {
+		first = b.writes % len(b.imgs)
+	}
+	for k := 0; k < n; k++ {
+		i := (first + k) % len(b.imgs)
+		if b.times[i].After(since) {
+			imgs = append(imgs, b.imgs[i])
+			times = append(times, b.times[i])
+			m := gocv.NewMat()
+			b.imgs[i] = &m
+		}
+		b.times[i] = time.Time{}
+	}
+	b.writes = 0
+	return imgs, times
+}
+
+// Duration returns the duration between the first and last frame added, by
+// the monotonic clock.
 func (b *MatBuffer) Duration() time.Duration {
-	oldest, newest := b.TimeWindow()
-	return newest.Sub(oldest)
+	if b.writes < 2 {
+		return 0
+	}
+	first, last := 0, b.writes-1
+	if b.writes > len(b.imgs) {
+		first = b.writes % len(b.imgs)
+		last = (b.writes - 1) % len(b.imgs)
+	}
+	return b.monos[last] - b.monos[first]
 }
 
 // Count returns the number of frames in the buffer.
@@ -61,6 +114,15 @@ func (b *MatBuffer) Count() int {
 	return len(b.imgs)
 }
 
+// Len returns the number of frames currently stored in the buffer, which is at
+// most Count.
+func (b *MatBuffer) Len() int {
+	if b.writes < len(b.imgs) {
+		return b.writes
+	}
+	return len(b.imgs)
+}
+
 // TimeWindow returns the timestamps of the first and last frames added.
 // If no frames were added, the zero-value times are returned for both.
 func (b *MatBuffer) TimeWindow() (time.Time, time.Time) {
@@ -83,6 +145,10 @@ func (b *MatBuffer) FPS() float64 {
 		return 0
 	}
 	seconds := b.Duration().Seconds()
+	if seconds <= 0 {
+		// the frames were all added at once
+		return 0
+	}
 	if b.writes < len(b.imgs) {
 		return float64(b.writes) / seconds
 	}
@@ -98,9 +164,40 @@ func (b *MatBuffer) Slice() []*gocv.Mat {
 	return append(b.imgs[i:], b.imgs[:i]...)
 }
 
+// sliceTimes returns the timestamps of the frames returned by Slice.
+func (b *MatBuffer) sliceTimes() []time.Time {
+	if b.writes <= len(b.times) {
+		return b.times[0:b.writes]
+	}
+	i := b.writes % len(b.times)
+	return append(b.times[i:len(b.times):len(b.times)], b.times[:i]...)
+}
+
 // WriteFile writes the buffer as a video to the specified filename, using the
-// specified "FourCC" codec (e.g. "mp4v"), with the given video dimensions.
-func (b *MatBuffer) WriteFile(filename, codec string) error {
+// specified "FourCC" codec (e.g. "mp4v"), with the given video dimensions. If
+// annotate is set, it is applied to a copy of each frame before it's written.
+// If progress is set, it is called every second while writing. If the write
+// fails, the partially written file is removed.
+func (b *MatBuffer) WriteFile(filename, codec string, annotate FrameAnnotator, progress func(ExportProgress)) error {
+	var written int64
+	stop := watchProgress(filename, b.Len(), func() int {
+		return int(atomic.LoadInt64(&written))
+	}, progress)
+	err := b.writeFile(filename, codec, annotate, &written)
+	final := stop()
+	if err == nil && diskFull(filepath.Dir(filename)) {
+		err = fmt.Errorf("%w: disk full while writing", errNoSpace)
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	logInfo("Saved buffer", "path", filename, "frames", b.Len(), "mb", math.Round(float64(final.Bytes)/(1<<20)*10)/10,
+		"duration", b.Duration().Round(time.Millisecond), "elapsed", final.Elapsed.Round(time.Millisecond))
+	return nil
+}
+
+func (b *MatBuffer) writeFile(filename, codec string, annotate FrameAnnotator, written *int64) error {
 	imgs := b.Slice()
 	if len(imgs) < 2 {
 		return fmt.Errorf("need at least 2 frames")
@@ -117,13 +214,24 @@ func (b *MatBuffer) WriteFile(filename, codec string) error {
 	}
 	defer vw.Close()
 
-	for _, img := range imgs {
+	times := b.sliceTimes()
+	for i, img := range imgs {
 		if img.Cols() != width || img.Rows() != height {
 			return fmt.Errorf("not all frames have the same dimensions")
 		}
-		if err := vw.Write(*img); err != nil {
+		if annotate != nil {
+			// annotate a copy, since the buffer may keep being used
+			m := img.Clone()
+			annotate(&m, times[i])
+			err = vw.Write(m)
+			m.Close()
+		} else {
+			err = vw.Write(*img)
+		}
+		if err != nil {
 			return fmt.Errorf("writing image failed: %w", err)
 		}
+		atomic.AddInt64(written, 1)
 	}
 	return nil
 }
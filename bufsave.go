@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// bufferSaveMessageFor is how long the result of a save stays in the status.
+const bufferSaveMessageFor = 5 * time.Second
+
+// BufferSaver saves copies of the pre-roll buffer on demand, in the background.
+// Only one save may be in progress at a time.
+type BufferSaver struct {
+	// Codec is the "FourCC" codec to record with (e.g. "mp4v").
+	Codec string
+	// Annotate, if set, is applied to each frame as it is written.
+	Annotate FrameAnnotator
+
+	mu        sync.Mutex
+	saving    bool
+	message   string
+	messageAt time.Time
+	wg        sync.WaitGroup
+}
+
+// Save starts saving a copy of the frames currently in the buffer to filename,
+// returning false without doing anything if a save is already in progress.
+// Only copying the frames happens before Save returns.
+func (s *BufferSaver) Save(b *MatBuffer, filename string) bool {
+	s.mu.Lock()
+	if s.saving {
+		s.mu.Unlock()
+		return false
+	}
+	s.saving = true
+	s.setMessage("saving...")
+	s.mu.Unlock()
+
+	imgs, times := b.Slice(), b.sliceTimes()
+	tmp := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename))
+	w := NewSegmentWriter(tmp, s.Codec, b.FPS(), len(imgs))
+	w.Annotate = s.Annotate
+	for i, img := range imgs {
+		m := img.Clone()
+		w.Add(&m, times[i], 0)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		stop := watchProgress(tmp, len(imgs), w.Written, func(p ExportProgress) {
+			LogProgress(p)
+			s.mu.Lock()
+			s.setMessage(fmt.Sprintf("saving %0.0f%%", p.Percent()))
+			s.mu.Unlock()
+		})
+		err := finalizeSegment(w, filename)
+		final := stop()
+		if err == nil && w.Count() < 2 {
+			err = fmt.Errorf("need at least 2 frames")
+			os.Remove(filename)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.saving = false
+		if err != nil {
+			logError("Saving buffer failed", "path", filename, "error", err)
+			os.Remove(tmp)
+			s.setMessage("save failed: " + err.Error())
+			return
+		}
+		// the progress was tracked under the temporary name
+		if info, err := os.Stat(filename); err == nil {
+			final.Bytes = info.Size()
+		}
+		first, last := w.TimeWindow()
+		logInfo("Saved buffer", "path", filename, "frames", w.Count(), "mb", math.Round(float64(final.Bytes)/(1<<20)*10)/10,
+			"duration", last.Sub(first).Round(time.Millisecond), "elapsed", final.Elapsed.Round(time.Millisecond))
+		s.setMessage("saved " + filepath.Base(filename))
+	}()
+	return true
+}
+
+// setMessage sets the status message. s.mu must be held.
+func (s *BufferSaver) setMessage(msg string) {
+	s.message = msg
+	s.messageAt = time.Now()
+}
+
+// Status returns a message describing the save in progress or the result of
+// the last one, or "" if there's nothing recent to report.
+func (s *BufferSaver) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.saving && time.Since(s.messageAt) > bufferSaveMessageFor {
+		return ""
+	}
+	return s.message
+}
+
+// Wait waits for a save in progress to finish.
+func (s *BufferSaver) Wait() {
+	s.wg.Wait()
+}
+
+// saveResult is the body of /api/save responses.
+type saveResult struct {
+	Camera string `json:"camera"`
+}
+
+// HandleSave registers /api/save with http.DefaultServeMux. POST saves a
+// camera's pre-roll buffer, like the s key, once its current frame has been
+// processed. The camera is chosen by name with the query parameter camera,
+// and defaults to the first.
+func HandleSave(cams []*Camera) {
+	http.HandleFunc("/api/save", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c := requestCamera(w, r, cams)
+		if c == nil {
+			return
+		}
+		if c.Stopped() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "camera has stopped"})
+			return
+		}
+		c.Key('s')
+		writeJSON(w, http.StatusAccepted, saveResult{Camera: c.Name})
+	})
+}
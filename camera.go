@@ -0,0 +1,1125 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// maxPlausibleFPS is the highest frame rate reported by a source that is
+	// believed.
+	maxPlausibleFPS = 240
+	// fallbackFPS is assumed when a source's frame rate can't be measured,
+	// and what it reports isn't plausible.
+	fallbackFPS = 30
+)
+
+// cameraKeys is the number of key presses that may be waiting to be handled
+// by a camera before further ones are ignored.
+const cameraKeys = 16
+
+// rotateFlags maps the rotations accepted by -rotate, in degrees clockwise, to
+// their gocv flags.
+var rotateFlags = map[int]gocv.RotateFlag{
+	90:  gocv.Rotate90Clockwise,
+	180: gocv.Rotate180Clockwise,
+	270: gocv.Rotate90CounterClockwise,
+}
+
+// Camera is a single video source, along with everything that processes it:
+// its own detector, FPS counter, pre-roll buffer, event tracker and recorders.
+// Each camera runs its capture loop in its own goroutine (see Run), and hands
+// its live view to the UI, which runs on the main goroutine, through View.
+type Camera struct {
+	// Name identifies the camera in filenames, events and the HUD.
+	Name string
+	// Source is the device ID, file or URL the camera is read from.
+	Source string
+	// Input is the type of Source (see DetectInputType).
+	Input string
+	// Tag distinguishes the camera's files from other cameras' when there
+	// are several, and is empty otherwise.
+	Tag string
+
+	Width  int
+	Height int
+	MaxFPS float64
+
+	Detector         *MotionDetector
+	DetectionEnabled bool
+	// Arm, if set, is whether detected motion produces events. Detection
+	// still runs while disarmed, but an event in progress ends as if motion
+	// had stopped.
+	Arm *ArmState
+
+	// DrawLive and DrawRecord control whether detections are marked up in
+	// the live view and in recordings, respectively, and HUDLive and
+	// HUDRecord do the same for the HUD text. HUD is how much of the HUD is
+	// drawn: HUDFull, HUDMinimal or HUDOff.
+	DrawLive   bool
+	DrawRecord bool
+	HUDLive    bool
+	HUDRecord  bool
+	HUD        string
+
+	FieldChanged rune
+
+	// Mirror flips frames horizontally, as in a selfie view.
+	Mirror bool
+	// Rotate rotates frames clockwise by 90, 180 or 270 degrees as soon as
+	// they're captured, for cameras mounted sideways or upside down.
+	// Everything downstream, including detection regions and Width and
+	// Height, is in terms of the scratch frame.
+	Rotate int
+	// Crop, if not empty, is the region of each (rotated) frame that is kept,
+	// with the rest discarded before anything else sees it.
+	Crop image.Rectangle
+
+	FPS    *FPSCounter
+	Stages *StageTimer
+	// Metrics are exported at /metrics.
+	Metrics *CameraMetrics
+
+	Buffer     *MatBuffer
+	Tracker    *EventTracker
+	Recorder   *Recorder
+	Continuous *ContinuousRecorder
+	Manual     *ManualRecorder
+	Saver      *BufferSaver
+	Snapshots  *Snapshotter
+	// SnapshotPeak also saves region crops whenever an event's peak area
+	// grows, at most once a second.
+	SnapshotPeak bool
+
+	Reconnector *Reconnector
+	// CalibrateFrames is the number of frames read to measure the frame rate
+	// when the camera is (re)connected (see Calibrate), or 0 to trust the
+	// rate it reports.
+	CalibrateFrames int
+	// ReopenAfter is the number of consecutive failed reads after which a
+	// device is reopened, rather than given up on.
+	ReopenAfter int
+	Limiter     *RateLimiter
+
+	// Annotate, if set, is applied to frames saved on demand or on exit.
+	Annotate FrameAnnotator
+	// SaveDir and SaveExt are the directory and extension of buffers saved
+	// on demand or on exit.
+	SaveDir string
+	SaveExt string
+	// Codec is the "FourCC" codec buffers are saved with.
+	Codec string
+	// SaveOnExit saves the buffer when the camera stops.
+	SaveOnExit bool
+
+	// Main, if set, is the camera's high resolution main stream, which is
+	// recorded while detection runs on the camera's own source.
+	Main *MainStream
+	// PiP, if set, insets another camera into this one's frames.
+	PiP *PictureInPicture
+	// Feed keeps each frame for other cameras' PiP to read with Frame.
+	Feed bool
+
+	// Alert, if set, is played when an event starts.
+	Alert *AudioAlert
+	// HLS, RTSP, WebRTC, Stream and Stdout, if set, are sent the live view.
+	HLS    *HLSStreamer
+	RTSP   *RTSPServer
+	WebRTC *H264Encoder
+	Stream *MJPEGStreamer
+	Stdout *FrameStreamer
+	// Hub, if set, is sent a status update every HubStatusEvery, if that's
+	// set.
+	Hub            *EventHub
+	HubStatusEvery time.Duration
+	// LogEvent and RecordEvent, if set, are called as events start and end.
+	LogEvent    func(ev *MotionEvent, phase string)
+	RecordEvent func(ev *MotionEvent)
+
+	webcam    FrameSource
+	fileStart time.Time
+	lastFrame time.Time
+
+	imgSrc  gocv.Mat
+	img     gocv.Mat
+	display gocv.Mat
+	mainImg gocv.Mat
+	scratch gocv.Mat
+
+	status           string
+	statusColor      color.RGBA
+	lastEvent        time.Time
+	lastPeakSnapshot time.Time
+	lastHubStatus    time.Time
+	readFailures     int
+	capture          *Capturer
+	captured         time.Time
+	controls         string
+	exposure         float64
+	exposureNudged   bool
+	gaveUp           bool
+
+	keys chan rune
+	// configs is unbuffered, so that a change is only accepted while the
+	// capture loop is running to apply it
+	configs chan configRequest
+	reopen  chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	view    gocv.Mat
+	viewSeq int
+	feed    gocv.Mat
+	feedSeq int
+	state   CameraState
+}
+
+// OpenCamera opens the named camera from the given source, of the given input
+// type, and reads its dimensions and frame rate.
+func OpenCamera(name, source, input string) (*Camera, error) {
+	webcam, err := openSource(source, input)
+	if err != nil {
+		return nil, err
+	}
+	c := &Camera{
+		Name:         name,
+		Source:       source,
+		Input:        input,
+		Width:        int(webcam.Get(gocv.VideoCaptureFrameWidth)),
+		Height:       int(webcam.Get(gocv.VideoCaptureFrameHeight)),
+		MaxFPS:       webcam.Get(gocv.VideoCaptureFPS),
+		Detector:     NewMotionDetector(),
+		Metrics:      NewCameraMetrics(),
+		FieldChanged: 'a',
+		Mirror:       true,
+		webcam:       webcam,
+		imgSrc:       gocv.NewMat(),
+		img:          gocv.NewMat(),
+		display:      gocv.NewMat(),
+		mainImg:      gocv.NewMat(),
+		scratch:      gocv.NewMat(),
+		view:         gocv.NewMat(),
+		feed:         gocv.NewMat(),
+		keys:         make(chan rune, cameraKeys),
+		configs:      make(chan configRequest),
+		reopen:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	if vc, ok := webcam.(*gocv.VideoCapture); ok && input == InputDevice {
+		c.controls = deviceControlStatus(vc)
+	}
+	return c, nil
+}
+
+// Calibrate measures the camera's frame rate by reading CalibrateFrames frames,
+// since what devices and streams report is often 0, or far too high, and uses
+// that as MaxFPS. If the frames can't be read, the reported rate is kept,
+// unless it's implausible, in which case fallbackFPS is assumed.
+func (c *Camera) Calibrate() {
+	reported := c.webcam.Get(gocv.VideoCaptureFPS)
+	measured := 0.0
+	if c.CalibrateFrames > 1 {
+		var first time.Time
+		n := 0
+		for n < c.CalibrateFrames && c.webcam.Read(&c.imgSrc) {
+			if c.imgSrc.Empty() {
+				continue
+			}
+			// the first frame is often buffered, so only time the ones after
+			if n == 0 {
+				first = time.Now()
+			}
+			n++
+		}
+		if elapsed := time.Since(first); n > 1 && elapsed > 0 {
+			measured = float64(n-1) / elapsed.Seconds()
+		}
+	}
+
+	switch {
+	case measured > 0:
+		c.MaxFPS = measured
+		logInfo("Measured FPS", "camera", c.Name, "fps", math.Round(measured*10)/10, "reported", math.Round(reported*10)/10)
+	case reported > 0 && reported <= maxPlausibleFPS:
+		c.MaxFPS = reported
+	default:
+		c.MaxFPS = fallbackFPS
+		logWarn("Implausible FPS reported, assuming the default", "camera", c.Name, "reported", math.Round(reported*10)/10, "fps", fallbackFPS)
+	}
+	if c.Recorder != nil {
+		c.Recorder.FPS = c.MaxFPS
+	}
+	if c.Manual != nil {
+		c.Manual.FPS = c.MaxFPS
+	}
+	if c.Continuous != nil {
+		c.Continuous.FPS = c.MaxFPS
+	}
+}
+
+// Reopen asks the camera to reopen its source before reading the next frame.
+func (c *Camera) Reopen() {
+	select {
+	case c.reopen <- struct{}{}:
+	default:
+	}
+}
+
+// Key passes a key press to the camera, to be handled once the current frame
+// has been processed.
+func (c *Camera) Key(k rune) {
+	select {
+	case c.keys <- k:
+	default:
+	}
+}
+
+// View copies the camera's latest live view to dst if it is newer than the
+// one numbered seq, returning the number of the latest view and whether it
+// was copied.
+func (c *Camera) View(dst *gocv.Mat, seq int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.viewSeq == seq || c.view.Empty() {
+		return c.viewSeq, false
+	}
+	c.view.CopyTo(dst)
+	return c.viewSeq, true
+}
+
+// Frame copies the camera's latest frame, before any markup, to dst if it is
+// newer than the one numbered seq, like View. Frames are only kept if Feed is
+// set.
+func (c *Camera) Frame(dst *gocv.Mat, seq int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.feedSeq == seq || c.feed.Empty() {
+		return c.feedSeq, false
+	}
+	c.feed.CopyTo(dst)
+	return c.feedSeq, true
+}
+
+// setFeed makes img the camera's latest frame, for Frame.
+func (c *Camera) setFeed(img gocv.Mat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img.CopyTo(&c.feed)
+	c.feedSeq++
+}
+
+// setView makes img the camera's latest live view.
+func (c *Camera) setView(img gocv.Mat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img.CopyTo(&c.view)
+	c.viewSeq++
+}
+
+// Stopped returns whether the camera's capture loop has ended.
+func (c *Camera) Stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait waits for the camera's capture loop to end, and for everything it
+// was saving to be saved.
+func (c *Camera) Wait() {
+	<-c.done
+}
+
+// Close releases the camera's resources. It must only be called once the
+// capture loop has ended.
+func (c *Camera) Close() {
+	c.webcam.Close()
+	c.Detector.Close()
+	c.Buffer.Close()
+	c.imgSrc.Close()
+	c.img.Close()
+	c.display.Close()
+	c.mainImg.Close()
+	if c.Main != nil {
+		c.Main.Close()
+	}
+	c.scratch.Close()
+	c.view.Close()
+	c.feed.Close()
+	if c.PiP != nil {
+		c.PiP.Close()
+	}
+}
+
+// State returns the camera's current state, with the given status message.
+// It must only be called by the capture loop.
+func (c *Camera) State(status string) CameraState {
+	s := CameraState{
+		Camera:           c.Name,
+		Tagged:           c.Tag != "",
+		Width:            c.Width,
+		Height:           c.Height,
+		FPS:              c.FPS.FPS(),
+		FPSInstant:       c.FPS.Instant(),
+		FPSNominal:       c.MaxFPS,
+		FrameP95Ms:       frameTimer.Percentiles().P95.Seconds() * 1000,
+		DetectionEnabled: c.DetectionEnabled,
+		Armed:            c.Arm.Armed(),
+		Detector: DetectorParams{
+			Threshold:    c.Detector.Threshold,
+			DilateSize:   c.Detector.DilateSize,
+			MinArea:      c.Detector.MinimumContourArea,
+			DrawContours: c.Detector.DrawContours,
+			DrawRects:    c.Detector.DrawRects,
+		},
+		DrawLive:      c.DrawLive,
+		DrawRecord:    c.DrawRecord,
+		HUDLive:       c.HUDLive,
+		HUDRecord:     c.HUDRecord,
+		HUD:           c.HUD,
+		Rotate:        c.Rotate,
+		Selected:      string(c.FieldChanged),
+		BufferSeconds: c.Buffer.Duration().Seconds(),
+		Frames:        c.FPS.TotalFrames(),
+		UptimeSeconds: c.FPS.Uptime().Seconds(),
+		Status:        status,
+	}
+	if n := c.Buffer.Count(); n > 0 {
+		s.BufferFill = float64(c.Buffer.Len()) / float64(n)
+	}
+	s.BufferFrames = c.Buffer.Len()
+	s.BufferBytes = int64(s.BufferFrames) * int64(c.Width*c.Height*3)
+	if !c.lastFrame.IsZero() {
+		t := c.lastFrame.UTC()
+		s.LastFrame = &t
+	}
+	if !c.lastEvent.IsZero() {
+		t := c.lastEvent.UTC()
+		s.LastEvent = &t
+	}
+	s.Events = c.Metrics.Events()
+	return s
+}
+
+// LatestState returns the camera's state as of its latest frame, or, if it
+// hasn't processed one yet, just its name, with the status "Starting".
+func (c *Camera) LatestState() CameraState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Camera == "" {
+		return CameraState{Camera: c.Name, Status: "Starting"}
+	}
+	return c.state
+}
+
+// setState makes s the camera's latest state, for LatestState and the HUD.
+func (c *Camera) setState(s CameraState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = s
+}
+
+// How much of the HUD is drawn.
+const (
+	// HUDFull draws the status line and debug text.
+	HUDFull = "full"
+	// HUDMinimal draws the status line only.
+	HUDMinimal = "minimal"
+	// HUDOff draws nothing.
+	HUDOff = "off"
+)
+
+// hudModes are the HUD modes, in the order 'o' cycles through them.
+var hudModes = []string{HUDFull, HUDMinimal, HUDOff}
+
+// DrawHUD draws the status line and, with HUDFull, debug text onto img.
+func (c *Camera) DrawHUD(img *gocv.Mat) {
+	if c.HUD == HUDOff {
+		return
+	}
+	// only the capture loop sets the state, so it needn't be locked here
+	gocv.PutText(img, c.state.Line(*verboseStatus), image.Pt(10, 20), gocv.FontHersheyPlain, 1.2, c.statusColor, 2)
+	if c.HUD == HUDMinimal {
+		return
+	}
+	y := 50
+	for i := 0; i < c.FPS.Buckets(); i += hudBucketsPerRow {
+		s := fmt.Sprintf("%v[%d]:", c.FPS.Interval(), i)
+		for j := i; j < i+hudBucketsPerRow && j < c.FPS.Buckets(); j++ {
+			frames, _ := c.FPS.Bucket(j)
+			s += fmt.Sprintf(" %d", frames)
+		}
+		gocv.PutText(img, s, image.Pt(10, y), gocv.FontHersheyPlain, 1.2, blue, 2)
+		y += 20
+	}
+	if c.Stages != nil {
+		gocv.PutText(img, c.Stages.String(), image.Pt(10, y), gocv.FontHersheyPlain, 1.2, blue, 2)
+		y += 20
+	}
+	if c.controls != "" {
+		gocv.PutText(img, c.controls, image.Pt(10, y), gocv.FontHersheyPlain, 1.2, blue, 2)
+		y += 20
+	}
+	gocv.PutText(img, "drops: "+drops.String(), image.Pt(10, y), gocv.FontHersheyPlain, 1.2, blue, 2)
+}
+
+// logStats logs, at debug level, the frame statistics shown on the HUD:
+// the frame rate, the frames counted in each of its buckets, the stage
+// timings with -stage-timing, and the largest area detected in the last
+// frame.
+func (c *Camera) logStats() {
+	buckets := make([]string, c.FPS.Buckets())
+	for i := range buckets {
+		frames, _ := c.FPS.Bucket(i)
+		buckets[i] = strconv.Itoa(frames)
+	}
+	kv := []interface{}{
+		"camera", c.Name,
+		"fps", math.Round(c.FPS.FPS()*10) / 10,
+		"p95_ms", math.Round(frameTimer.Percentiles().P95.Seconds() * 1000),
+		"bucket_interval", c.FPS.Interval(),
+		"buckets", strings.Join(buckets, ","),
+		"detection", c.DetectionEnabled,
+		"area", math.Round(c.Detector.LastArea()),
+		"drops", drops.String(),
+	}
+	if c.Stages != nil {
+		kv = append(kv, "stages", c.Stages.String())
+	}
+	logDebug("Frame statistics", kv...)
+}
+
+// handleKeys handles the key presses passed to Key since the last frame.
+func (c *Camera) handleKeys() {
+	for {
+		select {
+		case k := <-c.keys:
+			c.handleKey(k)
+		default:
+			return
+		}
+	}
+}
+
+// handleKey handles a key press, as bound in keyBindings.
+func (c *Camera) handleKey(k rune) {
+	if b := lookupKey(k); b != nil && b.Camera != nil {
+		b.Camera(c, k)
+	}
+}
+
+// cycleHUD switches to the next of hudModes.
+func (c *Camera) cycleHUD() {
+	for i, mode := range hudModes {
+		if mode == c.HUD {
+			c.HUD = hudModes[(i+1)%len(hudModes)]
+			return
+		}
+	}
+	c.HUD = HUDFull
+}
+
+// adjustSelected changes the detection setting selected with 'a', 'd' or 't'
+// by a step in the direction dir, keeping it in range.
+func (c *Camera) adjustSelected(dir int) {
+	switch c.FieldChanged {
+	case 'a':
+		c.Detector.MinimumContourArea += float64(100 * dir)
+		if c.Detector.MinimumContourArea <= 0 {
+			c.Detector.MinimumContourArea = 100
+		}
+	case 'd':
+		c.Detector.DilateSize += 1 * dir
+		if c.Detector.DilateSize <= 0 {
+			c.Detector.DilateSize = 1
+		}
+	case 't':
+		c.Detector.Threshold += float32(1 * dir)
+		if c.Detector.Threshold <= 0 {
+			c.Detector.Threshold = 1
+		} else if c.Detector.Threshold > 255 {
+			c.Detector.Threshold = 255
+		}
+	}
+}
+
+// nudgeExposure changes the exposure of a device by delta, turning auto
+// exposure off first.
+func (c *Camera) nudgeExposure(delta float64) {
+	vc, ok := c.webcam.(*gocv.VideoCapture)
+	if !ok || c.Input != InputDevice {
+		logInfo("Not changing exposure: only devices have exposure controls", "camera", c.Name)
+		return
+	}
+	if !c.exposureNudged {
+		c.exposure = vc.Get(gocv.VideoCaptureExposure)
+		c.exposureNudged = true
+	}
+	c.exposure += delta
+	c.applyExposure(vc)
+	logInfo("Set exposure", "camera", c.Name, "exposure", vc.Get(gocv.VideoCaptureExposure))
+}
+
+// applyExposure sets the exposure chosen with nudgeExposure on a device, so
+// that it survives the device being reopened.
+func (c *Camera) applyExposure(vc *gocv.VideoCapture) {
+	setDeviceProp(vc, c.Source, "auto exposure", gocv.VideoCaptureAutoExposure, autoExposureModes["off"])
+	setDeviceProp(vc, c.Source, "exposure", gocv.VideoCaptureExposure, c.exposure)
+	c.controls = deviceControlStatus(vc)
+}
+
+// rotate changes the rotation of frames to the given number of degrees, while
+// running. Frames of the new size can't go in the same files as the old ones,
+// so the event and manual recordings in progress are finished, the continuous
+// recording is split, and the buffer is emptied.
+func (c *Camera) rotate(degrees int) {
+	if !c.Crop.Empty() {
+		logInfo("Not rotating: the crop region is for the current rotation", "camera", c.Name)
+		return
+	}
+	if (degrees-c.Rotate)%180 != 0 {
+		if ev := c.Tracker.Stop(c.lastFrame); ev != nil {
+			c.Recorder.Finish(ev)
+			c.logEvent(ev, PhaseEnd)
+			c.recordEvent(ev)
+		}
+		c.Manual.Stop()
+		if c.Continuous != nil {
+			c.Continuous.Close()
+		}
+		imgs, _ := c.Buffer.Take(time.Time{})
+		for _, img := range imgs {
+			img.Close()
+		}
+		c.Width, c.Height = c.Height, c.Width
+	}
+	c.Rotate = degrees
+	logInfo("Rotating", "camera", c.Name, "degrees", degrees)
+}
+
+// filename returns the path of a file saved by the camera, named prefix
+// followed by the time of the last frame.
+func (c *Camera) filename(prefix string) string {
+	if c.Tag != "" {
+		prefix += "_" + c.Tag
+	}
+	return OutputPath(c.SaveDir, prefix+c.lastFrame.Format("_20060102_150405")+c.SaveExt)
+}
+
+// saveBuffer saves the buffer in the background.
+func (c *Camera) saveBuffer() {
+	if !c.Saver.Save(c.Buffer, c.filename("buffer")) {
+		logWarn("Not saving buffer: a save is already in progress", "camera", c.Name)
+	}
+}
+
+// toggleManual starts or stops a manual recording.
+func (c *Camera) toggleManual() {
+	if c.Manual.Recording() {
+		c.Manual.Stop()
+	} else {
+		c.Manual.Start(c.Buffer, c.lastFrame)
+	}
+}
+
+// Run runs the camera's capture loop until the source ends or Quit is called,
+// then finishes any recordings in progress. It is meant to be run in its own
+// goroutine.
+func (c *Camera) Run() {
+	defer close(c.done)
+
+	logInfo("Start reading device", "camera", c.Name, "source", c.Source)
+	c.FPS.Start()
+	defer c.FPS.Stop()
+
+	if c.Input == InputFile || c.Input == InputSynthetic {
+		c.fileStart = time.Now()
+	}
+	c.startCapture()
+	lastStageLog := time.Now()
+	for !Quitting() {
+		frameStart := time.Now()
+		if !c.step() {
+			break
+		}
+		c.handleKeys()
+		c.handleConfigs()
+		frameTimer.FrameDone(time.Since(frameStart))
+		c.Metrics.FrameDuration.Observe(time.Since(frameStart))
+		c.Metrics.SetDetectionEnabled(c.DetectionEnabled)
+
+		if time.Since(lastStageLog) >= *stageLogInterval && logger.Enabled(LevelDebug) {
+			c.logStats()
+			lastStageLog = time.Now()
+		}
+		if c.Limiter != nil {
+			c.Limiter.Wait()
+		}
+	}
+	c.finish()
+}
+
+// startCapture starts reading frames from the source in the background.
+// Files and other inputs that can be read at any pace are never dropped.
+func (c *Camera) startCapture() {
+	block := c.Input == InputFile || c.Input == InputStdin || c.Input == InputSynthetic
+	c.capture = StartCapture(c.webcam, c.frameTime, block)
+}
+
+// stopCapture stops reading frames from the source, if it's being read.
+func (c *Camera) stopCapture() {
+	if c.capture != nil {
+		c.capture.Stop()
+		c.capture = nil
+	}
+}
+
+// frameTime returns the time of the frame just read from the source: its
+// position for files and synthetic inputs, when it arrived for MJPEG streams,
+// and otherwise now. It is called by the capture goroutine.
+func (c *Camera) frameTime() time.Time {
+	if c.Input == InputFile || c.Input == InputSynthetic {
+		pos := c.webcam.Get(gocv.VideoCapturePosMsec)
+		return c.fileStart.Add(time.Duration(pos * float64(time.Millisecond)))
+	}
+	if mjpeg, ok := c.webcam.(*MJPEGSource); ok {
+		return mjpeg.Received()
+	}
+	return time.Now()
+}
+
+// open reopens the camera's source, which must have been closed.
+func (c *Camera) open() error {
+	webcam, err := openSource(c.Source, c.Input)
+	if err != nil {
+		return err
+	}
+	c.webcam = webcam
+	if vc, ok := webcam.(*gocv.VideoCapture); ok && c.Input == InputDevice {
+		if c.exposureNudged {
+			c.applyExposure(vc)
+		}
+		c.controls = deviceControlStatus(vc)
+	}
+	return nil
+}
+
+// read takes the next captured frame into imgSrc, and its time into captured,
+// reopening the source after outages. It returns whether there is a new frame
+// to process, and false for ok once the source has ended.
+func (c *Camera) read() (got, ok bool) {
+	select {
+	case <-c.reopen:
+		logInfo("Reopening video capture device", "camera", c.Name, "source", c.Source)
+		c.stopCapture()
+		c.webcam.Close()
+		if err := c.open(); err != nil {
+			logError("Reopening video capture device failed", "camera", c.Name, "source", c.Source, "error", err)
+			c.Reconnector.Failed(time.Now())
+			return false, true
+		}
+		c.Calibrate()
+		c.FPS.Reset()
+		c.startCapture()
+	default:
+	}
+
+	if c.Reconnector.Active() {
+		if t := time.Now(); c.Reconnector.Due(t) {
+			logInfo("Reconnecting", "camera", c.Name, "source", c.Source, "attempt", c.Reconnector.Attempt())
+			c.stopCapture()
+			c.webcam.Close()
+			if err := c.open(); err == nil {
+				outage := c.Reconnector.Succeeded(time.Now())
+				logInfo("Reconnected", "camera", c.Name, "source", c.Source, "outage", outage.Round(time.Second))
+				reconnects.Add(1)
+				c.Calibrate()
+				c.FPS.Reset()
+				c.readFailures = 0
+				c.startCapture()
+				return false, true
+			}
+			if !c.Reconnector.Retry(time.Now()) {
+				logError("Giving up reconnecting", "camera", c.Name, "source", c.Source, "attempts", c.Reconnector.Attempt()-1)
+				c.gaveUp = true
+				return false, false
+			}
+		}
+		// keep showing the last frame, with the state of the outage
+		if !c.img.Empty() {
+			c.img.CopyTo(&c.display)
+			msg := fmt.Sprintf("reconnecting (attempt %d)", c.Reconnector.Attempt())
+			gocv.PutText(&c.display, msg, image.Pt(10, c.display.Rows()-20), gocv.FontHersheyPlain, 1.5, red, 2)
+			c.setView(c.display)
+		}
+		time.Sleep(reconnectPoll)
+		return false, true
+	}
+
+	t, ok := c.capture.Next(&c.imgSrc)
+	if !ok {
+		switch c.Input {
+		case InputStream, InputMJPEG:
+			logWarn("Lost connection, reconnecting", "camera", c.Name, "source", c.Source)
+			c.Reconnector.Failed(time.Now())
+			return false, true
+		case InputDevice:
+			// devices that briefly drop off the bus fail a few reads before
+			// they can be reopened
+			c.readFailures++
+			drops.Drop("cam")
+			if c.readFailures < c.ReopenAfter {
+				time.Sleep(reconnectPoll)
+				c.stopCapture()
+				c.startCapture()
+				return false, true
+			}
+			logWarn("Device failed reads in a row, reopening", "camera", c.Name, "source", c.Source, "failures", c.readFailures)
+			c.Reconnector.Failed(time.Now())
+			return false, true
+		case InputFile:
+			logInfo("End of file", "camera", c.Name, "source", c.Source)
+		case InputStdin:
+			logInfo("End of input", "camera", c.Name, "source", "stdin")
+		case InputSynthetic:
+			logInfo("End of synthetic input", "camera", c.Name)
+		}
+		return false, false
+	}
+	c.readFailures = 0
+	c.captured = t
+	return true, true
+}
+
+// step reads and processes a single frame, returning false once the source
+// has ended.
+func (c *Camera) step() bool {
+	c.Stages.Start("read")
+	got, ok := c.read()
+	if !ok {
+		return false
+	}
+	c.Stages.Stop("read")
+	if !got {
+		return true
+	}
+	now := c.captured
+	if mjpeg, ok := c.webcam.(*MJPEGSource); ok {
+		// the stream has no nominal rate, so show the measured one
+		c.MaxFPS = mjpeg.FPS()
+	}
+	if c.imgSrc.Empty() {
+		drops.Drop("cam")
+		return true
+	}
+	c.lastFrame = now
+
+	// rotate and crop into scratch, swapping the result back into imgSrc
+	if flag, ok := rotateFlags[c.Rotate]; ok {
+		gocv.Rotate(c.imgSrc, &c.scratch, flag)
+		c.imgSrc, c.scratch = c.scratch, c.imgSrc
+	}
+	if !c.Crop.Empty() {
+		if !c.Crop.In(image.Rect(0, 0, c.imgSrc.Cols(), c.imgSrc.Rows())) {
+			// the source changed size, e.g. when reopened
+			drops.Drop("cam")
+			return true
+		}
+		region := c.imgSrc.Region(c.Crop)
+		region.CopyTo(&c.scratch)
+		region.Close()
+		c.imgSrc, c.scratch = c.scratch, c.imgSrc
+	}
+	if c.Mirror {
+		// Flip horizontally (mirror view)
+		gocv.Flip(c.imgSrc, &c.img, 1)
+	} else {
+		// img is what everything downstream works off, so just swap the
+		// frame in, and read the next one into the previous img
+		c.imgSrc, c.img = c.img, c.imgSrc
+	}
+
+	if c.Feed {
+		c.setFeed(c.img)
+	}
+
+	c.Stages.Start("detect")
+	var regions []Detection
+	insetFirst := c.PiP != nil && c.PiP.Detect == PiPDetectComposite
+	if insetFirst {
+		c.PiP.Draw(&c.img)
+	}
+	if c.DetectionEnabled {
+		detectStart := time.Now()
+		regions = c.Detector.Detect(c.img)
+		c.Metrics.DetectDuration.Observe(time.Since(detectStart))
+	}
+	if c.PiP != nil && !insetFirst {
+		c.PiP.Draw(&c.img)
+		if c.DetectionEnabled && c.PiP.Detect == PiPDetectBoth {
+			regions = append(regions, c.PiP.DetectInset(c.Detector)...)
+		}
+	}
+	motion := len(regions) > 0
+	armed := c.Arm.Armed()
+	if !c.DetectionEnabled {
+		c.status = "Motion detection disabled"
+		c.statusColor = blue
+	} else if !armed {
+		c.status = "Disarmed"
+		if motion {
+			c.status = "Disarmed: motion detected"
+		}
+		c.statusColor = blue
+	} else if motion {
+		c.status = "Motion detected"
+		c.statusColor = red
+		detections.Add(1)
+	} else {
+		c.status = "Ready"
+		c.statusColor = green
+	}
+	c.Stages.Stop("detect")
+
+	// with a main stream, its frames are what get recorded and snapshotted
+	rec, recRegions := &c.img, regions
+	if c.Main != nil {
+		c.Main.Match(c.img, now, &c.mainImg)
+		rec = &c.mainImg
+		recRegions = c.Main.Scale(regions, image.Pt(c.img.Cols(), c.img.Rows()))
+	}
+
+	change, ev := c.Tracker.Update(motion && armed, now)
+	if change == EventStarted {
+		c.Metrics.EventStarted()
+		c.lastEvent = ev.Start
+	}
+	if change == EventStarted && c.Snapshots != nil {
+		ev.Regions = c.Snapshots.Save(*rec, recRegions, ev, "region")
+		if len(ev.Regions) > 0 {
+			ev.Snapshot = ev.Regions[0]
+		}
+	}
+	area := 0.0
+	for _, d := range regions {
+		if d.Area > area {
+			area = d.Area
+		}
+	}
+	if c.HubStatusEvery > 0 && now.Sub(c.lastHubStatus) >= c.HubStatusEvery {
+		c.Hub.PublishStatus(&CameraStatus{
+			Camera: c.Name,
+			Time:   now.UTC(),
+			FPS:    c.FPS.FPS(),
+			Score:  area,
+			Motion: motion,
+			Armed:  armed,
+		})
+		c.lastHubStatus = now
+	}
+	if ev != nil && area > ev.PeakArea {
+		ev.PeakArea = area
+		if c.SnapshotPeak && c.Snapshots != nil && change != EventStarted && now.Sub(c.lastPeakSnapshot) >= time.Second {
+			c.Snapshots.Save(*rec, recRegions, ev, "peak_region")
+			c.lastPeakSnapshot = now
+		}
+	}
+	if ev != nil && c.Tracker.Paused() {
+		remaining := c.Tracker.MergeGap - now.Sub(ev.End)
+		c.status = fmt.Sprintf("Waiting to merge (%v)", remaining.Round(time.Second))
+		c.statusColor = red
+	} else if ev != nil && !motion && change != EventEnded {
+		remaining := c.Tracker.Quiet - now.Sub(ev.LastMotion)
+		c.status = fmt.Sprintf("Recording (post-roll %v)", remaining.Round(time.Second))
+		c.statusColor = red
+	}
+
+	if msg := c.Saver.Status(); msg != "" {
+		c.status += " | " + msg
+	}
+	c.setState(c.State(c.status))
+
+	disp := &c.img
+	if c.Main != nil {
+		// the recording and live view are separate frames already
+		if c.DrawRecord {
+			c.Detector.Annotate(rec, recRegions)
+		}
+		if c.HUDRecord {
+			c.DrawHUD(rec)
+		}
+		c.record(rec, now, change, ev, area)
+		if c.DrawLive {
+			c.Detector.Annotate(disp, regions)
+		}
+		if c.HUDLive {
+			c.DrawHUD(disp)
+		}
+	} else {
+		// draw what both the live view and the recording want first, so
+		// that a separate display copy is only needed when the recording
+		// wants something the live view doesn't
+		var (
+			markupBoth = c.DrawLive && c.DrawRecord
+			hudBoth    = c.HUDLive && c.HUDRecord
+		)
+		if markupBoth {
+			c.Detector.Annotate(&c.img, regions)
+		}
+		if hudBoth {
+			c.DrawHUD(&c.img)
+		}
+		if (c.DrawRecord && !c.DrawLive) || (c.HUDRecord && !c.HUDLive) {
+			c.img.CopyTo(&c.display)
+			disp = &c.display
+			if c.DrawRecord && !markupBoth {
+				c.Detector.Annotate(&c.img, regions)
+			}
+			if c.HUDRecord && !hudBoth {
+				c.DrawHUD(&c.img)
+			}
+		}
+		c.record(&c.img, now, change, ev, area)
+		if c.DrawLive && !markupBoth {
+			c.Detector.Annotate(disp, regions)
+		}
+		if c.HUDLive && !hudBoth {
+			c.DrawHUD(disp)
+		}
+	}
+	if c.HUDLive && c.HUD != HUDOff && c.Manual.Recording() {
+		DrawRecIndicator(disp, c.Manual.Elapsed(now))
+	}
+	if c.HLS != nil {
+		c.HLS.Write(*disp)
+	}
+	if c.RTSP != nil {
+		c.RTSP.Write(*disp)
+	}
+	if c.WebRTC != nil {
+		c.WebRTC.Write(*disp)
+	}
+	if c.Stream != nil {
+		c.Stream.Write(*disp)
+	}
+	if c.Stdout != nil {
+		c.Stdout.Write(*disp)
+	}
+
+	c.Stages.Start("show")
+	c.setView(*disp)
+	c.Stages.Stop("show")
+	c.FPS.NextFrame()
+	c.Metrics.FrameReceived()
+	return true
+}
+
+// record buffers a frame and passes it on to the recorders, depending on the
+// change in the event being tracked.
+func (c *Camera) record(img *gocv.Mat, now time.Time, change EventChange, ev *MotionEvent, area float64) {
+	c.Stages.Start("buffer")
+	c.Buffer.Add(img, now)
+	switch change {
+	case EventStarted:
+		c.Recorder.Start(ev, c.Buffer)
+		c.logEvent(ev, PhaseStart)
+		if c.Alert != nil {
+			c.Alert.Play()
+		}
+	case EventResumed:
+		c.Recorder.Resume(c.Buffer)
+		c.Recorder.Add(img, now, area)
+	case EventPaused:
+		c.Recorder.Add(img, now, area)
+		c.Recorder.Pause()
+	case EventEnded:
+		c.Recorder.Add(img, now, area)
+		c.Recorder.Finish(ev)
+		c.logEvent(ev, PhaseEnd)
+		c.recordEvent(ev)
+	default:
+		c.Recorder.Add(img, now, area)
+	}
+	if c.Continuous != nil {
+		c.Continuous.Add(img, now, area)
+	}
+	c.Manual.Add(img, now)
+	c.Stages.Stop("buffer")
+	fill := float64(c.Buffer.Len()) / float64(c.Buffer.Count())
+	bufferFill.Set(fill)
+	c.Metrics.SetBufferFill(fill)
+}
+
+func (c *Camera) logEvent(ev *MotionEvent, phase string) {
+	// record when the event started relative to the monotonic clock, and how
+	// far its wall clock timestamps can be trusted
+	if phase == PhaseStart {
+		ev.Offset = hostClock.Offset(ev.Start)
+	}
+	ev.Clock = hostClock.Status()
+	if c.LogEvent != nil {
+		c.LogEvent(ev, phase)
+	}
+}
+
+func (c *Camera) recordEvent(ev *MotionEvent) {
+	if c.RecordEvent != nil {
+		c.RecordEvent(ev)
+	}
+}
+
+// finish ends the event in progress, and waits for all recordings to be
+// saved, saving the buffer too if SaveOnExit is set.
+func (c *Camera) finish() {
+	c.stopCapture()
+	logInfo("Processed frames", "camera", c.Name, "frames", c.FPS.TotalFrames(),
+		"uptime", c.FPS.Uptime().Truncate(time.Second), "events", c.Tracker.Events())
+
+	if ev := c.Tracker.Stop(time.Now()); ev != nil {
+		c.Recorder.Finish(ev)
+		c.logEvent(ev, PhaseEnd)
+		c.recordEvent(ev)
+	}
+	if c.Continuous != nil {
+		c.Continuous.Close()
+	}
+	c.Manual.Stop()
+	c.Manual.Wait()
+	c.Recorder.Wait()
+	if c.gaveUp && !c.SaveOnExit {
+		// the outage may well have been caused by whatever was in view
+		logInfo("Saving buffer after losing the camera", "camera", c.Name)
+		c.saveBuffer()
+	}
+	c.Saver.Wait()
+	if c.Snapshots != nil {
+		c.Snapshots.Wait()
+	}
+
+	if c.SaveOnExit {
+		logInfo("Saving buffer", "camera", c.Name, "duration", c.Buffer.Duration(), "fps", math.Round(c.Buffer.FPS()))
+		name := "video.mp4"
+		if c.Tag != "" {
+			name = "video_" + c.Tag + ".mp4"
+		}
+		frameSize := int64(c.img.Total() * c.img.Channels())
+		path := OutputPath(c.SaveDir, name)
+		if err := c.Recorder.Space.Check(c.SaveDir, frameSize, c.Buffer.Len()); err != nil {
+			logError("Saving buffer failed", "camera", c.Name, "path", path, "error", err)
+			return
+		}
+		if err := c.Buffer.WriteFile(path, c.Codec, c.Annotate, LogProgress); err != nil {
+			logError("Saving buffer failed", "camera", c.Name, "path", path, "error", err)
+		}
+	}
+}
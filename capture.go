@@ -0,0 +1,128 @@
+package main
+
+import (
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// captureQueue is the number of captured frames that may be waiting to be
+// processed. Live sources drop the oldest waiting frame when it's full.
+const captureQueue = 4
+
+// capturedFrame is a frame read by a Capturer, and when it was captured.
+type capturedFrame struct {
+	img *gocv.Mat
+	t   time.Time
+}
+
+// Capturer reads frames from a source in its own goroutine, so that a slow
+// consumer doesn't hold up capture, and frames are timestamped as they're
+// read rather than when they're processed.
+//
+// Frames are read into a fixed pool of captureQueue matrices. Each is owned
+// by the capture goroutine until it's queued, by the queue until it's taken
+// by Next, which swaps its contents into the caller's matrix and hands it
+// straight back to the pool. Stop closes all of them.
+type Capturer struct {
+	src   FrameSource
+	stamp func() time.Time
+	block bool
+
+	frames chan capturedFrame
+	pool   chan *gocv.Mat
+	stop   chan struct{}
+}
+
+// StartCapture starts reading frames from src, timestamped by stamp. If block
+// is set, reading waits for the consumer once the queue is full, as is right
+// for files; otherwise the oldest waiting frame is dropped, as is right for
+// live sources.
+func StartCapture(src FrameSource, stamp func() time.Time, block bool) *Capturer {
+	cp := &Capturer{
+		src:    src,
+		stamp:  stamp,
+		block:  block,
+		frames: make(chan capturedFrame, captureQueue),
+		pool:   make(chan *gocv.Mat, captureQueue),
+		stop:   make(chan struct{}),
+	}
+	for i := 0; i < captureQueue; i++ {
+		m := gocv.NewMat()
+		cp.pool <- &m
+	}
+	go cp.run()
+	return cp
+}
+
+// run reads frames until Stop is called or a read fails, and then closes the
+// queue.
+func (cp *Capturer) run() {
+	defer close(cp.frames)
+	for {
+		m := cp.free()
+		if m == nil {
+			return
+		}
+		if !cp.src.Read(m) {
+			cp.pool <- m
+			return
+		}
+		// the queue has room for every matrix, so this never blocks
+		cp.frames <- capturedFrame{img: m, t: cp.stamp()}
+	}
+}
+
+// free returns a matrix to read the next frame into, or nil once stopped.
+func (cp *Capturer) free() *gocv.Mat {
+	select {
+	case m := <-cp.pool:
+		return m
+	case <-cp.stop:
+		return nil
+	default:
+	}
+	if cp.block {
+		select {
+		case m := <-cp.pool:
+			return m
+		case <-cp.stop:
+			return nil
+		}
+	}
+	// every matrix is waiting to be processed, so recycle the oldest
+	select {
+	case f := <-cp.frames:
+		drops.Drop("queue")
+		return f.img
+	case m := <-cp.pool:
+		return m
+	case <-cp.stop:
+		return nil
+	}
+}
+
+// Next waits for the next frame, and swaps it into dst, returning when it was
+// captured. It returns false once reading has failed, or been stopped.
+func (cp *Capturer) Next(dst *gocv.Mat) (time.Time, bool) {
+	f, ok := <-cp.frames
+	if !ok {
+		return time.Time{}, false
+	}
+	*dst, *f.img = *f.img, *dst
+	cp.pool <- f.img
+	return f.t, true
+}
+
+// Stop stops reading, waiting for any read in progress, and closes all the
+// matrices. The source is left open.
+func (cp *Capturer) Stop() {
+	close(cp.stop)
+	for f := range cp.frames {
+		f.img.Close()
+	}
+	for len(cp.pool) > 0 {
+		m := <-cp.pool
+		m.Close()
+	}
+}
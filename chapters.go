@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chapterGap is the longest break in motion that is still considered part of
+// the same chapter, so that flickering detections don't produce a chapter
+// per frame.
+const chapterGap = time.Second
+
+// Chapter is a span of a recording in which there was motion, relative to the
+// start of the recording.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ChaptersPath returns the path of the chapters sidecar for the given
+// recording.
+func ChaptersPath(clip string) string {
+	return strings.TrimSuffix(clip, filepath.Ext(clip)) + ".chapters.txt"
+}
+
+// WriteChapters writes the given chapters to the recording's ChaptersPath, in
+// the simple OGM format understood by e.g. mkvmerge and VLC. Each chapter is
+// titled with title and its number. Nothing is written if there are no
+// chapters.
+func WriteChapters(clip string, chapters []Chapter, title string) error {
+	if len(chapters) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for i, c := range chapters {
+		fmt.Fprintf(&sb, "CHAPTER%02d=%s\n", i+1, formatChapterTime(c.Start))
+		fmt.Fprintf(&sb, "CHAPTER%02dNAME=%s %d (%v)\n", i+1, title, i+1, (c.End - c.Start).Round(time.Second))
+	}
+	return os.WriteFile(ChaptersPath(clip), []byte(sb.String()), 0644)
+}
+
+// formatChapterTime formats d as HH:MM:SS.mmm.
+func formatChapterTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ChapterTitle returns the chapter title for motion in the given zones.
+func ChapterTitle(zones []string) string {
+	if len(zones) == 0 {
+		return "Motion"
+	}
+	return "Motion in " + strings.Join(zones, ", ")
+}
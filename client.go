@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// clientTokenEnv is the environment variable client subcommands read the
+// instance's -http-token from, if -token isn't given.
+const clientTokenEnv = "MOTIONDETECT_TOKEN"
+
+// apiClient makes requests to the HTTP API of a running instance, for the
+// client subcommands.
+type apiClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// clientFlags are the flags shared by the client subcommands.
+type clientFlags struct {
+	addr    *string
+	token   *string
+	timeout *time.Duration
+	json    *bool
+}
+
+// addClientFlags adds the flags shared by the client subcommands to fs.
+func addClientFlags(fs *flag.FlagSet, timeout time.Duration) *clientFlags {
+	return &clientFlags{
+		addr:    fs.String("addr", "localhost:8080", "the -http-addr of the running instance, or its URL"),
+		token:   fs.String("token", "", "the instance's -http-token, if it has one (default: $"+clientTokenEnv+")"),
+		timeout: fs.Duration("timeout", timeout, "how long to wait for a response"),
+		json:    fs.Bool("json", false, "print the response as JSON, rather than as a table"),
+	}
+}
+
+// client returns an apiClient for the flags.
+func (f *clientFlags) client() *apiClient {
+	base := *f.addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	if _, err := url.Parse(base); err != nil {
+		log.Fatalf("Invalid -addr %q: %v", *f.addr, err)
+	}
+	token := *f.token
+	if token == "" {
+		token = os.Getenv(clientTokenEnv)
+	}
+	return &apiClient{
+		base:   strings.TrimSuffix(base, "/"),
+		token:  token,
+		client: &http.Client{Timeout: *f.timeout},
+	}
+}
+
+// do makes a request to path, with in, if set, as its JSON body, and decodes
+// the JSON response into out, if set. A response other than 2xx is returned
+// as an error, with the message from its body.
+func (c *apiClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var result struct {
+			Error  string            `json:"error"`
+			Errors map[string]string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		for _, field := range sortedFields(result.Errors) {
+			result.Error += fmt.Sprintf("%s %s; ", field, result.Errors[field])
+		}
+		if result.Error = strings.TrimSuffix(result.Error, "; "); result.Error == "" {
+			result.Error = resp.Status
+		}
+		return fmt.Errorf("%s", result.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// sortedFields returns the keys of m, such as the fields of validation
+// errors, in order.
+func sortedFields(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// onOff formats a setting that's on or off.
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// runStatusCommand implements the "status" subcommand, which prints the
+// status of a running instance's cameras from /api/status.
+func runStatusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	cf := addClientFlags(fs, 10*time.Second)
+	fs.Parse(args)
+
+	var report StatusReport
+	if err := cf.client().do(http.MethodGet, "/api/status", nil, &report); err != nil {
+		log.Fatalf("Error getting status: %v", err)
+	}
+	if *cf.json {
+		printJSON(report)
+		return
+	}
+	fmt.Printf("motiondetect %s, up %v\n\n", report.Version, time.Duration(report.UptimeSeconds*float64(time.Second)).Truncate(time.Second))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAMERA\tSIZE\tFPS\tDETECTION\tARMED\tMIN AREA\tDILATE\tTHRESHOLD\tBUFFER\tLAST EVENT\tSTATUS")
+	for _, s := range report.Cameras {
+		last := "-"
+		if s.LastEvent != nil {
+			last = s.LastEvent.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%dx%d\t%0.1f/%0.1f\t%s\t%s\t%0.0f\t%d\t%0.0f\t%0.0f%% (%0.1fs)\t%s\t%s\n",
+			s.Camera, s.Width, s.Height,
+			s.FPS, s.FPSNominal,
+			onOff(s.DetectionEnabled), onOff(s.Armed),
+			s.Detector.MinArea, s.Detector.DilateSize, s.Detector.Threshold,
+			s.BufferFill*100, s.BufferSeconds,
+			last,
+			s.Status,
+		)
+	}
+	tw.Flush()
+}
+
+// runSetCommand implements the "set" subcommand, which changes a running
+// instance's detection settings through /api/config.
+func runSetCommand(args []string) {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	cf := addClientFlags(fs, 10*time.Second)
+	var (
+		camera     = fs.String("camera", "", "the camera to change (default: the first)")
+		minArea    = fs.Float64("min-area", 0, "the minimum area of motion, in pixels")
+		dilateSize = fs.Int("dilate-size", 0, "the dilate size")
+		threshold  = fs.Float64("threshold", 0, "the threshold, from 1 to 255")
+		detection  = fs.String("detection", "", "turn detection on or off")
+		contours   = fs.String("draw-contours", "", "turn drawing contours on or off")
+		rects      = fs.String("draw-rects", "", "turn drawing rectangles on or off")
+	)
+	fs.Parse(args)
+
+	// only the flags given are changed
+	var change DetectorConfig
+	parseOnOff := func(name, v string) *bool {
+		switch v {
+		case "on", "true", "1":
+			b := true
+			return &b
+		case "off", "false", "0":
+			b := false
+			return &b
+		}
+		log.Fatalf("Invalid -%s %q: must be on or off", name, v)
+		return nil
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-area":
+			change.MinArea = minArea
+		case "dilate-size":
+			change.DilateSize = dilateSize
+		case "threshold":
+			t := float32(*threshold)
+			change.Threshold = &t
+		case "detection":
+			change.DetectionEnabled = parseOnOff(f.Name, *detection)
+		case "draw-contours":
+			change.DrawContours = parseOnOff(f.Name, *contours)
+		case "draw-rects":
+			change.DrawRects = parseOnOff(f.Name, *rects)
+		}
+	})
+	if errs := change.Validate(); len(errs) > 0 {
+		for _, field := range sortedFields(errs) {
+			log.Printf("Invalid %s: %s", field, errs[field])
+		}
+		os.Exit(1)
+	}
+
+	path := "/api/config"
+	if *camera != "" {
+		path += "?camera=" + url.QueryEscape(*camera)
+	}
+	var cfg DetectorConfig
+	if err := cf.client().do(http.MethodPatch, path, &change, &cfg); err != nil {
+		log.Fatalf("Error changing settings: %v", err)
+	}
+	if *cf.json {
+		printJSON(cfg)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	settings := map[string]string{}
+	if cfg.MinArea != nil {
+		settings["min_area"] = fmt.Sprintf("%0.0f", *cfg.MinArea)
+	}
+	if cfg.DilateSize != nil {
+		settings["dilate_size"] = fmt.Sprint(*cfg.DilateSize)
+	}
+	if cfg.Threshold != nil {
+		settings["threshold"] = fmt.Sprint(*cfg.Threshold)
+	}
+	if cfg.DetectionEnabled != nil {
+		settings["detection_enabled"] = onOff(*cfg.DetectionEnabled)
+	}
+	if cfg.DrawContours != nil {
+		settings["draw_contours"] = onOff(*cfg.DrawContours)
+	}
+	if cfg.DrawRects != nil {
+		settings["draw_rects"] = onOff(*cfg.DrawRects)
+	}
+	for _, name := range sortedFields(settings) {
+		fmt.Fprintf(tw, "%s\t%s\n", name, settings[name])
+	}
+	tw.Flush()
+}
+
+// runSaveNowCommand implements the "save-now" subcommand, which has a running
+// instance save a camera's pre-roll buffer, through /api/save.
+func runSaveNowCommand(args []string) {
+	fs := flag.NewFlagSet("save-now", flag.ExitOnError)
+	cf := addClientFlags(fs, 10*time.Second)
+	camera := fs.String("camera", "", "the camera whose buffer to save (default: the first)")
+	fs.Parse(args)
+
+	path := "/api/save"
+	if *camera != "" {
+		path += "?camera=" + url.QueryEscape(*camera)
+	}
+	var res saveResult
+	if err := cf.client().do(http.MethodPost, path, nil, &res); err != nil {
+		log.Fatalf("Error saving: %v", err)
+	}
+	if *cf.json {
+		printJSON(res)
+		return
+	}
+	fmt.Printf("Saving the buffer of %s\n", res.Camera)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clipIDBytes is the number of bytes of a hash clip IDs are made of.
+const clipIDBytes = 10
+
+// clipTypes are the content types of recordings, in case the system's MIME
+// database doesn't know them.
+var clipTypes = map[string]string{
+	".mp4": "video/mp4",
+	".avi": "video/x-msvideo",
+	".mkv": "video/x-matroska",
+	".mov": "video/quicktime",
+}
+
+// Clip is a saved recording, as listed by /api/clips.
+type Clip struct {
+	// ID identifies the clip at /clips/{id}. It is derived from the clip's
+	// path within the output directory, so it is stable across restarts.
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	URL      string    `json:"url"`
+	// The event's details, if the clip was found in the events database.
+	Camera       string     `json:"camera,omitempty"`
+	EventID      int        `json:"event_id,omitempty"`
+	Start        *time.Time `json:"start,omitempty"`
+	End          *time.Time `json:"end,omitempty"`
+	PeakArea     float64    `json:"peak_area,omitempty"`
+	Zones        []string   `json:"zones,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+
+	path string
+}
+
+// ClipCatalog finds the clips saved in Dir, either from the events in Store
+// or, without one, by scanning Dir for recordings. Only regular files within
+// Dir are ever listed, and so served. The clips last listed are indexed by
+// ID, so that serving one doesn't take listing them all again.
+type ClipCatalog struct {
+	Dir   string
+	Store EventStore
+
+	mu   sync.Mutex
+	byID map[string]*Clip
+}
+
+// clipID returns the ID of the clip at rel, its slash-separated path within
+// the output directory.
+func clipID(rel string) string {
+	sum := sha1.Sum([]byte(rel))
+	return hex.EncodeToString(sum[:clipIDBytes])
+}
+
+// List returns the clips found, newest first.
+func (c *ClipCatalog) List() ([]*Clip, error) {
+	var clips []*Clip
+	if c.Store != nil {
+		evs, err := c.Store.Query(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, ev := range evs {
+			files := ev.Segments
+			if len(files) == 0 && ev.Clip != "" {
+				files = []string{ev.Clip}
+			}
+			for _, f := range files {
+				clip := c.clip(f)
+				if clip == nil || seen[clip.ID] {
+					continue
+				}
+				seen[clip.ID] = true
+				start, end := ev.Start, ev.End
+				clip.Camera, clip.EventID = ev.Camera, ev.ID
+				clip.Start, clip.End = &start, &end
+				clip.PeakArea, clip.Zones = ev.PeakArea, ev.Zones
+				clip.ThumbnailURL = mediaURL(c.Dir, ev.Thumbnail)
+				clips = append(clips, clip)
+			}
+		}
+	} else {
+		err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// skip hidden files, such as those still being written
+			if strings.HasPrefix(info.Name(), ".") && path != c.Dir {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || !recordingExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			if clip := c.clip(path); clip != nil {
+				clips = append(clips, clip)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("scanning %s failed: %w", c.Dir, err)
+		}
+	}
+	sort.SliceStable(clips, func(i, j int) bool {
+		return clips[i].time().After(clips[j].time())
+	})
+
+	byID := make(map[string]*Clip, len(clips))
+	for _, clip := range clips {
+		byID[clip.ID] = clip
+	}
+	c.mu.Lock()
+	c.byID = byID
+	c.mu.Unlock()
+	return clips, nil
+}
+
+// time returns when the clip was recorded.
+func (c *Clip) time() time.Time {
+	if c.Start != nil {
+		return *c.Start
+	}
+	return c.Modified
+}
+
+// clip returns the clip at filename, or nil if it isn't a regular file within
+// the output directory, once symlinks are resolved.
+func (c *ClipCatalog) clip(filename string) *Clip {
+	if filename == "" {
+		return nil
+	}
+	dir, err := filepath.EvalSymlinks(c.Dir)
+	if err != nil {
+		return nil
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil
+	}
+	path, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	id := clipID(filepath.ToSlash(rel))
+	return &Clip{
+		ID:       id,
+		Name:     filepath.ToSlash(rel),
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+		URL:      "/clips/" + id,
+		path:     path,
+	}
+}
+
+// Find returns the clip with the given ID, or nil if there isn't one. Clips
+// are looked up in the index, which is only refreshed by listing them again if
+// the ID isn't in it, e.g. because the clip was saved since. A clip found in
+// the index may have been deleted since.
+func (c *ClipCatalog) Find(id string) (*Clip, error) {
+	c.mu.Lock()
+	clip, ok := c.byID[id]
+	c.mu.Unlock()
+	if ok {
+		return clip, nil
+	}
+
+	if _, err := c.List(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byID[id], nil
+}
+
+// HandleClips registers the clips in catalog with http.DefaultServeMux, listed
+// at /api/clips (optionally only those of a ?camera=, and the newest ?limit=),
+// and served at /clips/{id}, with range requests so that browsers can seek.
+// Clips are only ever served by the IDs listed, never by a path from the
+// request.
+func HandleClips(catalog *ClipCatalog) {
+	http.HandleFunc("/api/clips", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 0
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		clips, err := catalog.List()
+		if err != nil {
+			logError("Listing clips failed", "path", catalog.Dir, "error", err)
+			http.Error(w, "listing clips failed", http.StatusInternalServerError)
+			return
+		}
+		camera := r.URL.Query().Get("camera")
+		listed := []*Clip{}
+		for _, clip := range clips {
+			if camera != "" && clip.Camera != camera {
+				continue
+			}
+			if limit > 0 && len(listed) == limit {
+				break
+			}
+			listed = append(listed, clip)
+		}
+		writeJSON(w, http.StatusOK, listed)
+	})
+
+	http.HandleFunc("/clips/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/clips/")
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 2*clipIDBytes {
+			http.NotFound(w, r)
+			return
+		}
+		clip, err := catalog.Find(id)
+		if err != nil {
+			logError("Listing clips failed", "path", catalog.Dir, "error", err)
+			http.Error(w, "listing clips failed", http.StatusInternalServerError)
+			return
+		}
+		if clip == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(clip.path)
+		if err != nil {
+			// it may have been deleted since
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		ext := strings.ToLower(filepath.Ext(clip.path))
+		contentType := clipTypes[ext]
+		if contentType == "" {
+			contentType = mime.TypeByExtension(ext)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filepath.Base(clip.path)}))
+		// ServeContent handles ranges and conditional requests
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	})
+}
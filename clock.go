@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// clockCheckInterval is how often the wall clock is compared with the
+	// monotonic clock.
+	clockCheckInterval = time.Second
+	// clockStepThreshold is how far the wall clock may drift from the
+	// monotonic clock between checks before it's considered to have stepped.
+	// Gradual NTP adjustments are far smaller.
+	clockStepThreshold = 500 * time.Millisecond
+)
+
+// Clock sync states, as reported in a ClockStatus.
+const (
+	ClockSynced   = "synced"
+	ClockUnsynced = "unsynced"
+	ClockUnknown  = "unknown"
+)
+
+// Clock reads the wall clock, and a monotonic clock, which are separate so
+// that the wall clock stepping can be simulated.
+type Clock interface {
+	// Now returns the wall clock time.
+	Now() time.Time
+	// Mono returns the monotonic time elapsed since some fixed point.
+	Mono() time.Duration
+}
+
+// systemClock is the host's clock, which is what Clock is everywhere but in
+// tests.
+type systemClock struct {
+	start time.Time
+}
+
+// Now returns the wall clock time, with the monotonic reading that time.Now
+// records stripped, since Mono is what's monotonic.
+func (c systemClock) Now() time.Time {
+	return time.Now().Round(0)
+}
+
+// Mono returns the monotonic time elapsed since the clock was created.
+func (c systemClock) Mono() time.Duration {
+	return time.Since(c.start)
+}
+
+// hostSystemClock is the systemClock of the whole program.
+var hostSystemClock Clock = systemClock{start: time.Now()}
+
+// ClockStatus is the state of the host's clock when an event started or
+// ended, so that the event's timestamps can be judged when correlating them
+// with those of other cameras and hosts.
+type ClockStatus struct {
+	// Sync is whether the kernel considers the clock synchronized, e.g. by
+	// NTP: ClockSynced, ClockUnsynced, or ClockUnknown where that can't be
+	// queried.
+	Sync string `json:"sync"`
+	// MaxErrorSeconds and EstErrorSeconds are the kernel's maximum and
+	// estimated error of the clock, if known.
+	MaxErrorSeconds float64 `json:"max_error_seconds,omitempty"`
+	EstErrorSeconds float64 `json:"est_error_seconds,omitempty"`
+	// Steps is the number of times the wall clock has stepped since the
+	// program started.
+	Steps int `json:"steps,omitempty"`
+}
+
+// ClockMonitor watches for the wall clock stepping, such as when NTP first
+// syncs after boot, or the time is set by hand, by comparing it with the
+// monotonic clock. Frame and event durations are all measured with the
+// monotonic clock, which Go records in times from time.Now, so they're
+// unaffected by steps, but the wall clock timestamps of events either side of
+// a step can't be compared, and may be out of order if it stepped backwards.
+// It is safe for concurrent use.
+type ClockMonitor struct {
+	clock    Clock
+	mu       sync.Mutex
+	started  time.Time
+	lastMono time.Duration
+	lastWall time.Time
+	steps    int
+	done     chan struct{}
+}
+
+// hostClock monitors the host's clock for the whole program.
+var hostClock = NewClockMonitor(hostSystemClock)
+
+// NewClockMonitor creates a ClockMonitor of clock, measuring offsets from
+// now. Start must be called for it to check for steps.
+func NewClockMonitor(clock Clock) *ClockMonitor {
+	return &ClockMonitor{clock: clock, started: time.Now(), lastMono: clock.Mono(), lastWall: clock.Now()}
+}
+
+// Start starts checking the clock in the background, until Stop is called.
+func (m *ClockMonitor) Start() {
+	m.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(clockCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops checking the clock.
+func (m *ClockMonitor) Stop() {
+	if m.done != nil {
+		close(m.done)
+	}
+}
+
+// Check compares the wall and monotonic time elapsed since the last check,
+// and returns how far the wall clock stepped, if it did, or 0.
+func (m *ClockMonitor) Check() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wall, mono := m.clock.Now(), m.clock.Mono()
+	step := wall.Sub(m.lastWall) - (mono - m.lastMono)
+	m.lastMono, m.lastWall = mono, wall
+	if step > -clockStepThreshold && step < clockStepThreshold {
+		return 0
+	}
+	m.steps++
+	if step < 0 {
+		clockSteps.Drop("backward")
+		logWarn("The wall clock stepped backwards: event timestamps either side of this may be out of order, though durations are unaffected",
+			"step", -step, "time", wall.Format(time.RFC3339Nano))
+	} else {
+		clockSteps.Drop("forward")
+		logInfo("The wall clock stepped forwards", "step", step, "time", wall.Format(time.RFC3339Nano))
+	}
+	return step
+}
+
+// Offset returns the monotonic time from when the monitor was created to t,
+// which must be from time.Now, or derived from one with Add, for it to be
+// unaffected by steps.
+func (m *ClockMonitor) Offset(t time.Time) time.Duration {
+	return t.Sub(m.started)
+}
+
+// Status returns the current state of the clock.
+func (m *ClockMonitor) Status() *ClockStatus {
+	m.mu.Lock()
+	steps := m.steps
+	m.mu.Unlock()
+	s := &ClockStatus{Sync: ClockUnknown, Steps: steps}
+	synced, maxErr, estErr, err := clockSync()
+	if err != nil {
+		return s
+	}
+	s.Sync = ClockUnsynced
+	if synced {
+		s.Sync = ClockSynced
+	}
+	s.MaxErrorSeconds, s.EstErrorSeconds = maxErr.Seconds(), estErr.Seconds()
+	return s
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+const (
+	// adjtimexError is the state adjtimex returns when the clock isn't
+	// synchronized (TIME_ERROR).
+	adjtimexError = 5
+	// adjtimexUnsync is the status flag set while the clock isn't
+	// synchronized (STA_UNSYNC).
+	adjtimexUnsync = 0x40
+)
+
+// clockSync queries the kernel, with adjtimex, for whether the clock is
+// synchronized, and its maximum and estimated error.
+func clockSync() (bool, time.Duration, time.Duration, error) {
+	// with no modes set, adjtimex only reads, so needs no privileges
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	synced := state != adjtimexError && tx.Status&adjtimexUnsync == 0
+	// the errors are in microseconds
+	maxErr := time.Duration(int64(tx.Maxerror)) * time.Microsecond
+	estErr := time.Duration(int64(tx.Esterror)) * time.Microsecond
+	return synced, maxErr, estErr, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// clockSync isn't implemented outside Linux, so the sync status is reported
+// as unknown.
+func clockSync() (bool, time.Duration, time.Duration, error) {
+	return false, 0, 0, errors.New("clock sync status not available")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// fakeClock is a Clock whose wall clock can be stepped independently of its
+// monotonic clock.
+type fakeClock struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *fakeClock) Now() time.Time      { return c.wall }
+func (c *fakeClock) Mono() time.Duration { return c.mono }
+
+// advance moves both clocks on by d, as time passes.
+func (c *fakeClock) advance(d time.Duration) {
+	c.wall = c.wall.Add(d)
+	c.mono += d
+}
+
+func TestWallClockStepBackwards(t *testing.T) {
+	var logged bytes.Buffer
+	defer func(l *Logger) { logger = l }(logger)
+	logger = &Logger{Level: LevelInfo, sink: &streamSink{w: &logged}}
+	backwards := clockSteps.Drops()["backward"]
+
+	clock := &fakeClock{wall: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	monitor := NewClockMonitor(clock)
+	buf := NewMatBuffer(time.Second, 10)
+	defer buf.Close()
+	buf.clock = clock
+	img := gocv.NewMatWithSize(4, 4, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	// 10 frames at 10fps, with the wall clock stepping back an hour halfway
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			clock.wall = clock.wall.Add(-time.Hour)
+			if step := monitor.Check(); step > -time.Hour+clockStepThreshold {
+				t.Errorf("Check returned a step of %v, want about -1h", step)
+			}
+		}
+		buf.Add(&img, clock.Now())
+		clock.advance(100 * time.Millisecond)
+	}
+
+	if d := buf.Duration(); d != 900*time.Millisecond {
+		t.Errorf("buffer duration is %v, want 900ms", d)
+	}
+	if fps := buf.FPS(); fps <= 0 {
+		t.Errorf("buffer FPS is %v, want more than 0", fps)
+	}
+	if n := clockSteps.Drops()["backward"]; n != backwards+1 {
+		t.Errorf("%d backward steps counted, want %d", n-backwards, 1)
+	}
+	if !strings.Contains(logged.String(), "stepped backwards") {
+		t.Errorf("no warning of the step logged, got %q", logged.String())
+	}
+
+	// time passing normally after the step isn't another
+	clock.advance(time.Second)
+	if step := monitor.Check(); step != 0 {
+		t.Errorf("Check returned a step of %v after the clock ran normally, want 0", step)
+	}
+}
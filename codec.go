@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// commonCodecs are FourCC codecs that are usually available in OpenCV builds,
+// suggested when the requested one isn't.
+var commonCodecs = []string{"mp4v", "avc1", "XVID", "MJPG"}
+
+// ValidateCodec checks that videos can be recorded with the given "FourCC"
+// codec, by opening a throwaway writer with the given extension, FPS and frame
+// dimensions. The temporary file is removed before returning.
+func ValidateCodec(codec, ext string, fps float64, width, height int) error {
+	if len(codec) != 4 {
+		return fmt.Errorf("codec %q must be 4 characters (e.g. %s)", codec, strings.Join(commonCodecs, ", "))
+	}
+	f, err := os.CreateTemp("", "motiondetect-codec-*"+ext)
+	if err != nil {
+		return err
+	}
+	filename := f.Name()
+	f.Close()
+	defer os.Remove(filename)
+
+	if fps <= 0 {
+		fps = 30
+	}
+	vw, err := gocv.VideoWriterFile(filename, codec, fps, width, height, true)
+	if err != nil {
+		return err
+	}
+	defer vw.Close()
+	if !vw.IsOpened() {
+		return fmt.Errorf("codec %q can't be used to write %s files with this OpenCV build; try one of %s",
+			codec, ext, strings.Join(commonCodecs, ", "))
+	}
+	return nil
+}
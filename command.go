@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// commandMaxRunning is the number of commands an EventCommand may have
+// running at once before events start being dropped.
+const commandMaxRunning = 8
+
+// EventCommand runs shell commands when events start and end. Each command is
+// run in the background with the event's fields in MD_* environment variables
+// and its JSON EventPayload on stdin, and its output is logged once it exits.
+// A nil *EventCommand does nothing.
+type EventCommand struct {
+	// Start and End are the commands run when events start and end. Either
+	// may be empty.
+	Start string
+	End   string
+	// Timeout is how long each command may run before it is killed.
+	Timeout time.Duration
+
+	running chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewEventCommand creates an EventCommand running the given commands.
+func NewEventCommand(start, end string, timeout time.Duration) *EventCommand {
+	return &EventCommand{
+		Start:   start,
+		End:     end,
+		Timeout: timeout,
+		running: make(chan struct{}, commandMaxRunning),
+	}
+}
+
+// Notify starts the command for the given phase of ev, if there is one. It
+// never blocks.
+func (c *EventCommand) Notify(ev *MotionEvent, phase string) {
+	if c == nil {
+		return
+	}
+	command := c.Start
+	if phase == PhaseEnd {
+		command = c.End
+	}
+	if command == "" {
+		return
+	}
+	p := ev.Payload(phase)
+	stdin, err := json.Marshal(p)
+	if err != nil {
+		logError("Encoding event for command failed", "camera", p.Camera, "event_id", p.ID, "error", err)
+		return
+	}
+	// the event goes on changing as the capture loop runs, so everything the
+	// command needs from it is taken now
+	env := eventEnv(ev, phase)
+	select {
+	case c.running <- struct{}{}:
+	default:
+		logError("Too many commands running, not running command", "camera", p.Camera, "event_id", p.ID, "phase", phase)
+		notifyFailures.Drop("command")
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() { <-c.running }()
+		c.run(command, p.Camera, p.ID, phase, env, stdin)
+	}()
+}
+
+// Close waits for running commands to exit.
+func (c *EventCommand) Close() {
+	if c == nil {
+		return
+	}
+	c.wg.Wait()
+}
+
+// run runs command for the given phase of the event with the given camera and
+// ID, with env added to its environment and stdin as its input, logging its
+// output.
+func (c *EventCommand) run(command, camera string, id int, phase string, env []string, stdin []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), env...)
+	// each command gets its own copy of the input, so concurrent commands
+	// can't interleave
+	cmd.Stdin = bytes.NewReader(stdin)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		logInfo("Command output", "camera", camera, "event_id", id, "phase", phase, "output", sc.Text())
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		logError("Command killed", "camera", camera, "event_id", id, "phase", phase, "timeout", c.Timeout)
+		notifyFailures.Drop("command")
+	case err != nil:
+		logError("Command failed", "camera", camera, "event_id", id, "phase", phase, "error", err)
+		notifyFailures.Drop("command")
+	}
+}
+
+// shellCommand returns a command running command with the system's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// eventEnv returns the environment variables describing the given phase of
+// ev to a command. Times are in RFC 3339 format, in UTC.
+func eventEnv(ev *MotionEvent, phase string) []string {
+	env := []string{
+		"MD_PHASE=" + phase,
+		"MD_EVENT_ID=" + strconv.Itoa(ev.ID),
+		"MD_CAMERA=" + ev.Camera,
+		"MD_START=" + ev.Start.UTC().Format(time.RFC3339Nano),
+		"MD_SNAPSHOT_PATH=" + ev.Snapshot,
+		"MD_CLIP_PATH=" + ev.Clip,
+		"MD_PEAK_AREA=" + strconv.FormatFloat(ev.PeakArea, 'f', -1, 64),
+		"MD_SUPPRESSED=" + strconv.Itoa(ev.Suppressed),
+		"MD_DURATION=" + strconv.FormatFloat(ev.Duration().Seconds(), 'f', 3, 64),
+	}
+	if !ev.End.IsZero() {
+		env = append(env, "MD_END="+ev.End.UTC().Format(time.RFC3339Nano))
+	}
+	return env
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DetectorConfig is a camera's detection settings, as served and changed by
+// /api/config. Fields left out of a change are left as they are.
+type DetectorConfig struct {
+	Threshold        *float32 `json:"threshold,omitempty"`
+	DilateSize       *int     `json:"dilate_size,omitempty"`
+	MinArea          *float64 `json:"min_area,omitempty"`
+	DrawContours     *bool    `json:"draw_contours,omitempty"`
+	DrawRects        *bool    `json:"draw_rects,omitempty"`
+	DetectionEnabled *bool    `json:"detection_enabled,omitempty"`
+}
+
+// Validate returns a message for each field of the config with an invalid
+// value, keyed by its JSON name.
+func (d DetectorConfig) Validate() map[string]string {
+	errs := map[string]string{}
+	if d.Threshold != nil && (*d.Threshold <= 0 || *d.Threshold > 255) {
+		errs["threshold"] = "must be greater than 0 and at most 255"
+	}
+	if d.DilateSize != nil && *d.DilateSize <= 0 {
+		errs["dilate_size"] = "must be greater than 0"
+	}
+	if d.MinArea != nil && *d.MinArea <= 0 {
+		errs["min_area"] = "must be greater than 0"
+	}
+	return errs
+}
+
+// configRequest is a change to a camera's detection settings, waiting to be
+// applied by its capture loop, which replies with the resulting settings.
+type configRequest struct {
+	change DetectorConfig
+	reply  chan DetectorConfig
+}
+
+// Configure applies change to the camera's detection settings between frames,
+// so that they never change while one is being processed, and returns the
+// resulting settings. An empty change just returns them. It returns false if
+// the capture loop has ended, or ctx is done first, e.g. as the loop is stuck
+// waiting for a stalled stream, in which case the change may still be applied
+// once it gets to it.
+func (c *Camera) Configure(ctx context.Context, change DetectorConfig) (DetectorConfig, bool) {
+	req := configRequest{change, make(chan DetectorConfig, 1)}
+	select {
+	case c.configs <- req:
+	case <-c.done:
+		return DetectorConfig{}, false
+	case <-ctx.Done():
+		return DetectorConfig{}, false
+	}
+	select {
+	case cfg := <-req.reply:
+		return cfg, true
+	case <-ctx.Done():
+		return DetectorConfig{}, false
+	}
+}
+
+// handleConfigs applies the changes passed to Configure since the last frame.
+func (c *Camera) handleConfigs() {
+	for {
+		select {
+		case req := <-c.configs:
+			c.applyConfig(req.change)
+			req.reply <- c.config()
+		default:
+			return
+		}
+	}
+}
+
+// config returns a copy of the camera's current detection settings.
+func (c *Camera) config() DetectorConfig {
+	var (
+		d          = c.Detector
+		threshold  = d.Threshold
+		dilateSize = d.DilateSize
+		minArea    = d.MinimumContourArea
+		contours   = d.DrawContours
+		rects      = d.DrawRects
+		enabled    = c.DetectionEnabled
+	)
+	return DetectorConfig{
+		Threshold:        &threshold,
+		DilateSize:       &dilateSize,
+		MinArea:          &minArea,
+		DrawContours:     &contours,
+		DrawRects:        &rects,
+		DetectionEnabled: &enabled,
+	}
+}
+
+// applyConfig applies a validated change to the camera's detection settings,
+// logging each setting that changes.
+func (c *Camera) applyConfig(change DetectorConfig) {
+	d := c.Detector
+	set := func(name string, old, new interface{}) {
+		if old != new {
+			logInfo("Changed setting", "camera", c.Name, "setting", name, "old", old, "new", new)
+		}
+	}
+	if v := change.Threshold; v != nil {
+		set("threshold", d.Threshold, *v)
+		d.Threshold = *v
+	}
+	if v := change.DilateSize; v != nil {
+		set("dilate_size", d.DilateSize, *v)
+		d.DilateSize = *v
+	}
+	if v := change.MinArea; v != nil {
+		set("min_area", d.MinimumContourArea, *v)
+		d.MinimumContourArea = *v
+	}
+	if v := change.DrawContours; v != nil {
+		set("draw_contours", d.DrawContours, *v)
+		d.DrawContours = *v
+	}
+	if v := change.DrawRects; v != nil {
+		set("draw_rects", d.DrawRects, *v)
+		d.DrawRects = *v
+	}
+	if v := change.DetectionEnabled; v != nil {
+		set("detection_enabled", c.DetectionEnabled, *v)
+		c.DetectionEnabled = *v
+	}
+}
+
+// HandleConfig registers /api/config with http.DefaultServeMux. GET returns
+// a camera's detection settings as a DetectorConfig, and PATCH changes those
+// given, all at once or not at all. The camera is chosen by name with the
+// query parameter camera, and defaults to the first.
+func HandleConfig(cams []*Camera) {
+	http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		c := requestCamera(w, r, cams)
+		if c == nil {
+			return
+		}
+
+		var change DetectorConfig
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPatch:
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&change); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if errs := change.Validate(); len(errs) > 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PATCH")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg, ok := c.Configure(r.Context(), change)
+		if !ok {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": configureError(r.Context())})
+			return
+		}
+		writeJSON(w, http.StatusOK, cfg)
+	})
+}
+
+// configureError returns why Configure failed, given the context it was
+// called with.
+func configureError(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return "camera isn't responding"
+	}
+	return "camera has stopped"
+}
+
+// requestCamera returns the camera named by the request's camera query
+// parameter, or the first if there's none. If there's no such camera, it
+// responds 404, and returns nil.
+func requestCamera(w http.ResponseWriter, r *http.Request, cams []*Camera) *Camera {
+	name := r.URL.Query().Get("camera")
+	if c := cameraNamed(cams, name); c != nil {
+		return c
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no camera named %q", name)})
+	return nil
+}
+
+// cameraNamed returns the camera with the given name, or the first if name is
+// empty, or nil if there's no such camera.
+func cameraNamed(cams []*Camera, name string) *Camera {
+	if name == "" {
+		return cams[0]
+	}
+	for _, c := range cams {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logError("Writing response failed", "error", err)
+	}
+}
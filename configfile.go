@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile is a configuration file, which sets flags, so that everything
+// that can be set on the command line can be set in it, with flags given on
+// the command line taking precedence over the file, and the file over the
+// defaults.
+//
+// It's a subset of YAML: each key is the name of a flag, optionally grouped
+// under sections named after its prefix, or any other name, so that these
+// are equivalent:
+//
+//	http-addr: ":8080"
+//
+//	http:
+//	  addr: ":8080"
+//
+// Values are scalars, plain or quoted, or, for flags that may be repeated or
+// take comma-separated lists, sequences, either as "- item" lines or [a, b].
+// The cameras key lists the cameras, as they'd be given as arguments, which
+// are used if none are given on the command line.
+type ConfigFile struct {
+	Path string
+	// Cameras are the cameras listed under the cameras key.
+	Cameras []string
+	// Entries are the settings, in the order they appear in the file.
+	Entries []ConfigEntry
+}
+
+// ConfigEntry is a setting from a ConfigFile.
+type ConfigEntry struct {
+	// Key is the key's path, from its outermost section.
+	Key []string
+	// Values are the setting's values, of which there's one unless it was a
+	// sequence.
+	Values []string
+	List   bool
+	Line   int
+}
+
+// String returns the entry's key, with its sections joined by dots.
+func (e ConfigEntry) String() string {
+	return strings.Join(e.Key, ".")
+}
+
+// configLine is a line of a configuration file, without its indentation and
+// comment.
+type configLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// LoadConfigFile reads and parses the configuration file at path.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := parseConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// parseConfigFile parses a configuration file from r.
+func parseConfigFile(r io.Reader) (*ConfigFile, error) {
+	var lines []configLine
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		raw := scanner.Text()
+		if num == 1 {
+			raw = strings.TrimPrefix(raw, "\ufeff")
+		}
+		text := strings.TrimRight(stripConfigComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indented with a tab, rather than spaces", num)
+		}
+		lines = append(lines, configLine{num, len(text) - len(trimmed), trimmed})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cfg := &ConfigFile{}
+	rest, err := cfg.parseBlock(lines, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected indentation", rest[0].num)
+	}
+	for i, e := range cfg.Entries {
+		if len(e.Key) == 1 && e.Key[0] == "cameras" {
+			cfg.Cameras = e.Values
+			cfg.Entries = append(cfg.Entries[:i:i], cfg.Entries[i+1:]...)
+			break
+		}
+	}
+	return cfg, nil
+}
+
+// parseBlock parses the mapping at the start of lines, indented by indent,
+// under the section path, returning the lines after it.
+func (cfg *ConfigFile) parseBlock(lines []configLine, indent int, path []string) ([]configLine, error) {
+	for len(lines) > 0 {
+		l := lines[0]
+		if l.indent < indent {
+			return lines, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if strings.HasPrefix(l.text, "- ") || l.text == "-" {
+			return nil, fmt.Errorf("line %d: a sequence item outside of a sequence", l.num)
+		}
+		name, value, err := splitConfigKey(l)
+		if err != nil {
+			return nil, err
+		}
+		key := append(path[:len(path):len(path)], name)
+		lines = lines[1:]
+
+		switch {
+		case value != "":
+			e := ConfigEntry{Key: key, Line: l.num}
+			if strings.HasPrefix(value, "[") {
+				if e.Values, err = parseConfigFlowList(value); err != nil {
+					return nil, fmt.Errorf("line %d: %v", l.num, err)
+				}
+				e.List = true
+			} else {
+				v, err := parseConfigScalar(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", l.num, err)
+				}
+				e.Values = []string{v}
+			}
+			cfg.Entries = append(cfg.Entries, e)
+		case len(lines) > 0 && lines[0].indent > indent && strings.HasPrefix(lines[0].text, "-"):
+			e := ConfigEntry{Key: key, Line: l.num, List: true}
+			seqIndent := lines[0].indent
+			for len(lines) > 0 && lines[0].indent == seqIndent && strings.HasPrefix(lines[0].text, "-") {
+				item := strings.TrimSpace(strings.TrimPrefix(lines[0].text, "-"))
+				v, err := parseConfigScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lines[0].num, err)
+				}
+				e.Values = append(e.Values, v)
+				lines = lines[1:]
+			}
+			if len(lines) > 0 && lines[0].indent > indent {
+				return nil, fmt.Errorf("line %d: sequence items must be scalars", lines[0].num)
+			}
+			cfg.Entries = append(cfg.Entries, e)
+		case len(lines) > 0 && lines[0].indent > indent:
+			if lines, err = cfg.parseBlock(lines, lines[0].indent, key); err != nil {
+				return nil, err
+			}
+		default:
+			// an empty value
+			cfg.Entries = append(cfg.Entries, ConfigEntry{Key: key, Values: []string{""}, Line: l.num})
+		}
+	}
+	return lines, nil
+}
+
+// splitConfigKey splits a "key: value" line.
+func splitConfigKey(l configLine) (string, string, error) {
+	i := strings.Index(l.text, ":")
+	for i >= 0 && i+1 < len(l.text) && l.text[i+1] != ' ' {
+		// a colon in the key, or in a plain value with no key
+		j := strings.Index(l.text[i+1:], ":")
+		if j < 0 {
+			i = -1
+			break
+		}
+		i += 1 + j
+	}
+	if i <= 0 {
+		return "", "", fmt.Errorf("line %d: expected key: value", l.num)
+	}
+	key, err := parseConfigScalar(strings.TrimSpace(l.text[:i]))
+	if err != nil {
+		return "", "", fmt.Errorf("line %d: %v", l.num, err)
+	}
+	return key, strings.TrimSpace(l.text[i+1:]), nil
+}
+
+// stripConfigComment removes a comment from a line: from a # at its start,
+// or after a space, outside of quotes.
+func stripConfigComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[,:", s[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseConfigScalar parses a plain, single-quoted or double-quoted scalar.
+func parseConfigScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid single-quoted string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s == "~" || s == "null":
+		return "", nil
+	}
+	return s, nil
+}
+
+// parseConfigFlowList parses a sequence written as [a, b, c].
+func parseConfigFlowList(s string) ([]string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated sequence %s", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return nil, nil
+	}
+	var (
+		values []string
+		quote  byte
+		start  int
+	)
+	add := func(item string) error {
+		v, err := parseConfigScalar(strings.TrimSpace(item))
+		if err == nil {
+			values = append(values, v)
+		}
+		return err
+	}
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			if err := add(inner[start:i]); err != nil {
+				return nil, err
+			}
+			start = i + 1
+		}
+	}
+	if err := add(inner[start:]); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// repeatableFlag is implemented by flag values that may be given more than
+// once, each adding to the list.
+type repeatableFlag interface {
+	flag.Value
+	repeatable()
+}
+
+func (l *stringList) repeatable() {}
+
+// isBoolFlag reports whether f is a boolean flag, which may be given without
+// a value on the command line.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Flag returns the flag the entry sets: the one named by its key, with its
+// sections joined by dashes, or failing that, without its outermost
+// sections, one at a time.
+func (e ConfigEntry) Flag(fs *flag.FlagSet) *flag.Flag {
+	for i := range e.Key {
+		if f := fs.Lookup(strings.Join(e.Key[i:], "-")); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// Apply sets the flags in fs from the file's entries, except those in skip,
+// such as the flags given on the command line, returning a warning for each
+// key that isn't a flag, and the names of the flags set. Sequences set
+// repeatable flags once per item, and others to the comma-separated items.
+func (cfg *ConfigFile) Apply(fs *flag.FlagSet, skip map[string]bool) (warnings []string, set map[string]bool, err error) {
+	set = make(map[string]bool)
+	for _, e := range cfg.Entries {
+		f := e.Flag(fs)
+		if f == nil || f.Name == "config" {
+			warnings = append(warnings, fmt.Sprintf("%s line %d: unknown key %q", cfg.Path, e.Line, e.String()))
+			continue
+		}
+		if skip[f.Name] {
+			continue
+		}
+		values := e.Values
+		_, repeatable := f.Value.(repeatableFlag)
+		if e.List && !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		if repeatable && !set[f.Name] {
+			// the file's items replace the default, rather than adding to it
+			if l, ok := f.Value.(*stringList); ok {
+				*l = nil
+			}
+		}
+		for _, v := range values {
+			if repeatable && v == "" {
+				continue
+			}
+			if isBoolFlag(f) {
+				v = configBool(v)
+			}
+			if err := fs.Set(f.Name, v); err != nil {
+				return warnings, set, fmt.Errorf("%s line %d: invalid value %q for %s: %v", cfg.Path, e.Line, v, e.String(), err)
+			}
+		}
+		set[f.Name] = true
+	}
+	return warnings, set, nil
+}
+
+// configBool maps the YAML spellings of booleans to those flag.ParseBool
+// accepts.
+func configBool(v string) string {
+	switch strings.ToLower(v) {
+	case "yes", "y", "on", "":
+		return "true"
+	case "no", "n", "off":
+		return "false"
+	}
+	return v
+}
+
+// secretFlag reports whether the named flag holds a secret, which config
+// print leaves out by default.
+func secretFlag(name string) bool {
+	for _, s := range []string{"password", "token", "secret", "access-key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// configScalar formats v as a YAML scalar, quoting it if necessary.
+func configScalar(v string) string {
+	if v == "" || strings.TrimSpace(v) != v || strings.ContainsAny(v, ":#[]{},\"'&*!|>%@`\\\n\t") || strings.HasPrefix(v, "-") || strings.HasPrefix(v, "~") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// writeEffectiveConfig writes the effective configuration, as a file that
+// would produce it, noting where each setting came from: the command line,
+// the configuration file, or the defaults. Secrets are redacted unless
+// showSecrets is set.
+func writeEffectiveConfig(w io.Writer, fs *flag.FlagSet, cameras []string, fromFlags, fromFile map[string]bool, path string, showSecrets bool) {
+	fmt.Fprintln(w, "# Effective configuration: command-line flags override the configuration")
+	if path != "" {
+		fmt.Fprintf(w, "# file, %s, which overrides the defaults.\n", path)
+	} else {
+		fmt.Fprintln(w, "# file, of which there's none, which overrides the defaults.")
+	}
+	if len(cameras) > 0 {
+		fmt.Fprintln(w, "cameras:")
+		for _, c := range cameras {
+			fmt.Fprintf(w, "  - %s\n", configScalar(c))
+		}
+	}
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for _, name := range names {
+		f := fs.Lookup(name)
+		source := "default"
+		switch {
+		case fromFlags[name]:
+			source = "flag"
+		case fromFile[name]:
+			source = "file"
+		}
+		if _, ok := f.Value.(repeatableFlag); ok {
+			fmt.Fprintf(w, "%s:%s # %s\n", name, strings.Repeat(" ", width-len(name)+1), source)
+			if l, ok := f.Value.(*stringList); ok {
+				for _, v := range *l {
+					fmt.Fprintf(w, "  - %s\n", configScalar(v))
+				}
+			}
+			continue
+		}
+		value := configScalar(f.Value.String())
+		if secretFlag(name) && f.Value.String() != "" && !showSecrets {
+			value = `"<redacted>"`
+		}
+		fmt.Fprintf(w, "%s:%s %s # %s\n", name, strings.Repeat(" ", width-len(name)+1), value, source)
+	}
+}
+
+// loadConfig reads the configuration file at path, if set, and applies it to
+// the flags not given on the command line, exiting if it's invalid. It
+// returns the file, which is nil if there's none, the names of the flags given
+// on the command line and those set by the file, and a warning for each
+// unknown key, to be logged once logging is set up.
+func loadConfig(path string) (cfg *ConfigFile, fromFlags, fromFile map[string]bool, warnings []string) {
+	fromFlags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+	if path == "" {
+		return nil, fromFlags, nil, nil
+	}
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		log.Fatalf("Invalid -config: %v", err)
+	}
+	if warnings, fromFile, err = cfg.Apply(flag.CommandLine, fromFlags); err != nil {
+		log.Fatalf("Invalid -config: %v", err)
+	}
+	return cfg, fromFlags, fromFile, warnings
+}
+
+// runConfigCommand implements the "config" subcommand, of which "config
+// print" prints the effective configuration.
+func runConfigCommand(args []string, cfg *ConfigFile, cameras []string, fromFlags, fromFile map[string]bool) {
+	if len(args) == 0 || args[0] != "print" {
+		log.Fatal("Usage: config print [-show-secrets]")
+	}
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	showSecrets := fs.Bool("show-secrets", false, "include passwords, tokens and keys, rather than redacting them")
+	fs.Parse(args[1:])
+
+	path := ""
+	if cfg != nil {
+		path = cfg.Path
+	}
+	writeEffectiveConfig(os.Stdout, flag.CommandLine, cameras, fromFlags, fromFile, path, *showSecrets)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// continuousRetry is how long ContinuousRecorder waits before trying to start
+// a new file after failing to.
+const continuousRetry = 10 * time.Second
+
+// ContinuousRecorder records every frame to rolling files of SegmentLength
+// each, named by an OutputTemplate, independently of any motion events. Like
+// Recorder, files are written under a hidden name and renamed once done.
+type ContinuousRecorder struct {
+	// Dir is the directory relative to which Template is expanded.
+	Dir string
+	// Template names the recorded files. {seq} is the file's sequence number.
+	Template *OutputTemplate
+	// Camera is the camera name used in filenames and logs.
+	Camera string
+	// Codec is the "FourCC" codec to record with (e.g. "mp4v").
+	Codec string
+	// FPS is used for the first file, until the FPS can be measured.
+	FPS float64
+	// SegmentLength is the length of each file.
+	SegmentLength time.Duration
+	// Chapters writes a chapters sidecar for each saved file, marking the
+	// spans with motion.
+	Chapters bool
+	// Annotate, if set, is applied to each frame as it is written.
+	Annotate FrameAnnotator
+	// Space, if set, is checked for enough free disk space before each file
+	// is started.
+	Space *SpaceGuard
+	// OnSave, if set, is called in the background with the name of each file
+	// once it has been saved.
+	OnSave func(filename string)
+
+	current    *SegmentWriter
+	seq        int
+	start      time.Time
+	last       time.Time
+	retryAt    time.Time
+	frames     int
+	firstFrame time.Time
+	wg         sync.WaitGroup
+}
+
+// filename returns the final filename of the current file, assuming it ends
+// at time end.
+func (r *ContinuousRecorder) filename(end time.Time) string {
+	return OutputPath(r.Dir, r.Template.Expand(TemplateFields{
+		Camera:   r.Camera,
+		Seq:      r.seq,
+		Part:     1,
+		Start:    r.start,
+		Duration: end.Sub(r.start),
+	}))
+}
+
+// Add copies a frame captured at time t, with the given area of motion, to the
+// current file, first starting a new file if the current one has reached
+// SegmentLength.
+func (r *ContinuousRecorder) Add(img *gocv.Mat, t time.Time, area float64) {
+	if r.firstFrame.IsZero() {
+		r.firstFrame = t
+	}
+	r.frames++
+
+	if r.current != nil && t.Sub(r.start) >= r.SegmentLength {
+		r.close()
+	}
+	if r.current == nil {
+		if t.Before(r.retryAt) {
+			return
+		}
+		if err := r.open(t, int64(img.Total()*img.Channels())); err != nil {
+			logError("Not recording continuously", "camera", r.Camera, "retry_in", continuousRetry, "error", err)
+			r.retryAt = t.Add(continuousRetry)
+			return
+		}
+	}
+	m := img.Clone()
+	if !r.current.Add(&m, t, area) {
+		drops.Drop("rec")
+	}
+	r.last = t
+}
+
+// open starts a new file at time t, for frames of the given raw size.
+func (r *ContinuousRecorder) open(t time.Time, frameSize int64) error {
+	fps := r.FPS
+	if elapsed := t.Sub(r.firstFrame); elapsed >= time.Second {
+		fps = float64(r.frames-1) / elapsed.Seconds()
+	}
+
+	r.seq++
+	r.start = t
+	dir := filepath.Dir(r.filename(t))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := r.Space.Check(dir, frameSize, int(r.SegmentLength.Seconds()*fps)); err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, fmt.Sprintf(".continuous-%d%s", r.seq, filepath.Ext(r.Template.String())))
+	r.current = NewSegmentWriter(filename, r.Codec, fps, recorderQueue)
+	r.current.Annotate = r.Annotate
+	return nil
+}
+
+// close finalizes the current file in the background.
+func (r *ContinuousRecorder) close() {
+	w, filename := r.current, r.filename(r.last)
+	r.current = nil
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := finalizeSegment(w, filename); err != nil {
+			logError("Saving continuous recording failed", "camera", r.Camera, "path", filename, "error", err)
+			os.Remove(w.Filename)
+			return
+		}
+		first, last := w.TimeWindow()
+		logInfo("Saved continuous recording", "camera", r.Camera, "path", filename, "frames", w.Count(), "duration", last.Sub(first))
+		if r.Chapters {
+			if err := WriteChapters(filename, w.Chapters(), ChapterTitle(nil)); err != nil {
+				logError("Writing chapters failed", "camera", r.Camera, "path", filename, "error", err)
+			}
+		}
+		if r.OnSave != nil {
+			r.OnSave(filename)
+		}
+	}()
+}
+
+// Close finalizes the current file, if any, and waits for all files to be
+// saved.
+func (r *ContinuousRecorder) Close() {
+	if r.current != nil {
+		r.close()
+	}
+	r.wg.Wait()
+}
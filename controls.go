@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+
+	"gocv.io/x/gocv"
+)
+
+// autoExposureModes maps the values of -auto-exposure to the values of
+// VideoCaptureAutoExposure that select them. These are what the V4L2 backend
+// expects; other backends may differ.
+var autoExposureModes = map[string]float64{
+	"off": 0.25,
+	"on":  0.75,
+}
+
+// optionalFloat is a flag.Value for a number that may be left unset, for
+// device controls that should be left alone unless asked for.
+type optionalFloat struct {
+	value float64
+	set   bool
+}
+
+func (f *optionalFloat) String() string {
+	if !f.set {
+		return ""
+	}
+	return strconv.FormatFloat(f.value, 'g', -1, 64)
+}
+
+func (f *optionalFloat) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	f.value, f.set = v, true
+	return nil
+}
+
+var (
+	exposureFlag   optionalFloat
+	gainFlag       optionalFloat
+	brightnessFlag optionalFloat
+)
+
+func init() {
+	flag.Var(&exposureFlag, "exposure", "exposure to set on device inputs, in the device's units; turns auto exposure off unless -auto-exposure is set (nudge with '[' and ']')")
+	flag.Var(&gainFlag, "gain", "gain to set on device inputs, in the device's units")
+	flag.Var(&brightnessFlag, "brightness", "brightness to set on device inputs, in the device's units")
+}
+
+// deviceControls are the image controls that can be set on devices, with the
+// flags that set them.
+var deviceControls = []struct {
+	name string
+	prop gocv.VideoCaptureProperties
+	flag *optionalFloat
+}{
+	{"exposure", gocv.VideoCaptureExposure, &exposureFlag},
+	{"gain", gocv.VideoCaptureGain, &gainFlag},
+	{"brightness", gocv.VideoCaptureBrightness, &brightnessFlag},
+}
+
+// setDeviceProp sets a property of a device, and returns the value read back.
+// Devices silently ignore properties they don't support, and round others to
+// what they do, so a warning is logged if the value read back differs.
+func setDeviceProp(vc *gocv.VideoCapture, source, name string, prop gocv.VideoCaptureProperties, value float64) float64 {
+	vc.Set(prop, value)
+	got := vc.Get(prop)
+	if math.Abs(got-value) > 0.01 {
+		logWarn("Device is using a different value than requested", "source", source, "setting", name, "got", got, "requested", value)
+	}
+	return got
+}
+
+// configureControls sets the controls given by -auto-exposure, -exposure,
+// -gain and -brightness on a device.
+func configureControls(vc *gocv.VideoCapture, source string) {
+	mode := *autoExposure
+	if mode == "" && exposureFlag.set {
+		// an exposure is ignored by most devices while auto exposure is on
+		mode = "off"
+	}
+	if mode != "" {
+		setDeviceProp(vc, source, "auto exposure", gocv.VideoCaptureAutoExposure, autoExposureModes[mode])
+	}
+	for _, ctl := range deviceControls {
+		if ctl.flag.set {
+			setDeviceProp(vc, source, ctl.name, ctl.prop, ctl.flag.value)
+		}
+	}
+}
+
+// deviceControlStatus describes the current image controls of a device, for
+// the HUD.
+func deviceControlStatus(vc *gocv.VideoCapture) string {
+	s := fmt.Sprintf("auto exposure=%v", vc.Get(gocv.VideoCaptureAutoExposure))
+	for _, ctl := range deviceControls {
+		s += fmt.Sprintf(" %s=%v", ctl.name, vc.Get(ctl.prop))
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dashboardEvents is the number of recent events the dashboard lists.
+const dashboardEvents = 50
+
+// dashboardFiles is the dashboard page and its assets, so that the binary is
+// self-contained.
+//
+//go:embed web
+var dashboardFiles embed.FS
+
+// RecentEvents keeps the latest state of the most recent events, for the
+// dashboard. It is safe for concurrent use, and a nil *RecentEvents does
+// nothing.
+type RecentEvents struct {
+	// Max is the number of events kept.
+	Max int
+
+	mu sync.Mutex
+	// events is oldest first
+	events []*EventPayload
+}
+
+// NewRecentEvents creates a RecentEvents keeping up to max events.
+func NewRecentEvents(max int) *RecentEvents {
+	return &RecentEvents{Max: max}
+}
+
+// Add adds events that have already ended, e.g. from an EventStore, oldest
+// first.
+func (r *RecentEvents) Add(evs []*MotionEvent) {
+	for _, ev := range evs {
+		r.Notify(ev, PhaseEnd)
+	}
+}
+
+// Notify records the event in the given phase, replacing its previous state.
+func (r *RecentEvents) Notify(ev *MotionEvent, phase string) {
+	if r == nil {
+		return
+	}
+	p := ev.Payload(phase)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].ID == p.ID && r.events[i].Camera == p.Camera {
+			r.events[i] = p
+			return
+		}
+	}
+	r.events = append(r.events, p)
+	if len(r.events) > r.Max {
+		r.events = r.events[len(r.events)-r.Max:]
+	}
+}
+
+// Close does nothing.
+func (r *RecentEvents) Close() {}
+
+// Events returns the events kept, newest first.
+func (r *RecentEvents) Events() []*EventPayload {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	evs := make([]*EventPayload, len(r.events))
+	for i, p := range r.events {
+		evs[len(evs)-1-i] = p
+	}
+	return evs
+}
+
+// dashboardEvent is an event as listed by /api/events, with the URLs its
+// files are served at under /media/.
+type dashboardEvent struct {
+	*EventPayload
+	SnapshotURL  string `json:"snapshot_url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	ClipURL      string `json:"clip_url,omitempty"`
+}
+
+// HandleDashboard registers the dashboard at / with http.DefaultServeMux,
+// along with the recent events it lists at /api/events, and the files in dir
+// at /media/, for their snapshots, thumbnails and clips.
+func HandleDashboard(recent *RecentEvents, dir string) {
+	web, err := fs.Sub(dashboardFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	http.Handle("/", http.FileServer(http.FS(web)))
+
+	http.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		evs := []dashboardEvent{}
+		for _, p := range recent.Events() {
+			evs = append(evs, dashboardEvent{
+				EventPayload: p,
+				SnapshotURL:  mediaURL(dir, p.Snapshot),
+				ThumbnailURL: mediaURL(dir, p.Thumbnail),
+				ClipURL:      mediaURL(dir, p.Clip),
+			})
+		}
+		writeJSON(w, http.StatusOK, evs)
+	})
+
+	files := http.StripPrefix("/media/", http.FileServer(http.Dir(dir)))
+	http.HandleFunc("/media/", func(w http.ResponseWriter, r *http.Request) {
+		// no directory listings, or hidden files, such as those still being
+		// written
+		if strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(path.Base(r.URL.Path), ".") {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// mediaURL returns the URL at which filename is served under /media/, or ""
+// if it's empty or outside dir.
+func mediaURL(dir, filename string) string {
+	if filename == "" {
+		return ""
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(absDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return (&url.URL{Path: "/media/" + filepath.ToSlash(rel)}).EscapedPath()
+}
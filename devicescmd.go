@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// deviceInfo is what probing a capture device found out about it.
+type deviceInfo struct {
+	Index  int
+	Path   string
+	Name   string
+	Status string
+	Width  int
+	Height int
+	FPS    float64
+}
+
+// runDevicesCommand implements the "devices" subcommand, which lists the
+// capture devices that can be opened, by index.
+func runDevicesCommand(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	var (
+		maxIndex = fs.Int("max", 10, "probe device indices from 0 up to, but not including, this")
+		timeout  = fs.Duration("timeout", 5*time.Second, "skip devices that take longer than this to open and read")
+		api      = fs.String("backend", "any", "capture backend to probe devices with")
+		backends = fs.Bool("backends", false, "list the capture backends accepted by -backend instead of probing devices")
+	)
+	fs.Parse(args)
+
+	if *backends {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "BACKEND\tPLATFORMS")
+		for _, name := range backendNames() {
+			fmt.Fprintf(tw, "%s\t%s\n", name, captureBackends[name].platforms)
+		}
+		tw.Flush()
+		fmt.Println("\nBackends are only available if OpenCV was built with them.")
+		return
+	}
+	capi, err := parseBackend(*api)
+	if err != nil {
+		log.Fatalf("Invalid -backend: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tDEVICE\tNAME\tSTATUS\tRESOLUTION\tFPS")
+	for i := 0; i < *maxIndex; i++ {
+		info := probeDevice(i, capi, *timeout)
+		if info.Path == "" && info.Status == "unavailable" {
+			// nothing there at all, which isn't worth listing
+			continue
+		}
+		res, fps := "-", "-"
+		if info.Width > 0 {
+			res = fmt.Sprintf("%dx%d", info.Width, info.Height)
+			fps = fmt.Sprintf("%0.1f", info.FPS)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", info.Index, orDash(info.Path), orDash(info.Name), info.Status, res, fps)
+	}
+	tw.Flush()
+}
+
+// probeDevice opens the device with the given index using the given capture
+// API, and reads a frame from it. Devices that block are given up on after
+// timeout; the goroutine probing them is left behind, since OpenCV can't
+// interrupt it, but the process is about to exit anyway.
+func probeDevice(index int, api gocv.VideoCaptureAPI, timeout time.Duration) deviceInfo {
+	info := deviceInfo{Index: index}
+	// on Linux, index N is /dev/videoN, and sysfs has the card name
+	if dev := fmt.Sprintf("/dev/video%d", index); fileExists(dev) {
+		info.Path = dev
+		if name, err := os.ReadFile(filepath.Join("/sys/class/video4linux", filepath.Base(dev), "name")); err == nil {
+			info.Name = strings.TrimSpace(string(name))
+		}
+	}
+
+	result := make(chan deviceInfo, 1)
+	go func() {
+		probed := info
+		vc, err := gocv.OpenVideoCaptureWithAPI(index, api)
+		defer vc.Close()
+		if err != nil || !vc.IsOpened() {
+			probed.Status = "unavailable"
+			result <- probed
+			return
+		}
+		probed.Width = int(vc.Get(gocv.VideoCaptureFrameWidth))
+		probed.Height = int(vc.Get(gocv.VideoCaptureFrameHeight))
+		probed.FPS = vc.Get(gocv.VideoCaptureFPS)
+
+		img := gocv.NewMat()
+		defer img.Close()
+		if vc.Read(&img) && !img.Empty() {
+			probed.Status = "ok"
+		} else {
+			probed.Status = "opens, but no frames"
+		}
+		result <- probed
+	}()
+
+	select {
+	case probed := <-result:
+		return probed
+	case <-time.After(timeout):
+		info.Status = fmt.Sprintf("timed out after %v", timeout)
+		return info
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// discoveredService is an instance found by the "discover" subcommand.
+type discoveredService struct {
+	Name string
+	Host []string
+	Port uint16
+	Text []string
+}
+
+// txt returns the value of key in the service's TXT record.
+func (s *discoveredService) txt(key string) string {
+	for _, kv := range s.Text {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// runDiscoverCommand implements the "discover" subcommand, which browses the
+// local network for instances announced with -mdns and lists them.
+func runDiscoverCommand(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	var (
+		timeout = fs.Duration("timeout", 3*time.Second, "how long to wait for instances to answer")
+		all     = fs.Bool("all", false, "list all HTTP services, not only motiondetect instances")
+	)
+	fs.Parse(args)
+
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		log.Fatalf("Error browsing: %v", err)
+	}
+	defer conn.Close()
+	query := appendDNSMessage(nil, 0, false, []dnsQuestion{{Name: parseDNSName(mdnsService), Type: dnsTypePTR}}, nil, nil)
+	if _, err := conn.WriteToUDP(query, group); err != nil {
+		log.Fatalf("Error browsing: %v", err)
+	}
+
+	var (
+		instances [][]string
+		srvs      = make(map[string]dnsRR)
+		txts      = make(map[string][]string)
+		ips       = make(map[string][]net.IP)
+	)
+	key := func(name []string) string { return strings.ToLower(strings.Join(name, ".")) }
+	conn.SetReadDeadline(time.Now().Add(*timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		_, response, _, rrs, err := parseDNSMessage(buf[:n])
+		if err != nil || !response {
+			continue
+		}
+		for _, rr := range rrs {
+			switch rr.Type {
+			case dnsTypePTR:
+				if dnsNameEqual(rr.Name, parseDNSName(mdnsService)) {
+					instances = append(instances, rr.Target)
+				}
+			case dnsTypeSRV:
+				srvs[key(rr.Name)] = rr
+			case dnsTypeTXT:
+				txts[key(rr.Name)] = rr.Text
+			case dnsTypeA:
+				k := key(rr.Name)
+				found := false
+				for _, ip := range ips[k] {
+					found = found || ip.Equal(rr.IP)
+				}
+				if !found {
+					ips[k] = append(ips[k], rr.IP)
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var services []*discoveredService
+	for _, name := range instances {
+		k := key(name)
+		if seen[k] || len(name) == 0 {
+			continue
+		}
+		seen[k] = true
+		s := &discoveredService{Name: name[0], Text: txts[k]}
+		if srv, ok := srvs[k]; ok {
+			s.Port = srv.Port
+			for _, ip := range ips[key(srv.Target)] {
+				s.Host = append(s.Host, ip.String())
+			}
+			if len(s.Host) == 0 {
+				s.Host = []string{strings.Join(srv.Target, ".")}
+			}
+		}
+		found := false
+		for _, kv := range s.Text {
+			found = found || kv == mdnsApp
+		}
+		if found || *all {
+			services = append(services, s)
+		}
+	}
+	if len(services) == 0 {
+		fmt.Fprintln(os.Stderr, "No instances found")
+		return
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tCAMERAS\tVERSION")
+	for _, s := range services {
+		scheme := s.txt("scheme")
+		if scheme == "" {
+			scheme = "http"
+		}
+		var addrs []string
+		for _, h := range s.Host {
+			addrs = append(addrs, fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(h, fmt.Sprint(s.Port)), s.txt("path")))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, orDash(strings.Join(addrs, " ")), orDash(s.txt("cameras")), orDash(s.txt("version")))
+	}
+	tw.Flush()
+}
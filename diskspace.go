@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNoSpace is returned when there isn't enough free disk space for a save.
+var errNoSpace = errors.New("not enough free disk space")
+
+// minFreeBytes is the free space below which a filesystem is considered full,
+// in which case anything just written to it is assumed to be truncated.
+const minFreeBytes = 1 << 20
+
+// SpaceGuard checks that there is enough free disk space before saving video,
+// pruning old recordings to make room if necessary. A nil *SpaceGuard allows
+// all saves.
+type SpaceGuard struct {
+	// Factor is the expected compressed size of a frame relative to its raw
+	// size, which depends on the codec and scene.
+	Factor float64
+	// Retention, if set, is used to free up space when there isn't enough.
+	Retention *Retention
+}
+
+// Check returns errNoSpace if the filesystem containing dir doesn't have room
+// for the given number of frames, each of the given raw size, even after
+// pruning old recordings. If free space can't be determined on this platform,
+// the save is allowed.
+func (g *SpaceGuard) Check(dir string, frameBytes int64, frames int) error {
+	if g == nil {
+		return nil
+	}
+	need := int64(float64(frameBytes) * float64(frames) * g.Factor)
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	if int64(free) >= need {
+		return nil
+	}
+	if g.Retention != nil {
+		logWarn("Too little free space, pruning recordings", "path", dir, "free", int64(free), "need", need)
+		if err := g.Retention.Prune(); err != nil {
+			logError("Applying retention failed", "path", g.Retention.Dir, "error", err)
+		}
+		if free, err = freeSpace(dir); err == nil && int64(free) >= need {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w in %s: %d bytes free, need ~%d", errNoSpace, dir, free, need)
+}
+
+// diskFull returns whether the filesystem containing dir is (nearly) full. It
+// returns false if free space can't be determined on this platform.
+func diskFull(dir string) bool {
+	free, err := freeSpace(dir)
+	return err == nil && free < minFreeBytes
+}
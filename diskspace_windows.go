@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+)
+
+// freeSpace isn't implemented on Windows, so disk space checks are skipped.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space not available on windows")
+}
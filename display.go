@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// focusColor outlines the camera that key presses go to, when there are
+// several.
+var focusColor = color.RGBA{255, 255, 0, 0}
+
+// Display shows the live views of one or more cameras, either in a window
+// each, or tiled in a single window.
+type Display struct {
+	Tile bool
+	// Fullscreen is set while the windows are fullscreen. Screen, if set, is
+	// the size of the screen, to which fullscreen views are letterboxed;
+	// otherwise they're left to the window system to scale.
+	Fullscreen bool
+	Screen     image.Point
+
+	windows   []*gocv.Window
+	composite gocv.Mat
+	// overlaid is a copy of a view with the help overlay drawn on it, so
+	// that the view itself is left alone
+	overlaid gocv.Mat
+	// letterboxed is a view letterboxed to the screen
+	letterboxed gocv.Mat
+	// shown is the size of the view last shown in each window while it
+	// wasn't fullscreen, and windowed that as of going fullscreen, to
+	// restore the windows to
+	shown, windowed []image.Point
+}
+
+// NewDisplay creates a Display for cameras with the given names. A single
+// camera gets a single window, regardless of tile.
+func NewDisplay(names []string, tile bool) *Display {
+	d := &Display{
+		Tile:        tile || len(names) == 1,
+		composite:   gocv.NewMat(),
+		overlaid:    gocv.NewMat(),
+		letterboxed: gocv.NewMat(),
+	}
+	if d.Tile {
+		d.windows = []*gocv.Window{gocv.NewWindow("Motion Window")}
+	} else {
+		for _, name := range names {
+			d.windows = append(d.windows, gocv.NewWindow("Motion Window - "+name))
+		}
+	}
+	d.shown = make([]image.Point, len(d.windows))
+	d.windowed = make([]image.Point, len(d.windows))
+	return d
+}
+
+// SetFullscreen makes the windows fullscreen, without window decorations, or
+// restores them to their size before.
+func (d *Display) SetFullscreen(on bool) {
+	if on == d.Fullscreen {
+		return
+	}
+	d.Fullscreen = on
+	for i, w := range d.windows {
+		if on {
+			d.windowed[i] = d.shown[i]
+			w.SetWindowProperty(gocv.WindowPropertyFullscreen, gocv.WindowFullscreen)
+			continue
+		}
+		w.SetWindowProperty(gocv.WindowPropertyFullscreen, gocv.WindowNormal)
+		if size := d.windowed[i]; size.X > 0 {
+			w.ResizeWindow(size.X, size.Y)
+		}
+	}
+}
+
+// show shows img in the i-th window, letterboxed to the screen if it's
+// fullscreen.
+func (d *Display) show(i int, img gocv.Mat) {
+	if !d.Fullscreen {
+		d.shown[i] = image.Pt(img.Cols(), img.Rows())
+	} else if d.Screen.X > 0 && d.Screen.Y > 0 {
+		letterbox(img, &d.letterboxed, d.Screen)
+		img = d.letterboxed
+	}
+	d.windows[i].IMShow(img)
+}
+
+// letterbox scales src to fit in dst, of the given size, keeping its aspect
+// ratio, and centers it on a black background.
+func letterbox(src gocv.Mat, dst *gocv.Mat, size image.Point) {
+	if dst.Cols() != size.X || dst.Rows() != size.Y || dst.Type() != src.Type() {
+		dst.Close()
+		*dst = gocv.NewMatWithSize(size.Y, size.X, src.Type())
+	}
+	dst.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	scale := math.Min(float64(size.X)/float64(src.Cols()), float64(size.Y)/float64(src.Rows()))
+	fit := image.Pt(int(float64(src.Cols())*scale), int(float64(src.Rows())*scale))
+	if fit.X <= 0 || fit.Y <= 0 {
+		return
+	}
+	r := image.Rectangle{Max: fit}.Add(size.Sub(fit).Div(2))
+	region := dst.Region(r)
+	gocv.Resize(src, &region, fit, 0, 0, gocv.InterpolationLinear)
+	region.Close()
+}
+
+// Show shows the given views, the focus-th of which is the focused camera,
+// with the help overlay listing help on it, if set. Empty views are skipped.
+func (d *Display) Show(views []gocv.Mat, focus int, help []helpLine) {
+	if len(views) == 1 {
+		if !views[0].Empty() {
+			d.show(0, d.withHelp(views[0], help))
+		}
+		return
+	}
+	if !d.Tile {
+		for i, v := range views {
+			if v.Empty() {
+				continue
+			}
+			if i == focus {
+				gocv.Rectangle(&v, image.Rect(0, 0, v.Cols(), v.Rows()), focusColor, 4)
+				v = d.withHelp(v, help)
+			}
+			d.show(i, v)
+		}
+		return
+	}
+
+	// tile the views in a grid, with cells the size of the first view
+	var cell image.Point
+	for _, v := range views {
+		if !v.Empty() {
+			cell = image.Pt(v.Cols(), v.Rows())
+			break
+		}
+	}
+	if cell.X == 0 {
+		return
+	}
+	cols := 1
+	for cols*cols < len(views) {
+		cols++
+	}
+	rows := (len(views) + cols - 1) / cols
+	if d.composite.Cols() != cols*cell.X || d.composite.Rows() != rows*cell.Y {
+		d.composite.Close()
+		d.composite = gocv.NewMatWithSize(rows*cell.Y, cols*cell.X, gocv.MatTypeCV8UC3)
+	}
+	d.composite.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	for i, v := range views {
+		r := image.Rect(0, 0, cell.X, cell.Y).Add(image.Pt(i%cols*cell.X, i/cols*cell.Y))
+		if !v.Empty() {
+			dst := d.composite.Region(r)
+			gocv.Resize(v, &dst, cell, 0, 0, gocv.InterpolationLinear)
+			dst.Close()
+		}
+		if i == focus {
+			gocv.Rectangle(&d.composite, r, focusColor, 4)
+			if help != nil {
+				drawHelp(&d.composite, r, help)
+			}
+		}
+	}
+	d.show(0, d.composite)
+}
+
+// withHelp returns v, or, if help is set, a copy of it with the help overlay
+// drawn on it.
+func (d *Display) withHelp(v gocv.Mat, help []helpLine) gocv.Mat {
+	if help == nil {
+		return v
+	}
+	v.CopyTo(&d.overlaid)
+	drawHelp(&d.overlaid, image.Rect(0, 0, v.Cols(), v.Rows()), help)
+	return d.overlaid
+}
+
+// PollKey returns the key pressed in any of the windows, or -1 if none was.
+func (d *Display) PollKey() int {
+	return d.windows[0].PollKey()
+}
+
+// Close closes the windows.
+func (d *Display) Close() {
+	for _, w := range d.windows {
+		w.Close()
+	}
+	d.composite.Close()
+	d.overlaid.Close()
+	d.letterboxed.Close()
+}
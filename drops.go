@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DropCounter counts frames dropped or skipped by the pipeline, by reason. It
+// is safe for concurrent use.
+type DropCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	order  []string
+}
+
+// NewDropCounter creates an empty DropCounter.
+func NewDropCounter() *DropCounter {
+	return &DropCounter{
+		counts: make(map[string]int64),
+	}
+}
+
+// Drop counts a single dropped frame for the given reason.
+func (c *DropCounter) Drop(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.counts[reason]; !ok {
+		c.order = append(c.order, reason)
+	}
+	c.counts[reason]++
+}
+
+// Drops returns the number of dropped frames for each reason.
+func (c *DropCounter) Drops() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	drops := make(map[string]int64, len(c.counts))
+	for reason, n := range c.counts {
+		drops[reason] = n
+	}
+	return drops
+}
+
+// String formats the drop counts in the order their reasons were first seen,
+// e.g. "cam=3 queue=120".
+func (c *DropCounter) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sb strings.Builder
+	for i, reason := range c.order {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%s=%d", reason, c.counts[reason])
+	}
+	return sb.String()
+}
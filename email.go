@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTP security modes, as accepted by -smtp-tls.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+const (
+	// emailAttempts is how many times sending an email is attempted.
+	// Snapshots and clips are saved in the background, so the first attempts
+	// may find they don't exist yet.
+	emailAttempts = 6
+	// emailTimeout is how long connecting to the server may take.
+	emailTimeout = 30 * time.Second
+)
+
+// Default templates of the subject and body of emails, executed with the
+// event's EventPayload.
+const (
+	DefaultEmailSubject = `Motion on {{.Camera}}{{if eq .Phase "end"}} ended{{end}}`
+	DefaultEmailBody    = `Motion was detected on {{.Camera}} at {{.Start.Local.Format "2006-01-02 15:04:05"}}.
+{{- if .Zones}}
+Zones: {{join .Zones ", "}}{{end}}
+{{- if eq .Phase "end"}}
+It lasted {{printf "%.0f" .DurationSeconds}}s, with a peak area of {{printf "%.0f" .PeakArea}}.{{end}}
+{{- if .Suppressed}}
+{{.Suppressed}} more detections were suppressed since the last email.{{end}}
+`
+)
+
+// Email sends events by email over SMTP, using a NotifyQueue. When an event
+// starts, it sends an email with the event's snapshot, if it has one. If
+// Clips is set, it also sends an email when the event ends, with the clip
+// attached if it's no bigger than MaxAttachment, or its path otherwise. A nil
+// *Email does nothing.
+type Email struct {
+	// Host and Port are the SMTP server's address.
+	Host string
+	Port int
+	// TLS is SMTPStartTLS, SMTPTLS (implicit TLS) or SMTPNone.
+	TLS string
+	// Username and Password, if set, authenticate with the server.
+	Username string
+	Password string
+	// From and To are the sender and recipients.
+	From string
+	To   []string
+	// Subject and Body are the templates of each email.
+	Subject *template.Template
+	Body    *template.Template
+	// Clips also sends an email when each event ends, with its clip.
+	Clips bool
+	// MaxAttachment is the size of the largest clip that is attached.
+	MaxAttachment int64
+
+	queue *NotifyQueue
+}
+
+// ParseEmailTemplates parses the templates of the subject and body of emails.
+// Besides the standard functions, they may use join (strings.Join).
+func ParseEmailTemplates(subject, body string) (*template.Template, *template.Template, error) {
+	funcs := template.FuncMap{"join": strings.Join}
+	s, err := template.New("subject").Funcs(funcs).Parse(subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subject: %v", err)
+	}
+	b, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid body: %v", err)
+	}
+	return s, b, nil
+}
+
+// Start starts sending emails in the background. The fields must be set
+// first.
+func (e *Email) Start() {
+	e.queue = NewNotifyQueue("email", emailAttempts)
+}
+
+// Notify queues an email about the event, in the given phase. It never
+// blocks.
+func (e *Email) Notify(ev *MotionEvent, phase string) {
+	if e == nil || (phase == PhaseEnd && !e.Clips) {
+		return
+	}
+	p := ev.Payload(phase)
+	var subject, body bytes.Buffer
+	err := e.Subject.Execute(&subject, p)
+	if err == nil {
+		err = e.Body.Execute(&body, p)
+	}
+	if err != nil {
+		logError("Not emailing event", "camera", p.Camera, "event_id", p.ID, "error", err)
+		notifyFailures.Drop("email")
+		return
+	}
+	attachment := p.Snapshot
+	if phase == PhaseEnd {
+		attachment = p.Clip
+	}
+	desc := fmt.Sprintf("event %d %s", p.ID, phase)
+	e.queue.Send(desc, func() (bool, error) {
+		msg, err := e.message(strings.TrimSpace(subject.String()), body.String(), attachment)
+		if err != nil {
+			// the attachment may still be being saved
+			return true, err
+		}
+		return true, e.send(msg)
+	})
+}
+
+// Close waits for queued emails to be sent.
+func (e *Email) Close() {
+	if e == nil {
+		return
+	}
+	e.queue.Close()
+}
+
+// message builds an email with the given subject and body, attaching the
+// named file if it isn't empty and isn't too big, and mentioning it in the
+// body otherwise.
+func (e *Email) message(subject, body, attachment string) ([]byte, error) {
+	var data []byte
+	if attachment != "" {
+		info, err := os.Stat(attachment)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() > e.MaxAttachment {
+			body += fmt.Sprintf("\n%s is too big to attach (%0.1fMB).\n", attachment, float64(info.Size())/(1<<20))
+			attachment = ""
+		} else if data, err = ioutil.ReadFile(attachment); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if attachment == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+		return buf.Bytes(), nil
+	}
+
+	boundary := emailBoundary()
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+	name := filepath.Base(attachment)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	fmt.Fprintf(&buf, "Content-Type: %s; name=%q\r\n", contentType, name)
+	fmt.Fprintf(&buf, "Content-Disposition: %s; filename=%q\r\n", disposition, name)
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// emailBoundary returns a random MIME boundary.
+func emailBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("motiondetect-%x", b)
+}
+
+// send sends msg to the server.
+func (e *Email) send(msg []byte) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := &tls.Config{ServerName: e.Host}
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	if e.TLS == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.TLS == SMTPStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("authentication as %s failed: %v", e.Username, err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
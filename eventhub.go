@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// hubQueue is the number of messages that may be waiting to be sent to a
+	// client before it is disconnected for being too slow.
+	hubQueue = 16
+	// hubWriteTimeout is how long sending a message to a client may take.
+	hubWriteTimeout = 10 * time.Second
+	// hubPing is how often clients are pinged, to keep idle connections open
+	// and notice dead ones.
+	hubPing = 30 * time.Second
+)
+
+// HubMessage is a message sent to EventHub clients. Type is "event" for the
+// start and end of events, with Event set, and "status" for periodic status
+// updates, with Status set.
+type HubMessage struct {
+	Type   string        `json:"type"`
+	Event  *EventPayload `json:"event,omitempty"`
+	Status *CameraStatus `json:"status,omitempty"`
+}
+
+// CameraStatus is a periodic update on a camera, sent to EventHub clients.
+type CameraStatus struct {
+	Camera string    `json:"camera"`
+	Time   time.Time `json:"time"`
+	FPS    float64   `json:"fps"`
+	// Score is the area of the largest region of motion in the latest
+	// frame, or 0 if there was none.
+	Score  float64 `json:"score"`
+	Motion bool    `json:"motion"`
+	// Armed is whether motion produces events; see ArmState.
+	Armed bool `json:"armed"`
+}
+
+// EventHub pushes events to WebSocket clients, and to subscribers such as
+// gRPC streams, as they happen. It is independent of the cameras, so clients
+// stay connected while a camera reconnects. Clients that can't keep up are
+// disconnected, rather than holding up the others, or the cameras. A nil
+// *EventHub does nothing.
+type EventHub struct {
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*hubClient]struct{}
+	subs    map[chan *EventPayload]struct{}
+}
+
+type hubClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// NewEventHub creates an EventHub with no clients.
+func NewEventHub() *EventHub {
+	return &EventHub{
+		clients: make(map[*hubClient]struct{}),
+		subs:    make(map[chan *EventPayload]struct{}),
+	}
+}
+
+// Watching returns whether any clients are connected, or subscribed.
+func (h *EventHub) Watching() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) > 0 || len(h.subs) > 0
+}
+
+// Subscribe returns a channel receiving every event published, and a function
+// to call to unsubscribe. A subscriber that can't keep up is unsubscribed, and
+// its channel closed, as is every subscriber's when the hub is closed.
+func (h *EventHub) Subscribe() (<-chan *EventPayload, func()) {
+	ch := make(chan *EventPayload, hubQueue)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.unsubscribe(ch)
+	}
+}
+
+// Notify sends the event, in the given phase, to every client.
+func (h *EventHub) Notify(ev *MotionEvent, phase string) {
+	if h.Watching() {
+		h.publish(HubMessage{Type: "event", Event: ev.Payload(phase)})
+	}
+}
+
+// PublishStatus sends a status update to every client.
+func (h *EventHub) PublishStatus(s *CameraStatus) {
+	if h.Watching() {
+		h.publish(HubMessage{Type: "status", Status: s})
+	}
+}
+
+func (h *EventHub) publish(msg HubMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		logError("Encoding hub message failed", "type", msg.Type, "error", err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for cl := range h.clients {
+		select {
+		case cl.send <- b:
+		default:
+			logWarn("Disconnecting slow event client", "client", cl.conn.RemoteAddr())
+			h.remove(cl)
+		}
+	}
+	if msg.Event == nil {
+		return
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- msg.Event:
+		default:
+			logWarn("Unsubscribing slow event subscriber")
+			h.unsubscribe(ch)
+		}
+	}
+}
+
+// remove disconnects a client. h.mu must be held.
+func (h *EventHub) remove(cl *hubClient) {
+	if _, ok := h.clients[cl]; ok {
+		delete(h.clients, cl)
+		close(cl.send)
+	}
+}
+
+// unsubscribe removes a subscriber. h.mu must be held.
+func (h *EventHub) unsubscribe(ch chan *EventPayload) {
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Close disconnects all clients, and unsubscribes all subscribers.
+func (h *EventHub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for cl := range h.clients {
+		h.remove(cl)
+	}
+	for ch := range h.subs {
+		h.unsubscribe(ch)
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket, and sends the client a
+// HubMessage for everything published until it disconnects.
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return
+	}
+	cl := &hubClient{conn: conn, send: make(chan []byte, hubQueue)}
+	h.mu.Lock()
+	h.clients[cl] = struct{}{}
+	h.mu.Unlock()
+	logInfo("Event client connected", "client", conn.RemoteAddr())
+
+	// nothing is expected from clients, but reading handles pings and
+	// closes, and notices when they've gone
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				h.mu.Lock()
+				h.remove(cl)
+				h.mu.Unlock()
+				return
+			}
+		}
+	}()
+	h.write(cl)
+	conn.Close()
+	logInfo("Event client disconnected", "client", conn.RemoteAddr())
+}
+
+// write sends the client's messages until it is removed, or writing fails.
+func (h *EventHub) write(cl *hubClient) {
+	ping := time.NewTicker(hubPing)
+	defer ping.Stop()
+	for {
+		select {
+		case b, ok := <-cl.send:
+			cl.conn.SetWriteDeadline(time.Now().Add(hubWriteTimeout))
+			if !ok {
+				cl.conn.WriteMessage(websocket.CloseMessage, nil)
+				return
+			}
+			if err := cl.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				h.mu.Lock()
+				h.remove(cl)
+				h.mu.Unlock()
+				return
+			}
+		case <-ping.C:
+			cl.conn.SetWriteDeadline(time.Now().Add(hubWriteTimeout))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				h.mu.Lock()
+				h.remove(cl)
+				h.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// HandleEvents registers h at /api/events/ws with http.DefaultServeMux.
+func HandleEvents(h *EventHub) {
+	http.Handle("/api/events/ws", h)
+}
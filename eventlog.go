@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventLog appends events to a JSON-lines file, one EventPayload per line. Each
+// line is written to the file immediately, so that a crash loses at most the
+// line being written. It is safe for concurrent use.
+type EventLog struct {
+	// MaxSize is the size in bytes after which the log is rotated, by renaming
+	// it with a ".1" suffix (replacing any previous one). 0 disables rotation.
+	MaxSize int64
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+}
+
+// OpenEventLog opens the event log at path for appending, creating it if
+// necessary.
+func OpenEventLog(path string) (*EventLog, error) {
+	l := &EventLog{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *EventLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends the given event, in the given phase, to the log.
+func (l *EventLog) Write(ev *MotionEvent, phase string) error {
+	line, err := json.Marshal(ev.Payload(phase))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating %s failed: %w", l.path, err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the current log aside and starts a new one. l.mu must be held.
+func (l *EventLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the log.
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ReadEventLog reads the ended events that started at or after since from the
+// JSON-lines event log at path, in the order they were logged.
+func ReadEventLog(path string, since time.Time) ([]*MotionEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []*MotionEvent
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var p EventPayload
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if p.Phase != PhaseEnd || p.Start.Before(since) {
+			continue
+		}
+		events = append(events, p.Event())
+	}
+	return events, scanner.Err()
+}
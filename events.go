@@ -0,0 +1,267 @@
+package main
+
+import (
+	"time"
+)
+
+// MotionEvent is a period of motion, which may include short pauses.
+type MotionEvent struct {
+	ID         int
+	Camera     string
+	Start      time.Time
+	LastMotion time.Time
+	End        time.Time
+
+	// SubEvents is the number of periods of motion merged into the event,
+	// which is 1 unless events are merged (see EventTracker.MergeGap).
+	SubEvents int
+	// ActiveMotion is the total time from the start to the last motion of
+	// each sub-event.
+	ActiveMotion time.Duration
+
+	// PeakArea is the largest contour area detected during the event.
+	PeakArea float64
+	// Zones lists the names of the zones in which motion was detected.
+	Zones []string
+
+	// Clip is the filename the event was recorded to, if any.
+	Clip string
+	// Segments lists all the files the event was recorded to, in order, if it
+	// was split due to its length. It is empty otherwise.
+	Segments []string
+	// Snapshot is the filename of a still image of the event, if any.
+	Snapshot string
+	// Thumbnail is the filename of a poster image for Clip, if any.
+	Thumbnail string
+	// Regions lists the filenames of crops of the regions in which motion was
+	// detected, if any.
+	Regions []string
+
+	// Suppressed is the number of events a notifier's Governor suppressed
+	// since it last passed one on. It's only set on the copy passed on.
+	Suppressed int
+
+	// Offset is the monotonic time from the program starting to the start of
+	// the event, which, unlike Start, is unaffected by the wall clock
+	// stepping, for ordering events from the same run.
+	Offset time.Duration
+	// Clock is the state of the host's clock as of the latest phase of the
+	// event, if known.
+	Clock *ClockStatus
+}
+
+// Duration returns the duration of the event so far, or its total duration if
+// it has ended.
+func (e *MotionEvent) Duration() time.Duration {
+	if e.End.IsZero() {
+		return e.LastMotion.Sub(e.Start)
+	}
+	return e.End.Sub(e.Start)
+}
+
+// Event phases, as reported in an EventPayload.
+const (
+	PhaseStart = "start"
+	PhaseEnd   = "end"
+)
+
+// EventPayload is the serialized form of a MotionEvent, as written to logs and
+// sent to integrations.
+type EventPayload struct {
+	Phase           string       `json:"phase"`
+	ID              int          `json:"id"`
+	Camera          string       `json:"camera"`
+	Start           time.Time    `json:"start"`
+	End             *time.Time   `json:"end,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	SubEvents       int          `json:"sub_events"`
+	ActiveSeconds   float64      `json:"active_seconds"`
+	PeakArea        float64      `json:"peak_area"`
+	Zones           []string     `json:"zones,omitempty"`
+	Clip            string       `json:"clip,omitempty"`
+	Segments        []string     `json:"segments,omitempty"`
+	Snapshot        string       `json:"snapshot,omitempty"`
+	Thumbnail       string       `json:"thumbnail,omitempty"`
+	Regions         []string     `json:"regions,omitempty"`
+	Suppressed      int          `json:"suppressed,omitempty"`
+	OffsetSeconds   float64      `json:"offset_seconds,omitempty"`
+	Clock           *ClockStatus `json:"clock,omitempty"`
+}
+
+// Payload returns the serializable form of the event, for the given phase.
+// Times are in UTC.
+func (e *MotionEvent) Payload(phase string) *EventPayload {
+	p := &EventPayload{
+		Phase:           phase,
+		ID:              e.ID,
+		Camera:          e.Camera,
+		Start:           e.Start.UTC(),
+		DurationSeconds: e.Duration().Seconds(),
+		SubEvents:       e.SubEvents,
+		ActiveSeconds:   e.ActiveMotion.Seconds(),
+		PeakArea:        e.PeakArea,
+		Zones:           e.Zones,
+		Clip:            e.Clip,
+		Segments:        e.Segments,
+		Snapshot:        e.Snapshot,
+		Thumbnail:       e.Thumbnail,
+		Regions:         e.Regions,
+		Suppressed:      e.Suppressed,
+		OffsetSeconds:   e.Offset.Seconds(),
+		Clock:           e.Clock,
+	}
+	if !e.End.IsZero() {
+		end := e.End.UTC()
+		p.End = &end
+	}
+	return p
+}
+
+// Event returns the MotionEvent described by the payload.
+func (p *EventPayload) Event() *MotionEvent {
+	ev := &MotionEvent{
+		ID:        p.ID,
+		Camera:    p.Camera,
+		Start:     p.Start,
+		SubEvents: p.SubEvents,
+		PeakArea:  p.PeakArea,
+		Zones:     p.Zones,
+		Clip:      p.Clip,
+		Segments:  p.Segments,
+		Snapshot:  p.Snapshot,
+		Thumbnail: p.Thumbnail,
+		Regions:   p.Regions,
+		Offset:    time.Duration(p.OffsetSeconds * float64(time.Second)),
+		Clock:     p.Clock,
+	}
+	if p.End != nil {
+		ev.End = *p.End
+	}
+	ev.ActiveMotion = time.Duration(p.ActiveSeconds * float64(time.Second))
+	ev.LastMotion = ev.Start.Add(time.Duration(p.DurationSeconds * float64(time.Second)))
+	return ev
+}
+
+// EventChange is a change in state reported by EventTracker.Update.
+type EventChange int
+
+const (
+	EventNone EventChange = iota
+	EventStarted
+	EventEnded
+	// EventPaused and EventResumed are only reported when merging events;
+	// see EventTracker.MergeGap.
+	EventPaused
+	EventResumed
+)
+
+// EventTracker groups per-frame motion detections into motion events. An event
+// starts on the first frame with motion, and ends once no motion has been
+// detected for the Quiet period.
+//
+// If MergeGap is set, an event is paused rather than ended after the Quiet
+// period, and resumed as a new sub-event if motion starts again within
+// MergeGap. Otherwise, it ends at the time it was paused.
+type EventTracker struct {
+	Camera   string
+	Quiet    time.Duration
+	MergeGap time.Duration
+
+	seq      int
+	current  *MotionEvent
+	paused   bool
+	subStart time.Time
+}
+
+// NewEventTracker creates an EventTracker for the named camera, with the given
+// quiet period.
+func NewEventTracker(camera string, quiet time.Duration) *EventTracker {
+	return &EventTracker{Camera: camera, Quiet: quiet}
+}
+
+// Update records whether motion was detected in the frame captured at time t.
+// It returns whether this changed the state of an event, along with the event
+// in progress (or just ended), which is nil if there is none.
+func (et *EventTracker) Update(motion bool, t time.Time) (EventChange, *MotionEvent) {
+	ev := et.current
+	if ev == nil {
+		if !motion {
+			return EventNone, nil
+		}
+		et.seq++
+		et.current = &MotionEvent{
+			ID:         et.seq,
+			Camera:     et.Camera,
+			Start:      t,
+			LastMotion: t,
+			SubEvents:  1,
+		}
+		et.subStart = t
+		return EventStarted, et.current
+	}
+
+	if et.paused {
+		if motion {
+			et.paused = false
+			et.subStart = t
+			ev.LastMotion = t
+			ev.End = time.Time{}
+			ev.SubEvents++
+			return EventResumed, ev
+		}
+		if t.Sub(ev.End) < et.MergeGap {
+			return EventNone, ev
+		}
+		et.paused = false
+		et.current = nil
+		return EventEnded, ev
+	}
+
+	if motion {
+		ev.LastMotion = t
+		return EventNone, ev
+	}
+	if t.Sub(ev.LastMotion) < et.Quiet {
+		return EventNone, ev
+	}
+	ev.ActiveMotion += ev.LastMotion.Sub(et.subStart)
+	ev.End = t
+	if et.MergeGap > 0 {
+		et.paused = true
+		return EventPaused, ev
+	}
+	et.current = nil
+	return EventEnded, ev
+}
+
+// Paused returns whether the event in progress is paused, waiting to see
+// whether it should be merged with the next one.
+func (et *EventTracker) Paused() bool {
+	return et.paused
+}
+
+// Stop ends the event in progress, if any, at time t (or when it was paused),
+// and returns it.
+func (et *EventTracker) Stop(t time.Time) *MotionEvent {
+	ev := et.current
+	if ev == nil {
+		return nil
+	}
+	if !et.paused {
+		ev.ActiveMotion += ev.LastMotion.Sub(et.subStart)
+		ev.End = t
+	}
+	et.paused = false
+	et.current = nil
+	return ev
+}
+
+// Events returns the number of events started so far.
+func (et *EventTracker) Events() int {
+	return et.seq
+}
+
+// Current returns the event in progress, or nil if there is none.
+func (et *EventTracker) Current() *MotionEvent {
+	return et.current
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runEventsCommand implements the "events" subcommand, which lists recorded
+// events, or exports them with "events export".
+func runEventsCommand(args []string) {
+	if len(args) > 0 && args[0] == "export" {
+		runEventsExportCommand(args[1:])
+		return
+	}
+
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	src := addEventSourceFlags(fs)
+	asJSON := fs.Bool("json", false, "print the events as JSON, rather than as a table")
+	fs.Parse(args)
+
+	events := src.load()
+	if *asJSON {
+		payloads := []*EventPayload{}
+		for _, ev := range events {
+			payloads = append(payloads, ev.Payload(PhaseEnd))
+		}
+		printJSON(payloads)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAMERA\tSEQ\tSTART\tDURATION\tPEAK AREA\tZONES\tCLIP")
+	for _, ev := range events {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%v\t%0.0f\t%s\t%s\n",
+			ev.Camera, ev.ID,
+			ev.Start.Local().Format("2006-01-02 15:04:05"),
+			ev.Duration().Round(time.Second),
+			ev.PeakArea,
+			strings.Join(ev.Zones, ","),
+			ev.Clip,
+		)
+	}
+	tw.Flush()
+}
+
+// runEventsExportCommand implements "events export", which writes events as
+// CSV to stdout.
+func runEventsExportCommand(args []string) {
+	fs := flag.NewFlagSet("events export", flag.ExitOnError)
+	src := addEventSourceFlags(fs)
+	var (
+		format = fs.String("format", "csv", "export format; only csv is supported")
+		sortBy = fs.String("sort", "start", "sort by start, duration or area")
+		tz     = fs.String("tz", "UTC", "time zone to format times in, e.g. Local or Europe/London")
+	)
+	fs.Parse(args)
+
+	if *format != "csv" {
+		log.Fatalf("Invalid -format %q: only csv is supported", *format)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("Invalid -tz: %v", err)
+	}
+	events := src.load()
+	switch *sortBy {
+	case "start":
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	case "duration":
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Duration() > events[j].Duration() })
+	case "area":
+		sort.SliceStable(events, func(i, j int) bool { return events[i].PeakArea > events[j].PeakArea })
+	default:
+		log.Fatalf("Invalid -sort %q: must be start, duration or area", *sortBy)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"camera", "seq", "start", "end", "duration_seconds", "peak_area", "zones", "clip"})
+	for _, ev := range events {
+		w.Write([]string{
+			ev.Camera,
+			strconv.Itoa(ev.ID),
+			ev.Start.In(loc).Format(time.RFC3339),
+			ev.End.In(loc).Format(time.RFC3339),
+			strconv.FormatFloat(ev.Duration().Seconds(), 'f', 1, 64),
+			strconv.FormatFloat(ev.PeakArea, 'f', 0, 64),
+			strings.Join(ev.Zones, ","),
+			ev.Clip,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Error writing CSV: %v", err)
+	}
+}
+
+// eventSource is where the events subcommands read events from: the events
+// database, the JSON-lines event log, or a running instance's recent events.
+type eventSource struct {
+	db    *string
+	log   *string
+	addr  *string
+	token *string
+	since *string
+}
+
+func addEventSourceFlags(fs *flag.FlagSet) *eventSource {
+	return &eventSource{
+		db:    fs.String("db", "", "events database to read"),
+		log:   fs.String("log", "", "JSON-lines event log to read, instead of -db"),
+		addr:  fs.String("addr", "", "the -http-addr of a running instance, or its URL, to read the recent events shown on its dashboard from, instead of -db"),
+		token: fs.String("token", "", "with -addr, the instance's -http-token, if it has one (default: $"+clientTokenEnv+")"),
+		since: fs.String("since", "24h", "only include events that started within this long ago, e.g. 90m, 24h or 7d"),
+	}
+}
+
+// load reads the events, exiting on error.
+func (s *eventSource) load() []*MotionEvent {
+	d, err := parseSince(*s.since)
+	if err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+	since := time.Now().Add(-d)
+
+	switch {
+	case *s.log != "":
+		events, err := ReadEventLog(*s.log, since)
+		if err != nil {
+			log.Fatalf("Error reading event log: %v", err)
+		}
+		return events
+	case *s.addr != "":
+		timeout := 30 * time.Second
+		cf := &clientFlags{addr: s.addr, token: s.token, timeout: &timeout}
+		var recent []dashboardEvent
+		if err := cf.client().do(http.MethodGet, "/api/events", nil, &recent); err != nil {
+			log.Fatalf("Error getting events: %v", err)
+		}
+		var events []*MotionEvent
+		for _, p := range recent {
+			if p.EventPayload != nil && p.Start.After(since) {
+				events = append(events, p.Event())
+			}
+		}
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+		return events
+	case *s.db != "":
+		store, err := OpenSQLiteEventStore(*s.db)
+		if err != nil {
+			log.Fatalf("Error opening events database: %v", err)
+		}
+		defer store.Close()
+		events, err := store.Query(since)
+		if err != nil {
+			log.Fatalf("Error querying events: %v", err)
+		}
+		return events
+	}
+	log.Fatal("One of -db, -log or -addr is required")
+	return nil
+}
+
+// parseSince parses a duration like time.ParseDuration, but also accepting a
+// number of days, e.g. "7d".
+func parseSince(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// EventStore persists motion events.
+type EventStore interface {
+	// Record stores an event that has ended.
+	Record(ev *MotionEvent) error
+	// Query returns the events that started at or after since, oldest first.
+	Query(since time.Time) ([]*MotionEvent, error)
+	Close() error
+}
+
+// migrations are applied in order to bring an events database up to date. The
+// number of migrations applied is tracked with PRAGMA user_version, so
+// existing entries must never be changed, only appended to.
+var migrations = []string{
+	`CREATE TABLE events (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		camera     TEXT NOT NULL,
+		seq        INTEGER NOT NULL,
+		start_time TEXT NOT NULL,
+		end_time   TEXT NOT NULL,
+		peak_area  REAL NOT NULL,
+		zones      TEXT NOT NULL DEFAULT '',
+		clip       TEXT NOT NULL DEFAULT '',
+		snapshot   TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX events_start_time ON events (start_time)`,
+	`ALTER TABLE events ADD COLUMN thumbnail TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE events ADD COLUMN sub_events INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE events ADD COLUMN active_seconds REAL NOT NULL DEFAULT 0`,
+}
+
+// timeFormat is used to store times as text, in UTC, which sorts
+// chronologically.
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// SQLiteEventStore is an EventStore backed by an SQLite database. It is safe
+// for concurrent use.
+type SQLiteEventStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteEventStore opens (creating if necessary) the SQLite database at
+// path, and migrates it to the latest schema.
+func OpenSQLiteEventStore(path string) (*SQLiteEventStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports one writer at a time anyway
+	db.SetMaxOpenConns(1)
+	s := &SQLiteEventStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s failed: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *SQLiteEventStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		// PRAGMA doesn't support placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record implements EventStore.
+func (s *SQLiteEventStore) Record(ev *MotionEvent) error {
+	_, err := s.db.Exec(
+		`INSERT INTO events (camera, seq, start_time, end_time, peak_area, zones, clip, snapshot, thumbnail, sub_events, active_seconds)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ev.Camera, ev.ID,
+		ev.Start.UTC().Format(timeFormat), ev.End.UTC().Format(timeFormat),
+		ev.PeakArea, strings.Join(ev.Zones, ","), ev.Clip, ev.Snapshot, ev.Thumbnail,
+		ev.SubEvents, ev.ActiveMotion.Seconds(),
+	)
+	return err
+}
+
+// Query implements EventStore.
+func (s *SQLiteEventStore) Query(since time.Time) ([]*MotionEvent, error) {
+	rows, err := s.db.Query(
+		`SELECT camera, seq, start_time, end_time, peak_area, zones, clip, snapshot, thumbnail, sub_events, active_seconds
+		FROM events WHERE start_time >= ? ORDER BY start_time`,
+		since.UTC().Format(timeFormat),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*MotionEvent
+	for rows.Next() {
+		var (
+			ev         MotionEvent
+			start, end string
+			zones      string
+			active     float64
+		)
+		if err := rows.Scan(&ev.Camera, &ev.ID, &start, &end, &ev.PeakArea, &zones, &ev.Clip, &ev.Snapshot, &ev.Thumbnail, &ev.SubEvents, &active); err != nil {
+			return nil, err
+		}
+		if ev.Start, err = time.Parse(timeFormat, start); err != nil {
+			return nil, err
+		}
+		if ev.End, err = time.Parse(timeFormat, end); err != nil {
+			return nil, err
+		}
+		if zones != "" {
+			ev.Zones = strings.Split(zones, ",")
+		}
+		ev.ActiveMotion = time.Duration(active * float64(time.Second))
+		events = append(events, &ev)
+	}
+	return events, rows.Err()
+}
+
+// Close implements EventStore.
+func (s *SQLiteEventStore) Close() error {
+	return s.db.Close()
+}
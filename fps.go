@@ -1,53 +1,120 @@
 package main
 
 import (
+	"sync"
 	"time"
 )
 
-// FPSCounter measures average frames per second.
+// FPSCounter measures average frames per second. It is safe for concurrent
+// use.
 type FPSCounter struct {
-	FPS float64
+	// RetainOnStop keeps the counter's window across a Stop and subsequent
+	// Start. By default, Stop clears the window.
+	RetainOnStop bool
 
+	mu  sync.Mutex
+	fps float64
+
+	interval  time.Duration
 	ticks     int
 	frames    []int
 	durations []time.Duration
+	lastTick  time.Time
 
 	totalFrames   int
 	totalDuration time.Duration
 
-	done   chan struct{}
-	ticker *time.Ticker
+	recent       []time.Time
+	recentWrites int
+
+	emaAlpha       float64
+	emaMaxInterval time.Duration
+	emaInterval    time.Duration
+
+	lowThreshold float64
+	lowSustained time.Duration
+	lowFn        func(current float64)
+	lowSince     time.Time
+	lowFired     bool
+
+	// allFrames and startTime aren't affected by Reset
+	allFrames int64
+	startTime time.Time
+
+	running bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// instantFrames is the number of most recent frames over which the
+// instantaneous FPS is measured.
+const instantFrames = 10
+
+// FPSOption configures optional behavior of an FPSCounter.
+type FPSOption func(*FPSCounter)
+
+// WithEMA makes the counter report FPS from an exponential moving average of
+// the interval between frames, rather than from its bucketed window. alpha is
+// the weight given to each new interval, in (0, 1]. Intervals longer than
+// maxInterval are capped to it, so that a single stall doesn't dominate the
+// average long after it's over.
+func WithEMA(alpha float64, maxInterval time.Duration) FPSOption {
+	return func(c *FPSCounter) {
+		c.emaAlpha = alpha
+		c.emaMaxInterval = maxInterval
+	}
 }
 
-// NewFPSCounter creates a new FPSCounter that keeps track of the average
-// FPS for the given last number of seconds. The counter is not started
-// automateically; this must be done by the caller.
-func NewFPSCounter(seconds int) *FPSCounter {
-	return &FPSCounter{
-		frames:    make([]int, seconds),
-		durations: make([]time.Duration, seconds),
-		done:      make(chan struct{}),
+// NewFPSCounter creates a new FPSCounter that keeps track of the average FPS
+// over the given number of buckets, each spanning the given interval (e.g. 20
+// buckets of 250ms for a 5 second window that updates 4 times a second). The
+// counter is not started automatically; this must be done by the caller.
+func NewFPSCounter(interval time.Duration, buckets int, opts ...FPSOption) *FPSCounter {
+	c := &FPSCounter{
+		interval:  interval,
+		frames:    make([]int, buckets),
+		durations: make([]time.Duration, buckets),
+		recent:    make([]time.Time, instantFrames),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Start starts the counter and keeps track of average FPS, where a new frame is
-// counted on each call to NextFrame. Start must not be called more than once
-// for a given FPSCounter, unless Stop was called first.
+// counted on each call to NextFrame. Calling Start on a running counter has no
+// effect; a stopped counter may be started again.
 func (c *FPSCounter) Start() {
-	go c.runTicker()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return
+	}
+	c.running = true
+	if c.startTime.IsZero() {
+		c.startTime = time.Now()
+	}
+	c.done = make(chan struct{})
+	c.lastTick = time.Now()
+	c.wg.Add(1)
+	go c.runTicker(c.done)
 }
 
-func (c *FPSCounter) runTicker() {
-	lastTime := time.Now()
-	c.ticker = time.NewTicker(time.Second)
-	<-c.ticker.C
+func (c *FPSCounter) runTicker(done <-chan struct{}) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-c.done:
-			break
-		case t := <-c.ticker.C:
-			lastDuration := t.Sub(lastTime)
-			lastTime = t
+		case <-done:
+			return
+		case t := <-ticker.C:
+			c.mu.Lock()
+			lastDuration := t.Sub(c.lastTick)
+			c.lastTick = t
 
 			idx := c.ticks % len(c.frames)
 			c.durations[idx] = lastDuration
@@ -61,24 +128,224 @@ func (c *FPSCounter) runTicker() {
 			c.frames[idx] = 0
 			c.durations[idx] = time.Duration(0)
 
-			c.FPS = float64(c.totalFrames) / c.totalDuration.Seconds()
+			if c.totalDuration > 0 {
+				c.fps = float64(c.totalFrames) / c.totalDuration.Seconds()
+			}
+			fire, current := c.checkLowFPS(t)
+			// OnLowFPS may change lowFn once c.mu is released
+			fn := c.lowFn
+			c.mu.Unlock()
+
+			if fire && fn != nil {
+				fn(current)
+			}
 		}
 	}
-	c.ticker.Stop()
 }
 
 // NextFrame registers to the counter that a new frame has passed.
 func (c *FPSCounter) NextFrame() {
-	c.frames[c.ticks % len(c.frames)]++
+	now := time.Now()
+	c.mu.Lock()
+	c.frames[c.ticks%len(c.frames)]++
+	c.allFrames++
+	if c.emaAlpha > 0 && c.recentWrites > 0 {
+		last := c.recent[(c.recentWrites-1)%len(c.recent)]
+		c.updateEMA(now.Sub(last))
+	}
+	c.recent[c.recentWrites%len(c.recent)] = now
+	c.recentWrites++
+	c.mu.Unlock()
+}
+
+// OnLowFPS registers fn to be called, from the counter's goroutine, once the
+// FPS has stayed below threshold for the sustained duration. It is not called
+// again until the FPS has recovered to at least threshold and then dropped
+// again. Registering a new function replaces the previous one.
+func (c *FPSCounter) OnLowFPS(threshold float64, sustained time.Duration, fn func(current float64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lowThreshold = threshold
+	c.lowSustained = sustained
+	c.lowFn = fn
+	c.lowSince = time.Time{}
+	c.lowFired = false
+}
+
+// checkLowFPS updates the low FPS state as of time t, returning whether the
+// OnLowFPS function should be called and with what value. c.mu must be held.
+func (c *FPSCounter) checkLowFPS(t time.Time) (bool, float64) {
+	if c.lowFn == nil {
+		return false, 0
+	}
+	current := c.currentFPS()
+	if current >= c.lowThreshold {
+		c.lowSince = time.Time{}
+		c.lowFired = false
+		return false, current
+	}
+	if c.lowSince.IsZero() {
+		c.lowSince = t
+	}
+	if c.lowFired || t.Sub(c.lowSince) < c.lowSustained {
+		return false, current
+	}
+	c.lowFired = true
+	return true, current
+}
+
+// updateEMA folds the interval since the last frame into the moving average.
+// c.mu must be held.
+func (c *FPSCounter) updateEMA(d time.Duration) {
+	if d > c.emaMaxInterval {
+		d = c.emaMaxInterval
+	}
+	if c.emaInterval == 0 {
+		c.emaInterval = d
+		return
+	}
+	c.emaInterval += time.Duration(c.emaAlpha * float64(d-c.emaInterval))
+}
+
+// FPS returns the average FPS over the counter's window, or the exponential
+// moving average if the counter was created with WithEMA.
+func (c *FPSCounter) FPS() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.currentFPS()
+}
+
+// currentFPS implements FPS. c.mu must be held.
+func (c *FPSCounter) currentFPS() float64 {
+	if c.emaAlpha > 0 {
+		if c.emaInterval <= 0 {
+			return 0
+		}
+		return 1 / c.emaInterval.Seconds()
+	}
+	return c.fps
+}
+
+// Instant returns the FPS measured over the last few frames, which responds to
+// changes much faster than the average returned by FPS. It returns 0 until at
+// least two frames have been counted.
+func (c *FPSCounter) Instant() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.recentWrites
+	if n > len(c.recent) {
+		n = len(c.recent)
+	}
+	if n < 2 {
+		return 0
+	}
+	var (
+		newest = c.recent[(c.recentWrites-1)%len(c.recent)]
+		oldest = c.recent[(c.recentWrites-n)%len(c.recent)]
+	)
+	elapsed := newest.Sub(oldest)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n-1) / elapsed.Seconds()
+}
+
+// TotalFrames returns the number of frames counted since the counter was
+// created, regardless of any calls to Reset.
+func (c *FPSCounter) TotalFrames() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.allFrames
+}
+
+// Uptime returns the time since the counter was first started, regardless of
+// any calls to Reset or Stop. It returns 0 if the counter was never started.
+func (c *FPSCounter) Uptime() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.startTime.IsZero() {
+		return 0
+	}
+	return time.Since(c.startTime)
 }
 
 // Duration returns the total duration over which the counter is currently
 // tracking.
 func (c *FPSCounter) Duration() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.totalDuration
 }
 
-// Stop stops the counter.
+// Buckets returns the number of buckets in the counter's window.
+func (c *FPSCounter) Buckets() int {
+	return len(c.frames)
+}
+
+// Interval returns the duration spanned by each bucket.
+func (c *FPSCounter) Interval() time.Duration {
+	return c.interval
+}
+
+// Bucket returns the number of frames counted in the i-th bucket of the
+// window, and the duration that bucket covered.
+func (c *FPSCounter) Bucket(i int) (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frames[i], c.durations[i]
+}
+
+// Reset clears the counter's window and displayed FPS without stopping it. It
+// may be called concurrently with NextFrame, e.g. when switching sources so
+// that the old and new rates aren't blended.
+func (c *FPSCounter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.frames {
+		c.frames[i] = 0
+		c.durations[i] = 0
+	}
+	c.ticks = 0
+	c.recentWrites = 0
+	c.totalFrames = 0
+	c.totalDuration = 0
+	c.fps = 0
+	c.emaInterval = 0
+	c.lowSince = time.Time{}
+	c.lowFired = false
+	c.lastTick = time.Now()
+}
+
+// HardReset clears everything Reset does, and also the total frame count and
+// uptime, as if the counter were newly created. The uptime restarts from now if
+// the counter is running.
+func (c *FPSCounter) HardReset() {
+	c.Reset()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.allFrames = 0
+	c.startTime = time.Time{}
+	if c.running {
+		c.startTime = time.Now()
+	}
+}
+
+// Stop stops the counter and waits for its ticker goroutine to exit. Unless
+// RetainOnStop is set, the window is also cleared. It is safe to call Stop more
+// than once.
 func (c *FPSCounter) Stop() {
-	c.done <- struct{}{}
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = false
+	close(c.done)
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	if !c.RetainOnStop {
+		c.Reset()
+	}
 }
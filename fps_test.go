@@ -0,0 +1,76 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waitGoroutines waits up to a second for the number of goroutines to drop to
+// want, returning the number left.
+func waitGoroutines(want int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFPSCounterStopLeavesNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	c := NewFPSCounter(10*time.Millisecond, 5)
+	for i := 0; i < 5; i++ {
+		c.Start()
+		// a second Start on a running counter mustn't start another ticker
+		c.Start()
+		c.NextFrame()
+		time.Sleep(25 * time.Millisecond)
+		c.Stop()
+	}
+	// nor must a second Stop on a stopped counter block or panic
+	c.Stop()
+
+	if n := waitGoroutines(before); n != before {
+		t.Errorf("%d goroutines running after stopping, want %d", n, before)
+	}
+}
+
+func TestFPSCounterRestartForgetsPreviousCycle(t *testing.T) {
+	// a window long enough that no frame leaves it during the test
+	c := NewFPSCounter(10*time.Millisecond, 100)
+
+	// a first cycle at a far higher rate than the second
+	c.Start()
+	for i := 0; i < 1000; i++ {
+		c.NextFrame()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c.FPS() == 0 {
+		t.Fatal("FPS is 0 after the first cycle")
+	}
+	// stopping resets the counter by itself
+	c.Stop()
+	c.Start()
+	defer c.Stop()
+	if fps := c.FPS(); fps != 0 {
+		t.Errorf("FPS is %v on restarting, want 0", fps)
+	}
+	const frames = 5
+	for i := 0; i < frames; i++ {
+		c.NextFrame()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// the frames the FPS is computed from are only those of the second cycle
+	fps, d := c.FPS(), c.Duration()
+	if d <= 0 {
+		t.Fatalf("window is %v after the second cycle, want more than 0", d)
+	}
+	if got := fps * d.Seconds(); got < frames-0.01 || got > frames+0.01 {
+		t.Errorf("FPS %v over %v is %v frames, want %d", fps, d, got, frames)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FramePercentiles summarizes the frame durations recorded by a FrameTimer.
+type FramePercentiles struct {
+	Min time.Duration
+	P50 time.Duration
+	P95 time.Duration
+	Max time.Duration
+}
+
+// HistogramBucket is a single bucket of a frame duration histogram, counting
+// frames that took at most UpperBound (and more than the previous bucket's
+// UpperBound).
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// histogramBounds are the fixed upper bounds of the frame duration histogram,
+// doubling from 1ms to ~1s so that runs are comparable. Frames slower than the
+// last bound are counted in an extra overflow bucket.
+var histogramBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	4 * time.Millisecond,
+	8 * time.Millisecond,
+	16 * time.Millisecond,
+	32 * time.Millisecond,
+	64 * time.Millisecond,
+	128 * time.Millisecond,
+	256 * time.Millisecond,
+	512 * time.Millisecond,
+	1024 * time.Millisecond,
+}
+
+// FrameTimer records the durations of the most recent frames and reports
+// percentiles over them, along with a histogram of all frame durations since
+// it was created. It is safe for concurrent use.
+type FrameTimer struct {
+	mu        sync.Mutex
+	durations []time.Duration
+	writes    int
+	sorted    durationSlice
+
+	// histogram is updated atomically, outside of mu
+	histogram []int64
+}
+
+// NewFrameTimer creates a FrameTimer that keeps the durations of the given
+// number of most recent frames.
+func NewFrameTimer(frames int) *FrameTimer {
+	return &FrameTimer{
+		durations: make([]time.Duration, frames),
+		sorted:    make(durationSlice, 0, frames),
+		histogram: make([]int64, len(histogramBounds)+1),
+	}
+}
+
+// FrameDone records the duration of a single frame.
+func (t *FrameTimer) FrameDone(d time.Duration) {
+	t.mu.Lock()
+	t.durations[t.writes%len(t.durations)] = d
+	t.writes++
+	t.mu.Unlock()
+
+	i := sort.Search(len(histogramBounds), func(i int) bool {
+		return d <= histogramBounds[i]
+	})
+	atomic.AddInt64(&t.histogram[i], 1)
+}
+
+// Histogram returns the histogram of all frame durations recorded so far. The
+// last bucket counts frames slower than all others, and has an UpperBound of
+// math.MaxInt64.
+func (t *FrameTimer) Histogram() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(t.histogram))
+	for i := range t.histogram {
+		buckets[i].Count = atomic.LoadInt64(&t.histogram[i])
+		if i < len(histogramBounds) {
+			buckets[i].UpperBound = histogramBounds[i]
+		} else {
+			buckets[i].UpperBound = math.MaxInt64
+		}
+	}
+	return buckets
+}
+
+// Percentiles returns the min, median, 95th percentile and max of the recorded
+// frame durations. If no frames were recorded, all values are zero.
+func (t *FrameTimer) Percentiles() FramePercentiles {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.writes
+	if n > len(t.durations) {
+		n = len(t.durations)
+	}
+	if n == 0 {
+		return FramePercentiles{}
+	}
+
+	t.sorted = append(t.sorted[:0], t.durations[:n]...)
+	sort.Sort(&t.sorted)
+	return FramePercentiles{
+		Min: t.sorted[0],
+		P50: t.sorted[(n-1)*50/100],
+		P95: t.sorted[(n-1)*95/100],
+		Max: t.sorted[n-1],
+	}
+}
+
+type durationSlice []time.Duration
+
+func (s durationSlice) Len() int           { return len(s) }
+func (s durationSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s durationSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
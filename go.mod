@@ -2,6 +2,14 @@ module github.com/atavakoli/camera
 
 go 1.16
 
-require gocv.io/x/gocv v0.28.0
+require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/pion/webrtc/v3 v3.1.11
+	gocv.io/x/gocv v0.28.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
+)
 
 replace gocv.io/x/gocv => ../gocv
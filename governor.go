@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotifyLimit limits how often a Governor passes events on to its notifier.
+// The zero value doesn't limit anything.
+type NotifyLimit struct {
+	// Rate is the number of events per second that may be sent on average,
+	// in bursts of up to Burst; 0 is unlimited.
+	Rate  float64
+	Burst int
+	// Dedup suppresses events starting within this long of the last one
+	// sent with the same camera and zones.
+	Dedup time.Duration
+	// Digest, if set, batches the events starting within this long of the
+	// first into a single summary, for notifiers that support it.
+	Digest time.Duration
+}
+
+// ParseNotifyLimit parses a NotifyLimit from comma-separated settings: a rate
+// of N/unit, where unit is s, m, h, d or a duration, e.g. 10/h or 1/30s, then
+// any of burst=N, dedup=duration and digest=duration. The burst defaults to
+// the number of events in the rate's unit, or 1.
+func ParseNotifyLimit(s string) (NotifyLimit, error) {
+	var l NotifyLimit
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) == 1 {
+			parts := strings.SplitN(f, "/", 2)
+			if len(parts) != 2 {
+				return l, fmt.Errorf("invalid rate %q: must be N/unit", f)
+			}
+			n, err := strconv.ParseFloat(parts[0], 64)
+			if err != nil || n <= 0 {
+				return l, fmt.Errorf("invalid rate %q: must be positive", f)
+			}
+			unit, err := parseRateUnit(parts[1])
+			if err != nil {
+				return l, fmt.Errorf("invalid rate %q: %v", f, err)
+			}
+			l.Rate = n / unit.Seconds()
+			if l.Burst == 0 {
+				l.Burst = int(math.Max(1, math.Floor(n)))
+			}
+			continue
+		}
+		switch kv[0] {
+		case "burst":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n <= 0 {
+				return l, fmt.Errorf("invalid burst %q: must be a positive integer", kv[1])
+			}
+			l.Burst = n
+		case "dedup", "digest":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil || d < 0 {
+				return l, fmt.Errorf("invalid %s %q", kv[0], kv[1])
+			}
+			if kv[0] == "dedup" {
+				l.Dedup = d
+			} else {
+				l.Digest = d
+			}
+		default:
+			return l, fmt.Errorf("unknown setting %q", kv[0])
+		}
+	}
+	return l, nil
+}
+
+// parseRateUnit parses the unit of a rate: s, m, h, d, or a duration.
+func parseRateUnit(s string) (time.Duration, error) {
+	switch s {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	case "d":
+		return 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("unit must be s, m, h, d or a positive duration")
+	}
+	return d, nil
+}
+
+// TokenBucket is a token bucket rate limiter. It is safe for concurrent use,
+// and a nil *TokenBucket never limits anything.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full TokenBucket refilling at rate tokens per
+// second, up to burst, or returns nil if rate isn't positive.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// refill adds the tokens accrued since the last refill. b.mu must be held.
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// Available returns whether a token is available, without taking it.
+func (b *TokenBucket) Available(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// Take takes a token if one is available, returning whether it did.
+func (b *TokenBucket) Take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Digest summarizes the events that started within a window, as sent by a
+// Governor in digest mode.
+type Digest struct {
+	Count    int       `json:"count"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	PeakArea float64   `json:"peak_area"`
+	Cameras  []string  `json:"cameras"`
+	// Suppressed is the number of events suppressed by rate limits since
+	// the last notification sent.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Summary describes the digest in a sentence, e.g. "7 events between 22:10
+// and 22:25, peak area 14000".
+func (d *Digest) Summary() string {
+	var s string
+	if d.Count == 1 {
+		s = fmt.Sprintf("1 event at %s", d.First.Local().Format("15:04"))
+	} else {
+		s = fmt.Sprintf("%d events between %s and %s", d.Count, d.First.Local().Format("15:04"), d.Last.Local().Format("15:04"))
+	}
+	if len(d.Cameras) > 1 {
+		s += " on " + strings.Join(d.Cameras, ", ")
+	}
+	return s + fmt.Sprintf(", peak area %.0f", d.PeakArea) + suppressedNote(d.Suppressed)
+}
+
+// suppressedNote notes in a message that n detections were suppressed since
+// the last one, if any were.
+func suppressedNote(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (suppressed %d additional detections)", n)
+}
+
+// DigestNotifier is a Notifier that can also send digests. NotifyDigest must
+// never block.
+type DigestNotifier interface {
+	Notifier
+	NotifyDigest(d *Digest)
+}
+
+// Governor passes events on to a notifier within the limits of a NotifyLimit,
+// and a token bucket shared with other Governors, suppressing the rest. The
+// end of an event is only passed on if its start was. The number of events
+// suppressed is passed on with the next one sent, as MotionEvent.Suppressed.
+// In digest mode, the starts of events are batched into Digests instead, and
+// their ends aren't passed on.
+type Governor struct {
+	Name     string
+	Notifier Notifier
+	Limit    NotifyLimit
+	// Global, if set, is a token bucket shared by all Governors.
+	Global *TokenBucket
+
+	mu         sync.Mutex
+	bucket     *TokenBucket
+	lastZones  map[string]time.Time
+	sent       map[string]bool
+	suppressed int
+	digest     *Digest
+	digestIDs  map[string]bool
+	timer      *time.Timer
+}
+
+// NewGovernor creates a Governor passing events on to n, named name in logs
+// and counters. Digest mode is ignored, with a warning, if n doesn't support
+// digests.
+func NewGovernor(name string, n Notifier, limit NotifyLimit, global *TokenBucket) *Governor {
+	if _, ok := n.(DigestNotifier); limit.Digest > 0 && !ok {
+		logWarn("Notifier doesn't support digests, so sends events individually", "notifier", name)
+		limit.Digest = 0
+	}
+	return &Governor{
+		Name:      name,
+		Notifier:  n,
+		Limit:     limit,
+		Global:    global,
+		bucket:    NewTokenBucket(limit.Rate, limit.Burst),
+		lastZones: make(map[string]time.Time),
+		sent:      make(map[string]bool),
+		digestIDs: make(map[string]bool),
+	}
+}
+
+// Notify passes the event on, in the given phase, unless it's suppressed.
+func (g *Governor) Notify(ev *MotionEvent, phase string) {
+	key := fmt.Sprintf("%s/%d", ev.Camera, ev.ID)
+	now := time.Now()
+	g.mu.Lock()
+	if phase == PhaseEnd {
+		if g.digestIDs[key] {
+			// the digest will report its final peak area
+			delete(g.digestIDs, key)
+			if g.digest != nil && ev.PeakArea > g.digest.PeakArea {
+				g.digest.PeakArea = ev.PeakArea
+			}
+		}
+		sent := g.sent[key]
+		delete(g.sent, key)
+		g.mu.Unlock()
+		if sent {
+			g.Notifier.Notify(ev, phase)
+		}
+		return
+	}
+	defer g.mu.Unlock()
+
+	zones := append([]string(nil), ev.Zones...)
+	sort.Strings(zones)
+	zoneKey := ev.Camera + "/" + strings.Join(zones, ",")
+	if last, ok := g.lastZones[zoneKey]; ok && g.Limit.Dedup > 0 && ev.Start.Sub(last) < g.Limit.Dedup {
+		g.suppress()
+		return
+	}
+	g.lastZones[zoneKey] = ev.Start
+	if g.Limit.Dedup > 0 {
+		for k, last := range g.lastZones {
+			if ev.Start.Sub(last) >= g.Limit.Dedup {
+				delete(g.lastZones, k)
+			}
+		}
+	}
+
+	if g.Limit.Digest > 0 {
+		g.addToDigest(ev, key)
+		return
+	}
+	if !g.take(now) {
+		g.suppress()
+		return
+	}
+	g.sent[key] = true
+	cp := *ev
+	cp.Suppressed, g.suppressed = g.suppressed, 0
+	// notifiers never block, so this is safe to do with g.mu held
+	g.Notifier.Notify(&cp, phase)
+}
+
+// take takes a token from both the Governor's and the global bucket, or
+// neither. g.mu must be held.
+func (g *Governor) take(now time.Time) bool {
+	if !g.bucket.Available(now) || !g.Global.Take(now) {
+		return false
+	}
+	g.bucket.Take(now)
+	return true
+}
+
+// suppress counts a suppressed event. g.mu must be held.
+func (g *Governor) suppress() {
+	g.suppressed++
+	notifySuppressed.Drop(g.Name)
+}
+
+// addToDigest adds the start of an event to the digest in progress, starting
+// one if there isn't. g.mu must be held.
+func (g *Governor) addToDigest(ev *MotionEvent, key string) {
+	if g.digest == nil {
+		g.digest = &Digest{First: ev.Start}
+		g.timer = time.AfterFunc(g.Limit.Digest, g.flush)
+	}
+	d := g.digest
+	d.Count++
+	d.Last = ev.Start
+	if ev.PeakArea > d.PeakArea {
+		d.PeakArea = ev.PeakArea
+	}
+	found := false
+	for _, c := range d.Cameras {
+		found = found || c == ev.Camera
+	}
+	if !found {
+		d.Cameras = append(d.Cameras, ev.Camera)
+	}
+	g.digestIDs[key] = true
+}
+
+// flush sends the digest in progress, if there is one, and if the rate limits
+// allow.
+func (g *Governor) flush() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d := g.digest
+	if d == nil {
+		return
+	}
+	g.digest, g.timer = nil, nil
+	g.digestIDs = make(map[string]bool)
+	if !g.take(time.Now()) {
+		for i := 0; i < d.Count; i++ {
+			g.suppress()
+		}
+		return
+	}
+	d.Suppressed, g.suppressed = g.suppressed, 0
+	g.Notifier.(DigestNotifier).NotifyDigest(d)
+}
+
+// Close sends the digest in progress, if any, then closes the notifier.
+func (g *Governor) Close() {
+	g.mu.Lock()
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.mu.Unlock()
+	g.flush()
+	g.Notifier.Close()
+}
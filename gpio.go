@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// gpioLine is a GPIO line requested as an output.
+type gpioLine interface {
+	// Set sets the line's logical value, where active is high unless the
+	// line was requested active-low.
+	Set(active bool) error
+	// Close releases the line.
+	Close() error
+}
+
+// GPIOOutput drives a GPIO line, e.g. for a relay switching a floodlight,
+// active while any event is in progress, and for Hold after the last one
+// ends. It is safe for concurrent use, and a nil *GPIOOutput does nothing.
+type GPIOOutput struct {
+	// Hold is how long the line stays active after the last event ends.
+	Hold time.Duration
+
+	mu     sync.Mutex
+	line   gpioLine
+	name   string
+	active map[string]bool
+	timer  *time.Timer
+	on     bool
+	closed bool
+}
+
+// OpenGPIOOutput requests the given line of the GPIO chip at path (such as
+// /dev/gpiochip0) as an output, initially inactive. If activeLow is set, the
+// line is driven low when active, e.g. for active-low relays.
+func OpenGPIOOutput(path string, offset int, activeLow bool, hold time.Duration) (*GPIOOutput, error) {
+	line, err := openGPIOLine(path, offset, activeLow)
+	if err != nil {
+		return nil, err
+	}
+	return &GPIOOutput{
+		Hold:   hold,
+		line:   line,
+		name:   fmt.Sprintf("%s line %d", path, offset),
+		active: make(map[string]bool),
+	}, nil
+}
+
+// Notify activates the line as an event starts, and deactivates it Hold
+// after the last event in progress ends.
+func (g *GPIOOutput) Notify(ev *MotionEvent, phase string) {
+	if g == nil {
+		return
+	}
+	key := fmt.Sprintf("%s/%d", ev.Camera, ev.ID)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return
+	}
+	if phase == PhaseStart {
+		g.active[key] = true
+		if g.timer != nil {
+			g.timer.Stop()
+			g.timer = nil
+		}
+		g.set(true)
+		return
+	}
+	delete(g.active, key)
+	if len(g.active) > 0 || g.timer != nil {
+		return
+	}
+	if g.Hold <= 0 {
+		g.set(false)
+		return
+	}
+	g.timer = time.AfterFunc(g.Hold, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.timer = nil
+		if len(g.active) == 0 && !g.closed {
+			g.set(false)
+		}
+	})
+}
+
+// set sets the line, if it isn't already. g.mu must be held.
+func (g *GPIOOutput) set(on bool) {
+	if g.on == on {
+		return
+	}
+	if err := g.line.Set(on); err != nil {
+		logError("Setting GPIO line failed", "line", g.name, "error", err)
+		return
+	}
+	g.on = on
+}
+
+// Close deactivates and releases the line.
+func (g *GPIOOutput) Close() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return
+	}
+	g.closed = true
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if err := g.line.Set(false); err != nil {
+		logError("Resetting GPIO line failed", "line", g.name, "error", err)
+	}
+	g.line.Close()
+}
+
+// CloseOnPanic closes g if the goroutine is panicking, then carries on
+// panicking, so that the line isn't left active. It must be deferred.
+func (g *GPIOOutput) CloseOnPanic() {
+	if r := recover(); r != nil {
+		g.Close()
+		panic(r)
+	}
+}
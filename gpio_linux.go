@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The GPIO character device's v1 line handle ABI, from linux/gpio.h.
+const (
+	gpioGetLineHandleIoctl     = 0xc16cb403
+	gpioHandleSetLineValsIoctl = 0xc040b409
+
+	gpioHandleRequestOutput    = 1 << 1
+	gpioHandleRequestActiveLow = 1 << 2
+)
+
+type gpioHandleRequest struct {
+	LineOffsets   [64]uint32
+	Flags         uint32
+	DefaultValues [64]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	Fd            int32
+}
+
+type gpioHandleData struct {
+	Values [64]uint8
+}
+
+// chardevLine is a line requested through the GPIO character device, which
+// needs neither cgo nor the deprecated sysfs interface.
+type chardevLine struct {
+	fd int
+}
+
+func openGPIOLine(path string, offset int, activeLow bool) (gpioLine, error) {
+	chip, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+	req := gpioHandleRequest{Flags: gpioHandleRequestOutput, Lines: 1}
+	req.LineOffsets[0] = uint32(offset)
+	if activeLow {
+		req.Flags |= gpioHandleRequestActiveLow
+	}
+	copy(req.ConsumerLabel[:], "motiondetect")
+	if err := gpioIoctl(chip.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, &os.PathError{Op: "requesting line", Path: path, Err: err}
+	}
+	return &chardevLine{fd: int(req.Fd)}, nil
+}
+
+func (l *chardevLine) Set(active bool) error {
+	var data gpioHandleData
+	if active {
+		data.Values[0] = 1
+	}
+	return gpioIoctl(uintptr(l.fd), gpioHandleSetLineValsIoctl, unsafe.Pointer(&data))
+}
+
+func (l *chardevLine) Close() error {
+	return syscall.Close(l.fd)
+}
+
+func gpioIoctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// openGPIOLine isn't implemented outside Linux.
+func openGPIOLine(path string, offset int, activeLow bool) (gpioLine, error) {
+	return nil, errors.New("GPIO not available on this platform")
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	motiondetectpb "github.com/atavakoli/camera/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves the MotionDetect gRPC service, the counterpart of the
+// HTTP API: it reads and changes the same state as /api/status, /api/config
+// and /api/save, and streams the events of the same EventHub as
+// /api/events/ws.
+type GRPCServer struct {
+	motiondetectpb.UnimplementedMotionDetectServer
+
+	cams []*Camera
+	hub  *EventHub
+}
+
+// ServeGRPC serves the MotionDetect service on addr in the background, over
+// TLS if certFile and keyFile are set, allowing the same requests as auth. It
+// returns an error if the certificate can't be loaded, or addr can't be
+// listened on.
+func ServeGRPC(addr, certFile, keyFile string, cams []*Camera, hub *EventHub, auth *HTTPAuth) error {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if !auth.allowedGRPC(ctx) {
+				return nil, status.Error(codes.Unauthenticated, "unauthorized")
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if !auth.allowedGRPC(ss.Context()) {
+				return status.Error(codes.Unauthenticated, "unauthorized")
+			}
+			return handler(srv, ss)
+		}),
+	}
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer(opts...)
+	motiondetectpb.RegisterMotionDetectServer(s, &GRPCServer{cams: cams, hub: hub})
+	logInfo("Serving gRPC", "addr", addr, "tls", certFile != "")
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			logError("gRPC server failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// allowedGRPC returns whether a gRPC request is allowed, by the same rules as
+// an HTTP request, with the token in its authorization metadata. A nil
+// *HTTPAuth allows every request.
+func (a *HTTPAuth) allowedGRPC(ctx context.Context) bool {
+	if a == nil || (a.Token == "" && len(a.Allow) == 0) {
+		return true
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok {
+			for _, n := range a.Allow {
+				if n.Contains(addr.IP) {
+					return true
+				}
+			}
+		}
+	}
+	if a.Token == "" {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, h := range md.Get("authorization") {
+		if strings.HasPrefix(h, "Bearer ") && a.check(strings.TrimPrefix(h, "Bearer ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// camera returns the camera with the given name, or the first if name is
+// empty, or a NotFound error.
+func (s *GRPCServer) camera(name string) (*Camera, error) {
+	c := cameraNamed(s.cams, name)
+	if c == nil {
+		return nil, status.Errorf(codes.NotFound, "no camera named %q", name)
+	}
+	return c, nil
+}
+
+// GetStatus returns the status of each camera, from the same Snapshot as
+// /api/status.
+func (s *GRPCServer) GetStatus(ctx context.Context, req *motiondetectpb.GetStatusRequest) (*motiondetectpb.GetStatusResponse, error) {
+	report := Snapshot(s.cams)
+	resp := &motiondetectpb.GetStatusResponse{}
+	for i := range report.Cameras {
+		cs := &report.Cameras[i]
+		pb := &motiondetectpb.CameraStatus{
+			Camera:           cs.Camera,
+			Fps:              cs.FPS,
+			FramesTotal:      cs.Frames,
+			DetectionEnabled: cs.DetectionEnabled,
+			Armed:            cs.Armed,
+			Status:           cs.Status,
+			Events:           cs.Events,
+		}
+		if cs.LastFrame != nil {
+			pb.LastFrame = timestamppb.New(*cs.LastFrame)
+		}
+		resp.Cameras = append(resp.Cameras, pb)
+	}
+	return resp, nil
+}
+
+// GetConfig returns a camera's detection settings, like GET /api/config.
+func (s *GRPCServer) GetConfig(ctx context.Context, req *motiondetectpb.GetConfigRequest) (*motiondetectpb.DetectorConfig, error) {
+	return s.configure(ctx, req.GetCamera(), DetectorConfig{})
+}
+
+// UpdateConfig changes the given detection settings of a camera, all at once
+// or not at all, like PATCH /api/config.
+func (s *GRPCServer) UpdateConfig(ctx context.Context, req *motiondetectpb.UpdateConfigRequest) (*motiondetectpb.DetectorConfig, error) {
+	change := detectorConfigFromPB(req.GetConfig())
+	if errs := change.Validate(); len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for name, msg := range errs {
+			msgs = append(msgs, name+" "+msg)
+		}
+		sort.Strings(msgs)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid config: %s", strings.Join(msgs, "; "))
+	}
+	return s.configure(ctx, req.GetCamera(), change)
+}
+
+// configure applies change to the named camera's detection settings, and
+// returns the resulting settings.
+func (s *GRPCServer) configure(ctx context.Context, name string, change DetectorConfig) (*motiondetectpb.DetectorConfig, error) {
+	c, err := s.camera(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, ok := c.Configure(ctx, change)
+	if !ok {
+		return nil, status.Error(codes.Unavailable, configureError(ctx))
+	}
+	return detectorConfigToPB(cfg), nil
+}
+
+// StreamEvents sends events as they start and end, from the same EventHub as
+// /api/events/ws, until the client cancels. If the client can't keep up, the
+// stream ends with ResourceExhausted.
+func (s *GRPCServer) StreamEvents(req *motiondetectpb.StreamEventsRequest, stream motiondetectpb.MotionDetect_StreamEventsServer) error {
+	name := req.GetCamera()
+	if name != "" {
+		if _, err := s.camera(name); err != nil {
+			return err
+		}
+	}
+	events, unsubscribe := s.hub.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case p, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "too slow to keep up with events")
+			}
+			if name != "" && p.Camera != name {
+				continue
+			}
+			if err := stream.Send(eventToPB(p)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// TriggerSave saves a camera's pre-roll buffer, like the s key and POST
+// /api/save.
+func (s *GRPCServer) TriggerSave(ctx context.Context, req *motiondetectpb.TriggerSaveRequest) (*motiondetectpb.TriggerSaveResponse, error) {
+	c, err := s.camera(req.GetCamera())
+	if err != nil {
+		return nil, err
+	}
+	if c.Stopped() {
+		return nil, status.Error(codes.Unavailable, "camera has stopped")
+	}
+	c.Key('s')
+	return &motiondetectpb.TriggerSaveResponse{}, nil
+}
+
+// detectorConfigFromPB returns the DetectorConfig described by pb, with the
+// fields it leaves unset left unset.
+func detectorConfigFromPB(pb *motiondetectpb.DetectorConfig) DetectorConfig {
+	if pb == nil {
+		return DetectorConfig{}
+	}
+	d := DetectorConfig{
+		Threshold:        pb.Threshold,
+		MinArea:          pb.MinArea,
+		DrawContours:     pb.DrawContours,
+		DrawRects:        pb.DrawRects,
+		DetectionEnabled: pb.DetectionEnabled,
+	}
+	if pb.DilateSize != nil {
+		n := int(*pb.DilateSize)
+		d.DilateSize = &n
+	}
+	return d
+}
+
+// detectorConfigToPB returns the protobuf form of d.
+func detectorConfigToPB(d DetectorConfig) *motiondetectpb.DetectorConfig {
+	pb := &motiondetectpb.DetectorConfig{
+		Threshold:        d.Threshold,
+		MinArea:          d.MinArea,
+		DrawContours:     d.DrawContours,
+		DrawRects:        d.DrawRects,
+		DetectionEnabled: d.DetectionEnabled,
+	}
+	if d.DilateSize != nil {
+		n := int32(*d.DilateSize)
+		pb.DilateSize = &n
+	}
+	return pb
+}
+
+// eventToPB returns the protobuf form of p.
+func eventToPB(p *EventPayload) *motiondetectpb.MotionEvent {
+	pb := &motiondetectpb.MotionEvent{
+		Phase:           p.Phase,
+		Id:              int64(p.ID),
+		Camera:          p.Camera,
+		Start:           timestamppb.New(p.Start),
+		DurationSeconds: p.DurationSeconds,
+		SubEvents:       int32(p.SubEvents),
+		ActiveSeconds:   p.ActiveSeconds,
+		PeakArea:        p.PeakArea,
+		Zones:           p.Zones,
+		Clip:            p.Clip,
+		Segments:        p.Segments,
+		Snapshot:        p.Snapshot,
+		Thumbnail:       p.Thumbnail,
+		Regions:         p.Regions,
+		Suppressed:      int32(p.Suppressed),
+		OffsetSeconds:   p.OffsetSeconds,
+	}
+	if p.End != nil {
+		pb.End = timestamppb.New(*p.End)
+	}
+	if c := p.Clock; c != nil {
+		pb.Clock = &motiondetectpb.ClockStatus{
+			Sync:            c.Sync,
+			MaxErrorSeconds: c.MaxErrorSeconds,
+			EstErrorSeconds: c.EstErrorSeconds,
+			Steps:           int32(c.Steps),
+		}
+	}
+	return pb
+}
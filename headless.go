@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// haveDisplay returns whether there's a display to open windows on. Windows
+// and macOS always have one, but elsewhere it takes an X11 or Wayland
+// session, without which OpenCV aborts the program on creating a window.
+func haveDisplay() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// runHeadless runs in place of the UI when there's no window, until Quit is
+// called or all cameras have stopped, logging each camera's status line every
+// interval, unless it's 0. Without a keyboard, cameras are controlled through
+// the HTTP API, MQTT and signals instead.
+func runHeadless(cams []*Camera, interval time.Duration) {
+	var statusC <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		statusC = t.C
+	}
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-statusC:
+			for _, c := range cams {
+				// cameras that haven't produced a frame yet have no status line
+				if s := c.LatestState(); s.Width > 0 {
+					logInfo("Status", "camera", s.Camera, "status", s.Line(*verboseStatus))
+				}
+			}
+		case <-poll.C:
+		}
+		running := false
+		for _, c := range cams {
+			if !c.Stopped() {
+				running = true
+			}
+		}
+		if !running {
+			return
+		}
+	}
+}
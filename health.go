@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SaveTracker tracks the files being saved, so that a save that never
+// finishes can be noticed. It is safe for concurrent use.
+type SaveTracker struct {
+	mu      sync.Mutex
+	next    int
+	pending map[int]pendingSave
+}
+
+type pendingSave struct {
+	filename string
+	start    time.Time
+}
+
+// saves tracks every file saved through finalizeSegment.
+var saves = &SaveTracker{pending: make(map[int]pendingSave)}
+
+// Begin records that filename has started being saved, returning a function
+// to call once it's done.
+func (t *SaveTracker) Begin(filename string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := t.next
+	t.next++
+	t.pending[id] = pendingSave{filename, time.Now()}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.pending, id)
+	}
+}
+
+// Oldest returns the file that has been being saved the longest, and when it
+// started, or false if nothing is being saved.
+func (t *SaveTracker) Oldest() (string, time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var oldest pendingSave
+	for _, s := range t.pending {
+		if oldest.start.IsZero() || s.start.Before(oldest.start) {
+			oldest = s
+		}
+	}
+	return oldest.filename, oldest.start, !oldest.start.IsZero()
+}
+
+// HealthCheck is the result of a single check made by /healthz or /readyz.
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Camera string `json:"camera,omitempty"`
+	OK     bool   `json:"ok"`
+	// Detail explains why the check failed.
+	Detail string `json:"detail,omitempty"`
+	// FailingSeconds is how long the check has been failing for, if known.
+	FailingSeconds float64 `json:"failing_seconds,omitempty"`
+}
+
+// HealthReport is the body of /healthz and /readyz responses.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// FrameReceived records that the camera's capture loop has just processed a
+// frame.
+func (m *CameraMetrics) FrameReceived() {
+	atomic.StoreInt64(&m.lastFrame, time.Now().UnixNano())
+}
+
+// LastFrame returns when the camera's capture loop last processed a frame, or
+// the zero time if it hasn't yet.
+func (m *CameraMetrics) LastFrame() time.Time {
+	ns := atomic.LoadInt64(&m.lastFrame)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// HandleHealth registers /healthz and /readyz with http.DefaultServeMux.
+// /healthz checks that every camera has processed a frame within
+// frameTimeout, and that no file has been being saved for longer than
+// saveTimeout. /readyz checks that every camera has processed its first
+// frame. Both respond 200 if all their checks pass, and 503 otherwise, with a
+// HealthReport.
+func HandleHealth(cams []*Camera, frameTimeout, saveTimeout time.Duration) {
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		var checks []HealthCheck
+		for _, c := range cams {
+			check := HealthCheck{Name: "frames", Camera: c.Name, OK: true}
+			last := c.Metrics.LastFrame()
+			switch {
+			case c.Stopped():
+				check.OK, check.Detail = false, "capture has stopped"
+			case last.IsZero():
+				// not ready yet, which /readyz reports; a camera that never
+				// delivers a frame is caught once the process has been up
+				// for frameTimeout
+				if age := c.FPS.Uptime(); age > frameTimeout {
+					check.OK, check.Detail = false, fmt.Sprintf("no frames received in %v", age.Round(time.Second))
+					check.FailingSeconds = (age - frameTimeout).Seconds()
+				}
+			case now.Sub(last) > frameTimeout:
+				age := now.Sub(last)
+				check.OK, check.Detail = false, fmt.Sprintf("no frames received for %v", age.Round(time.Second))
+				check.FailingSeconds = (age - frameTimeout).Seconds()
+			}
+			checks = append(checks, check)
+		}
+		check := HealthCheck{Name: "saves", OK: true}
+		if filename, start, ok := saves.Oldest(); ok && now.Sub(start) > saveTimeout {
+			age := now.Sub(start)
+			check.OK, check.Detail = false, fmt.Sprintf("saving %s has taken %v", filename, age.Round(time.Second))
+			check.FailingSeconds = (age - saveTimeout).Seconds()
+		}
+		writeHealth(w, append(checks, check))
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		var checks []HealthCheck
+		for _, c := range cams {
+			check := HealthCheck{Name: "first_frame", Camera: c.Name, OK: true}
+			if c.Metrics.LastFrame().IsZero() {
+				check.OK, check.Detail = false, "no frame received yet"
+			}
+			checks = append(checks, check)
+		}
+		writeHealth(w, checks)
+	})
+}
+
+// writeHealth writes a HealthReport of the given checks, with a 503 status if
+// any failed.
+func writeHealth(w http.ResponseWriter, checks []HealthCheck) {
+	report := HealthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			report.Status, status = "unhealthy", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, report)
+}
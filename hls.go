@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// hlsQueue is the number of frames that may be waiting for ffmpeg before
+	// frames start being dropped.
+	hlsQueue = 5
+	// hlsMaxBackoff is the longest wait before restarting ffmpeg after it
+	// has exited.
+	hlsMaxBackoff = 30 * time.Second
+	// hlsStopTimeout is how long ffmpeg is given to flush its output at
+	// shutdown before it is killed.
+	hlsStopTimeout = 5 * time.Second
+	// hlsPlaylist is the name of the playlist within the HLS directory.
+	hlsPlaylist = "live.m3u8"
+)
+
+type hlsFrame struct {
+	data          []byte
+	width, height int
+}
+
+// HLSStreamer encodes frames to an HLS stream in a directory, by piping them
+// to an ffmpeg process, which is restarted if it exits unexpectedly. Segments
+// are deleted by ffmpeg once they drop out of the playlist.
+type HLSStreamer struct {
+	Dir         string
+	SegmentTime time.Duration
+	ListSize    int
+	FPS         float64
+
+	frames chan hlsFrame
+	done   chan struct{}
+}
+
+// NewHLSStreamer creates an HLSStreamer writing to dir, removing any stale
+// stream left there by a previous run, and starts it in the background.
+func NewHLSStreamer(dir string, segmentTime time.Duration, listSize int, fps float64) (*HLSStreamer, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := removeHLSFiles(dir); err != nil {
+		return nil, err
+	}
+	s := &HLSStreamer{
+		Dir:         dir,
+		SegmentTime: segmentTime,
+		ListSize:    listSize,
+		FPS:         fps,
+		frames:      make(chan hlsFrame, hlsQueue),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// removeHLSFiles removes the playlist and segments in dir.
+func removeHLSFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".ts", ".m3u8", ".tmp":
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler serving the stream's directory.
+func (s *HLSStreamer) Handler() http.Handler {
+	return http.FileServer(http.Dir(s.Dir))
+}
+
+// Write queues a copy of the given 8-bit BGR frame to be streamed. If the queue
+// is full, the frame is dropped.
+func (s *HLSStreamer) Write(img gocv.Mat) {
+	if img.Type() != gocv.MatTypeCV8UC3 {
+		return
+	}
+	f := hlsFrame{img.ToBytes(), img.Cols(), img.Rows()}
+	select {
+	case s.frames <- f:
+	default:
+		drops.Drop("hls")
+	}
+}
+
+// Close stops streaming, giving ffmpeg a chance to finish the stream.
+func (s *HLSStreamer) Close() {
+	close(s.frames)
+	<-s.done
+}
+
+func (s *HLSStreamer) run() {
+	defer close(s.done)
+
+	backoff := time.Second
+	for {
+		first, ok := <-s.frames
+		if !ok {
+			return
+		}
+		started := time.Now()
+		err := s.stream(first)
+		if err == nil {
+			return
+		}
+		if time.Since(started) > hlsMaxBackoff {
+			backoff = time.Second
+		}
+		logError("HLS stream failed, restarting", "path", s.Dir, "retry_in", backoff, "error", err)
+
+		// keep draining frames while waiting, so that the queue doesn't hold
+		// on to stale ones
+		timer := time.NewTimer(backoff)
+	wait:
+		for {
+			select {
+			case _, ok := <-s.frames:
+				if !ok {
+					timer.Stop()
+					return
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		if backoff *= 2; backoff > hlsMaxBackoff {
+			backoff = hlsMaxBackoff
+		}
+	}
+}
+
+// stream runs ffmpeg, starting with the given frame, until it fails or the
+// frames channel is closed, in which case nil is returned. Frames that don't
+// match the dimensions of the first are skipped.
+func (s *HLSStreamer) stream(first hlsFrame) error {
+	gop := int(s.FPS * s.SegmentTime.Seconds())
+	if gop < 1 {
+		gop = 1
+	}
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "bgr24",
+		"-s", fmt.Sprintf("%dx%d", first.width, first.height),
+		"-r", strconv.FormatFloat(s.FPS, 'f', 2, 64),
+		"-i", "-",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+		"-g", strconv.Itoa(gop),
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(s.SegmentTime.Seconds(), 'f', -1, 64),
+		"-hls_list_size", strconv.Itoa(s.ListSize),
+		"-hls_flags", "delete_segments+omit_endlist",
+		"-hls_segment_filename", filepath.Join(s.Dir, "segment_%05d.ts"),
+		filepath.Join(s.Dir, hlsPlaylist),
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	f := first
+	for {
+		if f.width == first.width && f.height == first.height {
+			if _, err := stdin.Write(f.data); err != nil {
+				cmd.Process.Kill()
+				return fmt.Errorf("ffmpeg: %v", waitErr(<-exited, err))
+			}
+		}
+		var ok bool
+		select {
+		case f, ok = <-s.frames:
+			if !ok {
+				stdin.Close()
+				select {
+				case <-exited:
+				case <-time.After(hlsStopTimeout):
+					cmd.Process.Kill()
+					<-exited
+				}
+				return nil
+			}
+		case err := <-exited:
+			return fmt.Errorf("ffmpeg: %v", waitErr(err, io.ErrUnexpectedEOF))
+		}
+	}
+}
+
+// waitErr returns the error from waiting for a process if there was one, and
+// fallback otherwise.
+func waitErr(err, fallback error) error {
+	if err != nil {
+		return err
+	}
+	return fallback
+}
+
+// hlsIndex is a minimal page playing the stream, relying on native HLS
+// support, which most mobile browsers have.
+const hlsIndex = `<!DOCTYPE html>
+<title>motiondetect</title>
+<video src="` + hlsPlaylist + `" controls autoplay muted playsinline style="max-width:100%"></video>
+`
+
+// HandleHLS registers the stream's directory at prefix with
+// http.DefaultServeMux, along with a page playing it.
+func HandleHLS(prefix string, s *HLSStreamer) {
+	files := http.StripPrefix(prefix, s.Handler())
+	http.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, prefix) == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, hlsIndex)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// authCookie holds the token for browsers, which can't send it in a header
+// for images, streams and WebSockets.
+const authCookie = "motiondetect_token"
+
+// HTTPAuth decides which requests to the HTTP interface are allowed: those
+// with the bearer token, if Token is set, and those from the Allow networks,
+// if any. Requests that aren't get 401 Unauthorized, whatever the path, so
+// as not to reveal which paths exist. If neither is set, every request is
+// allowed. A nil *HTTPAuth allows every request.
+type HTTPAuth struct {
+	// Token is accepted in an "Authorization: Bearer" header, a token query
+	// parameter, or the cookie set when a browser first gives it as a query
+	// parameter.
+	Token string
+	// Allow lists the networks requests are allowed from without the token.
+	Allow []*net.IPNet
+	// Public lists paths that are always allowed, e.g. health checks.
+	Public []string
+}
+
+// ParseAllowList parses comma-separated CIDR ranges, or single addresses.
+func ParseAllowList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			ip := net.ParseIP(f)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", f)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Wrap returns a handler passing the requests a allows to h.
+func (a *HTTPAuth) Wrap(h http.Handler) http.Handler {
+	if a == nil || (a.Token == "" && len(a.Allow) == 0) {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowed(w, r) {
+			if a.Token != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="motiondetect"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowed returns whether r is allowed, setting the token cookie if it was
+// given as a query parameter.
+func (a *HTTPAuth) allowed(w http.ResponseWriter, r *http.Request) bool {
+	for _, p := range a.Public {
+		if r.URL.Path == p {
+			return true
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, n := range a.Allow {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	if a.Token == "" {
+		return false
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return a.check(strings.TrimPrefix(h, "Bearer "))
+	}
+	if t := r.URL.Query().Get("token"); t != "" {
+		if !a.check(t) {
+			return false
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookie,
+			Value:    t,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		return true
+	}
+	if c, err := r.Cookie(authCookie); err == nil {
+		return a.check(c.Value)
+	}
+	return false
+}
+
+// check compares a token with Token in constant time.
+func (a *HTTPAuth) check(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
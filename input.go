@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// defaultStdinFPS is the nominal frame rate of frames read from stdin, or
+// generated by a synthetic source, if -fps isn't set.
+const defaultStdinFPS = 30
+
+// Input types, as accepted by -input-type.
+const (
+	InputAuto   = "auto"
+	InputDevice = "device"
+	InputFile   = "file"
+	InputStream = "stream"
+	InputMJPEG  = "mjpeg"
+	InputStdin  = "stdin"
+	// InputSynthetic is chosen with the source "synthetic".
+	InputSynthetic = "synthetic"
+)
+
+// DetectInputType returns the type of the given source. The source "-" is
+// always stdin, and "synthetic" is always a SyntheticSource. Otherwise the
+// type is inputType unless that is InputAuto, in which case it is guessed:
+// numbers are device IDs, existing regular files are files, HTTP URLs
+// mentioning mjpeg or mjpg are MJPEG streams, and anything else (URLs, device
+// paths, GStreamer pipelines) is treated as a live stream.
+func DetectInputType(source, inputType string) (string, error) {
+	switch source {
+	case "-":
+		return InputStdin, nil
+	case InputSynthetic:
+		return InputSynthetic, nil
+	}
+	switch inputType {
+	case InputDevice, InputFile, InputStream, InputMJPEG:
+		return inputType, nil
+	case InputAuto:
+	default:
+		return "", fmt.Errorf("unknown input type %q", inputType)
+	}
+	if _, err := strconv.Atoi(source); err == nil {
+		return InputDevice, nil
+	}
+	if info, err := os.Stat(source); err == nil && info.Mode().IsRegular() {
+		return InputFile, nil
+	}
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if path := strings.ToLower(u.Path); strings.Contains(path, "mjpeg") || strings.Contains(path, "mjpg") {
+			return InputMJPEG, nil
+		}
+	}
+	return InputStream, nil
+}
+
+// FrameSource is where a camera reads its frames from. It is implemented by
+// *gocv.VideoCapture, by MJPEGSource for inputs that gocv can't reliably
+// read, and by RawSource for frames piped to stdin.
+type FrameSource interface {
+	Read(m *gocv.Mat) bool
+	Get(prop gocv.VideoCaptureProperties) float64
+	Close() error
+}
+
+// openSource opens the given source, of the given input type.
+func openSource(source, input string) (FrameSource, error) {
+	switch input {
+	case InputMJPEG:
+		return OpenMJPEG(source)
+	case InputStdin:
+		fps := *captureFPS
+		if fps <= 0 {
+			fps = defaultStdinFPS
+		}
+		return NewRawSource(os.Stdin, *width, *height, fps)
+	case InputSynthetic:
+		return openSynthetic()
+	}
+	// -backend was checked at startup
+	api, _ := parseBackend(*backend)
+	vc, err := gocv.OpenVideoCaptureWithAPI(source, api)
+	if err != nil {
+		vc.Close()
+		return nil, err
+	}
+	if input == InputDevice {
+		configureDevice(vc, source)
+	}
+	return vc, nil
+}
+
+// openSynthetic creates the SyntheticSource configured by -synthetic-*, with
+// the size and rate given by -width, -height and -fps.
+func openSynthetic() (*SyntheticSource, error) {
+	w, h, fps := *width, *height, *captureFPS
+	if w <= 0 || h <= 0 {
+		w, h = 640, 480
+	}
+	if fps <= 0 {
+		fps = defaultStdinFPS
+	}
+	bg, err := ParseColor(*syntheticBg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -synthetic-bg: %v", err)
+	}
+	objects, err := parseSyntheticObjects(*syntheticObjects)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -synthetic-objects: %v", err)
+	}
+	return NewSyntheticSource(w, h, fps, bg, objects, *syntheticDuration, !*asFastAsPossible)
+}
+
+// configureDevice requests the frame size and rate given by -width, -height
+// and -fps from a device, warning about any it doesn't accept, and then sets
+// its image controls. Devices often pick the closest mode they support
+// instead, so what they actually negotiated has to be read back.
+func configureDevice(vc *gocv.VideoCapture, source string) {
+	settings := []struct {
+		name string
+		prop gocv.VideoCaptureProperties
+		want float64
+	}{
+		{"width", gocv.VideoCaptureFrameWidth, float64(*width)},
+		{"height", gocv.VideoCaptureFrameHeight, float64(*height)},
+		{"fps", gocv.VideoCaptureFPS, *captureFPS},
+	}
+	for _, s := range settings {
+		if s.want > 0 {
+			vc.Set(s.prop, s.want)
+		}
+	}
+	for _, s := range settings {
+		if got := vc.Get(s.prop); s.want > 0 && math.Abs(got-s.want) > 0.5 {
+			logWarn("Device is using a different value than requested", "source", source, "setting", s.name, "got", got, "requested", s.want)
+		}
+	}
+	configureControls(vc, source)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses a level name: debug, info, warn (or warning) or error.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q: must be debug, info, warn or error", s)
+}
+
+// logPrefixes map the prefixes of messages logged with the log package to
+// their levels. The prefix is removed from messages with an explicit level.
+var logPrefixes = []struct {
+	prefix string
+	level  LogLevel
+	strip  bool
+}{
+	{"DEBUG: ", LevelDebug, true},
+	{"WARNING: ", LevelWarn, true},
+	{"ERROR: ", LevelError, true},
+	{"Error ", LevelError, false},
+}
+
+// logSink is where a Logger writes formatted lines.
+type logSink interface {
+	// WriteLine writes a line, without a trailing newline, at a level.
+	WriteLine(level LogLevel, line []byte) error
+	// Timestamps returns whether lines need a timestamp, which syslog and
+	// journald add themselves.
+	Timestamps() bool
+	Close() error
+}
+
+// Logger writes leveled log messages, each with a message and key-value
+// attributes, as text or JSON lines. It is safe for concurrent use.
+//
+// A Logger is also an io.Writer for the log package, so that everything
+// logged with it is leveled by its prefix: "DEBUG: ", "WARNING: " or
+// "ERROR: " (which are removed), or "Error " for errors; everything else is
+// info.
+type Logger struct {
+	// Level is the lowest level logged.
+	Level LogLevel
+	// JSON writes JSON lines, with time, level and msg fields followed by
+	// the attributes, instead of text.
+	JSON bool
+
+	mu   sync.Mutex
+	sink logSink
+}
+
+// logger is the Logger everything is logged with, including the log package
+// once SetupLogging is called.
+var logger = &Logger{Level: LevelInfo, sink: &streamSink{w: os.Stderr}}
+
+// SetupLogging replaces logger with one logging at the named level, in the
+// named format (text or json), to output: stderr, syslog, or a file path. A
+// file is rotated, by renaming it with a ".1" suffix, once it exceeds maxSize
+// bytes, unless that's 0. Standard error is written with journald priority
+// prefixes, and without timestamps, when running under systemd.
+func SetupLogging(level, format, output string, maxSize int64) error {
+	l := &Logger{}
+	var err error
+	if l.Level, err = ParseLogLevel(level); err != nil {
+		return fmt.Errorf("invalid -log-level: %v", err)
+	}
+	switch format {
+	case "text":
+	case "json":
+		l.JSON = true
+	default:
+		return fmt.Errorf("invalid -log-format %q: must be text or json", format)
+	}
+	switch output {
+	case "", "stderr":
+		l.sink = &streamSink{w: os.Stderr, journal: os.Getenv("JOURNAL_STREAM") != ""}
+	case "syslog":
+		if l.sink, err = newSyslogSink(); err != nil {
+			return fmt.Errorf("invalid -log-output: %v", err)
+		}
+	default:
+		f := &rotatingFile{path: output, maxSize: maxSize}
+		if err := f.open(); err != nil {
+			return fmt.Errorf("invalid -log-output: %v", err)
+		}
+		l.sink = f
+	}
+	logger = l
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(l)
+	return nil
+}
+
+// Enabled returns whether messages at level are logged, so that expensive
+// debug messages can be skipped.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.Level
+}
+
+// Log logs msg at level, with attributes given as alternating keys and
+// values.
+func (l *Logger) Log(level LogLevel, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var buf bytes.Buffer
+	now := time.Now()
+	if l.JSON {
+		buf.WriteByte('{')
+		if l.sink.Timestamps() {
+			writeJSONAttr(&buf, "time", now.Format(time.RFC3339Nano))
+			buf.WriteByte(',')
+		}
+		writeJSONAttr(&buf, "level", level.String())
+		buf.WriteByte(',')
+		writeJSONAttr(&buf, "msg", msg)
+		for i := 0; i < len(kv); i += 2 {
+			buf.WriteByte(',')
+			key, val := logAttr(kv, i)
+			writeJSONAttr(&buf, key, val)
+		}
+		buf.WriteByte('}')
+	} else {
+		if l.sink.Timestamps() {
+			buf.WriteString(now.Format("2006/01/02 15:04:05.000 "))
+		}
+		fmt.Fprintf(&buf, "%-5s %s", level, msg)
+		for i := 0; i < len(kv); i += 2 {
+			key, val := logAttr(kv, i)
+			fmt.Fprintf(&buf, " %s=%s", key, quoteLogValue(fmt.Sprint(val)))
+		}
+	}
+	if err := l.sink.WriteLine(level, buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s (logging failed: %v)\n", buf.Bytes(), err)
+	}
+}
+
+// Write logs a message written by the log package, at the level given by its
+// prefix.
+func (l *Logger) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := LevelInfo
+	for _, lp := range logPrefixes {
+		if strings.HasPrefix(msg, lp.prefix) {
+			level = lp.level
+			if lp.strip {
+				msg = msg[len(lp.prefix):]
+			}
+			break
+		}
+	}
+	l.Log(level, msg)
+	return len(p), nil
+}
+
+// Close closes the log output.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sink.Close()
+}
+
+// logAttr returns the i-th key and its value, which is formatted as a string
+// unless it's a number or a boolean.
+func logAttr(kv []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(kv[i])
+	if i+1 >= len(kv) {
+		return "!BADKEY", key
+	}
+	switch v := kv[i+1].(type) {
+	case int, int64, uint64, float64, bool, string:
+		return key, v
+	case error:
+		return key, v.Error()
+	default:
+		return key, fmt.Sprint(v)
+	}
+}
+
+// writeJSONAttr writes "key":value to buf.
+func writeJSONAttr(buf *bytes.Buffer, key string, val interface{}) {
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(val)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(val))
+	}
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+}
+
+// quoteLogValue quotes a text attribute value if it's empty, or contains
+// spaces, quotes or equals signs.
+func quoteLogValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logDebug logs msg at debug level, with attributes given as alternating
+// keys and values.
+func logDebug(msg string, kv ...interface{}) { logger.Log(LevelDebug, msg, kv...) }
+
+// logInfo logs msg at info level.
+func logInfo(msg string, kv ...interface{}) { logger.Log(LevelInfo, msg, kv...) }
+
+// logWarn logs msg at warn level.
+func logWarn(msg string, kv ...interface{}) { logger.Log(LevelWarn, msg, kv...) }
+
+// logError logs msg at error level.
+func logError(msg string, kv ...interface{}) { logger.Log(LevelError, msg, kv...) }
+
+// streamSink writes lines to a stream, such as standard error, optionally
+// prefixed with their syslog priority for journald.
+type streamSink struct {
+	w       io.Writer
+	journal bool
+}
+
+// syslogPriorities are the syslog priorities of each level.
+var syslogPriorities = []int{7, 6, 4, 3}
+
+func (s *streamSink) WriteLine(level LogLevel, line []byte) error {
+	if s.journal {
+		if _, err := fmt.Fprintf(s.w, "<%d>", syslogPriorities[level]); err != nil {
+			return err
+		}
+	}
+	_, err := s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *streamSink) Timestamps() bool { return !s.journal }
+
+func (s *streamSink) Close() error { return nil }
+
+// rotatingFile appends lines to a file, rotating it like an EventLog.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// WriteLine appends line, rotating the file first if it would grow past
+// maxSize. If the file can't be rotated, the line is appended to it anyway,
+// and the error returned.
+func (r *rotatingFile) WriteLine(level LogLevel, line []byte) error {
+	line = append(line, '\n')
+	var rotateErr error
+	if r.f != nil && r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		r.f.Close()
+		r.f = nil
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			rotateErr = fmt.Errorf("rotating %s failed: %w", r.path, err)
+		}
+	}
+	// the file is reopened whether or not it was rotated, and if it can't
+	// be, the next line tries again
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+func (r *rotatingFile) Timestamps() bool { return true }
+
+func (r *rotatingFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
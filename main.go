@@ -4,106 +4,743 @@ import (
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"gocv.io/x/gocv"
 )
 
+// version is reported to ONVIF clients and in mDNS announcements.
+const version = "1.0"
+
 var (
-	Width  int
-	Height int
-	MaxFPS float64
+	frameTimer = NewFrameTimer(150)
+	drops      = NewDropCounter()
 
-	Detector *MotionDetector
-	DetectionEnabled bool
+	// done is closed, once, by Quit.
+	done     = make(chan struct{})
+	doneOnce sync.Once
+)
 
-	BufferDuration time.Duration = 5 * time.Second
+// Quit asks everything to stop: the cameras' capture loops and the UI. It is
+// safe to call more than once, from any goroutine.
+func Quit() {
+	doneOnce.Do(func() { close(done) })
+}
 
-	fps = NewFPSCounter(5)
+// Quitting returns whether Quit has been called.
+func Quitting() bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
 
-	FieldChanged = 'a'
+// hudBucketsPerRow is the number of FPS counter buckets shown on each line of
+// the HUD.
+const hudBucketsPerRow = 10
 
-	Done bool
-)
+var retentionMaxSize byteSize
+
+var eventLogMaxSize byteSize
+var continuousMaxSize byteSize
+var logMaxSize byteSize
+var smtpMaxAttachment = byteSize(10 << 20)
+
+// stringList is a flag.Value for flags that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var mainStreams stringList
+
+func init() {
+	flag.Var(&eventLogMaxSize, "event-log-max-size", "rotate -event-log once it exceeds this size, e.g. 10M (0 to never rotate)")
+	flag.Var(&continuousMaxSize, "continuous-max-size", "delete the oldest continuous recordings to keep -continuous-dir under this size, e.g. 100G (0 for no limit)")
+	flag.Var(&mainStreams, "main-stream", "record this high resolution stream, as [camera=]URL, while detecting on the camera's own (sub-)stream; may be repeated, and applies to the first camera if no name is given")
+	flag.Var(&smtpMaxAttachment, "smtp-max-attachment", "with -smtp-host, the largest clip to attach, e.g. 10M; bigger clips are referred to by path")
+	flag.Var(&logMaxSize, "log-max-size", "rotate a -log-output file once it exceeds this size, e.g. 10M (0 to never rotate)")
+	flag.Var(&retentionMaxSize, "retention-max-size", "delete the oldest recordings in -output-dir to keep it under this size, e.g. 20G (0 for no limit)")
+}
 
 var (
+	configPath = flag.String("config", "", "read settings from this YAML file, whose keys are flag names, optionally grouped in sections named after their prefixes (e.g. http: {addr: \":8080\"}), and whose cameras key lists the cameras if none are given as arguments; flags given on the command line override the file, which overrides the defaults (see \"config print\"); on SIGHUP or \"reload\", changes to detection, retention and notifier settings are applied, and others logged as needing a restart")
+
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	memprofile = flag.String("memprofile", "", "write memory profile to file")
 	matprofile = flag.String("matprofile", "", "write matrix memory profile to file")
+	logLevel   = flag.String("log-level", "info", "log messages at this level and above: debug (including per-frame statistics), info, warn or error")
+	logFormat  = flag.String("log-format", "text", "format of log messages: text, or json (one object per line)")
+	logOutput  = flag.String("log-output", "stderr", "where to log: stderr (with journald priorities under systemd), syslog, or a file path")
+
+	pprofAddr = flag.String("pprof-addr", "", "serve live profiles at /debug/pprof/ and the matrix memory profile at /debug/matprofile on this address, separately from -http-addr (e.g. localhost:6060)")
+
+	fpsMode  = flag.String("fps-mode", "window", "FPS smoothing mode: window (rolling average) or ema (exponential moving average)")
+	fpsAlpha = flag.Float64("fps-alpha", 0.1, "smoothing factor in (0, 1] for -fps-mode=ema")
+	fpsLimit = flag.Float64("max-fps", 0, "cap the capture loop at this many frames per second (0 for no limit)")
+
+	stageTiming      = flag.Bool("stage-timing", false, "measure and display the time spent in each stage of the capture loop, and log it at -log-level=debug")
+	stageLogInterval = flag.Duration("stage-log-interval", 10*time.Second, "how often to log frame statistics, including stage timings with -stage-timing, at -log-level=debug")
+
+	lowFPS       = flag.Float64("low-fps", 0, "warn when the FPS stays below this value for -low-fps-for (0 to disable)")
+	lowFPSFor    = flag.Duration("low-fps-for", 5*time.Second, "how long the FPS must stay below -low-fps before warning")
+	lowFPSReopen = flag.Bool("low-fps-reopen", false, "reopen the capture device when the FPS stays below -low-fps")
+
+	inputType         = flag.String("input-type", InputAuto, "type of the input: device, file, stream, mjpeg (HTTP multipart JPEG, read natively), or auto to guess from the argument")
+	backend           = flag.String("backend", "any", "capture backend to open inputs with, e.g. v4l2, gstreamer, ffmpeg, dshow or msmf; with gstreamer, an input may be a full pipeline ending in appsink (list them with \"devices -backends\")")
+	speed             = flag.Float64("speed", 1, "playback speed for file inputs, e.g. 4 for 4x")
+	stdinInput        = flag.Bool("stdin", false, "also read raw bgr24 frames from stdin (as the camera \"stdin\"), of the size given by -width and -height; same as passing \"-\"")
+	width             = flag.Int("width", 0, "frame width to request from device inputs, and the width of raw frames read from stdin")
+	height            = flag.Int("height", 0, "frame height to request from device inputs, and the height of raw frames read from stdin")
+	captureFPS        = flag.Float64("fps", 0, "frame rate to request from device inputs, and the nominal rate of raw frames read from stdin (default 30)")
+	noMirror          = flag.Bool("no-mirror", false, "don't flip frames horizontally, e.g. for surveillance cameras rather than selfie-style webcams")
+	rotate            = flag.Int("rotate", 0, "rotate frames clockwise by 90, 180 or 270 degrees right after capture; detection regions are in rotated coordinates (cycle with 'R')")
+	crop              = flag.String("crop", "", "only process this region of each frame, as x,y,w,h in pixels of the (rotated) frame")
+	autoExposure      = flag.String("auto-exposure", "", "turn auto exposure of device inputs on or off (default: leave as is)")
+	exposureStep      = flag.Float64("exposure-step", 1, "how much '[' and ']' change the exposure by, in the device's units")
+	calibrateFrames   = flag.Int("calibrate-frames", 30, "read this many frames from device and stream inputs when they're opened, to measure their real frame rate (0 to trust the reported rate)")
+	syntheticBg       = flag.String("synthetic-bg", "#303030", "background color of the \"synthetic\" input")
+	syntheticObjects  = flag.String("synthetic-objects", "rect:60:120:3s/20s", "objects moving across the \"synthetic\" input, as a comma-separated list of shape:size:speed[:move/every], e.g. circle:40:200 (shape is rect or circle, speed in pixels per second)")
+	syntheticDuration = flag.Duration("synthetic-duration", 0, "stop the \"synthetic\" input after this long, in its own time (0 to run forever)")
+	asFastAsPossible  = flag.Bool("as-fast-as-possible", false, "process file and synthetic inputs as fast as possible, rather than at their own frame rate")
+
+	reconnectMaxRetries = flag.Int("reconnect-max-retries", 0, "give up on a stream input after this many failed reconnection attempts (0 to keep trying)")
+	reconnectGiveUp     = flag.Duration("reconnect-give-up", 0, "give up on a stream input once it has been disconnected for this long (0 to keep trying)")
+	deviceReopenAfter   = flag.Int("device-reopen-after", 5, "reopen a device input after this many failed reads in a row")
+	deviceGiveUp        = flag.Duration("device-give-up", time.Minute, "give up on a device input once it has failed to reopen for this long, saving the buffer (0 to keep trying)")
+	mainStreamTolerance = flag.Duration("main-stream-tolerance", 200*time.Millisecond, "how far apart in time detection and -main-stream frames may be to be matched")
+	reconnectMaxBackoff = flag.Duration("reconnect-max-backoff", 30*time.Second, "longest wait between attempts to reconnect to a stream input")
+
+	outputDir      = flag.String("output-dir", ".", "directory to save recordings and other exports to; created if missing")
+	codec          = flag.String("codec", "mp4v", "\"FourCC\" codec to record with, e.g. mp4v, avc1, XVID or MJPG")
+	output         = flag.String("output", "motion_%Y%m%d_%H%M%S.mp4", "filename template for event recordings; supports strftime directives and {camera}, {seq}, {part} and {duration}")
+	preRoll        = flag.Duration("pre-roll", 5*time.Second, "how much video before motion starts to include in event recordings")
+	postRoll       = flag.Duration("post-roll", 2*time.Second, "how long to keep recording after the last motion, before the event ends")
+	mergeGap       = flag.Duration("merge-gap", 0, "continue the previous event's recording if motion starts again within this long after it ended")
+	maxEventLength = flag.Duration("max-event-length", 0, "split event recordings into segments of at most this length (0 for no limit)")
+	manualRecord   = flag.Bool("record", false, "start a manual recording immediately, as if 'v' were pressed")
+	chapters       = flag.Bool("chapters", false, "write a .chapters.txt sidecar marking the spans with motion in each recording")
+	recordClean    = flag.Bool("record-clean", false, "record frames without detection markup or HUD; shorthand for -draw-record=false -hud-record=false")
+	drawLive       = flag.Bool("draw-live", true, "mark up detections in the live view (toggle with 'l')")
+	drawRecord     = flag.Bool("draw-record", true, "mark up detections in recordings (toggle with 'k')")
+	hudLive        = flag.Bool("hud-live", true, "draw the HUD text in the live view (toggle with 'L')")
+	hudRecord      = flag.Bool("hud-record", true, "draw the HUD text in recordings (toggle with 'K')")
+	hud            = flag.String("hud", HUDFull, "how much of the HUD to draw: full (the status line and debug text), minimal (the status line) or off (cycle with 'o')")
+	saveOnExit     = flag.Bool("save-on-exit", false, "also save the buffer to video.mp4 in -output-dir on exit")
+
+	continuous        = flag.Bool("continuous", false, "also record all frames to rolling files, alongside event recordings")
+	continuousDir     = flag.String("continuous-dir", "", "directory to save continuous recordings to (default \"continuous\" in -output-dir)")
+	continuousOutput  = flag.String("continuous-output", "continuous_%Y%m%d_%H%M%S.mp4", "filename template for continuous recordings; supports the same directives as -output")
+	continuousSegment = flag.Duration("continuous-segment", 10*time.Minute, "length of each continuous recording")
+	continuousMaxAge  = flag.Duration("continuous-max-age", 0, "delete continuous recordings older than this (0 to keep forever)")
+
+	retentionMaxAge = flag.Duration("retention-max-age", 0, "delete recordings in -output-dir older than this (0 to keep forever)")
+	retentionDryRun = flag.Bool("retention-dry-run", false, "log which recordings retention would delete, without deleting them")
+
+	eventsDB = flag.String("events-db", "", "record motion events to this SQLite database")
+	eventLog = flag.String("event-log", "", "append motion events to this JSON-lines file")
+
+	snapshotRegions = flag.Bool("snapshot-regions", false, "save a JPEG crop of each region with motion when an event starts")
+	snapshotPeak    = flag.Bool("snapshot-peak", false, "also save region crops whenever an event reaches a new peak area")
+	snapshotPadding = flag.Int("snapshot-padding", 20, "padding in pixels around each region crop")
+	snapshotMinSize = flag.Int("snapshot-min-size", 32, "minimum width and height in pixels of a region crop")
+
+	thumbnails       = flag.Bool("thumbnails", true, "save a JPEG poster thumbnail next to each recording")
+	thumbnailWidth   = flag.Int("thumbnail-width", 320, "width in pixels of recording thumbnails")
+	thumbnailOverlay = flag.Bool("thumbnail-overlay", true, "burn the event time and duration into recording thumbnails")
+
+	timestampOverlay = flag.String("timestamp-overlay", "", "burn the capture time into recorded frames using this strftime format, e.g. \"%Y-%m-%d %H:%M:%S\" (may include {camera})")
+	timestampCorner  = flag.String("timestamp-corner", "bl", "corner of the timestamp overlay: tl, tr, bl or br")
+	timestampScale   = flag.Float64("timestamp-scale", 0.6, "font scale of the timestamp overlay")
+	timestampColor   = flag.String("timestamp-color", "#ffffff", "color of the timestamp overlay text")
+	timestampBg      = flag.String("timestamp-bg", "#000000", "color of the box behind the timestamp overlay, or \"none\"")
+
+	audioAlert         = flag.String("audio-alert", "", "play a sound when motion starts: \"bell\" to ring the terminal bell, or a command to run, e.g. \"paplay alert.wav\"")
+	audioAlertCooldown = flag.Duration("audio-alert-cooldown", 10*time.Second, "minimum time between audio alerts")
+
+	sizeFactor = flag.Float64("size-factor", 0.05, "expected size of an encoded frame relative to its raw size, used to check for free disk space before saving (0 to skip the check)")
+
+	detect       = flag.Bool("detect", false, "start with motion detection enabled, rather than waiting for 'm' to be pressed")
+	threshold    = flag.Float64("threshold", 25, "how much a pixel must differ from the background to count as motion, from 1 to 255 (adjust with 't')")
+	dilateSize   = flag.Int("dilate", 3, "size in pixels of the dilation that joins nearby motion into regions (adjust with 'd')")
+	minArea      = flag.Float64("min-area", 3000, "minimum area in pixels of a region of motion (adjust with 'a')")
+	drawContours = flag.Bool("draw-contours", true, "draw the contours of regions of motion (toggle with 'c')")
+	drawRects    = flag.Bool("draw-rects", true, "draw the bounding rectangles of regions of motion (toggle with 'r')")
+
+	verboseStatus = flag.Bool("verbose-status", false, "include total frames and uptime in the status line")
+
+	httpAddr   = flag.String("http-addr", "", "serve the HTTP interface (a dashboard at /, MJPEG at /stream, the latest frame at /snapshot.jpg, detection settings at /api/config, arming and disarming detection at /api/arm, the status shown on the HUD and runtime statistics at /api/status (as text with ?format=text, as logged on SIGUSR1), saving the buffer at /api/save, configuration reloads at /api/reload, recent events at /api/events and their files at /media/, saved clips at /api/clips and /clips/{id}, events over WebSocket at /api/events/ws, ONVIF events at /onvif/ with -onvif, Prometheus metrics at /metrics, health checks at /healthz and /readyz, counters at /debug/vars, HLS at /hls/, WebRTC at /webrtc.html with -webrtc) on this address (e.g. :8080)")
+	expvarAddr = flag.String("expvar-addr", "", "deprecated alias for -http-addr")
+
+	httpToken        = flag.String("http-token", "", "require this bearer token for every request to -http-addr, in an Authorization header or, for browsers, a token query parameter, e.g. /?token=...")
+	httpAllow        = flag.String("http-allow", "", "allow requests to -http-addr from these comma-separated CIDR ranges, e.g. 192.168.1.0/24,127.0.0.1, without -http-token, and deny others if -http-token isn't set")
+	httpPublicHealth = flag.Bool("http-public-health", false, "allow requests to /healthz and /readyz without -http-token or -http-allow, e.g. for load balancers")
+	httpTLSCert      = flag.String("http-tls-cert", "", "serve -http-addr over HTTPS with this certificate file")
+	httpTLSKey       = flag.String("http-tls-key", "", "with -http-tls-cert, the certificate's private key file")
+
+	grpcAddr    = flag.String("grpc-addr", "", "serve the gRPC API (status, detection settings, saving the buffer and events, as in proto/motiondetect.proto) on this address (e.g. :9090), allowing the same requests as -http-token and -http-allow, with the token in authorization metadata")
+	grpcTLSCert = flag.String("grpc-tls-cert", "", "serve -grpc-addr over TLS with this certificate file")
+	grpcTLSKey  = flag.String("grpc-tls-key", "", "with -grpc-tls-cert, the certificate's private key file")
+
+	streamQuality = flag.Int("stream-quality", 80, "JPEG quality (0-100) of the MJPEG stream and snapshots served by -http-addr")
+	streamScale   = flag.Float64("stream-scale", 1, "scale the MJPEG stream served by -http-addr by this factor (0-1)")
+
+	mdnsAnnounce = flag.Bool("mdns", false, "with -http-addr, announce the HTTP interface on the local network with mDNS (as _http._tcp), so that \"discover\" and other zeroconf tools can find it")
+	mdnsName     = flag.String("mdns-name", "", "the instance name announced with -mdns (default: the camera names and the host name)")
+
+	wsStatusInterval = flag.Duration("ws-status-interval", time.Second, "also send clients of /api/events/ws, such as the dashboard, each camera's FPS and motion score this often (0 to disable)")
+
+	webhookURL     = flag.String("webhook-url", "", "POST each event to this URL as JSON when it starts")
+	webhookEnd     = flag.Bool("webhook-end", false, "with -webhook-url, also POST events when they end")
+	webhookSecret  = flag.String("webhook-secret", "", "with -webhook-url, sign request bodies with this shared secret (HMAC-SHA256, in the X-Motiondetect-Signature header)")
+	webhookTimeout = flag.Duration("webhook-timeout", 10*time.Second, "with -webhook-url, how long each attempt to POST an event may take")
+	webhookLimit   = flag.String("webhook-limit", "", "with -webhook-url, limit the events posted: a rate, e.g. 10/h, then any of burst=N, dedup=duration (per camera and zones) and digest=duration (batching events into summaries)")
+
+	onMotionStartCmd = flag.String("on-motion-start-cmd", "", "run this shell command when each event starts, with the event in MD_* environment variables (MD_EVENT_ID, MD_CAMERA, MD_START, MD_SNAPSHOT_PATH, MD_CLIP_PATH, MD_PEAK_AREA, ...) and as JSON on stdin")
+	onMotionEndCmd   = flag.String("on-motion-end-cmd", "", "run this shell command when each event ends, like -on-motion-start-cmd")
+	cmdTimeout       = flag.Duration("cmd-timeout", 30*time.Second, "kill -on-motion-start-cmd and -on-motion-end-cmd commands that run longer than this")
+	cmdLimit         = flag.String("cmd-limit", "", "limit the events -on-motion-start-cmd and -on-motion-end-cmd are run for, like -webhook-limit")
+
+	notifyCooldown = flag.Duration("notify-cooldown", time.Minute, "the minimum time between events sent to Telegram, ntfy, Pushover and Slack for each camera")
+	notifyLimit    = flag.String("notify-limit", "", "limit the events sent by all notifiers together to a rate, e.g. 30/h, and optionally burst=N; each notifier's -*-limit applies too")
+	publicURL      = flag.String("public-url", "", "the URL at which -http-addr is reachable from outside, e.g. http://pi.local:8080, for links in notifications")
+
+	telegramToken  = flag.String("telegram-token", "", "send events to Telegram with the bot with this token, with their snapshots")
+	telegramChatID = flag.String("telegram-chat-id", "", "with -telegram-token, the chat to send events to")
+	telegramClips  = flag.Bool("telegram-clips", false, "with -telegram-token, also send each event's clip when it ends")
+	telegramLimit  = flag.String("telegram-limit", "", "with -telegram-token, limit the events sent, like -webhook-limit")
+
+	ntfyURL       = flag.String("ntfy-url", "", "send the start of each event to this ntfy topic, e.g. https://ntfy.sh/mytopic")
+	ntfyToken     = flag.String("ntfy-token", "", "with -ntfy-url, the access token to publish with")
+	pushoverToken = flag.String("pushover-token", "", "send the start of each event to Pushover with this application token")
+	pushoverUser  = flag.String("pushover-user", "", "with -pushover-token, the user or group key to send to")
+	ntfyLimit     = flag.String("ntfy-limit", "", "with -ntfy-url, limit the events sent, like -webhook-limit")
+	pushoverLimit = flag.String("pushover-limit", "", "with -pushover-token, limit the events sent, like -webhook-limit")
+	pushHighArea  = flag.Float64("push-high-area", 20000, "send events to ntfy and Pushover with high priority, and to Slack with high confidence, if their peak area is at least this, and low if it's under a quarter of it")
+
+	slackWebhookURL = flag.String("slack-webhook-url", "", "send the start of each event to Slack as text, with this incoming webhook")
+	slackToken      = flag.String("slack-token", "", "send the start of each event to Slack with its snapshot, with this bot token, instead of -slack-webhook-url")
+	slackChannel    = flag.String("slack-channel", "", "with -slack-token, the ID of the channel to send to")
+	slackLimit      = flag.String("slack-limit", "", "limit the events sent to Slack, like -webhook-limit")
+
+	smtpHost     = flag.String("smtp-host", "", "email events through this SMTP server")
+	smtpPort     = flag.Int("smtp-port", 587, "with -smtp-host, the server's port")
+	smtpTLS      = flag.String("smtp-tls", SMTPStartTLS, "with -smtp-host, how to secure the connection: starttls, tls (implicit TLS, usually port 465) or none")
+	smtpUsername = flag.String("smtp-username", "", "with -smtp-host, the username to authenticate as")
+	smtpPassword = flag.String("smtp-password", "", "with -smtp-host, the password to authenticate with")
+	smtpFrom     = flag.String("smtp-from", "", "with -smtp-host, the sender of emails")
+	smtpTo       = flag.String("smtp-to", "", "with -smtp-host, comma-separated recipients of emails")
+	smtpSubject  = flag.String("smtp-subject", DefaultEmailSubject, "with -smtp-host, the template of the subject of emails, executed with the event's JSON fields")
+	smtpBody     = flag.String("smtp-body", DefaultEmailBody, "with -smtp-host, the template of the body of emails, executed with the event's JSON fields")
+	smtpClips    = flag.Bool("smtp-clips", false, "with -smtp-host, also email each event's clip when it ends")
+	smtpLimit    = flag.String("smtp-limit", "", "with -smtp-host, limit the events emailed, like -webhook-limit (without digests)")
+
+	uploadTarget      = flag.String("upload-target", "", "upload each saved event, with its chapters and thumbnail, to this S3 bucket (s3://bucket[/prefix]) or SSH server (sftp://[user[:password]@]host[:port]/dir, transferred with scp)")
+	uploadKey         = flag.String("upload-key", DefaultUploadKey, "with -upload-target, the template of each event's key, with {camera}, {date}, {event_id}, {name} and {ext} replaced")
+	uploadConcurrency = flag.Int("upload-concurrency", 2, "with -upload-target, the number of files to upload at once")
+	uploadDeleteLocal = flag.Bool("upload-delete-local", false, "with -upload-target, delete each file once it's been uploaded")
+
+	queueDir = flag.String("queue-dir", "", "journal -webhook-url notifications and -upload-target uploads waiting to be sent in this directory, so that they're retried across restarts (default: .queue in -output-dir)")
+	queueTTL = flag.Duration("queue-ttl", 24*time.Hour, "drop -webhook-url notifications and -upload-target uploads that still haven't been sent after this long (0 to retry forever)")
+
+	s3Endpoint  = flag.String("s3-endpoint", "https://s3.amazonaws.com", "with an s3:// -upload-target, the S3-compatible endpoint to upload to")
+	s3Region    = flag.String("s3-region", "us-east-1", "with an s3:// -upload-target, the bucket's region")
+	s3AccessKey = flag.String("s3-access-key", "", "with an s3:// -upload-target, the access key to upload with (default $AWS_ACCESS_KEY_ID)")
+	s3SecretKey = flag.String("s3-secret-key", "", "with an s3:// -upload-target, the secret key to upload with (default $AWS_SECRET_ACCESS_KEY)")
+
+	sshKey        = flag.String("ssh-key", defaultSSHKey, "with an sftp:// -upload-target, the private key to authenticate with")
+	sshPassword   = flag.String("ssh-password", "", "with an sftp:// -upload-target, the password to authenticate with, if not in the URL")
+	sshKnownHosts = flag.String("ssh-known-hosts", "~/.ssh/known_hosts", "with an sftp:// -upload-target, the known_hosts file to verify the server's key against")
+	sshInsecure   = flag.Bool("ssh-insecure-ignore-host-key", false, "with an sftp:// -upload-target, don't verify the server's key (insecure)")
+
+	mqttBroker      = flag.String("mqtt-broker", "", "publish events and availability to this MQTT broker (host:port)")
+	mqttTopicPrefix = flag.String("mqtt-topic-prefix", "motiondetect", "with -mqtt-broker, the prefix of all topics")
+	mqttUsername    = flag.String("mqtt-username", "", "with -mqtt-broker, the username to connect with")
+	mqttPassword    = flag.String("mqtt-password", "", "with -mqtt-broker, the password to connect with")
+	mqttQoS         = flag.Int("mqtt-qos", 1, "with -mqtt-broker, the QoS of published messages (0 or 1)")
+	mqttLimit       = flag.String("mqtt-limit", "", "with -mqtt-broker, limit the events published, like -webhook-limit (without digests)")
+	mqttDiscovery   = flag.Bool("mqtt-ha-discovery", false, "with -mqtt-broker, publish Home Assistant discovery config for a switch arming and disarming detection")
+
+	armStateFile = flag.String("arm-state-file", "", "save whether detection is armed, as set through /api/arm or MQTT, to this file, so that it survives restarts (default: .armed.json in -output-dir)")
+
+	gpioPin       = flag.Int("gpio-pin", -1, "drive this line of -gpio-chip, e.g. a Raspberry Pi's BCM pin number, while any event is in progress, e.g. for a relay switching a floodlight (-1 to disable)")
+	gpioChip      = flag.String("gpio-chip", "/dev/gpiochip0", "with -gpio-pin, the GPIO character device the line belongs to")
+	gpioHold      = flag.Duration("gpio-hold", 30*time.Second, "with -gpio-pin, keep the line active for this long after the last event ends")
+	gpioActiveLow = flag.Bool("gpio-active-low", false, "with -gpio-pin, drive the line low while active, e.g. for active-low relays")
+
+	triggerUDP        = flag.String("trigger-udp", "", "send a UDP packet to each of these comma-separated host:port targets as each event starts and ends, e.g. for lighting or relay controllers")
+	triggerOSCAddress = flag.String("trigger-osc-address", "", "with -trigger-udp, send OSC messages to this address pattern, which may contain the placeholders of -output, {phase} and {area}, e.g. /motion/{camera}")
+	triggerOSCArgs    = flag.String("trigger-osc-args", DefaultOSCArgs, "with -trigger-osc-address, the event fields sent as arguments: camera, seq, phase (start or end), active (1 or 0), area, duration, time (unix seconds) and zones")
+	triggerPayload    = flag.String("trigger-payload", "motion {phase} {camera}", "with -trigger-udp and no -trigger-osc-address, the packet to send, which may contain the directives and placeholders of -output, {phase} and {area}")
+
+	healthFrameTimeout = flag.Duration("health-frame-timeout", 10*time.Second, "report a camera as unhealthy at /healthz if it hasn't delivered a frame for this long")
+	healthSaveTimeout  = flag.Duration("health-save-timeout", 5*time.Minute, "report saving as unhealthy at /healthz if a file has been being saved for this long")
+
+	stdoutFormat = flag.String("stdout-format", "", "write each processed frame to stdout as rawvideo (bgr24) or mjpeg, for piping into other tools")
+
+	hlsDir      = flag.String("hls-dir", "", "stream the live view as HLS to this directory, served at /hls/ by -http-addr (requires ffmpeg)")
+	hlsSegment  = flag.Duration("hls-segment", 2*time.Second, "duration of each HLS segment")
+	hlsListSize = flag.Int("hls-list-size", 5, "number of segments in the HLS playlist")
+
+	onvifEvents = flag.Bool("onvif", false, "with -http-addr, let ONVIF clients such as NVRs subscribe to motion events, at /onvif/device_service")
+
+	rtspAddr = flag.String("rtsp-addr", "", "serve the live view over RTSP on this address (e.g. :8554), encoded only while a client is playing it (requires ffmpeg)")
+	rtspPath = flag.String("rtsp-path", "/live", "with -rtsp-addr, the path of the stream, e.g. rtsp://host:8554/live")
+
+	webrtcEnabled    = flag.Bool("webrtc", false, "with -http-addr, stream the live view over WebRTC, with sub-second latency, negotiated at /api/webrtc and viewable at /webrtc.html, encoded only while anyone is viewing it (requires ffmpeg, and building with -tags webrtc)")
+	webrtcICEServers = flag.String("webrtc-ice-servers", "", "with -webrtc, comma-separated STUN or TURN server URLs for viewers outside the local network, e.g. stun:stun.l.google.com:19302")
+
+	pip       = flag.String("pip", "", "inset another camera into the first camera's view and recordings, as camera:corner:scale, e.g. door:bottom-right:25%")
+	pipDetect = flag.String("pip-detect", PiPDetectPrimary, "with -pip, detect motion in the primary camera only, the composite (where the inset hides part of the primary), or both (the whole primary, and the inset separately)")
+
+	view           = flag.String("view", "windows", "how to show several cameras: windows (one each) or tile (in a grid in one window)")
+	fullscreen     = flag.Bool("fullscreen", false, "start with the windows fullscreen, e.g. for a kiosk (toggle with 'f')")
+	screenSize     = flag.String("screen-size", "", "letterbox fullscreen windows to this screen size, as WxH, e.g. 1920x1080, rather than leaving it to the window system to scale them, which doesn't keep their aspect ratio on all platforms")
+	headless       = flag.Bool("headless", false, "don't open any windows, e.g. on a server without a display, and log each camera's status line every -status-interval instead; cameras are then controlled through -http-addr, MQTT and signals (implied if there's no display)")
+	statusInterval = flag.Duration("status-interval", time.Minute, "with -headless, how often to log each camera's status line (0 to never)")
 )
 
+// LogHistogram logs the given frame duration histogram, one bucket per line.
+func LogHistogram(buckets []HistogramBucket) {
+	for i, b := range buckets {
+		if i == len(buckets)-1 {
+			logInfo("Frame durations", "over", buckets[i-1].UpperBound, "frames", b.Count)
+		} else {
+			logInfo("Frame durations", "up_to", b.UpperBound, "frames", b.Count)
+		}
+	}
+}
 
-func Status(s string) string {
-	return fmt.Sprintf(
-		"[%dx%d @ %0.0f/%0.0ffps] [a=%v d=%v t=%v (%s)]: %s",
-		Width, Height,
-		fps.FPS, MaxFPS,
-		Detector.MinimumContourArea, Detector.DilateSize, Detector.Threshold,
-		string(FieldChanged),
-		s,
-	)
+// DrawRecIndicator draws a red "REC" indicator with the elapsed recording
+// time in the top right corner of img.
+func DrawRecIndicator(img *gocv.Mat, elapsed time.Duration) {
+	secs := int(elapsed.Seconds())
+	text := fmt.Sprintf("REC %02d:%02d", secs/60, secs%60)
+	size := gocv.GetTextSize(text, gocv.FontHersheyPlain, 1.5, 2)
+	x := img.Cols() - size.X - 10
+	gocv.Circle(img, image.Pt(x-14, 20-size.Y/2), 7, red, -1)
+	gocv.PutText(img, text, image.Pt(x, 20), gocv.FontHersheyPlain, 1.5, red, 2)
 }
 
 func SetupCloseHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		Done = true
+		Quit()
 	}()
 }
 
-func PollInput(window *gocv.Window) {
-	switch k := window.PollKey(); k {
-	case 3: // ctrl+c
-		Done = true
-	default:
-		switch rk := rune(k); rk {
-		case 'm':
-			DetectionEnabled = !DetectionEnabled
-		case 'c':
-			Detector.DrawContours = !Detector.DrawContours
-		case 'r':
-			Detector.DrawRects = !Detector.DrawRects
-		case 'a', 'd', 't':
-			FieldChanged = rk
-		case '-', '=':
-			dir := 1
-			if rk == '-' {
-				dir = -1
-			}
-			switch FieldChanged {
-			case 'a':
-				Detector.MinimumContourArea += float64(100 * dir)
-				if Detector.MinimumContourArea <= 0 {
-					Detector.MinimumContourArea = 100
-				}
-			case 'd':
-				Detector.DilateSize += 1 * dir
-				if Detector.DilateSize <= 0 {
-					Detector.DilateSize = 1
-				}
-			case 't':
-				Detector.Threshold += float32(1 * dir)
-				if Detector.Threshold <= 0 {
-					Detector.Threshold = 1
-				}
+// detectorFlagConfig returns the detection settings given by flags, which
+// have the same ranges as /api/config.
+func detectorFlagConfig() (DetectorConfig, error) {
+	t := float32(*threshold)
+	cfg := DetectorConfig{
+		Threshold:        &t,
+		DilateSize:       dilateSize,
+		MinArea:          minArea,
+		DrawContours:     drawContours,
+		DrawRects:        drawRects,
+		DetectionEnabled: detect,
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		names := map[string]string{"threshold": "threshold", "dilate_size": "dilate", "min_area": "min-area"}
+		field := sortedFields(errs)[0]
+		return cfg, fmt.Errorf("invalid -%s: %s", names[field], errs[field])
+	}
+	return cfg, nil
+}
+
+// checkOutboundFlags checks the flags of the notifiers that send events
+// elsewhere, so that newOutboundNotifiers won't fail on them.
+func checkOutboundFlags() error {
+	if *webhookURL != "" {
+		if u, err := url.Parse(*webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid -webhook-url %q: must be an http or https URL", *webhookURL)
+		}
+	}
+	if *cmdTimeout <= 0 {
+		return fmt.Errorf("invalid -cmd-timeout %v: must be positive", *cmdTimeout)
+	}
+	if *telegramToken != "" && *telegramChatID == "" {
+		return fmt.Errorf("invalid -telegram-token: -telegram-chat-id must be set too")
+	}
+	if *slackToken != "" && *slackChannel == "" {
+		return fmt.Errorf("invalid -slack-token: -slack-channel must be set too")
+	}
+	if *pushoverToken != "" && *pushoverUser == "" {
+		return fmt.Errorf("invalid -pushover-token: -pushover-user must be set too")
+	}
+	if *smtpHost != "" {
+		switch *smtpTLS {
+		case SMTPStartTLS, SMTPTLS, SMTPNone:
+		default:
+			return fmt.Errorf("invalid -smtp-tls %q: must be starttls, tls or none", *smtpTLS)
+		}
+		if *smtpFrom == "" || *smtpTo == "" {
+			return fmt.Errorf("invalid -smtp-host: -smtp-from and -smtp-to must be set too")
+		}
+		if _, _, err := ParseEmailTemplates(*smtpSubject, *smtpBody); err != nil {
+			return fmt.Errorf("invalid -smtp-subject or -smtp-body: %v", err)
+		}
+	}
+	limits := map[string]string{
+		"webhook-limit":  *webhookLimit,
+		"telegram-limit": *telegramLimit,
+		"cmd-limit":      *cmdLimit,
+		"ntfy-limit":     *ntfyLimit,
+		"pushover-limit": *pushoverLimit,
+		"slack-limit":    *slackLimit,
+		"smtp-limit":     *smtpLimit,
+	}
+	for _, name := range sortedFields(limits) {
+		if _, err := ParseNotifyLimit(limits[name]); err != nil {
+			return fmt.Errorf("invalid -%s %q: %v", name, limits[name], err)
+		}
+	}
+	return nil
+}
+
+// governNotifier limits the events passed to n, named name in logs, to those
+// allowed by spec, as given to the -*-limit flags, and the global bucket of
+// -notify-limit, if there is one.
+func governNotifier(n Notifier, name, spec string, global *TokenBucket) (Notifier, error) {
+	limit, err := ParseNotifyLimit(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec == "" && global == nil {
+		return n, nil
+	}
+	return NewGovernor(name, n, limit, global), nil
+}
+
+// newOutboundNotifiers builds the notifiers that send events elsewhere, other
+// than MQTT, from their flags, once checkOutboundFlags has checked them. They
+// only depend on their flags and global, the bucket of -notify-limit, so that
+// they can be rebuilt, while the old ones are still in use, as the
+// configuration is reloaded. The journals they share with the old ones are
+// left to persist, which must be called before they're notified, once the old
+// ones are closed. If building any fails, those built are closed.
+func newOutboundNotifiers(global *TokenBucket) (ns Notifiers, persist func() error, err error) {
+	persist = func() error { return nil }
+	defer func() {
+		if err != nil {
+			ns.Close()
+			ns = nil
+		}
+	}()
+	add := func(n Notifier, name, spec string) error {
+		governed, err := governNotifier(n, name, spec, global)
+		if err != nil {
+			n.Close()
+			return err
+		}
+		ns = append(ns, governed)
+		return nil
+	}
+	if *webhookURL != "" {
+		webhook := NewWebhook(*webhookURL, *webhookSecret, *webhookTimeout)
+		webhook.OnEnd = *webhookEnd
+		journal, ttl := filepath.Join(*queueDir, "webhook.journal"), *queueTTL
+		persist = func() error {
+			if err := webhook.Persist(journal, ttl); err != nil {
+				return fmt.Errorf("error opening the webhook queue: %v", err)
 			}
+			return nil
+		}
+		if err := add(webhook, "webhook", *webhookLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *telegramToken != "" {
+		telegram := NewTelegram(*telegramToken, *telegramChatID, *notifyCooldown)
+		telegram.OnEnd = *telegramClips
+		if err := add(telegram, "telegram", *telegramLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *onMotionStartCmd != "" || *onMotionEndCmd != "" {
+		command := NewEventCommand(*onMotionStartCmd, *onMotionEndCmd, *cmdTimeout)
+		if err := add(command, "command", *cmdLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *ntfyURL != "" {
+		ntfy := NewNtfy(*ntfyURL, *notifyCooldown)
+		ntfy.Token, ntfy.HighArea, ntfy.PublicURL = *ntfyToken, *pushHighArea, *publicURL
+		if err := add(ntfy, "ntfy", *ntfyLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *pushoverToken != "" {
+		pushover := NewPushover(*pushoverToken, *pushoverUser, *notifyCooldown)
+		pushover.HighArea, pushover.PublicURL = *pushHighArea, *publicURL
+		if err := add(pushover, "pushover", *pushoverLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *slackWebhookURL != "" || *slackToken != "" {
+		slack := NewSlack(*slackWebhookURL, *slackToken, *slackChannel, *notifyCooldown)
+		slack.HighArea = *pushHighArea
+		if err := add(slack, "slack", *slackLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	if *smtpHost != "" {
+		subject, body, err := ParseEmailTemplates(*smtpSubject, *smtpBody)
+		if err != nil {
+			return ns, persist, err
+		}
+		email := &Email{
+			Host:          *smtpHost,
+			Port:          *smtpPort,
+			TLS:           *smtpTLS,
+			Username:      *smtpUsername,
+			Password:      *smtpPassword,
+			From:          *smtpFrom,
+			To:            strings.Split(*smtpTo, ","),
+			Subject:       subject,
+			Body:          body,
+			Clips:         *smtpClips,
+			MaxAttachment: int64(smtpMaxAttachment),
+		}
+		email.Start()
+		if err := add(email, "email", *smtpLimit); err != nil {
+			return ns, persist, err
+		}
+	}
+	return ns, persist, nil
+}
+
+// parseTimestampOverlay builds the overlay configured by the -timestamp-*
+// flags for the given camera.
+func parseTimestampOverlay(camera string) (*TimestampOverlay, error) {
+	format, err := ParseOutputTemplate(*timestampOverlay)
+	if err != nil {
+		return nil, err
+	}
+	switch *timestampCorner {
+	case "tl", "tr", "bl", "br":
+	default:
+		return nil, fmt.Errorf("unknown corner %q", *timestampCorner)
+	}
+	if *timestampScale <= 0 {
+		return nil, fmt.Errorf("scale must be positive")
+	}
+	fg, err := ParseColor(*timestampColor)
+	if err != nil {
+		return nil, err
+	}
+	o := &TimestampOverlay{
+		Format: format,
+		Camera: camera,
+		Corner: *timestampCorner,
+		Scale:  *timestampScale,
+		Color:  fg,
+	}
+	if *timestampBg != "none" {
+		bg, err := ParseColor(*timestampBg)
+		if err != nil {
+			return nil, err
+		}
+		o.Background = &bg
+	}
+	return o, nil
+}
+
+// parseCrop parses a crop region given as "x,y,w,h".
+func parseCrop(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("expected x,y,w,h")
+	}
+	var n [4]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid number %q", p)
+		}
+		n[i] = v
+	}
+	if n[0] < 0 || n[1] < 0 || n[2] <= 0 || n[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("x and y must not be negative, and w and h must be positive")
+	}
+	return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
+
+// parseSize parses a size given as "WxH".
+func parseSize(s string) (image.Point, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return image.Point{}, fmt.Errorf("expected WxH")
+	}
+	var n [2]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || v <= 0 {
+			return image.Point{}, fmt.Errorf("invalid size %q", p)
 		}
+		n[i] = v
 	}
+	return image.Pt(n[0], n[1]), nil
+}
+
+// newFPSCounter builds the FPS counter configured by the -fps-* flags.
+func newFPSCounter() (*FPSCounter, error) {
+	switch *fpsMode {
+	case "window":
+		return NewFPSCounter(250*time.Millisecond, 20), nil
+	case "ema":
+		if *fpsAlpha <= 0 || *fpsAlpha > 1 {
+			return nil, fmt.Errorf("invalid -fps-alpha %v: must be in (0, 1]", *fpsAlpha)
+		}
+		return NewFPSCounter(250*time.Millisecond, 20, WithEMA(*fpsAlpha, time.Second)), nil
+	}
+	return nil, fmt.Errorf("invalid -fps-mode %q: must be window or ema", *fpsMode)
+}
+
+// parseCameraArg splits a camera argument of the form "name=source" into its
+// name and source. Arguments without a name, or whose prefix isn't a valid
+// name (as in a URL with a query string), are named after the source.
+func parseCameraArg(arg string) (name, source string) {
+	i := strings.IndexByte(arg, '=')
+	if i <= 0 {
+		return arg, arg
+	}
+	for _, r := range arg[:i] {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return arg, arg
+		}
+	}
+	return arg[:i], arg[i+1:]
 }
 
 func main() {
 	flag.Parse()
+	config, fromFlags, fromFile, configWarnings := loadConfig(*configPath)
+	// reloads compare the file with the flags as they're loaded, before
+	// any are filled in below
+	configValues := flagValues(flag.CommandLine)
+
+	if err := SetupLogging(*logLevel, *logFormat, *logOutput, int64(logMaxSize)); err != nil {
+		log.Fatal(err)
+	}
+	defer logger.Close()
+	for _, w := range configWarnings {
+		logWarn(w, "path", *configPath)
+	}
+	hostClock.Start()
+	defer hostClock.Stop()
+	if _, err := newFPSCounter(); err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := rotateFlags[*rotate]; !ok && *rotate != 0 {
+		log.Fatalf("Invalid -rotate %d: must be 0, 90, 180 or 270", *rotate)
+	}
+	if _, err := parseBackend(*backend); err != nil {
+		log.Fatalf("Invalid -backend: %v", err)
+	}
+	if _, ok := autoExposureModes[*autoExposure]; !ok && *autoExposure != "" {
+		log.Fatalf("Invalid -auto-exposure %q: must be on or off", *autoExposure)
+	}
+	var cropRect image.Rectangle
+	if *crop != "" {
+		var err error
+		if cropRect, err = parseCrop(*crop); err != nil {
+			log.Fatalf("Invalid -crop %q: %v", *crop, err)
+		}
+	}
+	if *view != "windows" && *view != "tile" {
+		log.Fatalf("Invalid -view %q: must be windows or tile", *view)
+	}
+	var screen image.Point
+	if *screenSize != "" {
+		var err error
+		if screen, err = parseSize(*screenSize); err != nil {
+			log.Fatalf("Invalid -screen-size %q: %v", *screenSize, err)
+		}
+	}
+	switch *hud {
+	case HUDFull, HUDMinimal, HUDOff:
+	default:
+		log.Fatalf("Invalid -hud %q: must be full, minimal or off", *hud)
+	}
+	if *preRoll <= 0 {
+		log.Fatalf("Invalid -pre-roll %v: must be positive", *preRoll)
+	}
+	if *statusInterval < 0 {
+		log.Fatalf("Invalid -status-interval %v: must not be negative", *statusInterval)
+	}
+	if !*headless && !haveDisplay() {
+		logWarn("No display found ($DISPLAY and $WAYLAND_DISPLAY aren't set); running -headless")
+		*headless = true
+	}
+	httpAllowList, err := ParseAllowList(*httpAllow)
+	if err != nil {
+		log.Fatalf("Invalid -http-allow %q: %v", *httpAllow, err)
+	}
+	httpAuth := &HTTPAuth{Token: *httpToken, Allow: httpAllowList}
+	if *httpPublicHealth {
+		httpAuth.Public = []string{"/healthz", "/readyz"}
+	}
+	if (*httpTLSCert == "") != (*httpTLSKey == "") {
+		log.Fatalf("Invalid -http-tls-cert or -http-tls-key: both must be set")
+	}
+	if (*grpcTLSCert == "") != (*grpcTLSKey == "") {
+		log.Fatalf("Invalid -grpc-tls-cert or -grpc-tls-key: both must be set")
+	}
+	if err := checkOutboundFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if *queueTTL < 0 {
+		log.Fatalf("Invalid -queue-ttl %v: must not be negative", *queueTTL)
+	}
+	if *queueDir == "" {
+		*queueDir = filepath.Join(*outputDir, ".queue")
+	}
+	if *armStateFile == "" {
+		*armStateFile = filepath.Join(*outputDir, ".armed.json")
+	}
+	if *uploadTarget != "" && *uploadConcurrency < 1 {
+		log.Fatalf("Invalid -upload-concurrency %d: must be at least 1", *uploadConcurrency)
+	}
+	if *mqttQoS != 0 && *mqttQoS != 1 {
+		log.Fatalf("Invalid -mqtt-qos %d: must be 0 or 1", *mqttQoS)
+	}
+	if *streamQuality < 0 || *streamQuality > 100 {
+		log.Fatalf("Invalid -stream-quality %d: must be between 0 and 100", *streamQuality)
+	}
+	if *streamScale <= 0 || *streamScale > 1 {
+		log.Fatalf("Invalid -stream-scale %v: must be between 0 and 1", *streamScale)
+	}
+	detectorFlags, err := detectorFlagConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if *cpuprofile != "" {
-		log.Println("Profiling CPU to", *cpuprofile)
+		logInfo("Profiling CPU", "path", *cpuprofile)
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
 			log.Fatal(err)
@@ -113,94 +750,624 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if len(flag.Args()) < 1 {
-		fmt.Println("USAGE: camera [camera ID]")
+	if *pprofAddr != "" {
+		ServePprof(*pprofAddr)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 && config != nil {
+		args = config.Cameras
+	}
+	if *stdinInput {
+		args = append([]string{"stdin=-"}, args...)
+	}
+	if len(args) < 1 {
+		fmt.Println("USAGE: camera [name=](camera ID | video file | stream URL | - | synthetic)...")
+		fmt.Println("       camera events [-db path | -log path | -addr host:port | URL] [-since duration] [-json]")
+		fmt.Println("       camera events export [-db path | -log path] [-since duration] [-format csv] [-sort field] [-tz zone]")
+		fmt.Println("       camera devices [-max N] [-timeout duration] [-backend name] [-backends]")
+		fmt.Println("       camera reload [-addr host:port | URL] [-token token] [-timeout duration] [-json]")
+		fmt.Println("       camera status [-addr host:port | URL] [-token token] [-json]")
+		fmt.Println("       camera set [-addr host:port | URL] [-token token] [-camera name] [-min-area N] [-dilate-size N] [-threshold N] [-detection on|off] [-json]")
+		fmt.Println("       camera save-now [-addr host:port | URL] [-token token] [-camera name] [-json]")
+		fmt.Println("       camera discover [-timeout duration] [-all]")
+		fmt.Println("       camera [-config file] [flags] config print [-show-secrets]")
+		return
+	}
+	if flag.Arg(0) == "events" {
+		runEventsCommand(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "devices" {
+		runDevicesCommand(flag.Args()[1:])
 		return
 	}
+	if flag.Arg(0) == "reload" {
+		runReloadCommand(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "discover" {
+		runDiscoverCommand(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "config" {
+		var cameras []string
+		if config != nil {
+			cameras = config.Cameras
+		}
+		runConfigCommand(flag.Args()[1:], config, cameras, fromFlags, fromFile)
+		return
+	}
+	if flag.Arg(0) == "status" {
+		runStatusCommand(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "set" {
+		runSetCommand(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "save-now" {
+		runSaveNowCommand(flag.Args()[1:])
+		return
+	}
+
+	outputTemplate, err := ParseOutputTemplate(*output)
+	if err != nil {
+		log.Fatalf("Invalid -output: %v", err)
+	}
+	continuousTemplate, err := ParseOutputTemplate(*continuousOutput)
+	if err != nil {
+		log.Fatalf("Invalid -continuous-output: %v", err)
+	}
+	if *timestampOverlay != "" {
+		if _, err := parseTimestampOverlay(""); err != nil {
+			log.Fatalf("Invalid timestamp overlay: %v", err)
+		}
+	}
+	if err := PrepareOutputDir(*outputDir); err != nil {
+		log.Fatalf("Invalid -output-dir: %v", err)
+	}
 
-	// parse args
-	deviceID := flag.Arg(0)
+	// open all cameras up front, so that a bad argument is reported before
+	// anything starts
+	var (
+		cams  []*Camera
+		names []string
+	)
+	for _, arg := range args {
+		name, source := parseCameraArg(arg)
+		input, err := DetectInputType(source, *inputType)
+		if err != nil {
+			log.Fatalf("Invalid -input-type: %v", err)
+		}
+		if input == InputStdin && (*width <= 0 || *height <= 0) {
+			log.Fatalf("Reading frames from stdin requires -width and -height")
+		}
+		c, err := OpenCamera(name, source, input)
+		if err != nil {
+			log.Fatalf("Error opening video capture device %v: %v", source, err)
+		}
+		if input == InputDevice || input == InputStream {
+			c.CalibrateFrames = *calibrateFrames
+			c.Calibrate()
+		}
+		logInfo("Opened camera", "camera", c.Name, "source", source, "width", c.Width, "height", c.Height, "fps", math.Round(c.MaxFPS*10)/10)
+		cams = append(cams, c)
+		names = append(names, name)
+	}
+	if len(cams) > 1 {
+		// each camera's files need to be told apart
+		if outputTemplate, err = outputTemplate.WithCamera(); err != nil {
+			log.Fatalf("Invalid -output: %v", err)
+		}
+		if continuousTemplate, err = continuousTemplate.WithCamera(); err != nil {
+			log.Fatalf("Invalid -continuous-output: %v", err)
+		}
+	}
 
-	webcam, err := gocv.OpenVideoCapture(deviceID)
+	var store EventStore
+	if *eventsDB != "" {
+		if store, err = OpenSQLiteEventStore(*eventsDB); err != nil {
+			log.Fatalf("Error opening events database: %v", err)
+		}
+		defer store.Close()
+	}
+	var events *EventLog
+	if *eventLog != "" {
+		if events, err = OpenEventLog(*eventLog); err != nil {
+			log.Fatalf("Error opening event log: %v", err)
+		}
+		events.MaxSize = int64(eventLogMaxSize)
+		defer events.Close()
+	}
+	arm, err := OpenArmState(*armStateFile)
 	if err != nil {
-		log.Fatalf("Error opening video capture device %v: %v", deviceID, err)
+		log.Fatalf("Error reading -arm-state-file: %v", err)
+	}
+	// the hub only does anything once clients connect to -http-addr or
+	// -grpc-addr
+	hub := NewEventHub()
+	recent := NewRecentEvents(dashboardEvents)
+	if store != nil {
+		evs, err := store.Query(time.Now().Add(-24 * time.Hour))
+		if err != nil {
+			logError("Loading recent events failed", "error", err)
+		}
+		recent.Add(evs)
+	}
+	notifiers := Notifiers{hub, recent}
+	var onvif *ONVIFEvents
+	if *onvifEvents {
+		names := make([]string, len(cams))
+		for i, c := range cams {
+			names[i] = c.Name
+		}
+		onvif = NewONVIFEvents(names)
+		notifiers = append(notifiers, onvif)
+	}
+	// the notifiers sending events elsewhere are each governed by their own
+	// limits, and the global one
+	globalLimit, err := ParseNotifyLimit(*notifyLimit)
+	if err != nil || globalLimit.Dedup > 0 || globalLimit.Digest > 0 {
+		log.Fatalf("Invalid -notify-limit %q: must be a rate, and optionally a burst", *notifyLimit)
+	}
+	globalBucket := NewTokenBucket(globalLimit.Rate, globalLimit.Burst)
+	// all but MQTT are replaced by new ones as the configuration is reloaded
+	outbound, persistOutbound, err := newOutboundNotifiers(globalBucket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := persistOutbound(); err != nil {
+		log.Fatal(err)
+	}
+	outboundSwitch := NewNotifierSwitch(outbound)
+	notifiers = append(notifiers, outboundSwitch)
+	if *mqttBroker != "" {
+		mqtt := NewMQTTPublisher(*mqttBroker, *mqttTopicPrefix, *mqttUsername, *mqttPassword, byte(*mqttQoS), arm, *mqttDiscovery)
+		governed, err := governNotifier(mqtt, "mqtt", *mqttLimit, globalBucket)
+		if err != nil {
+			log.Fatalf("Invalid -mqtt-limit %q: %v", *mqttLimit, err)
+		}
+		notifiers = append(notifiers, governed)
+	}
+	var gpio *GPIOOutput
+	if *gpioPin >= 0 {
+		if gpio, err = OpenGPIOOutput(*gpioChip, *gpioPin, *gpioActiveLow, *gpioHold); err != nil {
+			log.Fatalf("Invalid -gpio-pin: %v", err)
+		}
+		notifiers = append(notifiers, gpio)
+	}
+	if *triggerUDP != "" {
+		trigger, err := NewUDPTrigger(*triggerUDP, *triggerOSCAddress, *triggerOSCArgs, *triggerPayload)
+		if err != nil {
+			log.Fatalf("Invalid -trigger-udp: %v", err)
+		}
+		notifiers = append(notifiers, trigger)
+	}
+	defer notifiers.Close()
+	logEvent := func(ev *MotionEvent, phase string) {
+		if phase == PhaseStart {
+			logInfo("Motion started", "camera", ev.Camera, "event_id", ev.ID)
+		} else {
+			logInfo("Motion ended", "camera", ev.Camera, "event_id", ev.ID, "peak_area", math.Round(ev.PeakArea), "path", ev.Clip)
+		}
+		notifiers.Notify(ev, phase)
+		if events == nil {
+			return
+		}
+		if err := events.Write(ev, phase); err != nil {
+			logError("Logging event failed", "camera", ev.Camera, "event_id", ev.ID, "error", err)
+		}
+	}
+
+	var pendingEvents sync.WaitGroup
+	defer pendingEvents.Wait()
+	recordEvent := func(ev *MotionEvent) {
+		if store == nil {
+			return
+		}
+		pendingEvents.Add(1)
+		go func() {
+			defer pendingEvents.Done()
+			if err := store.Record(ev); err != nil {
+				logError("Recording event failed", "camera", ev.Camera, "event_id", ev.ID, "error", err)
+			}
+		}()
 	}
-	defer webcam.Close()
 
-	window := gocv.NewWindow("Motion Window")
-	defer window.Close()
+	var alert *AudioAlert
+	switch *audioAlert {
+	case "":
+	case "bell":
+		alert = &AudioAlert{Cooldown: *audioAlertCooldown}
+	default:
+		alert = &AudioAlert{Command: *audioAlert, Cooldown: *audioAlertCooldown}
+	}
 
-	imgSrc := gocv.NewMat()
-	defer imgSrc.Close()
+	var uploader *Uploader
+	if *uploadTarget != "" {
+		target, err := ParseUploadTarget(*uploadTarget)
+		if err != nil {
+			log.Fatalf("Invalid -upload-target: %v", err)
+		}
+		uploader = &Uploader{
+			Target:      target,
+			KeyTemplate: *uploadKey,
+			DeleteLocal: *uploadDeleteLocal,
+			Journal:     filepath.Join(*queueDir, "uploads.journal"),
+			TTL:         *queueTTL,
+			Pending:     filepath.Join(*outputDir, ".uploads-pending.json"),
+		}
+		if err := uploader.Start(*uploadConcurrency); err != nil {
+			log.Fatalf("Error starting uploads: %v", err)
+		}
+		defer uploader.Close()
+	}
 
-	img := gocv.NewMat()
-	defer img.Close()
+	retention := &Retention{
+		Dir:      *outputDir,
+		MaxBytes: int64(retentionMaxSize),
+		MaxAge:   *retentionMaxAge,
+		DryRun:   *retentionDryRun,
+	}
+	var (
+		continuousDirPath   string
+		continuousRetention *Retention
+	)
+	if *continuous {
+		continuousDirPath = *continuousDir
+		if continuousDirPath == "" {
+			continuousDirPath = filepath.Join(*outputDir, "continuous")
+		}
+		if err := PrepareOutputDir(continuousDirPath); err != nil {
+			log.Fatalf("Invalid -continuous-dir: %v", err)
+		}
+		// continuous recordings are pruned as a separate pool, even if they're
+		// within -output-dir
+		retention.Skip = append(retention.Skip, continuousDirPath)
+		continuousRetention = &Retention{
+			Dir:      continuousDirPath,
+			MaxBytes: int64(continuousMaxSize),
+			MaxAge:   *continuousMaxAge,
+			DryRun:   *retentionDryRun,
+		}
+		if err := continuousRetention.Prune(); err != nil {
+			logError("Applying continuous retention failed", "path", continuousRetention.Dir, "error", err)
+		}
+	}
+	if err := retention.Prune(); err != nil {
+		logError("Applying retention failed", "path", retention.Dir, "error", err)
+	}
 
-	Width = int(webcam.Get(gocv.VideoCaptureFrameWidth))
-	Height = int(webcam.Get(gocv.VideoCaptureFrameHeight))
-	MaxFPS = webcam.Get(gocv.VideoCaptureFPS)
+	for _, c := range cams {
+		c := c
+		if len(cams) > 1 {
+			c.Tag = sanitizeFilename(c.Name)
+		}
+		c.FPS, _ = newFPSCounter()
+		if *lowFPS > 0 {
+			c.FPS.OnLowFPS(*lowFPS, *lowFPSFor, func(current float64) {
+				logWarn("Low FPS", "camera", c.Name, "fps", math.Round(current*10)/10, "below", *lowFPS, "for", *lowFPSFor)
+				if *lowFPSReopen {
+					c.Reopen()
+				}
+			})
+		}
+		// Stages stays nil unless enabled, which makes its methods no-ops
+		if *stageTiming {
+			c.Stages = NewStageTimer()
+		}
 
-	var status string
-	var statusColor color.RGBA
+		c.Mirror = !*noMirror
+		c.Rotate = *rotate
+		if *rotate%180 != 0 {
+			c.Width, c.Height = c.Height, c.Width
+		}
+		if !cropRect.Empty() {
+			if !cropRect.In(image.Rect(0, 0, c.Width, c.Height)) {
+				log.Fatalf("Invalid -crop %q: outside the %dx%d frame of %v", *crop, c.Width, c.Height, c.Name)
+			}
+			c.Crop = cropRect
+			c.Width, c.Height = cropRect.Dx(), cropRect.Dy()
+		}
+		c.applyConfig(detectorFlags)
+		c.DrawLive, c.DrawRecord = *drawLive, *drawRecord
+		c.HUDLive, c.HUDRecord, c.HUD = *hudLive, *hudRecord, *hud
+		if *recordClean {
+			c.DrawRecord, c.HUDRecord = false, false
+		}
+		if *timestampOverlay != "" {
+			overlay, _ := parseTimestampOverlay(c.Name)
+			c.Annotate = overlay.Draw
+		}
+		c.SaveDir, c.SaveExt, c.Codec = *outputDir, filepath.Ext(*output), *codec
+		c.SaveOnExit = *saveOnExit
+		c.SnapshotPeak = *snapshotPeak
+		c.Alert = alert
+		c.LogEvent, c.RecordEvent = logEvent, recordEvent
+		c.Arm = arm
+		c.Hub, c.HubStatusEvery = hub, *wsStatusInterval
 
-	Detector = NewMotionDetector()
-	defer Detector.Close()
+		c.Buffer = NewMatBuffer(*preRoll, c.MaxFPS)
+		logInfo("Buffering", "camera", c.Name, "duration", *preRoll, "fps", math.Round(c.MaxFPS*10)/10)
 
-	SetupCloseHandler()
+		if *snapshotRegions {
+			c.Snapshots = &Snapshotter{
+				Dir:     *outputDir,
+				Padding: *snapshotPadding,
+				MinSize: *snapshotMinSize,
+				Tag:     c.Tag,
+			}
+		}
 
-	fmt.Printf("Start reading device: %v\n", deviceID)
+		c.Tracker = NewEventTracker(c.Name, *postRoll)
+		c.Tracker.MergeGap = *mergeGap
+		c.Recorder = NewRecorder(*outputDir, outputTemplate, c.Name, *codec, c.MaxFPS)
+		c.Recorder.MaxLength = *maxEventLength
+		c.Recorder.Annotate = c.Annotate
+		c.Recorder.Chapters = *chapters
+		c.Recorder.Uploader = uploader
+		if *thumbnails {
+			c.Recorder.Thumbnails = &Thumbnailer{Width: *thumbnailWidth, Overlay: *thumbnailOverlay}
+		}
+		if *sizeFactor > 0 {
+			c.Recorder.Space = &SpaceGuard{Factor: *sizeFactor, Retention: retention}
+		}
+		c.Recorder.OnSave = func(string) {
+			if err := retention.Prune(); err != nil {
+				logError("Applying retention failed", "path", retention.Dir, "error", err)
+			}
+		}
 
-	fps.Start()
-	defer fps.Stop()
+		if *continuous {
+			c.Continuous = &ContinuousRecorder{
+				Dir:           continuousDirPath,
+				Template:      continuousTemplate,
+				Camera:        c.Name,
+				Codec:         *codec,
+				FPS:           c.MaxFPS,
+				SegmentLength: *continuousSegment,
+				Annotate:      c.Annotate,
+				Chapters:      *chapters,
+				OnSave: func(string) {
+					if err := continuousRetention.Prune(); err != nil {
+						logError("Applying continuous retention failed", "path", continuousRetention.Dir, "error", err)
+					}
+				},
+			}
+			if *sizeFactor > 0 {
+				c.Continuous.Space = &SpaceGuard{Factor: *sizeFactor, Retention: continuousRetention}
+			}
+			logInfo("Recording continuously", "camera", c.Name, "path", continuousDirPath, "segment", *continuousSegment)
+		}
 
-	buffer := NewMatBuffer(BufferDuration, MaxFPS)
-	log.Printf("Buffering %v @ %0.1ffps", BufferDuration, MaxFPS)
-	defer buffer.Close()
+		c.Saver = &BufferSaver{Codec: *codec, Annotate: c.Annotate}
+		c.Manual = &ManualRecorder{
+			Camera:   c.Name,
+			Dir:      *outputDir,
+			Ext:      filepath.Ext(*output),
+			Codec:    *codec,
+			FPS:      c.MaxFPS,
+			Annotate: c.Annotate,
+			Tag:      c.Tag,
+		}
+		if *manualRecord {
+			c.Key('v')
+		}
 
-	for !Done {
-		if ok := webcam.Read(&imgSrc); !ok {
-			fmt.Printf("Device closed: %v\n", deviceID)
-			return
+		c.Reconnector = &Reconnector{
+			MaxRetries:  *reconnectMaxRetries,
+			GiveUpAfter: *reconnectGiveUp,
+			MaxBackoff:  *reconnectMaxBackoff,
 		}
-		if imgSrc.Empty() {
-			continue
+		if c.Input == InputDevice {
+			c.Reconnector = &Reconnector{
+				GiveUpAfter: *deviceGiveUp,
+				MaxBackoff:  *reconnectMaxBackoff,
+			}
+			c.ReopenAfter = *deviceReopenAfter
 		}
 
-		// Flip horizontally (mirror view)
-		gocv.Flip(imgSrc, &img, 1)
+		// files are played back at their own rate (times -speed) unless
+		// asked to go as fast as possible
+		limit := *fpsLimit
+		if c.Input == InputFile && !*asFastAsPossible && c.MaxFPS > 0 && *speed > 0 {
+			if rate := c.MaxFPS * *speed; limit <= 0 || rate < limit {
+				limit = rate
+			}
+		}
+		if limit > 0 {
+			c.Limiter = NewRateLimiter(limit)
+			logInfo("Limiting FPS", "camera", c.Name, "fps", math.Round(limit*10)/10)
+		}
+	}
 
-		if !DetectionEnabled {
-			status = "Motion detection disabled"
-			statusColor = blue
-		} else if Detector.Detected(&img) {
-			status = "Motion detected"
-			statusColor = red
-		} else {
-			status = "Ready"
-			statusColor = green
+	first := cams[0]
+	for _, arg := range mainStreams {
+		c := first
+		name, source := parseCameraArg(arg)
+		if name != source {
+			for _, other := range cams {
+				if other.Name == name {
+					c = other
+				}
+			}
+			if c.Name != name {
+				log.Fatalf("Invalid -main-stream %q: no camera named %q", arg, name)
+			}
+		}
+		if c.Main, err = OpenMainStream(source, *mainStreamTolerance); err != nil {
+			log.Fatalf("Error opening main stream %v: %v", source, err)
+		}
+		logInfo("Recording from main stream", "camera", c.Name, "source", source, "width", c.Main.Size.X, "height", c.Main.Size.Y)
+	}
+	if *pip != "" {
+		name, p, err := ParsePiP(*pip)
+		if err != nil {
+			log.Fatalf("Invalid -pip %q: %v", *pip, err)
+		}
+		switch *pipDetect {
+		case PiPDetectPrimary, PiPDetectComposite, PiPDetectBoth:
+		default:
+			log.Fatalf("Invalid -pip-detect %q: must be primary, composite or both", *pipDetect)
+		}
+		for _, c := range cams[1:] {
+			if c.Name == name {
+				p.Camera = c
+			}
+		}
+		if p.Camera == nil {
+			log.Fatalf("Invalid -pip %q: no camera named %q other than the first", *pip, name)
 		}
+		p.Detect = *pipDetect
+		p.Camera.Feed = true
+		first.PiP = p
+	}
+	if err := ValidateCodec(*codec, filepath.Ext(*output), first.MaxFPS, first.Width, first.Height); err != nil {
+		log.Fatalf("Invalid -codec: %v", err)
+	}
 
-		gocv.PutText(&img, Status(status), image.Pt(10, 20), gocv.FontHersheyPlain, 1.2, statusColor, 2)
-		for i := range fps.frames {
-			s := fmt.Sprintf("%d: %d %v", i, fps.frames[i], fps.durations[i])
-			gocv.PutText(&img, s, image.Pt(10, 50+20*i), gocv.FontHersheyPlain, 1.2, blue, 2)
+	// the live view is only streamed for the first camera
+	if *stdoutFormat != "" {
+		// stdout is reserved for frames, which logging never writes to
+		if first.Stdout, err = NewFrameStreamer(os.Stdout, *stdoutFormat); err != nil {
+			log.Fatalf("Invalid -stdout-format: %v", err)
+		}
+		defer first.Stdout.Close()
+	}
+	if *hlsDir != "" {
+		if first.HLS, err = NewHLSStreamer(*hlsDir, *hlsSegment, *hlsListSize, first.MaxFPS); err != nil {
+			log.Fatalf("Error starting HLS stream: %v", err)
+		}
+		defer first.HLS.Close()
+		HandleHLS("/hls/", first.HLS)
+	}
+	if *rtspAddr != "" {
+		if first.RTSP, err = NewRTSPServer(*rtspAddr, *rtspPath, first.MaxFPS); err != nil {
+			log.Fatalf("Error starting RTSP server: %v", err)
 		}
+		defer first.RTSP.Close()
+		logInfo("Serving RTSP", "url", "rtsp://"+*rtspAddr+first.RTSP.Path)
+	}
+	if *webrtcEnabled {
+		if !webrtcAvailable {
+			log.Fatalf("Invalid -webrtc: %v", errWebRTCUnavailable)
+		}
+		if first.WebRTC, err = NewH264Encoder(first.MaxFPS); err != nil {
+			log.Fatalf("Error starting WebRTC encoder: %v", err)
+		}
+		defer first.WebRTC.Close()
+	}
 
-		buffer.Add(&img, time.Now())
-		window.IMShow(img)
-		fps.NextFrame()
+	// the configuration file is reloaded on SIGHUP and through /api/reload
+	var reloader *Reloader
+	if config != nil {
+		reload := &configReload{
+			Path:                config.Path,
+			FromFlags:           fromFlags,
+			Current:             configValues,
+			Cams:                cams,
+			Retention:           retention,
+			ContinuousRetention: continuousRetention,
+			Outbound:            outboundSwitch,
+			NotifyBucket:        globalBucket,
+		}
+		if flag.NArg() == 0 {
+			reload.Cameras = append([]string{}, config.Cameras...)
+		}
+		reloader = &Reloader{Load: reload.Load}
+	}
 
-		PollInput(window)
+	if *httpAddr == "" {
+		*httpAddr = *expvarAddr
+	}
+	if *httpAddr != "" {
+		first.Stream = NewMJPEGStreamer(*streamQuality, *streamScale)
+		defer first.Stream.Close()
+		HandleStream(first.Stream)
+		HandleDashboard(recent, *outputDir)
+		HandleClips(&ClipCatalog{Dir: *outputDir, Store: store})
+		// keep the clean frame too, for /snapshot.jpg?clean=1
+		first.Feed = true
+		HandleSnapshot(first, *streamQuality)
+		HandleConfig(cams)
+		HandleArm(arm)
+		HandleStatus(cams)
+		HandleSave(cams)
+		HandleReload(reloader)
+		HandleEvents(hub)
+		if onvif != nil {
+			HandleONVIF(onvif)
+		}
+		HandleMetrics(cams)
+		HandleHealth(cams, *healthFrameTimeout, *healthSaveTimeout)
+		if first.WebRTC != nil {
+			var iceServers []string
+			if *webrtcICEServers != "" {
+				iceServers = strings.Split(*webrtcICEServers, ",")
+			}
+			HandleWebRTC(first.WebRTC, iceServers)
+		}
+		ServeHTTP(*httpAddr, cams, httpAuth, *httpTLSCert, *httpTLSKey)
+		if *mdnsAnnounce {
+			if mdns := announceHTTP(*httpAddr, *mdnsName, cams, *httpTLSCert != ""); mdns != nil {
+				defer mdns.Close()
+			}
+		}
+	} else {
+		if first.HLS != nil {
+			logWarn("-hls-dir is set without -http-addr; the stream is only written to it", "path", *hlsDir)
+		}
+		if onvif != nil {
+			logWarn("-onvif is set without -http-addr; ONVIF clients can't subscribe")
+		}
+		if first.WebRTC != nil {
+			logWarn("-webrtc is set without -http-addr; viewers can't connect")
+		}
+		if *mdnsAnnounce {
+			logWarn("-mdns is set without -http-addr; there's nothing to announce")
+		}
+		if *headless && *mqttBroker == "" && *grpcAddr == "" {
+			logWarn("Running -headless without -http-addr, -grpc-addr or -mqtt-broker; detection settings can't be changed while running")
+		}
+	}
+	if *grpcAddr != "" {
+		if err := ServeGRPC(*grpcAddr, *grpcTLSCert, *grpcTLSKey, cams, hub, httpAuth); err != nil {
+			log.Fatalf("Error serving -grpc-addr: %v", err)
+		}
+	} else if *grpcTLSCert != "" {
+		logWarn("-grpc-tls-cert is set without -grpc-addr; there's nothing to serve")
+	}
+
+	SetupCloseHandler()
+	SetupReloadHandler(reloader)
+	SetupStatsHandler(cams)
+	for _, c := range cams {
+		go func(c *Camera) {
+			// a crash mustn't leave the floodlight on
+			defer gpio.CloseOnPanic()
+			c.Run()
+		}(c)
 	}
 
-	log.Printf("Saving (%v @ %0.0ffps)", buffer.Duration(), buffer.FPS())
-	if err := buffer.WriteFile("video.mp4", "mp4v"); err != nil {
-		log.Fatalf("Error saving buffer: %v", err)
+	if *headless {
+		runHeadless(cams, *statusInterval)
+	} else {
+		runUI(cams, names, *fullscreen, screen)
+	}
+
+	Quit()
+	for _, c := range cams {
+		c.Wait()
+	}
+	LogHistogram(frameTimer.Histogram())
+	for _, c := range cams {
+		c.Close()
 	}
-	log.Println("Done")
+	logInfo("Done")
 
 	if *memprofile != "" {
-		log.Println("Profiling memory to", *memprofile)
+		logInfo("Profiling memory", "path", *memprofile)
 
 		f, err := os.Create(*memprofile)
 		if err != nil {
@@ -214,7 +1381,7 @@ func main() {
 	}
 
 	if *matprofile != "" {
-		log.Println("Profiling matrix memory to", *matprofile)
+		logInfo("Profiling matrix memory", "path", *matprofile)
 
 		f, err := os.Create(*matprofile)
 		if err != nil {
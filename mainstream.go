@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// mainStreamFrames is the number of recent frames kept from a main stream to
+// match against detection frames.
+const mainStreamFrames = 8
+
+// MainStream reads a camera's high resolution main stream in the background,
+// while detection runs on its low resolution sub-stream, as NVRs do. Each
+// detection frame is matched with the main stream frame nearest to it in
+// time, which is what gets buffered, recorded and snapshotted.
+type MainStream struct {
+	// Source is the main stream's URL.
+	Source string
+	// Tolerance is how far apart in time matched frames may be.
+	Tolerance time.Duration
+	// Size is the size of the main stream's frames.
+	Size image.Point
+
+	input       string
+	reconnector *Reconnector
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	mu     sync.Mutex
+	frames [mainStreamFrames]gocv.Mat
+	times  [mainStreamFrames]time.Time
+	next   int
+
+	// degraded is whether detection frames are being recorded instead, and
+	// is only used by the camera's goroutine
+	degraded bool
+}
+
+// OpenMainStream opens the given main stream, and starts reading it in the
+// background.
+func OpenMainStream(source string, tolerance time.Duration) (*MainStream, error) {
+	input, err := DetectInputType(source, InputAuto)
+	if err != nil {
+		return nil, err
+	}
+	src, err := openSource(source, input)
+	if err != nil {
+		return nil, err
+	}
+	s := &MainStream{
+		Source:    source,
+		Tolerance: tolerance,
+		Size: image.Pt(
+			int(src.Get(gocv.VideoCaptureFrameWidth)),
+			int(src.Get(gocv.VideoCaptureFrameHeight)),
+		),
+		input:       input,
+		reconnector: &Reconnector{MaxBackoff: *reconnectMaxBackoff},
+		done:        make(chan struct{}),
+	}
+	for i := range s.frames {
+		s.frames[i] = gocv.NewMat()
+	}
+	s.wg.Add(1)
+	go s.run(src)
+	return s, nil
+}
+
+// run reads frames until Close is called, reopening the stream whenever it
+// fails.
+func (s *MainStream) run(src FrameSource) {
+	defer s.wg.Done()
+	img := gocv.NewMat()
+	defer img.Close()
+	for {
+		select {
+		case <-s.done:
+			src.Close()
+			return
+		default:
+		}
+
+		if s.reconnector.Active() {
+			if t := time.Now(); s.reconnector.Due(t) {
+				if reopened, err := openSource(s.Source, s.input); err == nil {
+					src = reopened
+					logInfo("Reconnected to main stream", "source", s.Source, "outage", s.reconnector.Succeeded(time.Now()).Round(time.Second))
+					continue
+				}
+				s.reconnector.Retry(t)
+			}
+			time.Sleep(reconnectPoll)
+			continue
+		}
+
+		if !src.Read(&img) {
+			logWarn("Lost main stream, reconnecting", "source", s.Source)
+			src.Close()
+			s.reconnector.Failed(time.Now())
+			continue
+		}
+		if img.Empty() {
+			continue
+		}
+		t := time.Now()
+		s.mu.Lock()
+		img.CopyTo(&s.frames[s.next])
+		s.times[s.next] = t
+		s.next = (s.next + 1) % mainStreamFrames
+		s.mu.Unlock()
+	}
+}
+
+// Nearest copies the frame read nearest to time t to dst, returning false if
+// there is none within Tolerance.
+func (s *MainStream) Nearest(t time.Time, dst *gocv.Mat) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	best := -1
+	var bestDiff time.Duration
+	for i, ft := range s.times {
+		if ft.IsZero() {
+			continue
+		}
+		diff := t.Sub(ft)
+		if diff < 0 {
+			diff = -diff
+		}
+		if best < 0 || diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	if best < 0 || bestDiff > s.Tolerance {
+		return false
+	}
+	s.frames[best].CopyTo(dst)
+	return true
+}
+
+// Match fills dst with the main stream frame matching a detection frame, img,
+// captured at time t. If the main stream has no frame close enough, img is
+// scaled up instead, so that recordings keep the same size, and a warning is
+// logged until the streams are back in step.
+func (s *MainStream) Match(img gocv.Mat, t time.Time, dst *gocv.Mat) {
+	if s.Nearest(t, dst) {
+		if s.degraded {
+			logInfo("Main stream is back in step, recording it again", "source", s.Source)
+			s.degraded = false
+		}
+		return
+	}
+	if !s.degraded {
+		logWarn("Main stream has no frame close enough to the detection stream, recording the detection stream instead", "source", s.Source, "tolerance", s.Tolerance)
+		s.degraded = true
+	}
+	if s.Size.X > 0 && s.Size.Y > 0 {
+		gocv.Resize(img, dst, s.Size, 0, 0, gocv.InterpolationLinear)
+	} else {
+		img.CopyTo(dst)
+	}
+}
+
+// Scale returns the given detections, from a detection frame of the given
+// size, in the coordinates of the main stream. They can't be annotated with
+// contours.
+func (s *MainStream) Scale(detections []Detection, from image.Point) []Detection {
+	if from.X <= 0 || from.Y <= 0 || s.Size.X <= 0 {
+		return detections
+	}
+	scaled := make([]Detection, len(detections))
+	for i, d := range detections {
+		r := d.Rect
+		scaled[i] = Detection{
+			Rect: image.Rect(
+				r.Min.X*s.Size.X/from.X, r.Min.Y*s.Size.Y/from.Y,
+				r.Max.X*s.Size.X/from.X, r.Max.Y*s.Size.Y/from.Y,
+			),
+			Area:    d.Area * float64(s.Size.X*s.Size.Y) / float64(from.X*from.Y),
+			contour: -1,
+		}
+	}
+	return scaled
+}
+
+// Close stops reading the main stream.
+func (s *MainStream) Close() {
+	close(s.done)
+	s.wg.Wait()
+	for i := range s.frames {
+		s.frames[i].Close()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// ManualRecorder records live frames to a file between calls to Start and
+// Stop, like a camcorder, independently of motion detection.
+type ManualRecorder struct {
+	// Camera is the camera's name, for logs.
+	Camera string
+	// Dir is the directory to save recordings to.
+	Dir string
+	// Ext is the extension of recordings, e.g. ".mp4".
+	Ext string
+	// Codec is the "FourCC" codec to record with (e.g. "mp4v").
+	Codec string
+	// FPS is used when it can't be measured from the buffer.
+	FPS float64
+	// Annotate, if set, is applied to each frame as it is written.
+	Annotate FrameAnnotator
+	// Tag, if set, distinguishes the camera's recordings from other cameras'.
+	Tag string
+
+	current  *SegmentWriter
+	filename string
+	start    time.Time
+	wg       sync.WaitGroup
+}
+
+// Recording returns whether a recording is in progress.
+func (r *ManualRecorder) Recording() bool {
+	return r.current != nil
+}
+
+// Elapsed returns how long the recording in progress has been going at time
+// t, counting from the first live frame.
+func (r *ManualRecorder) Elapsed(t time.Time) time.Duration {
+	if r.current == nil {
+		return 0
+	}
+	return t.Sub(r.start)
+}
+
+// Start starts a new recording at time t, beginning with copies of the frames
+// in buffer, which is left untouched. If a recording is already in progress,
+// Start does nothing.
+func (r *ManualRecorder) Start(buffer *MatBuffer, t time.Time) {
+	if r.current != nil {
+		return
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		logError("Not starting manual recording", "camera", r.Camera, "error", err)
+		return
+	}
+	fps := buffer.FPS()
+	if fps <= 0 {
+		fps = r.FPS
+	}
+	imgs, times := buffer.Slice(), buffer.sliceTimes()
+
+	prefix := "manual"
+	if r.Tag != "" {
+		prefix += "_" + r.Tag
+	}
+	r.filename = OutputPath(r.Dir, prefix+t.Format("_20060102_150405")+r.Ext)
+	r.start = t
+	tmp := filepath.Join(filepath.Dir(r.filename), "."+filepath.Base(r.filename))
+	r.current = NewSegmentWriter(tmp, r.Codec, fps, len(imgs)+recorderQueue)
+	r.current.Annotate = r.Annotate
+	for i, img := range imgs {
+		m := img.Clone()
+		r.current.Add(&m, times[i], 0)
+	}
+	logInfo("Started manual recording", "camera", r.Camera, "path", r.filename)
+}
+
+// Add copies a live frame captured at time t to the recording in progress. If
+// no recording is in progress, Add does nothing.
+func (r *ManualRecorder) Add(img *gocv.Mat, t time.Time) {
+	if r.current == nil {
+		return
+	}
+	m := img.Clone()
+	if !r.current.Add(&m, t, 0) {
+		drops.Drop("rec")
+	}
+}
+
+// Stop ends the recording in progress, which is finalized in the background.
+// If no recording is in progress, Stop does nothing.
+func (r *ManualRecorder) Stop() {
+	if r.current == nil {
+		return
+	}
+	w, filename := r.current, r.filename
+	r.current = nil
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := finalizeSegment(w, filename); err != nil {
+			logError("Saving manual recording failed", "camera", r.Camera, "path", filename, "error", err)
+			os.Remove(w.Filename)
+			return
+		}
+		first, last := w.TimeWindow()
+		logInfo("Saved manual recording", "camera", r.Camera, "path", filename, "frames", w.Count(), "duration", last.Sub(first))
+	}()
+}
+
+// Wait waits for all recordings being finalized in the background.
+func (r *ManualRecorder) Wait() {
+	r.wg.Wait()
+}
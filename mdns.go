@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mdnsAddr is the IPv4 mDNS multicast group and port.
+	mdnsAddr = "224.0.0.251:5353"
+	// mdnsService is the DNS-SD service type announced.
+	mdnsService = "_http._tcp.local."
+	// mdnsServices is the DNS-SD name listing all service types.
+	mdnsServices = "_services._dns-sd._udp.local."
+	// mdnsTTL is how long, in seconds, others may cache the records.
+	mdnsTTL = 120
+	// mdnsApp is the TXT entry identifying instances of motiondetect.
+	mdnsApp = "app=motiondetect"
+)
+
+// DNS record types and classes used by mDNS.
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN = 1
+	// dnsClassFlush is set on records that replace all others of the same
+	// name and type, and on questions asking for a unicast response.
+	dnsClassFlush = 0x8000
+)
+
+// dnsQuestion is a question in a DNS message.
+type dnsQuestion struct {
+	Name    []string
+	Type    uint16
+	Unicast bool
+}
+
+// dnsRR is a DNS resource record, of one of the types used by DNS-SD.
+type dnsRR struct {
+	Name  []string
+	Type  uint16
+	Flush bool
+	TTL   uint32
+
+	// Target is the name a PTR points to, or an SRV's host.
+	Target []string
+	// Port is an SRV's port.
+	Port uint16
+	// Text is a TXT's strings.
+	Text []string
+	// IP is an A's address.
+	IP net.IP
+}
+
+// parseDNSName splits a dot-separated name into labels.
+func parseDNSName(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "."), ".")
+}
+
+// dnsNameEqual compares names case-insensitively, as DNS does.
+func dnsNameEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func appendDNSName(b []byte, name []string) []byte {
+	for _, l := range name {
+		if len(l) > 63 {
+			l = l[:63]
+		}
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// appendDNSMessage appends a DNS message, without name compression.
+func appendDNSMessage(b []byte, id uint16, response bool, qs []dnsQuestion, answers, extra []dnsRR) []byte {
+	var flags uint16
+	if response {
+		// authoritative answer
+		flags = 0x8400
+	}
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(qs)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(extra)))
+	for _, q := range qs {
+		b = appendDNSName(b, q.Name)
+		class := uint16(dnsClassIN)
+		if q.Unicast {
+			class |= dnsClassFlush
+		}
+		b = append(b, byte(q.Type>>8), byte(q.Type), byte(class>>8), byte(class))
+	}
+	for _, rrs := range [][]dnsRR{answers, extra} {
+		for _, rr := range rrs {
+			b = rr.append(b)
+		}
+	}
+	return b
+}
+
+func (rr *dnsRR) append(b []byte) []byte {
+	b = appendDNSName(b, rr.Name)
+	class := uint16(dnsClassIN)
+	if rr.Flush {
+		class |= dnsClassFlush
+	}
+	b = append(b, byte(rr.Type>>8), byte(rr.Type), byte(class>>8), byte(class),
+		byte(rr.TTL>>24), byte(rr.TTL>>16), byte(rr.TTL>>8), byte(rr.TTL), 0, 0)
+	start := len(b)
+	switch rr.Type {
+	case dnsTypePTR:
+		b = appendDNSName(b, rr.Target)
+	case dnsTypeSRV:
+		// priority and weight, then the port
+		b = append(b, 0, 0, 0, 0, byte(rr.Port>>8), byte(rr.Port))
+		b = appendDNSName(b, rr.Target)
+	case dnsTypeTXT:
+		for _, s := range rr.Text {
+			if len(s) > 255 {
+				s = s[:255]
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case dnsTypeA:
+		b = append(b, rr.IP.To4()...)
+	}
+	binary.BigEndian.PutUint16(b[start-2:], uint16(len(b)-start))
+	return b
+}
+
+var errDNSMessage = errors.New("malformed DNS message")
+
+// dnsReader reads a DNS message.
+type dnsReader struct {
+	msg []byte
+	off int
+}
+
+func (r *dnsReader) uint16() (uint16, error) {
+	if r.off+2 > len(r.msg) {
+		return 0, errDNSMessage
+	}
+	v := binary.BigEndian.Uint16(r.msg[r.off:])
+	r.off += 2
+	return v, nil
+}
+
+// name reads a possibly compressed name.
+func (r *dnsReader) name() ([]string, error) {
+	var labels []string
+	off, jumped := r.off, false
+	for hops := 0; hops < 64; hops++ {
+		if off >= len(r.msg) {
+			return nil, errDNSMessage
+		}
+		n := int(r.msg[off])
+		switch {
+		case n == 0:
+			if !jumped {
+				r.off = off + 1
+			}
+			return labels, nil
+		case n&0xc0 == 0xc0:
+			if off+2 > len(r.msg) {
+				return nil, errDNSMessage
+			}
+			if !jumped {
+				r.off = off + 2
+			}
+			off, jumped = int(binary.BigEndian.Uint16(r.msg[off:])&0x3fff), true
+		default:
+			if off+1+n > len(r.msg) {
+				return nil, errDNSMessage
+			}
+			labels = append(labels, string(r.msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return nil, errDNSMessage
+}
+
+// parseDNSMessage parses a DNS message, skipping records of types other than
+// those used by DNS-SD.
+func parseDNSMessage(msg []byte) (id uint16, response bool, qs []dnsQuestion, rrs []dnsRR, err error) {
+	if len(msg) < 12 {
+		return 0, false, nil, nil, errDNSMessage
+	}
+	id = binary.BigEndian.Uint16(msg)
+	response = msg[2]&0x80 != 0
+	counts := make([]int, 4)
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(msg[4+2*i:]))
+	}
+	r := &dnsReader{msg: msg, off: 12}
+	for i := 0; i < counts[0]; i++ {
+		var q dnsQuestion
+		if q.Name, err = r.name(); err != nil {
+			return
+		}
+		var class uint16
+		if q.Type, err = r.uint16(); err != nil {
+			return
+		}
+		if class, err = r.uint16(); err != nil {
+			return
+		}
+		q.Unicast = class&dnsClassFlush != 0
+		qs = append(qs, q)
+	}
+	for i := 0; i < counts[1]+counts[2]+counts[3]; i++ {
+		var rr dnsRR
+		if rr.Name, err = r.name(); err != nil {
+			return
+		}
+		var class, ttlHi, ttlLo, length uint16
+		for _, v := range []*uint16{&rr.Type, &class, &ttlHi, &ttlLo, &length} {
+			if *v, err = r.uint16(); err != nil {
+				return
+			}
+		}
+		rr.Flush = class&dnsClassFlush != 0
+		rr.TTL = uint32(ttlHi)<<16 | uint32(ttlLo)
+		end := r.off + int(length)
+		if end > len(msg) {
+			err = errDNSMessage
+			return
+		}
+		switch rr.Type {
+		case dnsTypePTR:
+			rr.Target, err = r.name()
+		case dnsTypeSRV:
+			r.off += 4
+			if rr.Port, err = r.uint16(); err == nil {
+				rr.Target, err = r.name()
+			}
+		case dnsTypeTXT:
+			for off := r.off; off < end; {
+				n := int(msg[off])
+				if off+1+n > end {
+					err = errDNSMessage
+					break
+				}
+				rr.Text = append(rr.Text, string(msg[off+1:off+1+n]))
+				off += 1 + n
+			}
+		case dnsTypeA:
+			if length == 4 {
+				rr.IP = net.IP(append([]byte(nil), msg[r.off:end]...))
+			}
+		}
+		if err != nil {
+			return
+		}
+		r.off = end
+		switch rr.Type {
+		case dnsTypePTR, dnsTypeSRV, dnsTypeTXT, dnsTypeA:
+			rrs = append(rrs, rr)
+		}
+	}
+	return
+}
+
+// MDNSAnnouncer announces an HTTP service on the local network with mDNS, as
+// Instance._http._tcp.local, and answers queries for it until it's closed,
+// when it withdraws the announcement. It doesn't probe for conflicting
+// names, so instance names should be unique on the network.
+type MDNSAnnouncer struct {
+	// Instance is the service's instance name, as shown by discovery tools.
+	Instance string
+	// Host is the host name, without .local, and IPs its IPv4 addresses.
+	Host string
+	IPs  []net.IP
+	Port int
+	// Text is the service's TXT record, of key=value entries.
+	Text []string
+
+	conn *net.UDPConn
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewMDNSAnnouncer creates an MDNSAnnouncer for the service on port, at the
+// given host, or the addresses of all interfaces if it's empty.
+func NewMDNSAnnouncer(instance, host string, port int, text []string) (*MDNSAnnouncer, error) {
+	a := &MDNSAnnouncer{Instance: instance, Port: port, Text: text, done: make(chan struct{})}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	a.Host = strings.SplitN(hostname, ".", 2)[0]
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil && !ip.IsUnspecified() {
+		a.IPs = []net.IP{ip.To4()}
+		return a, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() {
+			a.IPs = append(a.IPs, ipnet.IP.To4())
+		}
+	}
+	if len(a.IPs) == 0 {
+		return nil, errors.New("no IPv4 addresses to announce")
+	}
+	return a, nil
+}
+
+// Start joins the mDNS group, announces the service, and answers queries in
+// the background.
+func (a *MDNSAnnouncer) Start() error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return err
+	}
+	if a.conn, err = net.ListenMulticastUDP("udp4", nil, group); err != nil {
+		return err
+	}
+	a.wg.Add(2)
+	go a.serve(group)
+	go func() {
+		defer a.wg.Done()
+		// announce twice, a second apart, in case the first is lost
+		for i := 0; i < 2; i++ {
+			a.send(group, 0, nil, a.records(mdnsTTL), nil)
+			select {
+			case <-a.done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return nil
+}
+
+// names returns the service's instance and host names.
+func (a *MDNSAnnouncer) names() (instance, host []string) {
+	return append([]string{a.Instance}, parseDNSName(mdnsService)...), []string{a.Host, "local"}
+}
+
+// records returns all the service's records, with the given TTL.
+func (a *MDNSAnnouncer) records(ttl uint32) []dnsRR {
+	instance, host := a.names()
+	rrs := []dnsRR{
+		{Name: parseDNSName(mdnsService), Type: dnsTypePTR, TTL: ttl, Target: instance},
+		{Name: instance, Type: dnsTypeSRV, Flush: true, TTL: ttl, Port: uint16(a.Port), Target: host},
+		{Name: instance, Type: dnsTypeTXT, Flush: true, TTL: ttl, Text: a.Text},
+	}
+	for _, ip := range a.IPs {
+		rrs = append(rrs, dnsRR{Name: host, Type: dnsTypeA, Flush: true, TTL: ttl, IP: ip})
+	}
+	return rrs
+}
+
+// answer returns the records answering q, and the additional records that
+// go with them.
+func (a *MDNSAnnouncer) answer(q dnsQuestion) (answers, extra []dnsRR) {
+	instance, host := a.names()
+	all := a.records(mdnsTTL)
+	wants := func(t uint16) bool { return q.Type == t || q.Type == dnsTypeANY }
+	switch {
+	case dnsNameEqual(q.Name, parseDNSName(mdnsServices)) && wants(dnsTypePTR):
+		answers = []dnsRR{{Name: q.Name, Type: dnsTypePTR, TTL: mdnsTTL, Target: parseDNSName(mdnsService)}}
+	case dnsNameEqual(q.Name, parseDNSName(mdnsService)) && wants(dnsTypePTR):
+		answers, extra = all[:1], all[1:]
+	case dnsNameEqual(q.Name, instance):
+		for _, rr := range all[1:3] {
+			if wants(rr.Type) {
+				answers = append(answers, rr)
+			}
+		}
+		extra = all[3:]
+	case dnsNameEqual(q.Name, host) && wants(dnsTypeA):
+		answers = all[3:]
+	}
+	return answers, extra
+}
+
+// serve answers queries until the connection is closed.
+func (a *MDNSAnnouncer) serve(group *net.UDPAddr) {
+	defer a.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-a.done:
+			default:
+				logError("Reading mDNS queries failed", "error", err)
+			}
+			return
+		}
+		id, response, qs, _, err := parseDNSMessage(buf[:n])
+		if err != nil || response {
+			continue
+		}
+		var answers, extra []dnsRR
+		unicast := false
+		for _, q := range qs {
+			an, ex := a.answer(q)
+			if len(an) > 0 {
+				answers = append(answers, an...)
+				extra = append(extra, ex...)
+				unicast = unicast || q.Unicast
+			}
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		if src.Port != group.Port {
+			// a one-shot query, e.g. from "discover", wants a conventional
+			// unicast response, with its ID and questions
+			a.send(src, id, qs, answers, extra)
+		} else if unicast {
+			a.send(src, 0, nil, answers, extra)
+		} else {
+			a.send(group, 0, nil, answers, extra)
+		}
+	}
+}
+
+// send sends a response to dst.
+func (a *MDNSAnnouncer) send(dst *net.UDPAddr, id uint16, qs []dnsQuestion, answers, extra []dnsRR) {
+	msg := appendDNSMessage(nil, id, true, qs, answers, extra)
+	if _, err := a.conn.WriteToUDP(msg, dst); err != nil {
+		logError("Sending mDNS response failed", "error", err)
+	}
+}
+
+// Close withdraws the announcement, by announcing the records with a TTL of
+// 0, and stops answering queries.
+func (a *MDNSAnnouncer) Close() {
+	if a == nil || a.conn == nil {
+		return
+	}
+	group, _ := net.ResolveUDPAddr("udp4", mdnsAddr)
+	close(a.done)
+	a.send(group, 0, nil, a.records(0), nil)
+	a.conn.Close()
+	a.wg.Wait()
+}
+
+// String describes the announced service.
+func (a *MDNSAnnouncer) String() string {
+	return fmt.Sprintf("%q on %s.local:%d", a.Instance, a.Host, a.Port)
+}
+
+// announceHTTP announces the HTTP interface at addr with mDNS, as instance, or
+// a name made of the cameras' names and the host name if it's empty, logging
+// and returning nil if it can't.
+func announceHTTP(addr, instance string, cams []*Camera, tls bool) *MDNSAnnouncer {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		logError("Not announcing with mDNS: invalid -http-addr", "error", err)
+		return nil
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		logError("Not announcing with mDNS: invalid -http-addr", "error", err)
+		return nil
+	}
+	names := make([]string, len(cams))
+	for i, c := range cams {
+		names[i] = c.Name
+	}
+	text := []string{mdnsApp, "version=" + version, "path=/", "cameras=" + strings.Join(names, ",")}
+	if tls {
+		text = append(text, "scheme=https")
+	}
+	a, err := NewMDNSAnnouncer(instance, host, port, text)
+	if err != nil {
+		logError("Not announcing with mDNS", "error", err)
+		return nil
+	}
+	if a.Instance == "" {
+		a.Instance = strings.Join(names, ", ") + " on " + a.Host
+	}
+	if err := a.Start(); err != nil {
+		logError("Not announcing with mDNS", "error", err)
+		return nil
+	}
+	logInfo("Announcing with mDNS", "instance", a.Instance, "host", a.Host+".local", "port", a.Port)
+	return a
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DurationHistogram is a cumulative histogram of durations over
+// histogramBounds, in the form Prometheus expects. It is safe for concurrent
+// use.
+type DurationHistogram struct {
+	counts []int64
+	sum    int64 // nanoseconds
+}
+
+// NewDurationHistogram creates an empty DurationHistogram.
+func NewDurationHistogram() *DurationHistogram {
+	return &DurationHistogram{counts: make([]int64, len(histogramBounds)+1)}
+}
+
+// Observe records a single duration.
+func (h *DurationHistogram) Observe(d time.Duration) {
+	i := sort.Search(len(histogramBounds), func(i int) bool {
+		return d <= histogramBounds[i]
+	})
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// CameraMetrics are a camera's metrics that aren't otherwise safe to read
+// outside its capture loop, which updates them.
+type CameraMetrics struct {
+	FrameDuration  *DurationHistogram
+	DetectDuration *DurationHistogram
+
+	events           int64
+	lastFrame        int64  // unix nanoseconds
+	bufferFill       uint64 // math.Float64bits
+	detectionEnabled int32
+}
+
+// NewCameraMetrics creates zeroed CameraMetrics.
+func NewCameraMetrics() *CameraMetrics {
+	return &CameraMetrics{
+		FrameDuration:  NewDurationHistogram(),
+		DetectDuration: NewDurationHistogram(),
+	}
+}
+
+// EventStarted counts an event.
+func (m *CameraMetrics) EventStarted() {
+	atomic.AddInt64(&m.events, 1)
+}
+
+// Events returns the number of events started.
+func (m *CameraMetrics) Events() int64 {
+	return atomic.LoadInt64(&m.events)
+}
+
+// SetBufferFill records how full the camera's buffer is, from 0 to 1.
+func (m *CameraMetrics) SetBufferFill(ratio float64) {
+	atomic.StoreUint64(&m.bufferFill, math.Float64bits(ratio))
+}
+
+// SetDetectionEnabled records whether detection is enabled.
+func (m *CameraMetrics) SetDetectionEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.detectionEnabled, v)
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	w io.Writer
+	// labelled is set if samples are labelled by camera
+	labelled bool
+}
+
+// family writes the HELP and TYPE lines of a metric.
+func (mw *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP motiondetect_%s %s\n# TYPE motiondetect_%s %s\n", name, help, name, typ)
+}
+
+// sample writes a single sample of a metric, for the given camera, and with
+// the given extra labels (as name, value pairs).
+func (mw *metricsWriter) sample(name, camera string, v float64, labels ...string) {
+	if mw.labelled {
+		labels = append([]string{"camera", camera}, labels...)
+	}
+	var sb strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=%q", labels[i], labels[i+1])
+	}
+	if sb.Len() > 0 {
+		fmt.Fprintf(mw.w, "motiondetect_%s{%s} %v\n", name, sb.String(), v)
+	} else {
+		fmt.Fprintf(mw.w, "motiondetect_%s %v\n", name, v)
+	}
+}
+
+// histogram writes the samples of a histogram for the given camera.
+func (mw *metricsWriter) histogram(name, camera string, h *DurationHistogram) {
+	var count int64
+	for i := range h.counts {
+		count += atomic.LoadInt64(&h.counts[i])
+		le := "+Inf"
+		if i < len(histogramBounds) {
+			le = fmt.Sprint(histogramBounds[i].Seconds())
+		}
+		mw.sample(name+"_bucket", camera, float64(count), "le", le)
+	}
+	mw.sample(name+"_sum", camera, time.Duration(atomic.LoadInt64(&h.sum)).Seconds())
+	mw.sample(name+"_count", camera, float64(count))
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HandleMetrics registers /metrics with http.DefaultServeMux, serving the
+// cameras' metrics in the Prometheus text format. With more than one camera,
+// per-camera metrics are labelled by camera.
+func HandleMetrics(cams []*Camera) {
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		mw := &metricsWriter{w: w, labelled: len(cams) > 1}
+
+		mw.family("frames_total", "counter", "Frames processed.")
+		for _, c := range cams {
+			mw.sample("frames_total", c.Name, float64(c.FPS.TotalFrames()))
+		}
+		mw.family("events_total", "counter", "Motion events started.")
+		for _, c := range cams {
+			mw.sample("events_total", c.Name, float64(c.Metrics.Events()))
+		}
+		mw.family("fps", "gauge", "Frames processed per second.")
+		for _, c := range cams {
+			mw.sample("fps", c.Name, c.FPS.FPS())
+		}
+		mw.family("buffer_fill_ratio", "gauge", "How full the pre-roll buffer is, from 0 to 1.")
+		for _, c := range cams {
+			mw.sample("buffer_fill_ratio", c.Name, math.Float64frombits(atomic.LoadUint64(&c.Metrics.bufferFill)))
+		}
+		mw.family("detection_enabled", "gauge", "Whether motion detection is enabled (1) or not (0).")
+		for _, c := range cams {
+			mw.sample("detection_enabled", c.Name, float64(atomic.LoadInt32(&c.Metrics.detectionEnabled)))
+		}
+		mw.family("frame_duration_seconds", "histogram", "Time taken to process each frame.")
+		for _, c := range cams {
+			mw.histogram("frame_duration_seconds", c.Name, c.Metrics.FrameDuration)
+		}
+		mw.family("detection_duration_seconds", "histogram", "Time taken to detect motion in each frame.")
+		for _, c := range cams {
+			mw.histogram("detection_duration_seconds", c.Name, c.Metrics.DetectDuration)
+		}
+
+		// these aren't per camera
+		mw.labelled = false
+		mw.family("armed", "gauge", "Whether detected motion produces events (1) or not (0).")
+		armed := 0.0
+		if cams[0].Arm.Armed() {
+			armed = 1
+		}
+		mw.sample("armed", "", armed)
+		mw.family("frames_dropped_total", "counter", "Frames dropped, by reason.")
+		counts := drops.Drops()
+		for _, reason := range sortedKeys(counts) {
+			mw.sample("frames_dropped_total", "", float64(counts[reason]), "reason", reason)
+		}
+		mw.family("notifications_failed_total", "counter", "Event notifications dropped or given up on, by notifier.")
+		counts = notifyFailures.Drops()
+		for _, notifier := range sortedKeys(counts) {
+			mw.sample("notifications_failed_total", "", float64(counts[notifier]), "notifier", notifier)
+		}
+		names, depths, ages := outboundStats()
+		mw.family("outbound_queue_depth", "gauge", "Notifications and uploads waiting to be sent, by queue.")
+		for _, name := range names {
+			mw.sample("outbound_queue_depth", "", float64(depths[name]), "queue", name)
+		}
+		mw.family("outbound_queue_oldest_age_seconds", "gauge", "Age of the oldest item waiting to be sent, by queue.")
+		for _, name := range names {
+			mw.sample("outbound_queue_oldest_age_seconds", "", ages[name].Seconds(), "queue", name)
+		}
+		mw.family("outbound_expired_total", "counter", "Notifications and uploads dropped for being older than -queue-ttl, by queue.")
+		counts = outboundExpired.Drops()
+		for _, name := range sortedKeys(counts) {
+			mw.sample("outbound_expired_total", "", float64(counts[name]), "queue", name)
+		}
+		mw.family("clock_steps_total", "counter", "Times the wall clock stepped, by direction.")
+		counts = clockSteps.Drops()
+		for _, direction := range sortedKeys(counts) {
+			mw.sample("clock_steps_total", "", float64(counts[direction]), "direction", direction)
+		}
+		if clock := hostClock.Status(); clock.Sync != ClockUnknown {
+			synced := 0.0
+			if clock.Sync == ClockSynced {
+				synced = 1
+			}
+			mw.family("clock_synced", "gauge", "Whether the kernel considers the clock synchronized, e.g. by NTP.")
+			mw.sample("clock_synced", "", synced)
+			mw.family("clock_max_error_seconds", "gauge", "The kernel's maximum error of the clock.")
+			mw.sample("clock_max_error_seconds", "", clock.MaxErrorSeconds)
+		}
+	})
+}
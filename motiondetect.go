@@ -30,6 +30,10 @@ type MotionDetector struct {
 	DrawContours bool
 	DrawRects    bool
 
+	lastArea    float64
+	contours    gocv.PointsVector
+	hasContours bool
+
 	deltaMat     gocv.Mat
 	threshMat    gocv.Mat
 	bgSubtractor gocv.BackgroundSubtractorMOG2
@@ -39,7 +43,7 @@ type MotionDetector struct {
 func NewMotionDetector() *MotionDetector {
 	return &MotionDetector{
 		Threshold:          25,
-		DilateSize:          3,
+		DilateSize:         3,
 		MinimumContourArea: 3000,
 		DrawContours:       true,
 		DrawRects:          true,
@@ -49,13 +53,22 @@ func NewMotionDetector() *MotionDetector {
 	}
 }
 
-// Detected returns true if motion has been detected in the given image,
-// compared to the image given the last time it was called. The image will also
-// be marked up with rectangles and contours where the motion was detected,
-// based on the values of DrawRects and DrawContours, respectively.
-func (m *MotionDetector) Detected(img *gocv.Mat) bool {
+// Detection is a region of an image in which motion was detected.
+type Detection struct {
+	// Rect is the bounding rectangle of the region.
+	Rect image.Rectangle
+	// Area is the area of the region's contour.
+	Area float64
+
+	contour int
+}
+
+// Detect returns the regions in which motion has been detected in the given
+// image, compared to the images given in previous calls. The image is not
+// modified; see Annotate.
+func (m *MotionDetector) Detect(img gocv.Mat) []Detection {
 	// first phase of cleaning up image, obtain foreground only
-	m.bgSubtractor.Apply(*img, &m.deltaMat)
+	m.bgSubtractor.Apply(img, &m.deltaMat)
 
 	// remaining cleanup of the image to use for finding contours.
 	// first use threshold
@@ -66,34 +79,65 @@ func (m *MotionDetector) Detected(img *gocv.Mat) bool {
 	defer kernel.Close()
 	gocv.Dilate(m.threshMat, &m.threshMat, kernel)
 
-	// now find contours
-	contours := gocv.FindContours(m.threshMat, gocv.RetrievalExternal, gocv.ChainApproxSimple)
-
-	hasMarkup := m.DrawContours || m.DrawRects
+	// now find contours, keeping them around for Annotate
+	if m.hasContours {
+		m.contours.Close()
+	}
+	m.contours = gocv.FindContours(m.threshMat, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	m.hasContours = true
 
-	motionDetected := false
-	for i := 0; i < contours.Size(); i++ {
+	var detections []Detection
+	m.lastArea = 0
+	for i := 0; i < m.contours.Size(); i++ {
 		var (
-			contour = contours.At(i)
+			contour = m.contours.At(i)
 			area    = gocv.ContourArea(contour)
 		)
 		if area < m.MinimumContourArea {
 			continue
 		}
-		motionDetected = true
-		if !hasMarkup {
-			break
+		if area > m.lastArea {
+			m.lastArea = area
 		}
+		detections = append(detections, Detection{
+			Rect:    gocv.BoundingRect(contour),
+			Area:    area,
+			contour: i,
+		})
+	}
+	return detections
+}
 
-		if m.DrawContours {
-			gocv.DrawContours(img, contours, i, ContourColor, ContourThickness)
+// Annotate marks up the given image with rectangles and contours for the given
+// detections, based on the values of DrawRects and DrawContours, respectively.
+// The detections must be from the most recent call to Detect, except for
+// those without a contour (see PictureInPicture.DetectInset), which only get
+// rectangles.
+func (m *MotionDetector) Annotate(img *gocv.Mat, detections []Detection) {
+	for _, d := range detections {
+		if m.DrawContours && d.contour >= 0 {
+			gocv.DrawContours(img, m.contours, d.contour, ContourColor, ContourThickness)
 		}
 		if m.DrawRects {
-			rect := gocv.BoundingRect(contour)
-			gocv.Rectangle(img, rect, RectColor, RectThickness)
+			gocv.Rectangle(img, d.Rect, RectColor, RectThickness)
 		}
 	}
-	return motionDetected
+}
+
+// Detected returns true if motion has been detected in the given image,
+// compared to the image given the last time it was called. The image will also
+// be marked up with rectangles and contours where the motion was detected,
+// based on the values of DrawRects and DrawContours, respectively.
+func (m *MotionDetector) Detected(img *gocv.Mat) bool {
+	detections := m.Detect(*img)
+	m.Annotate(img, detections)
+	return len(detections) > 0
+}
+
+// LastArea returns the area of the largest contour found by the last call to
+// Detect, or 0 if no motion was detected.
+func (m *MotionDetector) LastArea() float64 {
+	return m.lastArea
 }
 
 // Close closes the detector & cleans up all resources.
@@ -101,4 +145,7 @@ func (m *MotionDetector) Close() {
 	m.deltaMat.Close()
 	m.threshMat.Close()
 	m.bgSubtractor.Close()
+	if m.hasContours {
+		m.contours.Close()
+	}
 }
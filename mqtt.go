@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// mqttQueue is the number of messages that may be waiting to be
+	// published, e.g. during a broker outage, before the oldest are dropped.
+	mqttQueue = 100
+	// mqttKeepAlive is the keep alive interval sent to the broker, which
+	// disconnects clients it hasn't heard from for 1.5 times as long.
+	mqttKeepAlive = 60 * time.Second
+	// mqttTimeout is how long connecting, and each publish, may take.
+	mqttTimeout = 10 * time.Second
+	// mqttMaxBackoff is the longest wait before reconnecting to the broker.
+	mqttMaxBackoff = 30 * time.Second
+)
+
+// MQTT control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttDisconnect = 14
+)
+
+// mqttMessage is a message waiting to be published.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// MQTTPublisher publishes events to an MQTT (3.1.1) broker. It publishes
+// "online" to <prefix>/availability on connecting, and "offline" on closing,
+// or via its last will if it disconnects unexpectedly. As each event starts
+// and ends, it publishes "ON" or "OFF" to <prefix>/<camera>/motion, and the
+// event's EventPayload as JSON to <prefix>/<camera>/event. Availability and
+// motion are retained.
+//
+// If Arm is set, it's armed or disarmed by "ON" or "OFF" published to
+// <prefix>/armed/set, which is best retained, so that it's applied on
+// reconnecting, and its state is published, retained, to <prefix>/armed.
+// Changes made otherwise, e.g. through /api/arm, are published to the
+// command topic too, so that its retained command doesn't undo them. With
+// Discovery, the Home Assistant discovery config of a switch controlling it
+// is published too.
+//
+// Messages are published in the background, reconnecting after outages, and
+// are queued meanwhile, dropping the oldest once the queue is full.
+type MQTTPublisher struct {
+	// Broker is the broker's address, as host:port, optionally prefixed with
+	// tcp:// or mqtt://.
+	Broker string
+	// Prefix is the prefix of all topics.
+	Prefix string
+	// Username and Password, if set, authenticate with the broker.
+	Username string
+	Password string
+	// QoS is the quality of service of published messages, which is 0 (at
+	// most once) or 1 (at least once).
+	QoS byte
+	// Arm, if set, is controlled and reported through <prefix>/armed.
+	Arm *ArmState
+	// Discovery publishes Home Assistant MQTT discovery config for Arm.
+	Discovery bool
+
+	clientID string
+	queue    chan mqttMessage
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMQTTPublisher creates an MQTTPublisher, controlling and reporting arm if
+// it's set, and starts connecting to the broker in the background.
+func NewMQTTPublisher(broker, prefix, username, password string, qos byte, arm *ArmState, discovery bool) *MQTTPublisher {
+	host, _ := os.Hostname()
+	m := &MQTTPublisher{
+		Broker:    broker,
+		Prefix:    strings.TrimSuffix(prefix, "/"),
+		Username:  username,
+		Password:  password,
+		QoS:       qos,
+		Arm:       arm,
+		Discovery: discovery,
+		clientID:  fmt.Sprintf("motiondetect-%s-%d", host, os.Getpid()),
+		queue:     make(chan mqttMessage, mqttQueue),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if arm != nil {
+		arm.Watch(func(status ArmStatus) {
+			m.enqueue(m.armMessage(m.Prefix+"/armed", status.Armed))
+			if status.Source != ArmSourceMQTT {
+				m.enqueue(m.armMessage(m.Prefix+"/armed/set", status.Armed))
+			}
+		})
+	}
+	go m.run()
+	return m
+}
+
+// armMessage returns a retained message with the ON or OFF payload of an
+// armed state, for the given topic.
+func (m *MQTTPublisher) armMessage(topic string, armed bool) mqttMessage {
+	state := "ON"
+	if !armed {
+		state = "OFF"
+	}
+	return mqttMessage{topic, []byte(state), true}
+}
+
+// discoveryMessage returns the Home Assistant discovery config of a switch
+// controlling Arm.
+func (m *MQTTPublisher) discoveryMessage() (mqttMessage, error) {
+	id := strings.Replace(m.Prefix, "/", "_", -1) + "_armed"
+	b, err := json.Marshal(map[string]interface{}{
+		"name":               "Motion detection armed",
+		"unique_id":          id,
+		"icon":               "mdi:shield-home",
+		"command_topic":      m.Prefix + "/armed/set",
+		"state_topic":        m.Prefix + "/armed",
+		"availability_topic": m.Prefix + "/availability",
+		"payload_on":         "ON",
+		"payload_off":        "OFF",
+		"retain":             true,
+	})
+	return mqttMessage{"homeassistant/switch/" + id + "/config", b, true}, err
+}
+
+// command handles a message published to a topic subscribed to.
+func (m *MQTTPublisher) command(topic string, payload []byte) {
+	if topic != m.Prefix+"/armed/set" {
+		return
+	}
+	var armed bool
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case "ON":
+		armed = true
+	case "OFF":
+		armed = false
+	default:
+		logWarn("Ignoring MQTT message: expected ON or OFF", "topic", topic, "payload", string(payload))
+		return
+	}
+	if _, err := m.Arm.Set(armed, ArmSourceMQTT); err != nil {
+		logError("Saving arm state failed", "path", m.Arm.Path, "error", err)
+	}
+}
+
+// Notify queues the motion state and payload of the event, in the given
+// phase, to be published. It never blocks.
+func (m *MQTTPublisher) Notify(ev *MotionEvent, phase string) {
+	state := "ON"
+	if phase == PhaseEnd {
+		state = "OFF"
+	}
+	topic := m.Prefix + "/" + ev.Camera
+	m.enqueue(mqttMessage{topic + "/motion", []byte(state), true})
+	b, err := json.Marshal(ev.Payload(phase))
+	if err != nil {
+		logError("Encoding event for MQTT failed", "camera", ev.Camera, "event_id", ev.ID, "error", err)
+		return
+	}
+	m.enqueue(mqttMessage{topic + "/event", b, false})
+}
+
+// enqueue queues msg, dropping the oldest queued message if the queue is full.
+func (m *MQTTPublisher) enqueue(msg mqttMessage) {
+	for {
+		select {
+		case m.queue <- msg:
+			return
+		default:
+		}
+		select {
+		case <-m.queue:
+			notifyFailures.Drop("mqtt")
+		default:
+		}
+	}
+}
+
+// Close publishes what's queued, if connected, and then that the program is
+// offline, and disconnects.
+func (m *MQTTPublisher) Close() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *MQTTPublisher) run() {
+	defer close(m.done)
+	backoff := time.Second
+	var pending *mqttMessage
+	for {
+		err := m.session(&pending)
+		if err == nil {
+			return
+		}
+		logError("MQTT broker failed, reconnecting", "broker", m.Broker, "retry_in", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-m.stop:
+			return
+		}
+		if backoff *= 2; backoff > mqttMaxBackoff {
+			backoff = mqttMaxBackoff
+		}
+	}
+}
+
+// session connects to the broker and publishes queued messages until Close is
+// called, when it returns nil, or the connection fails. A message that failed
+// to be published is left in pending, to be published first next time.
+func (m *MQTTPublisher) session(pending **mqttMessage) error {
+	addr := m.Broker
+	for _, scheme := range []string{"tcp://", "mqtt://"} {
+		addr = strings.TrimPrefix(addr, scheme)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+	conn, err := net.DialTimeout("tcp", addr, mqttTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if err := m.connect(conn, r); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	logInfo("Connected to MQTT broker", "broker", m.Broker)
+
+	// the broker only sends acknowledgements, ping responses, and messages
+	// to the command topic, which are read in the background so that a dead
+	// connection is noticed
+	acks := make(chan uint16, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			typ, flags, body, err := readMQTTPacket(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			switch {
+			case typ == mqttPuback && len(body) >= 2:
+				select {
+				case acks <- binary.BigEndian.Uint16(body):
+				default:
+				}
+			case typ == mqttPublish:
+				if topic, payload, ok := parseMQTTPublish(flags, body); ok {
+					m.command(topic, payload)
+				}
+			}
+		}
+	}()
+
+	var id uint16
+	publish := func(msg mqttMessage) error {
+		id++
+		if id == 0 {
+			id = 1
+		}
+		conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+		if err := writeMQTTPublish(conn, msg, m.QoS, id); err != nil {
+			return err
+		}
+		if m.QoS == 0 {
+			return nil
+		}
+		timeout := time.NewTimer(mqttTimeout)
+		defer timeout.Stop()
+		for {
+			select {
+			case ack := <-acks:
+				if ack == id {
+					return nil
+				}
+			case err := <-readErr:
+				return err
+			case <-timeout.C:
+				return errors.New("timed out waiting for acknowledgement")
+			}
+		}
+	}
+
+	if err := publish(mqttMessage{m.Prefix + "/availability", []byte("online"), true}); err != nil {
+		return err
+	}
+	if m.Arm != nil {
+		if m.Discovery {
+			msg, err := m.discoveryMessage()
+			if err != nil {
+				return err
+			}
+			if err := publish(msg); err != nil {
+				return err
+			}
+		}
+		if err := publish(m.armMessage(m.Prefix+"/armed", m.Arm.Armed())); err != nil {
+			return err
+		}
+		// at QoS 0, so that the broker never needs acknowledgements, which
+		// would have to be written from the reading goroutine
+		id++
+		body := append([]byte{byte(id >> 8), byte(id)}, appendMQTTString(nil, m.Prefix+"/armed/set")...)
+		conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+		if err := writeMQTTPacket(conn, mqttSubscribe<<4|0x02, append(body, 0)); err != nil {
+			return err
+		}
+	}
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		if *pending != nil {
+			if err := publish(**pending); err != nil {
+				return err
+			}
+			*pending = nil
+		}
+		select {
+		case msg := <-m.queue:
+			*pending = &msg
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+			if _, err := conn.Write([]byte{mqttPingreq << 4, 0}); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-m.stop:
+			for len(m.queue) > 0 {
+				if err := publish(<-m.queue); err != nil {
+					return nil
+				}
+			}
+			publish(mqttMessage{m.Prefix + "/availability", []byte("offline"), true})
+			conn.Write([]byte{mqttDisconnect << 4, 0})
+			return nil
+		}
+	}
+}
+
+// connect sends a CONNECT packet, with a last will marking the program
+// offline, and waits for the broker to accept it.
+func (m *MQTTPublisher) connect(w io.Writer, r *bufio.Reader) error {
+	var (
+		vh      []byte
+		payload []byte
+		flags   byte = 0x02 | 0x04 | 0x20 // clean session, retained will
+	)
+	flags |= m.QoS << 3
+	if m.Username != "" {
+		flags |= 0x80
+	}
+	if m.Password != "" {
+		flags |= 0x40
+	}
+	vh = appendMQTTString(vh, "MQTT")
+	vh = append(vh, 4, flags)
+	vh = append(vh, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+	payload = appendMQTTString(payload, m.clientID)
+	payload = appendMQTTString(payload, m.Prefix+"/availability")
+	payload = appendMQTTString(payload, "offline")
+	if m.Username != "" {
+		payload = appendMQTTString(payload, m.Username)
+	}
+	if m.Password != "" {
+		payload = appendMQTTString(payload, m.Password)
+	}
+	if err := writeMQTTPacket(w, mqttConnect<<4, append(vh, payload...)); err != nil {
+		return err
+	}
+
+	typ, _, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnack || len(body) < 2 {
+		return fmt.Errorf("unexpected packet of type %d instead of CONNACK", typ)
+	}
+	if code := body[1]; code != 0 {
+		reasons := map[byte]string{
+			1: "unacceptable protocol version",
+			2: "client identifier rejected",
+			3: "server unavailable",
+			4: "bad username or password",
+			5: "not authorized",
+		}
+		return fmt.Errorf("connection refused: %s", reasons[code])
+	}
+	return nil
+}
+
+// writeMQTTPublish writes a PUBLISH packet for msg. The packet identifier is
+// only used if qos is above 0.
+func writeMQTTPublish(w io.Writer, msg mqttMessage, qos byte, id uint16) error {
+	header := byte(mqttPublish<<4) | qos<<1
+	if msg.retain {
+		header |= 1
+	}
+	body := appendMQTTString(nil, msg.topic)
+	if qos > 0 {
+		body = append(body, byte(id>>8), byte(id))
+	}
+	return writeMQTTPacket(w, header, append(body, msg.payload...))
+}
+
+// writeMQTTPacket writes a packet with the given first byte (its type and
+// flags) and body.
+func writeMQTTPacket(w io.Writer, header byte, body []byte) error {
+	b := []byte{header}
+	// the remaining length is encoded 7 bits at a time, least significant
+	// first, with the top bit set on all but the last byte
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		if n /= 128; n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// readMQTTPacket reads a packet, returning its type, flags, and body.
+func readMQTTPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, shift := 0, uint(0)
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+// parseMQTTPublish returns the topic and payload of a PUBLISH packet with the
+// given flags and body.
+func parseMQTTPublish(flags byte, body []byte) (string, []byte, bool) {
+	if len(body) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return "", nil, false
+	}
+	topic, rest := string(body[2:2+n]), body[2+n:]
+	if flags>>1&0x03 > 0 {
+		// skip the packet identifier
+		if len(rest) < 2 {
+			return "", nil, false
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, true
+}
+
+// appendMQTTString appends s to b, prefixed with its length.
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
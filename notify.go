@@ -0,0 +1,233 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Notifier is told about events as they start and end, to pass them on to
+// other systems. Notify must never block.
+type Notifier interface {
+	Notify(ev *MotionEvent, phase string)
+	// Close waits for everything queued to be sent, or given up on.
+	Close()
+}
+
+// Notifiers fans events out to several Notifiers.
+type Notifiers []Notifier
+
+// Notify passes the event to each Notifier in turn.
+func (ns Notifiers) Notify(ev *MotionEvent, phase string) {
+	for _, n := range ns {
+		n.Notify(ev, phase)
+	}
+}
+
+// Close closes each Notifier in turn.
+func (ns Notifiers) Close() {
+	for _, n := range ns {
+		n.Close()
+	}
+}
+
+// switchedEvent is an event held by a NotifierSwitch while it replaces its
+// Notifiers. ev is a copy, as the event goes on changing meanwhile.
+type switchedEvent struct {
+	ev    *MotionEvent
+	phase string
+}
+
+// NotifierSwitch is a Notifier passing events to Notifiers that can be
+// replaced while it's in use, as the configuration is reloaded.
+type NotifierSwitch struct {
+	mu        sync.Mutex
+	ns        Notifiers
+	replacing bool
+	held      []switchedEvent
+}
+
+// NewNotifierSwitch returns a NotifierSwitch passing events to ns.
+func NewNotifierSwitch(ns Notifiers) *NotifierSwitch {
+	return &NotifierSwitch{ns: ns}
+}
+
+// Notify passes the event to the current Notifiers, or, while they're being
+// replaced, holds a copy of it as it is now for the new ones.
+func (s *NotifierSwitch) Notify(ev *MotionEvent, phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replacing {
+		cp := *ev
+		s.held = append(s.held, switchedEvent{&cp, phase})
+		return
+	}
+	s.ns.Notify(ev, phase)
+}
+
+// Replace switches to ns, built while the current Notifiers are still in use,
+// and closes the current ones. persist, if set, is called once they're
+// closed, before ns are notified of anything, to open what ns can't share
+// with them, such as journals. Events notified in the meantime are held, and
+// passed to ns. If persist fails, ns are still switched to, without what it
+// would have opened, and its error is returned.
+func (s *NotifierSwitch) Replace(ns Notifiers, persist func() error) error {
+	s.mu.Lock()
+	old := s.ns
+	s.ns, s.replacing = nil, true
+	s.mu.Unlock()
+
+	old.Close()
+	var err error
+	if persist != nil {
+		err = persist()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ns, s.replacing = ns, false
+	for _, h := range s.held {
+		ns.Notify(h.ev, h.phase)
+	}
+	s.held = nil
+	return err
+}
+
+// Close closes the current Notifiers.
+func (s *NotifierSwitch) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ns.Close()
+}
+
+const (
+	// notifyQueue is the number of notifications that may be waiting to be
+	// sent by a NotifyQueue before they start being dropped.
+	notifyQueue = 32
+	// notifyBackoff is the wait before a NotifyQueue first retries a
+	// notification, which doubles with each retry after.
+	notifyBackoff = time.Second
+)
+
+// notifyJob is a notification waiting to be sent. send makes a single
+// attempt, returning whether it's worth retrying if it fails.
+type notifyJob struct {
+	desc string
+	send func() (bool, error)
+}
+
+// NotifyQueue sends notifications one at a time in the background, retrying
+// failures with backoff. If the notifications can't be sent as fast as they
+// come, they're dropped rather than queued without limit, so that a dead
+// endpoint can neither use up memory nor block the caller.
+type NotifyQueue struct {
+	// Name identifies the notifier in logs and notifyFailures.
+	Name string
+	// Attempts is how many times sending each notification is attempted.
+	Attempts int
+
+	jobs chan notifyJob
+	done chan struct{}
+}
+
+// NewNotifyQueue creates a NotifyQueue making up to attempts attempts at
+// each notification.
+func NewNotifyQueue(name string, attempts int) *NotifyQueue {
+	q := &NotifyQueue{
+		Name:     name,
+		Attempts: attempts,
+		jobs:     make(chan notifyJob, notifyQueue),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Send queues a notification, described by desc in logs, to be sent by
+// calling send until it succeeds, it returns false, or Attempts is reached.
+// It never blocks.
+func (q *NotifyQueue) Send(desc string, send func() (bool, error)) {
+	select {
+	case q.jobs <- notifyJob{desc, send}:
+	default:
+		logError("Too many notifications queued, dropping", "notifier", q.Name, "item", desc)
+		notifyFailures.Drop(q.Name)
+	}
+}
+
+// Close waits for queued notifications to be sent, or given up on.
+func (q *NotifyQueue) Close() {
+	close(q.jobs)
+	<-q.done
+}
+
+func (q *NotifyQueue) run() {
+	defer close(q.done)
+	for job := range q.jobs {
+		backoff := notifyBackoff
+		for attempt := 1; ; attempt++ {
+			retry, err := job.send()
+			if err == nil {
+				break
+			}
+			if !retry || attempt >= q.Attempts {
+				logError("Sending notification failed, giving up", "notifier", q.Name, "item", job.desc, "error", err)
+				notifyFailures.Drop(q.Name)
+				break
+			}
+			logWarn("Sending notification failed, retrying", "notifier", q.Name, "item", job.desc, "retry_in", backoff, "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// eventCooldown limits a notifier to one event per camera per Cooldown, and
+// remembers which events it notified of, so that it can notify of their ends
+// too. It is safe for concurrent use.
+type eventCooldown struct {
+	Cooldown time.Duration
+
+	mu         sync.Mutex
+	last       map[string]time.Time
+	sent       map[int]bool
+	suppressed map[string]int
+}
+
+// Start returns whether to notify of the start of ev.
+func (c *eventCooldown) Start(ev *MotionEvent) bool {
+	ok, _ := c.StartSuppressed(ev)
+	return ok
+}
+
+// StartSuppressed is like Start, but also returns how many starts on ev's
+// camera were suppressed since the last one notified of.
+func (c *eventCooldown) StartSuppressed(ev *MotionEvent) (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		c.last = make(map[string]time.Time)
+		c.sent = make(map[int]bool)
+		c.suppressed = make(map[string]int)
+	}
+	if last, ok := c.last[ev.Camera]; ok && ev.Start.Sub(last) < c.Cooldown {
+		c.suppressed[ev.Camera]++
+		return false, 0
+	}
+	c.last[ev.Camera] = ev.Start
+	c.sent[ev.ID] = true
+	suppressed := c.suppressed[ev.Camera]
+	delete(c.suppressed, ev.Camera)
+	return true, suppressed
+}
+
+// End returns whether to notify of the end of ev, which is only if its start
+// was.
+func (c *eventCooldown) End(ev *MotionEvent) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.sent[ev.ID] {
+		return false
+	}
+	delete(c.sent, ev.ID)
+	return true
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// onvifPrefix is where the ONVIF services are served.
+	onvifPrefix = "/onvif/"
+	// onvifMaxSubscriptions is the number of pull point subscriptions that
+	// may exist at once.
+	onvifMaxSubscriptions = 16
+	// onvifQueue is the number of messages kept for a subscription between
+	// pulls before the oldest are dropped.
+	onvifQueue = 100
+	// onvifDefaultTermination is how long a subscription lasts if the client
+	// doesn't say, and onvifMaxTermination the longest it may last without
+	// being renewed.
+	onvifDefaultTermination = time.Minute
+	onvifMaxTermination     = 24 * time.Hour
+	// onvifMaxPullTimeout is the longest a PullMessages request may wait for
+	// messages.
+	onvifMaxPullTimeout = time.Minute
+	// onvifMotionTopic is the topic of motion events.
+	onvifMotionTopic = "tns1:VideoSource/MotionAlarm"
+)
+
+// ONVIFEvents implements the minimal ONVIF device and event services needed
+// for an NVR to subscribe to motion: a client creates a pull point
+// subscription, then pulls tns1:VideoSource/MotionAlarm messages from it,
+// whose Source is the camera and whose State is true while an event is in
+// progress, renewing it before it expires. Media profiles and WS-Discovery
+// aren't supported, so the NVR must be given the address of the device
+// service, /onvif/device_service. A nil *ONVIFEvents does nothing.
+type ONVIFEvents struct {
+	mu sync.Mutex
+	// motion is the state of each camera seen so far
+	motion        map[string]bool
+	cameras       []string
+	subscriptions map[int]*onvifSubscription
+	nextID        int
+}
+
+// onvifSubscription is a pull point subscription.
+type onvifSubscription struct {
+	expires  time.Time
+	messages []onvifMessage
+	// wake is signalled when messages are added
+	wake chan struct{}
+}
+
+// onvifMessage is a change in a camera's motion state.
+type onvifMessage struct {
+	time      time.Time
+	camera    string
+	motion    bool
+	operation string
+}
+
+// NewONVIFEvents creates an ONVIFEvents reporting the motion state of the
+// named cameras, initially with no motion.
+func NewONVIFEvents(cameras []string) *ONVIFEvents {
+	o := &ONVIFEvents{
+		motion:        make(map[string]bool),
+		cameras:       cameras,
+		subscriptions: make(map[int]*onvifSubscription),
+	}
+	for _, c := range cameras {
+		o.motion[c] = false
+	}
+	return o
+}
+
+// Notify queues a change of state of the event's camera for every
+// subscription.
+func (o *ONVIFEvents) Notify(ev *MotionEvent, phase string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.motion[ev.Camera]; !ok {
+		o.cameras = append(o.cameras, ev.Camera)
+	}
+	motion := phase == PhaseStart
+	o.motion[ev.Camera] = motion
+	msg := onvifMessage{time.Now(), ev.Camera, motion, "Changed"}
+	for _, sub := range o.subscriptions {
+		sub.add(msg)
+	}
+}
+
+// Close does nothing: subscriptions end with the HTTP server.
+func (o *ONVIFEvents) Close() {}
+
+// add queues a message, dropping the oldest if the queue is full.
+func (sub *onvifSubscription) add(msg onvifMessage) {
+	if len(sub.messages) >= onvifQueue {
+		sub.messages = sub.messages[1:]
+	}
+	sub.messages = append(sub.messages, msg)
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// synchronize queues the current state of every camera, as the initial
+// messages of a subscription, or after a SetSynchronizationPoint. o.mu must
+// be held.
+func (o *ONVIFEvents) synchronize(sub *onvifSubscription) {
+	now := time.Now()
+	for _, c := range o.cameras {
+		sub.add(onvifMessage{now, c, o.motion[c], "Initialized"})
+	}
+}
+
+// expire removes expired subscriptions. o.mu must be held.
+func (o *ONVIFEvents) expire(now time.Time) {
+	for id, sub := range o.subscriptions {
+		if now.After(sub.expires) {
+			delete(o.subscriptions, id)
+		}
+	}
+}
+
+// HandleONVIF registers the ONVIF services with http.DefaultServeMux.
+func HandleONVIF(o *ONVIFEvents) {
+	http.HandleFunc(onvifPrefix, o.ServeHTTP)
+}
+
+// ServeHTTP handles SOAP requests to the device service, the event service,
+// and subscriptions, each at its own path under /onvif/.
+func (o *ONVIFEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := parseSOAPRequest(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", err.Error())
+		return
+	}
+	base := "http://" + r.Host + onvifPrefix
+	service := strings.TrimPrefix(r.URL.Path, onvifPrefix)
+	switch {
+	case service == "device_service":
+		o.device(w, req, base)
+	case service == "events_service":
+		o.events(w, req, base)
+	case strings.HasPrefix(service, "subscription/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(service, "subscription/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		o.subscription(w, r, req, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// device handles requests to the device service, enough for clients to find
+// the event service.
+func (o *ONVIFEvents) device(w http.ResponseWriter, req *soapRequest, base string) {
+	switch req.action {
+	case "GetSystemDateAndTime":
+		now := time.Now().UTC()
+		writeSOAP(w, "http://www.onvif.org/ver10/device/wsdl/GetSystemDateAndTimeResponse", fmt.Sprintf(
+			"<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>"+
+				"<tt:DateTimeType>Manual</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings>"+
+				"<tt:TimeZone><tt:TZ>UTC0</tt:TZ></tt:TimeZone>"+
+				"<tt:UTCDateTime><tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>"+
+				"<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date></tt:UTCDateTime>"+
+				"</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>",
+			now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day()))
+	case "GetDeviceInformation":
+		writeSOAP(w, "http://www.onvif.org/ver10/device/wsdl/GetDeviceInformationResponse",
+			"<tds:GetDeviceInformationResponse><tds:Manufacturer>motiondetect</tds:Manufacturer>"+
+				"<tds:Model>motiondetect</tds:Model><tds:FirmwareVersion>"+version+"</tds:FirmwareVersion>"+
+				"<tds:SerialNumber>0</tds:SerialNumber><tds:HardwareId>motiondetect</tds:HardwareId>"+
+				"</tds:GetDeviceInformationResponse>")
+	case "GetCapabilities":
+		writeSOAP(w, "http://www.onvif.org/ver10/device/wsdl/GetCapabilitiesResponse",
+			"<tds:GetCapabilitiesResponse><tds:Capabilities><tt:Events>"+
+				"<tt:XAddr>"+xmlEscape(base+"events_service")+"</tt:XAddr>"+
+				"<tt:WSSubscriptionPolicySupport>false</tt:WSSubscriptionPolicySupport>"+
+				"<tt:WSPullPointSupport>true</tt:WSPullPointSupport>"+
+				"<tt:WSPausableSubscriptionManagerInterfaceSupport>false</tt:WSPausableSubscriptionManagerInterfaceSupport>"+
+				"</tt:Events></tds:Capabilities></tds:GetCapabilitiesResponse>")
+	case "GetServices":
+		service := func(namespace, addr string) string {
+			return "<tds:Service><tds:Namespace>" + namespace + "</tds:Namespace>" +
+				"<tds:XAddr>" + xmlEscape(addr) + "</tds:XAddr>" +
+				"<tds:Version><tt:Major>2</tt:Major><tt:Minor>60</tt:Minor></tds:Version></tds:Service>"
+		}
+		writeSOAP(w, "http://www.onvif.org/ver10/device/wsdl/GetServicesResponse",
+			"<tds:GetServicesResponse>"+
+				service("http://www.onvif.org/ver10/device/wsdl", base+"device_service")+
+				service("http://www.onvif.org/ver10/events/wsdl", base+"events_service")+
+				"</tds:GetServicesResponse>")
+	default:
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", "action not supported: "+req.action)
+	}
+}
+
+// events handles requests to the event service, which creates pull point
+// subscriptions.
+func (o *ONVIFEvents) events(w http.ResponseWriter, req *soapRequest, base string) {
+	switch req.action {
+	case "GetServiceCapabilities":
+		writeSOAP(w, "http://www.onvif.org/ver10/events/wsdl/EventPortType/GetServiceCapabilitiesResponse", fmt.Sprintf(
+			`<tev:GetServiceCapabilitiesResponse><tev:Capabilities WSSubscriptionPolicySupport="false" `+
+				`WSPullPointSupport="true" WSPausableSubscriptionManagerInterfaceSupport="false" `+
+				`MaxNotificationProducers="0" MaxPullPoints="%d" PersistentNotificationStorage="false"/>`+
+				`</tev:GetServiceCapabilitiesResponse>`, onvifMaxSubscriptions))
+	case "GetEventProperties":
+		writeSOAP(w, "http://www.onvif.org/ver10/events/wsdl/EventPortType/GetEventPropertiesResponse",
+			"<tev:GetEventPropertiesResponse>"+
+				"<tev:TopicNamespaceLocation>http://www.onvif.org/onvif/ver10/topics/topicns.xml</tev:TopicNamespaceLocation>"+
+				"<wsnt:FixedTopicSet>true</wsnt:FixedTopicSet>"+
+				`<wstop:TopicSet><tns1:VideoSource><MotionAlarm wstop:topic="true">`+
+				`<tt:MessageDescription IsProperty="true">`+
+				`<tt:Source><tt:SimpleItemDescription Name="Source" Type="tt:ReferenceToken"/></tt:Source>`+
+				`<tt:Data><tt:SimpleItemDescription Name="State" Type="xs:boolean"/></tt:Data>`+
+				`</tt:MessageDescription></MotionAlarm></tns1:VideoSource></wstop:TopicSet>`+
+				"<wsnt:TopicExpressionDialect>http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet</wsnt:TopicExpressionDialect>"+
+				"<wsnt:TopicExpressionDialect>http://docs.oasis-open.org/wsn/t-1/TopicExpression/Concrete</wsnt:TopicExpressionDialect>"+
+				"<tev:MessageContentFilterDialect>http://www.onvif.org/ver10/tev/messageContentFilter/ItemFilter</tev:MessageContentFilterDialect>"+
+				"<tev:MessageContentSchemaLocation>http://www.onvif.org/onvif/ver10/schema/onvif.xsd</tev:MessageContentSchemaLocation>"+
+				"</tev:GetEventPropertiesResponse>")
+	case "CreatePullPointSubscription":
+		now := time.Now()
+		expires, err := onvifTermination(req.values["InitialTerminationTime"], now)
+		if err != nil {
+			writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", err.Error())
+			return
+		}
+		o.mu.Lock()
+		o.expire(now)
+		if len(o.subscriptions) >= onvifMaxSubscriptions {
+			o.mu.Unlock()
+			writeSOAPFault(w, http.StatusInternalServerError, "SOAP-ENV:Receiver", "too many subscriptions")
+			return
+		}
+		o.nextID++
+		id := o.nextID
+		sub := &onvifSubscription{expires: expires, wake: make(chan struct{}, 1)}
+		o.synchronize(sub)
+		o.subscriptions[id] = sub
+		o.mu.Unlock()
+
+		writeSOAP(w, "http://www.onvif.org/ver10/events/wsdl/EventPortType/CreatePullPointSubscriptionResponse",
+			"<tev:CreatePullPointSubscriptionResponse><tev:SubscriptionReference>"+
+				"<wsa:Address>"+xmlEscape(fmt.Sprintf("%ssubscription/%d", base, id))+"</wsa:Address>"+
+				"</tev:SubscriptionReference>"+
+				"<wsnt:CurrentTime>"+onvifTime(now)+"</wsnt:CurrentTime>"+
+				"<wsnt:TerminationTime>"+onvifTime(expires)+"</wsnt:TerminationTime>"+
+				"</tev:CreatePullPointSubscriptionResponse>")
+	default:
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", "action not supported: "+req.action)
+	}
+}
+
+// subscription handles requests to the subscription with the given ID.
+func (o *ONVIFEvents) subscription(w http.ResponseWriter, r *http.Request, req *soapRequest, id int) {
+	now := time.Now()
+	o.mu.Lock()
+	o.expire(now)
+	sub, ok := o.subscriptions[id]
+	o.mu.Unlock()
+	if !ok {
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", "unknown subscription")
+		return
+	}
+
+	switch req.action {
+	case "PullMessages":
+		o.pull(w, r, req, sub)
+	case "Renew":
+		expires, err := onvifTermination(req.values["TerminationTime"], now)
+		if err != nil {
+			writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", err.Error())
+			return
+		}
+		o.mu.Lock()
+		sub.expires = expires
+		o.mu.Unlock()
+		writeSOAP(w, "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/RenewResponse",
+			"<wsnt:RenewResponse>"+
+				"<wsnt:TerminationTime>"+onvifTime(expires)+"</wsnt:TerminationTime>"+
+				"<wsnt:CurrentTime>"+onvifTime(now)+"</wsnt:CurrentTime>"+
+				"</wsnt:RenewResponse>")
+	case "Unsubscribe":
+		o.mu.Lock()
+		delete(o.subscriptions, id)
+		o.mu.Unlock()
+		writeSOAP(w, "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/UnsubscribeResponse",
+			"<wsnt:UnsubscribeResponse/>")
+	case "SetSynchronizationPoint":
+		o.mu.Lock()
+		o.synchronize(sub)
+		o.mu.Unlock()
+		writeSOAP(w, "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/SetSynchronizationPointResponse",
+			"<tev:SetSynchronizationPointResponse/>")
+	default:
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", "action not supported: "+req.action)
+	}
+}
+
+// pull handles a PullMessages request, waiting up to its Timeout for
+// messages if there are none yet. Pulling extends the subscription by the
+// default termination time, as long as it's being pulled.
+func (o *ONVIFEvents) pull(w http.ResponseWriter, r *http.Request, req *soapRequest, sub *onvifSubscription) {
+	timeout, err := parseXSDuration(req.values["Timeout"])
+	if err != nil {
+		writeSOAPFault(w, http.StatusBadRequest, "SOAP-ENV:Sender", "invalid Timeout: "+err.Error())
+		return
+	}
+	if timeout > onvifMaxPullTimeout {
+		timeout = onvifMaxPullTimeout
+	}
+	limit, _ := strconv.Atoi(req.values["MessageLimit"])
+	if limit <= 0 {
+		limit = onvifQueue
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var messages []onvifMessage
+	for {
+		o.mu.Lock()
+		if n := len(sub.messages); n > 0 {
+			if n > limit {
+				n = limit
+			}
+			messages = append(messages, sub.messages[:n]...)
+			sub.messages = sub.messages[n:]
+		}
+		o.mu.Unlock()
+		if len(messages) > 0 {
+			break
+		}
+		select {
+		case <-sub.wake:
+			continue
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+		break
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	if expires := now.Add(onvifDefaultTermination); expires.After(sub.expires) {
+		sub.expires = expires
+	}
+	expires := sub.expires
+	o.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("<tev:PullMessagesResponse>")
+	b.WriteString("<tev:CurrentTime>" + onvifTime(now) + "</tev:CurrentTime>")
+	b.WriteString("<tev:TerminationTime>" + onvifTime(expires) + "</tev:TerminationTime>")
+	for _, m := range messages {
+		fmt.Fprintf(&b, "<wsnt:NotificationMessage>"+
+			`<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">%s</wsnt:Topic>`+
+			`<wsnt:Message><tt:Message UtcTime="%s" PropertyOperation="%s">`+
+			`<tt:Source><tt:SimpleItem Name="Source" Value="%s"/></tt:Source>`+
+			`<tt:Data><tt:SimpleItem Name="State" Value="%t"/></tt:Data>`+
+			"</tt:Message></wsnt:Message></wsnt:NotificationMessage>",
+			onvifMotionTopic, onvifTime(m.time), m.operation, xmlEscape(m.camera), m.motion)
+	}
+	b.WriteString("</tev:PullMessagesResponse>")
+	writeSOAP(w, "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesResponse", b.String())
+}
+
+// soapRequest is the action of a SOAP request, the local name of the first
+// element in its body, along with the text of every element within it, by
+// local name.
+type soapRequest struct {
+	action string
+	values map[string]string
+}
+
+// parseSOAPRequest parses the body of a SOAP request.
+func parseSOAPRequest(r io.Reader) (*soapRequest, error) {
+	req := &soapRequest{values: make(map[string]string)}
+	d := xml.NewDecoder(r)
+	var (
+		inBody bool
+		path   []string
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			if !inBody {
+				inBody = t.Name.Local == "Body"
+			} else if req.action == "" {
+				req.action = t.Name.Local
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		case xml.CharData:
+			if req.action != "" && len(path) > 0 {
+				if s := strings.TrimSpace(string(t)); s != "" {
+					req.values[path[len(path)-1]] = s
+				}
+			}
+		}
+	}
+	if req.action == "" {
+		return nil, fmt.Errorf("no action in body")
+	}
+	return req, nil
+}
+
+// writeSOAP writes a SOAP 1.2 envelope with the given action and body.
+func writeSOAP(w http.ResponseWriter, action, body string) {
+	writeSOAPStatus(w, http.StatusOK, action, body)
+}
+
+// writeSOAPFault writes a SOAP 1.2 fault, with the given status, code and
+// reason.
+func writeSOAPFault(w http.ResponseWriter, status int, code, reason string) {
+	writeSOAPStatus(w, status, "http://www.w3.org/2005/08/addressing/soap/fault",
+		"<SOAP-ENV:Fault><SOAP-ENV:Code><SOAP-ENV:Value>"+code+"</SOAP-ENV:Value></SOAP-ENV:Code>"+
+			`<SOAP-ENV:Reason><SOAP-ENV:Text xml:lang="en">`+xmlEscape(reason)+"</SOAP-ENV:Text></SOAP-ENV:Reason>"+
+			"</SOAP-ENV:Fault>")
+}
+
+func writeSOAPStatus(w http.ResponseWriter, status int, action, body string) {
+	w.Header().Set("Content-Type", `application/soap+xml; charset=utf-8; action="`+action+`"`)
+	w.WriteHeader(status)
+	_, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:wsa="http://www.w3.org/2005/08/addressing" `+
+		`xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" `+
+		`xmlns:wstop="http://docs.oasis-open.org/wsn/t-1" `+
+		`xmlns:tds="http://www.onvif.org/ver10/device/wsdl" `+
+		`xmlns:tev="http://www.onvif.org/ver10/events/wsdl" `+
+		`xmlns:tt="http://www.onvif.org/ver10/schema" `+
+		`xmlns:tns1="http://www.onvif.org/ver10/topics" `+
+		`xmlns:xs="http://www.w3.org/2001/XMLSchema">`+
+		`<SOAP-ENV:Header><wsa:Action>`+action+`</wsa:Action></SOAP-ENV:Header>`+
+		`<SOAP-ENV:Body>`+body+`</SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+	if err != nil {
+		logError("Writing ONVIF response failed", "error", err)
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// onvifTime formats t as an xs:dateTime in UTC.
+func onvifTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// onvifTermination parses a termination time, which is either an xs:duration
+// from now or an xs:dateTime, defaulting to onvifDefaultTermination from now
+// and limited to onvifMaxTermination.
+func onvifTermination(s string, now time.Time) (time.Time, error) {
+	t := now.Add(onvifDefaultTermination)
+	if strings.HasPrefix(s, "P") {
+		d, err := parseXSDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid termination time %q: %v", s, err)
+		}
+		t = now.Add(d)
+	} else if s != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return time.Time{}, fmt.Errorf("invalid termination time %q: %v", s, err)
+		}
+	}
+	if max := now.Add(onvifMaxTermination); t.After(max) {
+		t = max
+	}
+	return t, nil
+}
+
+var xsDurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseXSDuration parses an xs:duration of days, hours, minutes and seconds,
+// e.g. PT1M30S. An empty duration is zero.
+func parseXSDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m := xsDurationRegexp.FindStringSubmatch(s)
+	if m == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("unsupported duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute} {
+		if m[i+1] != "" {
+			n, _ := strconv.Atoi(m[i+1])
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[4] != "" {
+		secs, _ := strconv.ParseFloat(m[4], 64)
+		d += time.Duration(secs * float64(time.Second))
+	}
+	return d, nil
+}
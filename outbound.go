@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// outboundMaxBackoff is the longest an OutboundQueue waits between
+	// attempts at an item.
+	outboundMaxBackoff = 10 * time.Minute
+	// outboundCompactAfter is the number of journal records, beyond twice the
+	// number of items queued, after which the journal is rewritten.
+	outboundCompactAfter = 1000
+)
+
+// outboundQueues are all the OutboundQueues opened, for metrics.
+var outboundQueues struct {
+	sync.Mutex
+	queues []*OutboundQueue
+}
+
+// outboundItem is an item in an OutboundQueue.
+type outboundItem struct {
+	ID       uint64          `json:"-"`
+	Desc     string          `json:"desc"`
+	Created  time.Time       `json:"created"`
+	Attempts int             `json:"attempts,omitempty"`
+	Next     time.Time       `json:"next"`
+	Data     json.RawMessage `json:"data"`
+
+	active bool
+}
+
+// outboundRecord is a line of an OutboundQueue's journal, recording that an
+// item was added, retried, or finished with.
+type outboundRecord struct {
+	Op string `json:"op"`
+	ID uint64 `json:"id"`
+	// Item is the item added.
+	Item *outboundItem `json:"item,omitempty"`
+	// Attempts and Next are those of the item retried.
+	Attempts int        `json:"attempts,omitempty"`
+	Next     *time.Time `json:"next,omitempty"`
+}
+
+// OutboundQueue sends items, such as notifications and uploads, in the
+// background, retrying failures with exponential backoff until they succeed
+// or are older than TTL. Items are recorded in an append-only journal as
+// they're added, retried and finished, so that those not yet sent when the
+// program exits, or crashes, are resumed, with their backoff, when it next
+// starts. It is safe for concurrent use.
+type OutboundQueue struct {
+	// Name identifies the queue in logs and metrics.
+	Name string
+	// TTL is how old an item may get before it's dropped rather than
+	// attempted again; 0 is forever.
+	TTL time.Duration
+	// Backoff is the wait before the first retry of an item, which doubles
+	// with each retry after, up to outboundMaxBackoff.
+	Backoff time.Duration
+	// Drain makes Close wait for the items due to be sent, rather than
+	// leaving them for the next start.
+	Drain bool
+	// Send makes a single attempt at sending an item, described by desc in
+	// logs, returning whether it's worth retrying if it fails.
+	Send func(desc string, data json.RawMessage) (bool, error)
+
+	path    string
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []*outboundItem
+	nextID  uint64
+	journal *os.File
+	records int
+	timer   *time.Timer
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// OpenOutboundQueue opens the queue journalled at path, creating it if
+// necessary, and loads the items left in it. Start must be called to start
+// sending them.
+func OpenOutboundQueue(name, path string, ttl, backoff time.Duration, send func(desc string, data json.RawMessage) (bool, error)) (*OutboundQueue, error) {
+	q := &OutboundQueue{Name: name, TTL: ttl, Backoff: backoff, Send: send, path: path}
+	q.cond = sync.NewCond(&q.mu)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := q.load(); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	if len(q.items) > 0 {
+		logInfo("Resuming queued items", "queue", name, "items", len(q.items), "path", path)
+	}
+	outboundQueues.Lock()
+	outboundQueues.queues = append(outboundQueues.queues, q)
+	outboundQueues.Unlock()
+	return q, nil
+}
+
+// load replays the journal.
+func (q *OutboundQueue) load() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	byID := make(map[uint64]*outboundItem)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec outboundRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// most likely the last line, cut short by a crash
+			logWarn("Skipping unreadable journal line", "queue", q.Name, "path", q.path, "line", line, "error", err)
+			continue
+		}
+		if rec.ID > q.nextID {
+			q.nextID = rec.ID
+		}
+		switch rec.Op {
+		case "add":
+			if rec.Item != nil {
+				rec.Item.ID = rec.ID
+				byID[rec.ID] = rec.Item
+				q.items = append(q.items, rec.Item)
+			}
+		case "retry":
+			if item, ok := byID[rec.ID]; ok && rec.Next != nil {
+				item.Attempts, item.Next = rec.Attempts, *rec.Next
+			}
+		case "done":
+			delete(byID, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	items := q.items[:0]
+	for _, item := range q.items {
+		if byID[item.ID] != nil {
+			items = append(items, item)
+		}
+	}
+	q.items = items
+	return nil
+}
+
+// compact rewrites the journal with only the items still queued, and opens it
+// for appending. q.mu must be held, if the queue has been started.
+func (q *OutboundQueue) compact() error {
+	tmp := filepath.Join(filepath.Dir(q.path), "."+filepath.Base(q.path))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, item := range q.items {
+		line, err := json.Marshal(&outboundRecord{Op: "add", ID: item.ID, Item: item})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	if q.journal != nil {
+		q.journal.Close()
+	}
+	if q.journal, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	q.records = len(q.items)
+	return nil
+}
+
+// record appends a record to the journal. q.mu must be held.
+func (q *OutboundQueue) record(rec outboundRecord) {
+	line, err := json.Marshal(&rec)
+	if err == nil {
+		_, err = q.journal.Write(append(line, '\n'))
+	}
+	if err != nil {
+		logError("Writing journal failed", "queue", q.Name, "path", q.path, "error", err)
+	}
+	q.records++
+}
+
+// Start starts sending the items queued, and those added after, with the
+// given number of concurrent workers.
+func (q *OutboundQueue) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.run()
+	}
+}
+
+// Add queues data, encoded as JSON, to be sent. It never blocks for longer
+// than it takes to append to the journal.
+func (q *OutboundQueue) Add(desc string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logError("Encoding item failed", "queue", q.Name, "item", desc, "error", err)
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	item := &outboundItem{ID: q.nextID, Desc: desc, Created: time.Now(), Data: data}
+	q.record(outboundRecord{Op: "add", ID: item.ID, Item: item})
+	q.items = append(q.items, item)
+	q.cond.Broadcast()
+}
+
+// Close stops sending items once the attempts in progress are done, or, with
+// Drain, once there are none due to be sent. Items left are sent after the
+// next start.
+func (q *OutboundQueue) Close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closing = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.journal.Close()
+}
+
+// Stats returns the number of items queued, and the age of the oldest.
+func (q *OutboundQueue) Stats() (depth int, oldest time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, item := range q.items {
+		if age := now.Sub(item.Created); age > oldest {
+			oldest = age
+		}
+	}
+	return len(q.items), oldest
+}
+
+// next returns the first item due to be sent, after dropping expired ones,
+// or, if none are due, how long until one is (0 if there are none). q.mu must
+// be held.
+func (q *OutboundQueue) next(now time.Time) (*outboundItem, time.Duration) {
+	var wait time.Duration
+	for _, item := range q.items {
+		if item.active {
+			continue
+		}
+		if q.TTL > 0 && now.Sub(item.Created) > q.TTL {
+			logError("Dropping expired item", "queue", q.Name, "item", item.Desc, "age", now.Sub(item.Created).Round(time.Second), "attempts", item.Attempts)
+			outboundExpired.Drop(q.Name)
+			q.remove(item)
+			return q.next(now)
+		}
+		if !item.Next.After(now) {
+			return item, 0
+		}
+		if d := item.Next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+// remove removes an item that's finished with. q.mu must be held.
+func (q *OutboundQueue) remove(item *outboundItem) {
+	for i, it := range q.items {
+		if it == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.record(outboundRecord{Op: "done", ID: item.ID})
+	if q.records > 2*len(q.items)+outboundCompactAfter {
+		if err := q.compact(); err != nil {
+			logError("Compacting journal failed", "queue", q.Name, "path", q.path, "error", err)
+		}
+	}
+}
+
+func (q *OutboundQueue) run() {
+	defer q.wg.Done()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		item, wait := q.next(time.Now())
+		if item == nil {
+			if q.closing {
+				return
+			}
+			if wait > 0 {
+				if q.timer != nil {
+					q.timer.Stop()
+				}
+				q.timer = time.AfterFunc(wait, func() {
+					q.mu.Lock()
+					q.cond.Broadcast()
+					q.mu.Unlock()
+				})
+			}
+			q.cond.Wait()
+			continue
+		}
+		if q.closing && !q.Drain {
+			return
+		}
+
+		item.active = true
+		q.mu.Unlock()
+		retry, err := q.Send(item.Desc, item.Data)
+		q.mu.Lock()
+		item.active = false
+
+		switch {
+		case err == nil:
+			q.remove(item)
+		case !retry:
+			logError("Sending failed, giving up", "queue", q.Name, "item", item.Desc, "error", err)
+			notifyFailures.Drop(q.Name)
+			q.remove(item)
+		default:
+			item.Attempts++
+			backoff := q.Backoff << uint(item.Attempts-1)
+			if backoff > outboundMaxBackoff || backoff <= 0 {
+				backoff = outboundMaxBackoff
+			}
+			item.Next = time.Now().Add(backoff)
+			q.record(outboundRecord{Op: "retry", ID: item.ID, Attempts: item.Attempts, Next: &item.Next})
+			logWarn("Sending failed, retrying", "queue", q.Name, "item", item.Desc, "retry_in", backoff, "error", err)
+			if q.closing {
+				// leave it for the next start
+				return
+			}
+		}
+	}
+}
+
+// outboundStats returns the depth and oldest item age of each OutboundQueue,
+// by name, for metrics.
+func outboundStats() (names []string, depths map[string]int, ages map[string]time.Duration) {
+	outboundQueues.Lock()
+	defer outboundQueues.Unlock()
+	depths = make(map[string]int)
+	ages = make(map[string]time.Duration)
+	for _, q := range outboundQueues.queues {
+		depth, age := q.Stats()
+		names = append(names, q.Name)
+		depths[q.Name], ages[q.Name] = depth, age
+	}
+	sort.Strings(names)
+	return names, depths, ages
+}
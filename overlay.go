@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// FrameAnnotator draws on a frame captured at time t before it is written.
+type FrameAnnotator func(img *gocv.Mat, t time.Time)
+
+// TimestampOverlay burns the capture time of each frame into it.
+type TimestampOverlay struct {
+	// Format is expanded for each frame with the frame's capture time.
+	Format *OutputTemplate
+	// Camera is the camera name used for {camera} in Format.
+	Camera string
+	// Corner is one of "tl", "tr", "bl" or "br".
+	Corner string
+	// Scale is the font scale.
+	Scale float64
+	// Color is the color of the text.
+	Color color.RGBA
+	// Background, if set, is drawn as a filled box behind the text so that it
+	// stays readable over bright scenes.
+	Background *color.RGBA
+}
+
+// timestampMargin is the distance in pixels between the overlay and the edges
+// of the frame, and between its text and the edges of its background.
+const timestampMargin = 6
+
+// Draw draws the overlay for time t onto img.
+func (o *TimestampOverlay) Draw(img *gocv.Mat, t time.Time) {
+	text := o.Format.Expand(TemplateFields{Camera: o.Camera, Start: t})
+	thickness := int(o.Scale + 0.5)
+	if thickness < 1 {
+		thickness = 1
+	}
+	size := gocv.GetTextSize(text, gocv.FontHersheySimplex, o.Scale, thickness)
+
+	// origin is the bottom left corner of the text
+	x, y := 2*timestampMargin, 2*timestampMargin+size.Y
+	if strings.HasSuffix(o.Corner, "r") {
+		x = img.Cols() - 2*timestampMargin - size.X
+	}
+	if strings.HasPrefix(o.Corner, "b") {
+		y = img.Rows() - 2*timestampMargin
+	}
+	if o.Background != nil {
+		box := image.Rect(x-timestampMargin, y-size.Y-timestampMargin, x+size.X+timestampMargin, y+timestampMargin)
+		gocv.Rectangle(img, box, *o.Background, -1)
+	}
+	gocv.PutText(img, text, image.Pt(x, y), gocv.FontHersheySimplex, o.Scale, o.Color, thickness)
+}
+
+// ParseColor parses a color given as "#rrggbb" (the "#" is optional).
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// Picture-in-picture detection modes, as accepted by -pip-detect.
+const (
+	// PiPDetectPrimary detects motion in the primary camera's frame only.
+	PiPDetectPrimary = "primary"
+	// PiPDetectComposite detects motion in the composite, in which the inset
+	// covers part of the primary camera's frame.
+	PiPDetectComposite = "composite"
+	// PiPDetectBoth detects motion in the whole primary frame, and separately
+	// in the inset.
+	PiPDetectBoth = "both"
+)
+
+// pipMargin is the gap in pixels between an inset and the edges of the frame.
+const pipMargin = 10
+
+var pipBorder = color.RGBA{255, 255, 255, 0}
+
+// pipCorners maps the corners accepted in a -pip spec to the corners used by
+// TimestampOverlay.
+var pipCorners = map[string]string{
+	"top-left":     "tl",
+	"top-right":    "tr",
+	"bottom-left":  "bl",
+	"bottom-right": "br",
+	"tl":           "tl",
+	"tr":           "tr",
+	"bl":           "bl",
+	"br":           "br",
+}
+
+// PictureInPicture composites a small inset of a secondary camera into a
+// corner of the primary camera's frames.
+type PictureInPicture struct {
+	// Camera is the secondary camera, which must have Feed set.
+	Camera *Camera
+	// Corner is one of "tl", "tr", "bl" or "br".
+	Corner string
+	// Scale is the width of the inset relative to the primary frame.
+	Scale float64
+	// Detect is one of the PiPDetect modes.
+	Detect string
+
+	frame    gocv.Mat
+	frameSeq int
+	inset    gocv.Mat
+	detector *MotionDetector
+	rect     image.Rectangle
+}
+
+// ParsePiP parses a -pip spec of the form "camera:corner:scale", e.g.
+// "door:bottom-right:25%", returning the name of the camera, and a
+// PictureInPicture for it once Camera is set.
+func ParsePiP(spec string) (string, *PictureInPicture, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, fmt.Errorf("expected camera:corner:scale")
+	}
+	corner, ok := pipCorners[parts[1]]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown corner %q", parts[1])
+	}
+	scale, err := strconv.ParseFloat(strings.TrimSuffix(parts[2], "%"), 64)
+	if err != nil || scale <= 0 || scale >= 100 {
+		return "", nil, fmt.Errorf("invalid scale %q, expected a percentage between 0 and 100", parts[2])
+	}
+	return parts[0], &PictureInPicture{
+		Corner:   corner,
+		Scale:    scale / 100,
+		Detect:   PiPDetectPrimary,
+		frame:    gocv.NewMat(),
+		inset:    gocv.NewMat(),
+		detector: NewMotionDetector(),
+	}, nil
+}
+
+// Draw copies the secondary camera's latest frame into the corner of img. If
+// the secondary camera hasn't produced a frame yet, Draw does nothing.
+func (p *PictureInPicture) Draw(img *gocv.Mat) {
+	p.frameSeq, _ = p.Camera.Frame(&p.frame, p.frameSeq)
+	if p.frame.Empty() {
+		return
+	}
+	w := int(float64(img.Cols()) * p.Scale)
+	h := w * p.frame.Rows() / p.frame.Cols()
+	if w <= 0 || h <= 0 || w+2*pipMargin > img.Cols() || h+2*pipMargin > img.Rows() {
+		return
+	}
+	gocv.Resize(p.frame, &p.inset, image.Pt(w, h), 0, 0, gocv.InterpolationArea)
+
+	x, y := pipMargin, pipMargin
+	if strings.HasSuffix(p.Corner, "r") {
+		x = img.Cols() - w - pipMargin
+	}
+	if strings.HasPrefix(p.Corner, "b") {
+		y = img.Rows() - h - pipMargin
+	}
+	p.rect = image.Rect(x, y, x+w, y+h)
+	region := img.Region(p.rect)
+	p.inset.CopyTo(&region)
+	region.Close()
+	gocv.Rectangle(img, p.rect.Inset(-1), pipBorder, 1)
+}
+
+// DetectInset detects motion in the inset last drawn, with the settings of
+// the given detector scaled down to the inset. The detections are in the
+// coordinates of the composite, and can't be annotated with contours.
+func (p *PictureInPicture) DetectInset(primary *MotionDetector) []Detection {
+	if p.inset.Empty() {
+		return nil
+	}
+	p.detector.Threshold = primary.Threshold
+	p.detector.DilateSize = primary.DilateSize
+	p.detector.MinimumContourArea = primary.MinimumContourArea * p.Scale * p.Scale
+	detections := p.detector.Detect(p.inset)
+	for i := range detections {
+		detections[i].Rect = detections[i].Rect.Add(p.rect.Min)
+		detections[i].contour = -1
+	}
+	return detections
+}
+
+// Close releases the inset's resources.
+func (p *PictureInPicture) Close() {
+	p.frame.Close()
+	p.inset.Close()
+	p.detector.Close()
+}
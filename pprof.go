@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"gocv.io/x/gocv"
+)
+
+// ServePprof serves the net/http/pprof handlers at /debug/pprof/, and
+// gocv.MatProfile at /debug/matprofile, on the given address in the
+// background. It uses its own listener, so that profiling can be limited to
+// localhost while the HTTP interface isn't. MatProfile only records matrices
+// when built with -tags matprofile.
+func ServePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/matprofile", handleMatProfile)
+	logInfo("Serving profiles at /debug/pprof/ (matrices at /debug/matprofile)", "addr", addr)
+	go func() {
+		logError("Profiling server failed", "addr", addr, "error", http.ListenAndServe(addr, mux))
+	}()
+}
+
+// handleMatProfile writes the stack traces of the matrices currently
+// allocated, as text, or in the protobuf format if debug=0.
+func handleMatProfile(w http.ResponseWriter, r *http.Request) {
+	debug := 1
+	if d := r.FormValue("debug"); d != "" {
+		var err error
+		if debug, err = strconv.Atoi(d); err != nil {
+			http.Error(w, "invalid debug", http.StatusBadRequest)
+			return
+		}
+	}
+	if debug == 0 {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="matprofile"`)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if err := gocv.MatProfile.WriteTo(w, debug); err != nil {
+		logError("Writing matrix profile failed", "error", err)
+	}
+}
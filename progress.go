@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progressInterval is how often the progress of an export is reported.
+const progressInterval = time.Second
+
+// ExportProgress describes the progress of writing frames to a file.
+type ExportProgress struct {
+	Filename string
+	Frames   int
+	Total    int
+	Bytes    int64
+	Elapsed  time.Duration
+}
+
+// Percent returns the percentage of frames written.
+func (p ExportProgress) Percent() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Frames) / float64(p.Total)
+}
+
+// FPS returns the rate at which frames have been written.
+func (p ExportProgress) FPS() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Frames) / p.Elapsed.Seconds()
+}
+
+// Remaining estimates the time left until all frames are written, based on
+// the rate so far.
+func (p ExportProgress) Remaining() time.Duration {
+	fps := p.FPS()
+	if fps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Frames) / fps * float64(time.Second))
+}
+
+func (p ExportProgress) String() string {
+	return fmt.Sprintf("%d/%d frames (%0.0f%%), %0.1fMB, %0.1ffps, ~%v left",
+		p.Frames, p.Total, p.Percent(), float64(p.Bytes)/(1<<20), p.FPS(), p.Remaining().Round(time.Second))
+}
+
+// LogProgress is a progress callback that logs the progress.
+func LogProgress(p ExportProgress) {
+	logInfo("Saving", "path", p.Filename, "progress", p)
+}
+
+// watchProgress calls report, if set, every progressInterval with the
+// progress of writing total frames to filename, where frames returns the
+// number written so far. The returned function stops reporting, and returns
+// the final progress.
+func watchProgress(filename string, total int, frames func() int, report func(ExportProgress)) func() ExportProgress {
+	start := time.Now()
+	progress := func() ExportProgress {
+		p := ExportProgress{
+			Filename: filename,
+			Frames:   frames(),
+			Total:    total,
+			Elapsed:  time.Since(start),
+		}
+		if info, err := os.Stat(filename); err == nil {
+			p.Bytes = info.Size()
+		}
+		return p
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if report == nil {
+			<-done
+			return
+		}
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report(progress())
+			}
+		}
+	}()
+	return func() ExportProgress {
+		close(done)
+		<-stopped
+		return progress()
+	}
+}
@@ -0,0 +1,287 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package motiondetectpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MotionDetectClient is the client API for MotionDetect service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MotionDetectClient interface {
+	// GetStatus returns the status of each camera.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	// GetConfig returns a camera's detection settings.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*DetectorConfig, error)
+	// UpdateConfig changes the given detection settings of a camera, all at
+	// once or not at all, and returns the resulting settings.
+	UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*DetectorConfig, error)
+	// StreamEvents sends events as they start and end, until the client
+	// cancels.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (MotionDetect_StreamEventsClient, error)
+	// TriggerSave saves a camera's pre-roll buffer, like the s key.
+	TriggerSave(ctx context.Context, in *TriggerSaveRequest, opts ...grpc.CallOption) (*TriggerSaveResponse, error)
+}
+
+type motionDetectClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMotionDetectClient(cc grpc.ClientConnInterface) MotionDetectClient {
+	return &motionDetectClient{cc}
+}
+
+func (c *motionDetectClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	out := new(GetStatusResponse)
+	err := c.cc.Invoke(ctx, "/motiondetect.MotionDetect/GetStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *motionDetectClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*DetectorConfig, error) {
+	out := new(DetectorConfig)
+	err := c.cc.Invoke(ctx, "/motiondetect.MotionDetect/GetConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *motionDetectClient) UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*DetectorConfig, error) {
+	out := new(DetectorConfig)
+	err := c.cc.Invoke(ctx, "/motiondetect.MotionDetect/UpdateConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *motionDetectClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (MotionDetect_StreamEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MotionDetect_ServiceDesc.Streams[0], "/motiondetect.MotionDetect/StreamEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &motionDetectStreamEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MotionDetect_StreamEventsClient interface {
+	Recv() (*MotionEvent, error)
+	grpc.ClientStream
+}
+
+type motionDetectStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *motionDetectStreamEventsClient) Recv() (*MotionEvent, error) {
+	m := new(MotionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *motionDetectClient) TriggerSave(ctx context.Context, in *TriggerSaveRequest, opts ...grpc.CallOption) (*TriggerSaveResponse, error) {
+	out := new(TriggerSaveResponse)
+	err := c.cc.Invoke(ctx, "/motiondetect.MotionDetect/TriggerSave", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MotionDetectServer is the server API for MotionDetect service.
+// All implementations must embed UnimplementedMotionDetectServer
+// for forward compatibility
+type MotionDetectServer interface {
+	// GetStatus returns the status of each camera.
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	// GetConfig returns a camera's detection settings.
+	GetConfig(context.Context, *GetConfigRequest) (*DetectorConfig, error)
+	// UpdateConfig changes the given detection settings of a camera, all at
+	// once or not at all, and returns the resulting settings.
+	UpdateConfig(context.Context, *UpdateConfigRequest) (*DetectorConfig, error)
+	// StreamEvents sends events as they start and end, until the client
+	// cancels.
+	StreamEvents(*StreamEventsRequest, MotionDetect_StreamEventsServer) error
+	// TriggerSave saves a camera's pre-roll buffer, like the s key.
+	TriggerSave(context.Context, *TriggerSaveRequest) (*TriggerSaveResponse, error)
+	mustEmbedUnimplementedMotionDetectServer()
+}
+
+// UnimplementedMotionDetectServer must be embedded to have forward compatible implementations.
+type UnimplementedMotionDetectServer struct {
+}
+
+func (UnimplementedMotionDetectServer) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedMotionDetectServer) GetConfig(context.Context, *GetConfigRequest) (*DetectorConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedMotionDetectServer) UpdateConfig(context.Context, *UpdateConfigRequest) (*DetectorConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConfig not implemented")
+}
+func (UnimplementedMotionDetectServer) StreamEvents(*StreamEventsRequest, MotionDetect_StreamEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedMotionDetectServer) TriggerSave(context.Context, *TriggerSaveRequest) (*TriggerSaveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TriggerSave not implemented")
+}
+func (UnimplementedMotionDetectServer) mustEmbedUnimplementedMotionDetectServer() {}
+
+// UnsafeMotionDetectServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MotionDetectServer will
+// result in compilation errors.
+type UnsafeMotionDetectServer interface {
+	mustEmbedUnimplementedMotionDetectServer()
+}
+
+func RegisterMotionDetectServer(s grpc.ServiceRegistrar, srv MotionDetectServer) {
+	s.RegisterService(&MotionDetect_ServiceDesc, srv)
+}
+
+func _MotionDetect_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MotionDetectServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/motiondetect.MotionDetect/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MotionDetectServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MotionDetect_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MotionDetectServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/motiondetect.MotionDetect/GetConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MotionDetectServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MotionDetect_UpdateConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MotionDetectServer).UpdateConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/motiondetect.MotionDetect/UpdateConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MotionDetectServer).UpdateConfig(ctx, req.(*UpdateConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MotionDetect_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MotionDetectServer).StreamEvents(m, &motionDetectStreamEventsServer{stream})
+}
+
+type MotionDetect_StreamEventsServer interface {
+	Send(*MotionEvent) error
+	grpc.ServerStream
+}
+
+type motionDetectStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *motionDetectStreamEventsServer) Send(m *MotionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MotionDetect_TriggerSave_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TriggerSaveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MotionDetectServer).TriggerSave(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/motiondetect.MotionDetect/TriggerSave",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MotionDetectServer).TriggerSave(ctx, req.(*TriggerSaveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MotionDetect_ServiceDesc is the grpc.ServiceDesc for MotionDetect service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MotionDetect_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "motiondetect.MotionDetect",
+	HandlerType: (*MotionDetectServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _MotionDetect_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _MotionDetect_GetConfig_Handler,
+		},
+		{
+			MethodName: "UpdateConfig",
+			Handler:    _MotionDetect_UpdateConfig_Handler,
+		},
+		{
+			MethodName: "TriggerSave",
+			Handler:    _MotionDetect_TriggerSave_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _MotionDetect_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/motiondetect.proto",
+}
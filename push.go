@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// pushAttempts is how many times sending a push notification is
+	// attempted. Snapshots are saved in the background, so the first attempt
+	// may find they don't exist yet.
+	pushAttempts = 5
+	// pushTimeout is how long each attempt may take.
+	pushTimeout = 30 * time.Second
+	// pushoverAPI is the Pushover message API.
+	pushoverAPI = "https://api.pushover.net/1/messages.json"
+	// pushoverMaxAttachment is the largest attachment Pushover accepts.
+	pushoverMaxAttachment = 2500000
+)
+
+// Push notification priorities, by how confident the detection was.
+const (
+	PushLow = iota
+	PushNormal
+	PushHigh
+)
+
+// PushPriority rates the confidence of an event by its peak area: it is
+// PushHigh at highArea or above, PushLow below a quarter of that, and
+// PushNormal otherwise.
+func PushPriority(area, highArea float64) int {
+	switch {
+	case area >= highArea:
+		return PushHigh
+	case area < highArea/4:
+		return PushLow
+	}
+	return PushNormal
+}
+
+// pushMessage is a push notification about the start of an event.
+type pushMessage struct {
+	title    string
+	message  string
+	priority int
+	// click, if set, is opened when the notification is tapped.
+	click string
+	// attachment, if set, is the name of an image to attach.
+	attachment string
+}
+
+// newPushMessage describes the start of ev.
+func newPushMessage(ev *MotionEvent, highArea float64, publicURL string) pushMessage {
+	msg := pushMessage{
+		title:      "Motion on " + ev.Camera,
+		message:    fmt.Sprintf("Motion on %s at %s", ev.Camera, ev.Start.Format("15:04:05")),
+		priority:   PushPriority(ev.PeakArea, highArea),
+		attachment: ev.Snapshot,
+	}
+	if len(ev.Zones) > 0 {
+		msg.message += " in " + strings.Join(ev.Zones, ", ")
+	}
+	msg.message += suppressedNote(ev.Suppressed)
+	if publicURL != "" {
+		msg.click = strings.TrimSuffix(publicURL, "/") + "/snapshot.jpg"
+	}
+	return msg
+}
+
+// newDigestMessage summarizes d, with normal priority.
+func newDigestMessage(d *Digest, publicURL string) pushMessage {
+	msg := pushMessage{
+		title:    "Motion digest",
+		message:  d.Summary(),
+		priority: PushNormal,
+	}
+	if publicURL != "" {
+		msg.click = strings.TrimSuffix(publicURL, "/") + "/"
+	}
+	return msg
+}
+
+// Ntfy publishes the start of events to an ntfy topic, with their snapshots
+// attached, using a NotifyQueue. A nil *Ntfy does nothing.
+type Ntfy struct {
+	// URL is the topic's URL, e.g. https://ntfy.sh/mytopic.
+	URL string
+	// Token, if set, is sent as a bearer token.
+	Token string
+	// HighArea is the peak area at which events are sent with high priority
+	// (see PushPriority).
+	HighArea float64
+	// PublicURL, if set, is the base URL of the HTTP server, which
+	// notifications link to.
+	PublicURL string
+
+	client   *http.Client
+	queue    *NotifyQueue
+	cooldown eventCooldown
+}
+
+// NewNtfy creates an Ntfy publishing to the topic at url, at most one event
+// per camera per cooldown.
+func NewNtfy(url string, cooldown time.Duration) *Ntfy {
+	return &Ntfy{
+		URL:      url,
+		client:   &http.Client{Timeout: pushTimeout},
+		queue:    NewNotifyQueue("ntfy", pushAttempts),
+		cooldown: eventCooldown{Cooldown: cooldown},
+	}
+}
+
+// Notify queues a notification of the start of ev. It never blocks.
+func (n *Ntfy) Notify(ev *MotionEvent, phase string) {
+	if n == nil || phase != PhaseStart || !n.cooldown.Start(ev) {
+		return
+	}
+	msg := newPushMessage(ev, n.HighArea, n.PublicURL)
+	n.queue.Send(fmt.Sprintf("event %d", ev.ID), func() (bool, error) {
+		return n.send(msg)
+	})
+}
+
+// NotifyDigest queues a notification summarizing d. It never blocks.
+func (n *Ntfy) NotifyDigest(d *Digest) {
+	if n == nil {
+		return
+	}
+	msg := newDigestMessage(d, n.PublicURL)
+	n.queue.Send("digest", func() (bool, error) {
+		return n.send(msg)
+	})
+}
+
+// Close waits for queued notifications to be sent.
+func (n *Ntfy) Close() {
+	if n == nil {
+		return
+	}
+	n.queue.Close()
+}
+
+// send publishes msg once. With an attachment, the attachment is the body,
+// and the message goes in a header.
+func (n *Ntfy) send(msg pushMessage) (bool, error) {
+	var body io.Reader = strings.NewReader(msg.message)
+	if msg.attachment != "" {
+		f, err := os.Open(msg.attachment)
+		if err != nil {
+			// it may still be being saved
+			return true, err
+		}
+		defer f.Close()
+		body = f
+	}
+	req, err := http.NewRequest(http.MethodPut, n.URL, body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Title", msg.title)
+	// ntfy priorities run from 1 (min) to 5 (max), with 3 the default
+	req.Header.Set("Priority", fmt.Sprint(msg.priority+2))
+	req.Header.Set("Tags", "rotating_light")
+	if msg.click != "" {
+		req.Header.Set("Click", msg.click)
+	}
+	if msg.attachment != "" {
+		req.Header.Set("Message", msg.message)
+		req.Header.Set("Filename", filepath.Base(msg.attachment))
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return pushResponse(n.client.Do(req))
+}
+
+// Pushover sends the start of events to a Pushover user, with their snapshots
+// attached, using a NotifyQueue. A nil *Pushover does nothing.
+type Pushover struct {
+	// Token is the application's API token, and User the user (or group)
+	// key to send to.
+	Token string
+	User  string
+	// HighArea is the peak area at which events are sent with high priority
+	// (see PushPriority).
+	HighArea float64
+	// PublicURL, if set, is the base URL of the HTTP server, which
+	// notifications link to.
+	PublicURL string
+
+	client   *http.Client
+	queue    *NotifyQueue
+	cooldown eventCooldown
+}
+
+// NewPushover creates a Pushover sending to user with the application with
+// the given token, at most one event per camera per cooldown.
+func NewPushover(token, user string, cooldown time.Duration) *Pushover {
+	return &Pushover{
+		Token:    token,
+		User:     user,
+		client:   &http.Client{Timeout: pushTimeout},
+		queue:    NewNotifyQueue("pushover", pushAttempts),
+		cooldown: eventCooldown{Cooldown: cooldown},
+	}
+}
+
+// Notify queues a notification of the start of ev. It never blocks.
+func (p *Pushover) Notify(ev *MotionEvent, phase string) {
+	if p == nil || phase != PhaseStart || !p.cooldown.Start(ev) {
+		return
+	}
+	msg := newPushMessage(ev, p.HighArea, p.PublicURL)
+	p.queue.Send(fmt.Sprintf("event %d", ev.ID), func() (bool, error) {
+		return p.send(msg)
+	})
+}
+
+// NotifyDigest queues a notification summarizing d. It never blocks.
+func (p *Pushover) NotifyDigest(d *Digest) {
+	if p == nil {
+		return
+	}
+	msg := newDigestMessage(d, p.PublicURL)
+	p.queue.Send("digest", func() (bool, error) {
+		return p.send(msg)
+	})
+}
+
+// Close waits for queued notifications to be sent.
+func (p *Pushover) Close() {
+	if p == nil {
+		return
+	}
+	p.queue.Close()
+}
+
+// send sends msg once, attaching its attachment if it isn't too big.
+func (p *Pushover) send(msg pushMessage) (bool, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"token":   p.Token,
+		"user":    p.User,
+		"title":   msg.title,
+		"message": msg.message,
+		// Pushover priorities run from -2 (lowest) to 2 (emergency), with
+		// 0 the default
+		"priority": fmt.Sprint(msg.priority - 1),
+	}
+	if msg.click != "" {
+		fields["url"] = msg.click
+		fields["url_title"] = "Latest frame"
+	}
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if msg.attachment != "" {
+		data, err := ioutil.ReadFile(msg.attachment)
+		if err != nil {
+			// it may still be being saved
+			return true, err
+		}
+		if len(data) <= pushoverMaxAttachment {
+			part, err := mw.CreateFormFile("attachment", filepath.Base(msg.attachment))
+			if err != nil {
+				return false, err
+			}
+			part.Write(data)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return false, err
+	}
+	return pushResponse(p.client.Post(pushoverAPI, mw.FormDataContentType(), &body))
+}
+
+// pushResponse checks the response to a push notification, returning whether
+// it's worth retrying if it failed.
+func pushResponse(resp *http.Response, err error) (bool, error) {
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	var result struct {
+		// ntfy reports errors in error, and Pushover in errors
+		Error  string   `json:"error"`
+		Errors []string `json:"errors"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+	msg := result.Error
+	if len(result.Errors) > 0 {
+		msg = strings.Join(result.Errors, "; ")
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+	return retry, fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+}
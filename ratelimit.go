@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// RateLimiter paces a loop to a target number of iterations per second.
+type RateLimiter struct {
+	budget time.Duration
+	next   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter targeting the given FPS.
+func NewRateLimiter(fps float64) *RateLimiter {
+	return &RateLimiter{
+		budget: time.Duration(float64(time.Second) / fps),
+	}
+}
+
+// Wait sleeps for whatever remains of the current frame's budget. Deadlines
+// are advanced by a fixed budget rather than measured from when Wait returns,
+// so oversleeping on one frame is made up on the next. If the caller is
+// already slower than the target, Wait doesn't sleep, and the deficit is not
+// carried over as a burst of frames later.
+func (r *RateLimiter) Wait() {
+	now := time.Now()
+	if r.next.IsZero() || now.Sub(r.next) > r.budget {
+		r.next = now.Add(r.budget)
+		return
+	}
+	if d := r.next.Sub(now); d > 0 {
+		time.Sleep(d)
+	}
+	r.next = r.next.Add(r.budget)
+}
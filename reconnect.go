@@ -0,0 +1,81 @@
+package main
+
+import "time"
+
+const (
+	// reconnectMinBackoff is the wait before the first reconnection attempt,
+	// which doubles after each failed attempt, up to MaxBackoff.
+	reconnectMinBackoff = 500 * time.Millisecond
+	// reconnectPoll is how often the window is refreshed during an outage.
+	reconnectPoll = 100 * time.Millisecond
+)
+
+// Reconnector schedules attempts to reconnect to a source during an outage,
+// with exponential backoff.
+type Reconnector struct {
+	// MaxRetries is the number of failed attempts after which to give up, or
+	// 0 to never give up.
+	MaxRetries int
+	// GiveUpAfter is how long an outage may last before giving up, or 0 for
+	// no limit.
+	GiveUpAfter time.Duration
+	// MaxBackoff is the longest wait between attempts.
+	MaxBackoff time.Duration
+
+	active  bool
+	attempt int
+	since   time.Time
+	next    time.Time
+	backoff time.Duration
+}
+
+// Failed starts an outage at time t, with the first attempt due after the
+// minimum backoff. It does nothing if an outage is already in progress.
+func (r *Reconnector) Failed(t time.Time) {
+	if r.active {
+		return
+	}
+	r.active = true
+	r.attempt = 0
+	r.since = t
+	r.backoff = reconnectMinBackoff
+	r.next = t.Add(r.backoff)
+}
+
+// Active returns whether an outage is in progress.
+func (r *Reconnector) Active() bool {
+	return r.active
+}
+
+// Attempt returns the number of the next attempt, counting from 1.
+func (r *Reconnector) Attempt() int {
+	return r.attempt + 1
+}
+
+// Due returns whether the next attempt is due at time t.
+func (r *Reconnector) Due(t time.Time) bool {
+	return r.active && !t.Before(r.next)
+}
+
+// Retry records a failed attempt at time t and schedules the next one. It
+// returns false if the retries or time allowed are exhausted.
+func (r *Reconnector) Retry(t time.Time) bool {
+	r.attempt++
+	if r.MaxRetries > 0 && r.attempt >= r.MaxRetries {
+		return false
+	}
+	if r.GiveUpAfter > 0 && t.Sub(r.since) >= r.GiveUpAfter {
+		return false
+	}
+	if r.backoff *= 2; r.MaxBackoff > 0 && r.backoff > r.MaxBackoff {
+		r.backoff = r.MaxBackoff
+	}
+	r.next = t.Add(r.backoff)
+	return true
+}
+
+// Succeeded ends the outage at time t, returning how long it lasted.
+func (r *Reconnector) Succeeded(t time.Time) time.Duration {
+	r.active = false
+	return t.Sub(r.since)
+}
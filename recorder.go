@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// recorderQueue is the number of live frames that may be waiting to be written
+// to a recording before frames start being dropped.
+const recorderQueue = 60
+
+// Recorder records motion events to video files named by an OutputTemplate.
+// Each recording starts with the frames in the pre-roll buffer, continues with
+// live frames until the event ends, and is finalized in the background so that
+// capture isn't interrupted. Since the final filename may depend on the event
+// duration, events are recorded to a hidden file that is renamed once done.
+//
+// Events longer than MaxLength are split into consecutively numbered segments,
+// with each frame going to exactly one of them.
+type Recorder struct {
+	// Dir is the directory relative to which Template is expanded.
+	Dir string
+	// Template names the recorded files.
+	Template *OutputTemplate
+	// Camera is the camera name used in filenames.
+	Camera string
+	// Codec is the "FourCC" codec to record with (e.g. "mp4v").
+	Codec string
+	// FPS is used for recordings when it can't be measured from the buffer.
+	FPS float64
+	// MaxLength is the maximum length of a single file, or 0 for no limit.
+	MaxLength time.Duration
+	// Space, if set, is checked for enough free disk space before each file
+	// is started.
+	Space *SpaceGuard
+	// Annotate, if set, is applied to each frame as it is written.
+	Annotate FrameAnnotator
+	// Chapters writes a chapters sidecar for each saved file, marking the
+	// spans with motion.
+	Chapters bool
+	// Thumbnails, if set, generates a thumbnail for each saved file.
+	Thumbnails *Thumbnailer
+	// Uploader, if set, uploads each saved file, with its sidecars.
+	Uploader *Uploader
+	// OnSave, if set, is called in the background with the name of each file
+	// once it has been saved.
+	OnSave func(filename string)
+
+	current   *SegmentWriter
+	event     *MotionEvent
+	fps       float64
+	frameSize int64
+	part      int
+	partStart time.Time
+	split     bool
+	paused    bool
+	lastFrame time.Time
+	wg        sync.WaitGroup
+}
+
+// NewRecorder creates a Recorder that records the given camera to files named
+// by tmpl within dir, using the given codec, and falling back to the given FPS
+// when it can't be measured.
+func NewRecorder(dir string, tmpl *OutputTemplate, camera, codec string, fps float64) *Recorder {
+	return &Recorder{
+		Dir:      dir,
+		Template: tmpl,
+		Camera:   camera,
+		Codec:    codec,
+		FPS:      fps,
+	}
+}
+
+// filename returns the final filename of the current segment of the event
+// being recorded, assuming it ends at time end. Segments are numbered with
+// {part} if the template has it, and otherwise with a suffix, once the event
+// has been split.
+func (r *Recorder) filename(end time.Time) string {
+	fields := TemplateFields{
+		Camera:   r.Camera,
+		Seq:      r.event.ID,
+		Part:     r.part,
+		Start:    r.event.Start,
+		Duration: end.Sub(r.event.Start),
+	}
+	if r.part > 1 {
+		fields.Start = r.partStart
+		fields.Duration = end.Sub(r.partStart)
+	}
+	name := r.Template.Expand(fields)
+	if r.split && !r.Template.Has("part") {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), r.part, ext)
+	}
+	return OutputPath(r.Dir, name)
+}
+
+// Recording returns whether a recording is in progress.
+func (r *Recorder) Recording() bool {
+	return r.current != nil
+}
+
+// Start starts recording the given event, beginning with the frames in buffer
+// that weren't already part of a previous recording. The buffer is emptied. If
+// a recording is already in progress, Start does nothing.
+func (r *Recorder) Start(ev *MotionEvent, buffer *MatBuffer) {
+	if r.current != nil {
+		return
+	}
+
+	r.fps = buffer.FPS()
+	if r.fps <= 0 {
+		r.fps = r.FPS
+	}
+	imgs, times := buffer.Take(r.lastFrame)
+
+	r.event = ev
+	r.part = 1
+	r.split = false
+	r.paused = false
+	r.partStart = ev.Start
+	if len(times) > 0 {
+		r.partStart = times[0]
+		r.frameSize = int64(imgs[0].Total() * imgs[0].Channels())
+	}
+	if err := r.open(len(imgs)); err != nil {
+		logError("Not recording event", "camera", r.Camera, "event_id", ev.ID, "error", err)
+		for _, img := range imgs {
+			img.Close()
+		}
+		return
+	}
+	for i := range imgs {
+		r.current.Add(imgs[i], times[i], 0)
+		r.lastFrame = times[i]
+	}
+	logInfo("Recording event", "camera", r.Camera, "event_id", ev.ID)
+}
+
+// open starts writing a new segment, with room for queue frames in addition to
+// the usual live frames.
+func (r *Recorder) open(queue int) error {
+	// the final name isn't known yet, but record next to where it will likely
+	// end up so that the rename doesn't cross filesystems
+	dir := filepath.Dir(r.filename(r.partStart))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// assume the segment will be as long as allowed, or otherwise about
+	// twice the pre-roll
+	frames := 2 * queue
+	if r.MaxLength > 0 {
+		frames = int(r.MaxLength.Seconds() * r.fps)
+	}
+	if err := r.Space.Check(dir, r.frameSize, frames); err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, fmt.Sprintf(".event-%d-%d%s", r.event.ID, r.part, filepath.Ext(r.Template.String())))
+	r.current = NewSegmentWriter(filename, r.Codec, r.fps, queue+recorderQueue)
+	r.current.Annotate = r.Annotate
+	return nil
+}
+
+// Pause stops adding live frames to the recording in progress, without
+// finishing it, while waiting to see whether the event will resume.
+func (r *Recorder) Pause() {
+	r.paused = true
+}
+
+// Resume continues a paused recording, with the frames in buffer that were
+// captured since the last frame recorded, so that the new sub-event gets its
+// pre-roll. The buffer is emptied.
+func (r *Recorder) Resume(buffer *MatBuffer) {
+	if r.current == nil || !r.paused {
+		return
+	}
+	r.paused = false
+	imgs, times := buffer.Take(r.lastFrame)
+	for i := range imgs {
+		if !r.current.Add(imgs[i], times[i], 0) {
+			drops.Drop("rec")
+		}
+		r.lastFrame = times[i]
+	}
+	logInfo("Resuming recording of event", "camera", r.Camera, "event_id", r.event.ID)
+}
+
+// Add copies a live frame captured at time t, with the given area of motion,
+// to the recording in progress, starting a new segment first if the current
+// one has reached MaxLength. If no recording is in progress, or it is paused,
+// Add does nothing.
+func (r *Recorder) Add(img *gocv.Mat, t time.Time, area float64) {
+	if r.current == nil || r.paused {
+		return
+	}
+	if r.MaxLength > 0 && t.Sub(r.partStart) >= r.MaxLength {
+		// the segment being closed ends at the previous frame, and the new
+		// one starts at this one
+		r.split = true
+		r.event.Segments = append(r.event.Segments, r.filename(r.lastFrame))
+		r.close(r.event.Segments[len(r.event.Segments)-1])
+		r.part++
+		r.partStart = t
+		if err := r.open(0); err != nil {
+			logError("Not recording segment", "camera", r.Camera, "event_id", r.event.ID, "segment", r.part, "error", err)
+			return
+		}
+		logInfo("Event reached the maximum length, continuing in a new segment", "camera", r.Camera, "event_id", r.event.ID,
+			"max_length", r.MaxLength, "segment", r.part)
+	}
+	m := img.Clone()
+	if !r.current.Add(&m, t, area) {
+		drops.Drop("rec")
+	}
+	r.lastFrame = t
+}
+
+// Finish ends the recording of the given event, setting its Clip to the final
+// filename, which is finalized in the background. If the event was split, all
+// of its segments are listed in Segments, and Clip is the last of them. If no
+// recording is in progress, Finish does nothing.
+func (r *Recorder) Finish(ev *MotionEvent) {
+	if r.current == nil {
+		return
+	}
+	ev.Clip = r.filename(r.lastFrame)
+	if len(ev.Segments) > 0 {
+		ev.Segments = append(ev.Segments, ev.Clip)
+	}
+	if r.Thumbnails != nil {
+		ev.Thumbnail = ThumbnailPath(ev.Clip)
+	}
+	r.close(ev.Clip)
+	r.event = nil
+}
+
+// close finalizes the current segment as filename in the background.
+func (r *Recorder) close(filename string) {
+	w, id, zones := r.current, r.event.ID, r.event.Zones
+	r.current = nil
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := finalizeSegment(w, filename); err != nil {
+			logError("Saving event failed", "camera", r.Camera, "event_id", id, "path", filename, "error", err)
+			os.Remove(w.Filename)
+			return
+		}
+		first, last := w.TimeWindow()
+		logInfo("Saved event", "camera", r.Camera, "event_id", id, "path", filename, "frames", w.Count(), "duration", last.Sub(first))
+		if r.Chapters {
+			if err := WriteChapters(filename, w.Chapters(), ChapterTitle(zones)); err != nil {
+				logError("Writing chapters failed", "camera", r.Camera, "event_id", id, "path", filename, "error", err)
+			}
+		}
+		if r.Thumbnails != nil {
+			frame := w.Peak()
+			if frame < 0 {
+				frame = w.Count() / 2
+			}
+			if err := r.Thumbnails.Generate(filename, frame, first, last.Sub(first)); err != nil {
+				logError("Generating thumbnail failed", "camera", r.Camera, "event_id", id, "path", filename, "error", err)
+			}
+		}
+		r.Uploader.Upload(filename, r.Camera, id, first)
+		if r.OnSave != nil {
+			r.OnSave(filename)
+		}
+	}()
+}
+
+// finalizeSegment closes the given writer and moves its file to filename. It is
+// tracked by saves while it's in progress.
+func finalizeSegment(w *SegmentWriter, filename string) error {
+	defer saves.Begin(filename)()
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// OpenCV doesn't report write errors, so the best we can do to detect a
+	// truncated file is to check whether the disk filled up
+	if diskFull(filepath.Dir(w.Filename)) {
+		return fmt.Errorf("%w: disk full while writing", errNoSpace)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.Rename(w.Filename, filename)
+}
+
+// Wait waits for all recordings being finalized in the background.
+func (r *Recorder) Wait() {
+	r.wg.Wait()
+}
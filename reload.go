@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errNoConfigFile is returned by Reloader.Reload when there's no
+// configuration file to reload.
+var errNoConfigFile = errors.New("no configuration file to reload")
+
+// ReloadResult reports what a reload changed.
+type ReloadResult struct {
+	// Changed lists the settings that changed and were applied.
+	Changed []string `json:"changed"`
+	// RestartRequired lists the settings that changed but can only be
+	// applied by restarting, so were ignored.
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reloader re-reads the configuration file and applies what changed, one
+// reload at a time, however it's triggered. A nil *Reloader has nothing to
+// reload.
+type Reloader struct {
+	// Load re-reads the configuration and applies the settings that changed.
+	// If the configuration is invalid, it must return an error, leaving the
+	// current settings as they are.
+	Load func() (ReloadResult, error)
+
+	mu sync.Mutex
+}
+
+// Reload calls Load, waiting for any reload already in progress first, and
+// logs the result.
+func (r *Reloader) Reload() (ReloadResult, error) {
+	if r == nil || r.Load == nil {
+		return ReloadResult{}, errNoConfigFile
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, err := r.Load()
+	if err != nil {
+		logError("Reloading configuration failed, keeping the current settings", "error", err)
+		return res, err
+	}
+	logInfo("Reloaded configuration", "changed", len(res.Changed))
+	for _, s := range res.RestartRequired {
+		logWarn("Setting changed, but only takes effect after a restart", "setting", s)
+	}
+	return res, nil
+}
+
+// SetupReloadHandler reloads the configuration file on SIGHUP, as sent by
+// systemctl reload.
+func SetupReloadHandler(r *Reloader) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if _, err := r.Reload(); err == errNoConfigFile {
+				logWarn("Ignoring SIGHUP", "error", err)
+			}
+		}
+	}()
+}
+
+// Groups of flags that can be changed by reloading the configuration file.
+// Changes to any other flags only take effect after a restart.
+var (
+	reloadDetectorFlags = map[string]bool{
+		"threshold":     true,
+		"dilate":        true,
+		"min-area":      true,
+		"draw-contours": true,
+		"draw-rects":    true,
+		"detect":        true,
+	}
+	reloadRetentionFlags = map[string]bool{
+		"retention-max-size":  true,
+		"retention-max-age":   true,
+		"retention-dry-run":   true,
+		"continuous-max-size": true,
+		"continuous-max-age":  true,
+	}
+	// the flags of the notifiers built by newOutboundNotifiers
+	reloadNotifierFlags = map[string]bool{
+		"webhook-url":         true,
+		"webhook-end":         true,
+		"webhook-secret":      true,
+		"webhook-timeout":     true,
+		"webhook-limit":       true,
+		"on-motion-start-cmd": true,
+		"on-motion-end-cmd":   true,
+		"cmd-timeout":         true,
+		"cmd-limit":           true,
+		"notify-cooldown":     true,
+		"public-url":          true,
+		"telegram-token":      true,
+		"telegram-chat-id":    true,
+		"telegram-clips":      true,
+		"telegram-limit":      true,
+		"ntfy-url":            true,
+		"ntfy-token":          true,
+		"ntfy-limit":          true,
+		"pushover-token":      true,
+		"pushover-user":       true,
+		"pushover-limit":      true,
+		"push-high-area":      true,
+		"slack-webhook-url":   true,
+		"slack-token":         true,
+		"slack-channel":       true,
+		"slack-limit":         true,
+		"smtp-host":           true,
+		"smtp-port":           true,
+		"smtp-tls":            true,
+		"smtp-username":       true,
+		"smtp-password":       true,
+		"smtp-from":           true,
+		"smtp-to":             true,
+		"smtp-subject":        true,
+		"smtp-body":           true,
+		"smtp-clips":          true,
+		"smtp-limit":          true,
+		"smtp-max-attachment": true,
+	}
+)
+
+// flagValues returns the value of each flag in fs, as a string.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// cloneFlags returns a FlagSet with the same flags as fs, set to their
+// defaults, in new values of the same types, for reading a configuration file
+// into without changing fs.
+func cloneFlags(fs *flag.FlagSet) *flag.FlagSet {
+	clone := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	fs.VisitAll(func(f *flag.Flag) {
+		v := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+		if _, ok := v.(repeatableFlag); !ok || f.DefValue != "" {
+			v.Set(f.DefValue)
+		}
+		clone.Var(v, f.Name, f.Usage)
+	})
+	return clone
+}
+
+// diffConfigFile reads the configuration file at path, and returns the new
+// values of the flags it changes from current, other than those in skip,
+// such as the flags given on the command line, along with the file and a
+// warning for each unknown key. Flags no longer set by the file change back
+// to their defaults.
+func diffConfigFile(path string, current map[string]string, skip map[string]bool) (changed map[string]string, cfg *ConfigFile, warnings []string, err error) {
+	if cfg, err = LoadConfigFile(path); err != nil {
+		return nil, nil, nil, err
+	}
+	fs := cloneFlags(flag.CommandLine)
+	if warnings, _, err = cfg.Apply(fs, skip); err != nil {
+		return nil, nil, warnings, err
+	}
+	changed = make(map[string]string)
+	for name, v := range flagValues(fs) {
+		if !skip[name] && name != "config" && v != current[name] {
+			changed[name] = v
+		}
+	}
+	return changed, cfg, warnings, nil
+}
+
+// configReload re-reads the configuration file for Reloader.Load, applying
+// the changes to the flags in the reloadable groups to the running program,
+// without disturbing the cameras, their buffers or background models.
+type configReload struct {
+	Path string
+	// FromFlags are the flags given on the command line, which take
+	// precedence over the file, so never change.
+	FromFlags map[string]bool
+	// Current are the values of the flags as of the last load, against
+	// which the file is compared.
+	Current map[string]string
+	// Cameras are the cameras listed by the file, or nil if they were given
+	// on the command line.
+	Cameras []string
+
+	Cams                []*Camera
+	Retention           *Retention
+	ContinuousRetention *Retention
+	Outbound            *NotifierSwitch
+	// NotifyBucket is the bucket of -notify-limit, shared by the notifiers.
+	NotifyBucket *TokenBucket
+}
+
+// Load reloads the configuration file. The changes are checked as they are
+// at startup, and if any are invalid, none are applied.
+func (r *configReload) Load() (ReloadResult, error) {
+	changed, cfg, warnings, err := diffConfigFile(r.Path, r.Current, r.FromFlags)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	for _, w := range warnings {
+		logWarn(w, "path", r.Path)
+	}
+
+	res := ReloadResult{Changed: []string{}, RestartRequired: []string{}}
+	var detector, retention, notifiers bool
+	for _, name := range sortedFields(changed) {
+		switch {
+		case reloadDetectorFlags[name]:
+			detector = true
+		case reloadRetentionFlags[name]:
+			retention = true
+		case reloadNotifierFlags[name]:
+			notifiers = true
+		default:
+			res.RestartRequired = append(res.RestartRequired, name)
+			continue
+		}
+		res.Changed = append(res.Changed, name)
+	}
+	if r.Cameras != nil && strings.Join(cfg.Cameras, "\n") != strings.Join(r.Cameras, "\n") {
+		res.RestartRequired = append(res.RestartRequired, "cameras")
+	}
+
+	// the flags are set so that they're checked and applied just as they are
+	// at startup, and set back if they're invalid
+	old := make(map[string]string)
+	for _, name := range res.Changed {
+		old[name] = flag.Lookup(name).Value.String()
+		flag.Set(name, changed[name])
+	}
+	restore := func() {
+		for name, v := range old {
+			flag.Set(name, v)
+		}
+	}
+	detectorFlags, err := detectorFlagConfig()
+	if err != nil {
+		restore()
+		return ReloadResult{}, err
+	}
+	// the new notifiers are built before anything is applied, so that if
+	// building them fails, the old ones are left in use along with
+	// everything else
+	var outbound Notifiers
+	var persistOutbound func() error
+	if notifiers {
+		if err := checkOutboundFlags(); err != nil {
+			restore()
+			return ReloadResult{}, err
+		}
+		if outbound, persistOutbound, err = newOutboundNotifiers(r.NotifyBucket); err != nil {
+			restore()
+			return ReloadResult{}, err
+		}
+	}
+
+	if detector {
+		// only the settings that changed are applied, leaving any changed
+		// from the keyboard or API alone
+		var change DetectorConfig
+		set := func(name string) bool {
+			_, ok := changed[name]
+			return ok
+		}
+		if set("threshold") {
+			change.Threshold = detectorFlags.Threshold
+		}
+		if set("dilate") {
+			change.DilateSize = detectorFlags.DilateSize
+		}
+		if set("min-area") {
+			change.MinArea = detectorFlags.MinArea
+		}
+		if set("draw-contours") {
+			change.DrawContours = detectorFlags.DrawContours
+		}
+		if set("draw-rects") {
+			change.DrawRects = detectorFlags.DrawRects
+		}
+		if set("detect") {
+			change.DetectionEnabled = detectorFlags.DetectionEnabled
+		}
+		for _, c := range r.Cams {
+			c.Configure(context.Background(), change)
+		}
+	}
+	if retention {
+		r.Retention.SetLimits(int64(retentionMaxSize), *retentionMaxAge, *retentionDryRun)
+		if r.ContinuousRetention != nil {
+			r.ContinuousRetention.SetLimits(int64(continuousMaxSize), *continuousMaxAge, *retentionDryRun)
+		}
+		go func() {
+			if err := r.Retention.Prune(); err != nil {
+				logError("Applying retention failed", "path", r.Retention.Dir, "error", err)
+			}
+			if r.ContinuousRetention != nil {
+				if err := r.ContinuousRetention.Prune(); err != nil {
+					logError("Applying continuous retention failed", "path", r.ContinuousRetention.Dir, "error", err)
+				}
+			}
+		}()
+	}
+	if notifiers {
+		if err := r.Outbound.Replace(outbound, persistOutbound); err != nil {
+			// the webhook carries on without its journal, only keeping
+			// events queued in memory
+			logError("Webhook events won't be queued across restarts", "error", err)
+		}
+	}
+	for _, name := range res.Changed {
+		r.Current[name] = changed[name]
+	}
+	return res, nil
+}
+
+// HandleReload registers /api/reload with http.DefaultServeMux. POST reloads
+// the configuration file, returning a ReloadResult, or an error if the file
+// is invalid, in which case the current settings are kept.
+func HandleReload(r *Reloader) {
+	http.HandleFunc("/api/reload", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		res, err := r.Reload()
+		switch {
+		case err == errNoConfigFile:
+			writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, res)
+		}
+	})
+}
+
+// runReloadCommand implements the "reload" subcommand, which asks a running
+// instance to reload its configuration file through /api/reload.
+func runReloadCommand(args []string) {
+	fs := flag.NewFlagSet("reload", flag.ExitOnError)
+	cf := addClientFlags(fs, 30*time.Second)
+	fs.Parse(args)
+
+	var res ReloadResult
+	if err := cf.client().do(http.MethodPost, "/api/reload", nil, &res); err != nil {
+		log.Fatalf("Error reloading: %v", err)
+	}
+	if *cf.json {
+		printJSON(res)
+		return
+	}
+	if len(res.Changed) == 0 && len(res.RestartRequired) == 0 {
+		fmt.Println("No settings changed")
+	}
+	for _, s := range res.Changed {
+		fmt.Println("changed:", s)
+	}
+	for _, s := range res.RestartRequired {
+		fmt.Println("ignored until restart:", s)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingExts are the file extensions considered recordings by Retention.
+var recordingExts = map[string]bool{
+	".mp4": true,
+	".avi": true,
+	".mkv": true,
+	".mov": true,
+}
+
+// Retention prunes old recordings from a directory tree, keeping the total size
+// under MaxBytes and deleting anything older than MaxAge. A recording's
+// sidecar files (thumbnails etc.) share its name up to the extension, and are
+// deleted along with it. Hidden files, such as recordings still being written,
+// are never touched.
+type Retention struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+	// DryRun logs what would be deleted, without deleting anything.
+	DryRun bool
+	// Skip lists directories within Dir that are left alone, e.g. because
+	// they are pruned separately.
+	Skip []string
+
+	mu sync.Mutex
+}
+
+// SetLimits changes the limits, e.g. as the configuration is reloaded, once
+// any prune in progress is done.
+func (r *Retention) SetLimits(maxBytes int64, maxAge time.Duration, dryRun bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.MaxBytes, r.MaxAge, r.DryRun = maxBytes, maxAge, dryRun
+}
+
+// recording is a recording and its sidecar files.
+type recording struct {
+	files   []string
+	size    int64
+	modTime time.Time
+}
+
+// Prune deletes recordings, oldest first, until the limits are met. It is
+// safe to call concurrently; prunes are serialized.
+func (r *Retention) Prune() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.MaxBytes <= 0 && r.MaxAge <= 0 {
+		return nil
+	}
+
+	recs, total, err := r.scan()
+	if err != nil {
+		return err
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].modTime.Before(recs[j].modTime)
+	})
+
+	now := time.Now()
+	for _, rec := range recs {
+		tooOld := r.MaxAge > 0 && now.Sub(rec.modTime) > r.MaxAge
+		tooBig := r.MaxBytes > 0 && total > r.MaxBytes
+		if !tooOld && !tooBig {
+			break
+		}
+		for _, f := range rec.files {
+			if r.DryRun {
+				logInfo("Retention would delete recording", "path", f)
+				continue
+			}
+			if err := os.Remove(f); err != nil {
+				logError("Retention failed to delete recording", "path", f, "error", err)
+				continue
+			}
+			logInfo("Retention deleted recording", "path", f)
+		}
+		total -= rec.size
+	}
+	return nil
+}
+
+// scan finds all recordings in the directory tree, and their total size.
+func (r *Retention) scan() ([]*recording, int64, error) {
+	byStem := make(map[string]*recording)
+	var sidecars []string
+	var total int64
+
+	err := filepath.Walk(r.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files may be renamed or deleted as the tree is walked, e.g. as
+			// recordings are finished, or by another prune
+			if path != r.Dir && (os.IsNotExist(err) || strings.HasPrefix(filepath.Base(path), ".")) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != r.Dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			for _, skip := range r.Skip {
+				if filepath.Clean(path) == filepath.Clean(skip) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if !recordingExts[strings.ToLower(ext)] {
+			sidecars = append(sidecars, path)
+			return nil
+		}
+		byStem[strings.TrimSuffix(path, ext)] = &recording{
+			files:   []string{path},
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("scanning %s failed: %w", r.Dir, err)
+	}
+
+	// attach sidecars to their recordings, e.g. "clip.jpg" or
+	// "clip.chapters.txt" to "clip.mp4"
+	for _, path := range sidecars {
+		stem := path
+		for ext := filepath.Ext(stem); ext != ""; ext = filepath.Ext(stem) {
+			stem = strings.TrimSuffix(stem, ext)
+			if rec, ok := byStem[stem]; ok {
+				if info, err := os.Stat(path); err == nil {
+					rec.files = append(rec.files, path)
+					rec.size += info.Size()
+					total += info.Size()
+				}
+				break
+			}
+		}
+	}
+
+	recs := make([]*recording, 0, len(byStem))
+	for _, rec := range byStem {
+		recs = append(recs, rec)
+	}
+	return recs, total, nil
+}
+
+// byteSize is a flag.Value for sizes in bytes, with optional K, M, G or T
+// (binary) suffixes, e.g. "500M".
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	mult := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			mult = 1 << (10 * uint(i+1))
+			num = num[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
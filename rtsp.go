@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// rtspQueue is the number of frames that may be waiting for ffmpeg before
+	// frames start being dropped.
+	rtspQueue = 5
+	// rtspClientQueue is the number of RTP packets that may be waiting to be
+	// sent to a client before it is disconnected for being too slow.
+	rtspClientQueue = 512
+	// rtspMaxPayload is the largest RTP payload sent. Larger NAL units are
+	// fragmented.
+	rtspMaxPayload = 1400
+	// rtspIdleTimeout is how long ffmpeg is kept running without frames
+	// after the last client stops playing.
+	rtspIdleTimeout = 2 * time.Second
+	// rtspMaxBackoff is the longest wait before restarting ffmpeg after it
+	// has exited.
+	rtspMaxBackoff = 30 * time.Second
+	// rtspPayloadType is the dynamic RTP payload type of the H.264 stream.
+	rtspPayloadType = 96
+)
+
+// errRTSPIdle is returned by RTSPServer.stream when it stops ffmpeg because
+// nobody is playing the stream.
+var errRTSPIdle = errors.New("no clients")
+
+// RTSPServer serves the live view over RTSP, as H.264 encoded by an ffmpeg
+// process, which is only run while a client is playing the stream, and is
+// restarted if it exits unexpectedly. RTP is sent interleaved on the RTSP
+// connection or over UDP, as the client asks.
+type RTSPServer struct {
+	// Path is the path of the stream, e.g. /live.
+	Path string
+	FPS  float64
+
+	listener net.Listener
+	frames   chan hlsFrame
+	done     chan struct{}
+	epoch    time.Time
+
+	// only used by the packetizing goroutine, of which there's one at a time
+	seq  uint16
+	ssrc uint32
+
+	mu      sync.Mutex
+	players map[*rtspSession]bool
+	// sps and pps are the latest parameter sets seen, for DESCRIBE
+	sps, pps []byte
+}
+
+// NewRTSPServer creates an RTSPServer listening on addr, serving the stream at
+// path, and starts it in the background.
+func NewRTSPServer(addr, path string, fps float64) (*RTSPServer, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var ssrc [4]byte
+	rand.Read(ssrc[:])
+	s := &RTSPServer{
+		Path:     "/" + strings.Trim(path, "/"),
+		FPS:      fps,
+		listener: l,
+		frames:   make(chan hlsFrame, rtspQueue),
+		done:     make(chan struct{}),
+		epoch:    time.Now(),
+		ssrc:     binary.BigEndian.Uint32(ssrc[:]),
+		players:  make(map[*rtspSession]bool),
+	}
+	go s.accept()
+	go s.run()
+	return s, nil
+}
+
+// Playing reports whether any clients are playing the stream.
+func (s *RTSPServer) Playing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.players) > 0
+}
+
+// Write queues a copy of the given 8-bit BGR frame to be streamed. If nobody
+// is playing the stream, it does nothing, and if the queue is full, the frame
+// is dropped.
+func (s *RTSPServer) Write(img gocv.Mat) {
+	if img.Type() != gocv.MatTypeCV8UC3 || !s.Playing() {
+		return
+	}
+	f := hlsFrame{img.ToBytes(), img.Cols(), img.Rows()}
+	select {
+	case s.frames <- f:
+	default:
+		drops.Drop("rtsp")
+	}
+}
+
+// Close stops the server, disconnecting all clients.
+func (s *RTSPServer) Close() {
+	s.listener.Close()
+	close(s.frames)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sess := range s.players {
+		sess.conn.Close()
+	}
+}
+
+func (s *RTSPServer) run() {
+	defer close(s.done)
+
+	backoff := time.Second
+	for {
+		first, ok := <-s.frames
+		if !ok {
+			return
+		}
+		started := time.Now()
+		err := s.stream(first)
+		if err == nil {
+			return
+		}
+		if err == errRTSPIdle {
+			// wait for the next client
+			backoff = time.Second
+			continue
+		}
+		if time.Since(started) > rtspMaxBackoff {
+			backoff = time.Second
+		}
+		logError("RTSP stream failed, restarting", "retry_in", backoff, "error", err)
+
+		// keep draining frames while waiting, so that the queue doesn't hold
+		// on to stale ones
+		timer := time.NewTimer(backoff)
+	wait:
+		for {
+			select {
+			case _, ok := <-s.frames:
+				if !ok {
+					timer.Stop()
+					return
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		if backoff *= 2; backoff > rtspMaxBackoff {
+			backoff = rtspMaxBackoff
+		}
+	}
+}
+
+// stream runs ffmpeg, starting with the given frame, until it fails, nobody
+// is playing the stream, in which case errRTSPIdle is returned, or the frames
+// channel is closed, in which case nil is returned. Frames that don't match
+// the dimensions of the first are skipped.
+func (s *RTSPServer) stream(first hlsFrame) error {
+	// a keyframe every second, so that new clients don't wait long
+	gop := int(s.FPS)
+	if gop < 1 {
+		gop = 1
+	}
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "bgr24",
+		"-s", fmt.Sprintf("%dx%d", first.width, first.height),
+		"-r", strconv.FormatFloat(s.FPS, 'f', 2, 64),
+		"-i", "-",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+		"-g", strconv.Itoa(gop),
+		// parameter sets with every keyframe, for clients joining late, and
+		// access unit delimiters, to tell where each frame ends
+		"-x264-params", "repeat-headers=1:aud=1",
+		"-f", "h264", "-",
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		// Wait closes stdout, so it must all be read first
+		s.packetize(stdout)
+		exited <- cmd.Wait()
+	}()
+	stop := func() {
+		stdin.Close()
+		select {
+		case <-exited:
+		case <-time.After(hlsStopTimeout):
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	idle := time.NewTimer(rtspIdleTimeout)
+	defer idle.Stop()
+	f := first
+	for {
+		if f.width == first.width && f.height == first.height {
+			if _, err := stdin.Write(f.data); err != nil {
+				cmd.Process.Kill()
+				return fmt.Errorf("ffmpeg: %v", waitErr(<-exited, err))
+			}
+		}
+		var ok bool
+		select {
+		case f, ok = <-s.frames:
+			if !ok {
+				stop()
+				return nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(rtspIdleTimeout)
+		case <-idle.C:
+			// Write stops queueing frames once nobody is playing
+			stop()
+			return errRTSPIdle
+		case err := <-exited:
+			return fmt.Errorf("ffmpeg: %v", waitErr(err, io.ErrUnexpectedEOF))
+		}
+	}
+}
+
+// packetize reads an H.264 Annex B stream from r, sending each access unit to
+// the players as it's completed, until r is exhausted.
+func (s *RTSPServer) packetize(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 8*1024*1024)
+	sc.Split(splitNALUnits)
+	var (
+		au [][]byte
+		ts uint32
+	)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		nal := append([]byte(nil), sc.Bytes()...)
+		switch nal[0] & 0x1f {
+		case 9:
+			// an access unit delimiter starts each frame
+			s.sendAccessUnit(au, ts)
+			au = nil
+			ts = uint32(int64(time.Since(s.epoch)) * 9 / 100000) // 90kHz
+			continue
+		case 7:
+			s.mu.Lock()
+			s.sps = nal
+			s.mu.Unlock()
+		case 8:
+			s.mu.Lock()
+			s.pps = nal
+			s.mu.Unlock()
+		}
+		au = append(au, nal)
+	}
+	s.sendAccessUnit(au, ts)
+	// read whatever's left, so that ffmpeg isn't blocked writing it
+	io.Copy(ioutil.Discard, r)
+}
+
+// splitNALUnits is a bufio.SplitFunc splitting an Annex B stream into NAL
+// units, without their start codes.
+func splitNALUnits(data []byte, atEOF bool) (int, []byte, error) {
+	startCode := []byte{0, 0, 1}
+	i := bytes.Index(data, startCode)
+	if i < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	start := i + len(startCode)
+	if next := bytes.Index(data[start:], startCode); next >= 0 {
+		end := start + next
+		// the zero of a four byte start code
+		for end > start && data[end-1] == 0 {
+			end--
+		}
+		return start + next, data[start:end], nil
+	}
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+	return 0, nil, nil
+}
+
+// sendAccessUnit packetizes the NAL units of a frame, with the RTP timestamp
+// ts, and queues the packets to be sent to each player. Players that haven't
+// received anything yet are only sent keyframes.
+func (s *RTSPServer) sendAccessUnit(au [][]byte, ts uint32) {
+	if len(au) == 0 {
+		return
+	}
+	var (
+		packets  [][]byte
+		keyframe bool
+	)
+	for i, nal := range au {
+		typ := nal[0] & 0x1f
+		keyframe = keyframe || typ == 5 || typ == 7
+		last := i == len(au)-1
+		if len(nal) <= rtspMaxPayload {
+			packets = append(packets, s.rtpPacket(nal, last, ts))
+			continue
+		}
+		// fragment it into FU-A units
+		indicator := nal[0]&0xe0 | 28
+		for pos := 1; pos < len(nal); pos += rtspMaxPayload - 2 {
+			end := pos + rtspMaxPayload - 2
+			if end > len(nal) {
+				end = len(nal)
+			}
+			header := typ
+			if pos == 1 {
+				header |= 0x80
+			}
+			if end == len(nal) {
+				header |= 0x40
+			}
+			payload := append([]byte{indicator, header}, nal[pos:end]...)
+			packets = append(packets, s.rtpPacket(payload, last && end == len(nal), ts))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sess := range s.players {
+		if !sess.started && !keyframe {
+			continue
+		}
+		sess.started = true
+	queue:
+		for _, p := range packets {
+			select {
+			case sess.packets <- p:
+			default:
+				logWarn("Disconnecting slow RTSP client", "client", sess.conn.RemoteAddr())
+				sess.conn.Close()
+				break queue
+			}
+		}
+	}
+}
+
+// rtpPacket returns an RTP packet with the next sequence number.
+func (s *RTSPServer) rtpPacket(payload []byte, marker bool, ts uint32) []byte {
+	p := make([]byte, 12, 12+len(payload))
+	p[0] = 0x80 // version 2
+	p[1] = rtspPayloadType
+	if marker {
+		p[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(p[2:], s.seq)
+	binary.BigEndian.PutUint32(p[4:], ts)
+	binary.BigEndian.PutUint32(p[8:], s.ssrc)
+	s.seq++
+	return append(p, payload...)
+}
+
+// sdp describes the stream, including its parameter sets if any have been
+// seen.
+func (s *RTSPServer) sdp() string {
+	s.mu.Lock()
+	sps, pps := s.sps, s.pps
+	s.mu.Unlock()
+
+	fmtp := "packetization-mode=1"
+	if len(sps) >= 4 && len(pps) > 0 {
+		fmtp += ";profile-level-id=" + hex.EncodeToString(sps[1:4]) +
+			";sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(sps) + "," + base64.StdEncoding.EncodeToString(pps)
+	}
+	return "v=0\r\n" +
+		"o=- 0 0 IN IP4 0.0.0.0\r\n" +
+		"s=motiondetect\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n" +
+		fmt.Sprintf("m=video 0 RTP/AVP %d\r\n", rtspPayloadType) +
+		fmt.Sprintf("a=rtpmap:%d H264/90000\r\n", rtspPayloadType) +
+		fmt.Sprintf("a=fmtp:%d %s\r\n", rtspPayloadType, fmtp) +
+		"a=control:trackID=0\r\n"
+}
+
+// rtspSession is a client's session, set up on its RTSP connection.
+type rtspSession struct {
+	id   string
+	conn net.Conn
+	// connMu serializes writes to conn, which interleaved RTP shares with
+	// RTSP responses
+	connMu *sync.Mutex
+	// interleaved sends RTP on conn, on the given channel; otherwise it's
+	// sent with udp
+	interleaved bool
+	channel     byte
+	udp         *net.UDPConn
+
+	// packets are waiting to be sent; closed when the session stops playing
+	packets chan []byte
+	// started is set once the first keyframe is queued; guarded by the
+	// server's mu
+	started bool
+}
+
+// send sends queued packets until the session stops playing.
+func (sess *rtspSession) send() {
+	for p := range sess.packets {
+		var err error
+		if sess.interleaved {
+			header := []byte{'$', sess.channel, 0, 0}
+			binary.BigEndian.PutUint16(header[2:], uint16(len(p)))
+			sess.connMu.Lock()
+			if _, err = sess.conn.Write(header); err == nil {
+				_, err = sess.conn.Write(p)
+			}
+			sess.connMu.Unlock()
+		} else {
+			_, err = sess.udp.Write(p)
+		}
+		if err != nil {
+			sess.conn.Close()
+			// keep draining until the session is stopped
+			for range sess.packets {
+			}
+			return
+		}
+	}
+}
+
+// play starts sending the stream to sess.
+func (s *RTSPServer) play(sess *rtspSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.players[sess] {
+		return
+	}
+	sess.packets = make(chan []byte, rtspClientQueue)
+	sess.started = false
+	s.players[sess] = true
+	go sess.send()
+	logInfo("RTSP client playing", "client", sess.conn.RemoteAddr())
+}
+
+// stop stops sending the stream to sess.
+func (s *RTSPServer) stop(sess *rtspSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.players[sess] {
+		return
+	}
+	delete(s.players, sess)
+	close(sess.packets)
+	logInfo("RTSP client stopped", "client", sess.conn.RemoteAddr())
+}
+
+func (s *RTSPServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve handles RTSP requests on conn until it's closed. A connection has at
+// most one session, which ends with it.
+func (s *RTSPServer) serve(conn net.Conn) {
+	var (
+		br     = bufio.NewReader(conn)
+		connMu sync.Mutex
+		sess   *rtspSession
+	)
+	defer func() {
+		if sess != nil {
+			s.stop(sess)
+			if sess.udp != nil {
+				sess.udp.Close()
+			}
+		}
+		conn.Close()
+	}()
+
+	for {
+		// skip RTCP sent interleaved by the client
+		if b, err := br.Peek(1); err != nil {
+			return
+		} else if b[0] == '$' {
+			var header [4]byte
+			if _, err := io.ReadFull(br, header[:]); err != nil {
+				return
+			}
+			if _, err := io.CopyN(ioutil.Discard, br, int64(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return
+			}
+			continue
+		}
+
+		tp := textproto.NewReader(br)
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		req := strings.Fields(line)
+		if len(req) != 3 {
+			return
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		if n, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64); n > 0 {
+			if _, err := io.CopyN(ioutil.Discard, br, n); err != nil {
+				return
+			}
+		}
+
+		method, rawURL := req[0], req[1]
+		resp := textproto.MIMEHeader{}
+		resp.Set("CSeq", header.Get("CSeq"))
+		var (
+			status = "200 OK"
+			body   string
+		)
+		switch method {
+		case "OPTIONS":
+			resp.Set("Public", "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER")
+		case "DESCRIBE":
+			if !s.matchPath(rawURL, false) {
+				status = "404 Not Found"
+				break
+			}
+			resp.Set("Content-Base", strings.TrimSuffix(rawURL, "/")+"/")
+			resp.Set("Content-Type", "application/sdp")
+			body = s.sdp()
+		case "SETUP":
+			if !s.matchPath(rawURL, true) {
+				status = "404 Not Found"
+				break
+			}
+			if sess != nil {
+				status = "459 Aggregate Operation Not Allowed"
+				break
+			}
+			setup, err := s.setup(conn, header.Get("Transport"))
+			if err != nil {
+				status = "461 Unsupported Transport"
+				break
+			}
+			sess = setup
+			sess.connMu = &connMu
+			resp.Set("Transport", sessionTransport(sess, header.Get("Transport")))
+		case "PLAY":
+			if sess == nil {
+				status = "455 Method Not Valid in This State"
+				break
+			}
+			s.play(sess)
+			resp.Set("Range", "npt=0.000-")
+		case "TEARDOWN":
+			if sess != nil {
+				s.stop(sess)
+			}
+			s.respond(conn, &connMu, status, resp, sess, "")
+			return
+		case "GET_PARAMETER", "SET_PARAMETER":
+			// keepalives
+		default:
+			status = "501 Not Implemented"
+		}
+		if err := s.respond(conn, &connMu, status, resp, sess, body); err != nil {
+			return
+		}
+	}
+}
+
+// respond writes a response to conn.
+func (s *RTSPServer) respond(conn net.Conn, connMu *sync.Mutex, status string, header textproto.MIMEHeader, sess *rtspSession, body string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %s\r\n", status)
+	if sess != nil {
+		header.Set("Session", sess.id+";timeout=60")
+	}
+	if body != "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(&b, "%s: %s\r\n", rtspHeaderName(k), v)
+		}
+	}
+	b.WriteString("\r\n")
+	b.WriteString(body)
+
+	connMu.Lock()
+	defer connMu.Unlock()
+	_, err := io.WriteString(conn, b.String())
+	return err
+}
+
+// rtspHeaderName undoes the canonicalization of the one RTSP header whose
+// case clients are known to care about.
+func rtspHeaderName(k string) string {
+	if k == "Cseq" {
+		return "CSeq"
+	}
+	return k
+}
+
+// matchPath reports whether rawURL is the stream's, or, if track is set, the
+// stream's or its track's.
+func (s *RTSPServer) matchPath(rawURL string, track bool) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	p := "/" + strings.Trim(u.Path, "/")
+	return p == s.Path || (track && strings.HasPrefix(p, strings.TrimSuffix(s.Path, "/")+"/"))
+}
+
+// setup creates a session for a client connected with conn, with the
+// transport it asked for: interleaved RTP over TCP, or unicast UDP.
+func (s *RTSPServer) setup(conn net.Conn, transport string) (*rtspSession, error) {
+	var id [8]byte
+	rand.Read(id[:])
+	sess := &rtspSession{
+		id:   hex.EncodeToString(id[:]),
+		conn: conn,
+	}
+	params := transportParams(transport)
+	if strings.HasPrefix(transport, "RTP/AVP/TCP") {
+		sess.interleaved = true
+		if ch, ok := params["interleaved"]; ok {
+			n, err := strconv.Atoi(strings.SplitN(ch, "-", 2)[0])
+			if err != nil || n < 0 || n > 255 {
+				return nil, fmt.Errorf("invalid interleaved channel %q", ch)
+			}
+			sess.channel = byte(n)
+		}
+		return sess, nil
+	}
+	if _, multicast := params["multicast"]; multicast {
+		return nil, errors.New("multicast unsupported")
+	}
+	ports, ok := params["client_port"]
+	if !ok {
+		return nil, errors.New("no client_port")
+	}
+	port, err := strconv.Atoi(strings.SplitN(ports, "-", 2)[0])
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	if sess.udp, err = net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP(host), Port: port}); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// transportParams parses the parameters of the first transport in a
+// Transport header.
+func transportParams(transport string) map[string]string {
+	params := make(map[string]string)
+	first := strings.SplitN(transport, ",", 2)[0]
+	for _, p := range strings.Split(first, ";")[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = kv[1]
+		} else {
+			params[strings.TrimSpace(kv[0])] = ""
+		}
+	}
+	return params
+}
+
+// sessionTransport returns the Transport header of the response to setting up
+// sess.
+func sessionTransport(sess *rtspSession, requested string) string {
+	if sess.interleaved {
+		return fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", sess.channel, sess.channel+1)
+	}
+	port := sess.udp.LocalAddr().(*net.UDPAddr).Port
+	return fmt.Sprintf("RTP/AVP;unicast;client_port=%s;server_port=%d-%d",
+		transportParams(requested)["client_port"], port, port+1)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+type timedMat struct {
+	img  *gocv.Mat
+	t    time.Time
+	area float64
+}
+
+// SegmentWriter writes frames to a video file in the background, so that the
+// caller isn't blocked by encoding or disk I/O.
+type SegmentWriter struct {
+	// written is the number of frames written so far; accessed atomically
+	written int64
+
+	Filename string
+	// Annotate, if set, is applied to each frame before it is written. It must
+	// be set before the first call to Add.
+	Annotate FrameAnnotator
+
+	codec  string
+	fps    float64
+	frames chan timedMat
+	done   chan struct{}
+
+	// only used by the background goroutine, and read after done is closed
+	vw       *gocv.VideoWriter
+	count    int
+	peak     int
+	peakArea float64
+	chapters []Chapter
+	first    time.Time
+	last     time.Time
+	err      error
+}
+
+// NewSegmentWriter creates a SegmentWriter that writes to the given filename,
+// using the specified "FourCC" codec (e.g. "mp4v") at the given FPS. Up to
+// queue frames may be waiting to be written before Add starts dropping them.
+// The file is opened when the first frame is added, with that frame's
+// dimensions.
+func NewSegmentWriter(filename, codec string, fps float64, queue int) *SegmentWriter {
+	w := &SegmentWriter{
+		Filename: filename,
+		codec:    codec,
+		fps:      fps,
+		frames:   make(chan timedMat, queue),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Add queues a frame captured at time t, with the given area of motion, to be
+// written, taking ownership of the matrix, which will be closed once written.
+// If the queue is full, the frame is closed immediately, and false is returned.
+func (w *SegmentWriter) Add(img *gocv.Mat, t time.Time, area float64) bool {
+	select {
+	case w.frames <- timedMat{img, t, area}:
+		return true
+	default:
+		img.Close()
+		return false
+	}
+}
+
+// Close waits for all queued frames to be written, then closes the file. It
+// returns the first error encountered while writing, if any. Add must not be
+// called after Close.
+func (w *SegmentWriter) Close() error {
+	close(w.frames)
+	<-w.done
+	return w.err
+}
+
+// Written returns the number of frames written so far. Unlike Count, it may
+// be called at any time.
+func (w *SegmentWriter) Written() int {
+	return int(atomic.LoadInt64(&w.written))
+}
+
+// Count returns the number of frames written. It must only be called after
+// Close.
+func (w *SegmentWriter) Count() int {
+	return w.count
+}
+
+// Peak returns the index of the first frame written with the largest area of
+// motion, or -1 if no frame had any motion. It must only be called after
+// Close.
+func (w *SegmentWriter) Peak() int {
+	if w.peakArea <= 0 {
+		return -1
+	}
+	return w.peak
+}
+
+// Chapters returns the spans of the file in which there was motion. It must
+// only be called after Close.
+func (w *SegmentWriter) Chapters() []Chapter {
+	return w.chapters
+}
+
+// addMotion extends the last chapter to offset d, or starts a new one if
+// there was no motion for longer than chapterGap.
+func (w *SegmentWriter) addMotion(d time.Duration) {
+	if n := len(w.chapters); n > 0 && d-w.chapters[n-1].End <= chapterGap {
+		w.chapters[n-1].End = d
+		return
+	}
+	w.chapters = append(w.chapters, Chapter{Start: d, End: d})
+}
+
+// TimeWindow returns the timestamps of the first and last frames written. It
+// must only be called after Close.
+func (w *SegmentWriter) TimeWindow() (time.Time, time.Time) {
+	return w.first, w.last
+}
+
+func (w *SegmentWriter) run() {
+	defer close(w.done)
+
+	defer func() {
+		if w.vw != nil {
+			if err := w.vw.Close(); err != nil && w.err == nil {
+				w.err = fmt.Errorf("closing writer failed: %w", err)
+			}
+		}
+	}()
+
+	for f := range w.frames {
+		if w.err == nil {
+			w.err = w.write(f)
+		}
+		f.img.Close()
+	}
+}
+
+func (w *SegmentWriter) write(f timedMat) error {
+	if w.vw == nil {
+		vw, err := gocv.VideoWriterFile(w.Filename, w.codec, w.fps, f.img.Cols(), f.img.Rows(), true)
+		if err != nil {
+			return fmt.Errorf("opening writer failed: %w", err)
+		}
+		w.vw = vw
+		if !vw.IsOpened() {
+			return fmt.Errorf("opening writer failed: codec %q unavailable", w.codec)
+		}
+		w.first = f.t
+	}
+	if w.Annotate != nil {
+		w.Annotate(f.img, f.t)
+	}
+	if err := w.vw.Write(*f.img); err != nil {
+		return fmt.Errorf("writing image failed: %w", err)
+	}
+	if f.area > 0 {
+		w.addMotion(f.t.Sub(w.first))
+	}
+	if f.area > w.peakArea {
+		w.peak = w.count
+		w.peakArea = f.area
+	}
+	w.count++
+	atomic.AddInt64(&w.written, 1)
+	w.last = f.t
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ServeHTTP publishes the program's counters for the given cameras and serves
+// http.DefaultServeMux, with which the program's handlers are registered, on
+// the given address in the background, allowing only the requests auth
+// does. If certFile and keyFile are set, it's served over HTTPS.
+func ServeHTTP(addr string, cams []*Camera, auth *HTTPAuth, certFile, keyFile string) {
+	PublishExpvars(cams)
+	handler := auth.Wrap(withoutPprof(http.DefaultServeMux))
+	if certFile != "" {
+		logInfo("Serving HTTPS (counters at /debug/vars)", "addr", addr)
+		go func() {
+			logError("HTTPS server failed", "addr", addr, "error", http.ListenAndServeTLS(addr, certFile, keyFile, handler))
+		}()
+		return
+	}
+	logInfo("Serving HTTP (counters at /debug/vars)", "addr", addr)
+	go func() {
+		logError("HTTP server failed", "addr", addr, "error", http.ListenAndServe(addr, handler))
+	}()
+}
+
+// withoutPprof hides the handlers net/http/pprof registers with
+// http.DefaultServeMux, which are only meant to be served by ServePprof.
+func withoutPprof(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
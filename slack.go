@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// slackAPI is the base URL of the Slack Web API.
+	slackAPI = "https://slack.com/api/"
+	// slackAttempts is how many times sending a message is attempted.
+	// Snapshots are saved in the background, so the first attempts may find
+	// they don't exist yet.
+	slackAttempts = 5
+	// slackTimeout is how long each request may take.
+	slackTimeout = 30 * time.Second
+)
+
+// Slack sends the start of events to a Slack channel, using a NotifyQueue,
+// at most one per camera per cooldown. With a bot token, it sends a Block Kit
+// message with the event's snapshot uploaded. Otherwise it posts a plain text
+// message to an incoming webhook. Messages count the starts suppressed by
+// the cooldown since the last one. A nil *Slack does nothing.
+type Slack struct {
+	// WebhookURL is the incoming webhook to post to, if Token isn't set.
+	WebhookURL string
+	// Token is a bot token, with the chat:write and files:write scopes, and
+	// Channel the ID of the channel to send to.
+	Token   string
+	Channel string
+	// HighArea is the peak area at which events are reported with high
+	// confidence (see PushPriority).
+	HighArea float64
+
+	client   *http.Client
+	queue    *NotifyQueue
+	cooldown eventCooldown
+}
+
+// NewSlack creates a Slack sending at most one event per camera per
+// cooldown. Either webhookURL, or token and channel, must be set.
+func NewSlack(webhookURL, token, channel string, cooldown time.Duration) *Slack {
+	return &Slack{
+		WebhookURL: webhookURL,
+		Token:      token,
+		Channel:    channel,
+		client:     &http.Client{Timeout: slackTimeout},
+		queue:      NewNotifyQueue("slack", slackAttempts),
+		cooldown:   eventCooldown{Cooldown: cooldown},
+	}
+}
+
+// Notify queues a message about the start of ev. It never blocks.
+func (s *Slack) Notify(ev *MotionEvent, phase string) {
+	if s == nil || phase != PhaseStart {
+		return
+	}
+	ok, suppressed := s.cooldown.StartSuppressed(ev)
+	if !ok {
+		return
+	}
+	suppressed += ev.Suppressed
+	var (
+		when       = ev.Start.Local().Format("Mon 2 Jan 15:04:05 MST")
+		confidence = fmt.Sprintf("%s (peak area %.0f)",
+			[]string{"low", "normal", "high"}[PushPriority(ev.PeakArea, s.HighArea)], ev.PeakArea)
+		text = fmt.Sprintf("Motion on %s at %s, %s confidence", ev.Camera, when, confidence)
+	)
+	if len(ev.Zones) > 0 {
+		text += " in " + strings.Join(ev.Zones, ", ")
+	}
+	if suppressed > 0 {
+		text += fmt.Sprintf(" (suppressed %d additional detections)", suppressed)
+	}
+	desc := fmt.Sprintf("event %d", ev.ID)
+	if s.Token == "" {
+		s.queue.Send(desc, func() (bool, error) {
+			return s.post(s.WebhookURL, "application/json", "webhook", map[string]string{"text": text}, nil)
+		})
+		return
+	}
+
+	fields := []slackText{
+		{"mrkdwn", "*Camera*\n" + slackEscape(ev.Camera)},
+		{"mrkdwn", "*Time*\n" + when},
+		{"mrkdwn", "*Confidence*\n" + confidence},
+	}
+	if len(ev.Zones) > 0 {
+		fields = append(fields, slackText{"mrkdwn", "*Zones*\n" + slackEscape(strings.Join(ev.Zones, ", "))})
+	}
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{"plain_text", "Motion on " + ev.Camera}},
+		{Type: "section", Fields: fields},
+	}
+	if suppressed > 0 {
+		blocks = append(blocks, slackBlock{Type: "context", Elements: []slackText{
+			{"mrkdwn", fmt.Sprintf("Suppressed %d additional detections since the last alert", suppressed)},
+		}})
+	}
+	snapshot := ev.Snapshot
+	s.queue.Send(desc, func() (bool, error) {
+		if snapshot == "" {
+			return s.call("chat.postMessage", map[string]interface{}{
+				"channel": s.Channel,
+				"text":    text,
+				"blocks":  blocks,
+			}, nil)
+		}
+		return s.upload(snapshot, text, blocks)
+	})
+}
+
+// NotifyDigest queues a message summarizing d. It never blocks.
+func (s *Slack) NotifyDigest(d *Digest) {
+	if s == nil {
+		return
+	}
+	text := "Motion: " + d.Summary()
+	s.queue.Send("digest", func() (bool, error) {
+		if s.Token == "" {
+			return s.post(s.WebhookURL, "application/json", "webhook", map[string]string{"text": text}, nil)
+		}
+		return s.call("chat.postMessage", map[string]interface{}{"channel": s.Channel, "text": text}, nil)
+	})
+}
+
+// Close waits for queued messages to be sent.
+func (s *Slack) Close() {
+	if s == nil {
+		return
+	}
+	s.queue.Close()
+}
+
+// slackBlock is a Block Kit layout block.
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+// slackText is a Block Kit text object.
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackEscape escapes the characters Slack treats as markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// upload uploads a snapshot to the channel, with blocks as its message,
+// using Slack's external upload flow: reserving an upload URL, uploading the
+// file to it, then sharing the file.
+func (s *Slack) upload(filename, title string, blocks []slackBlock) (bool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		// it may still be being saved
+		return true, err
+	}
+	var reserved struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	form := url.Values{
+		"filename": {filepath.Base(filename)},
+		"length":   {strconv.Itoa(len(data))},
+	}
+	if retry, err := s.call("files.getUploadURLExternal", form, &reserved); err != nil {
+		return retry, err
+	}
+	if retry, err := s.post(reserved.UploadURL, "application/octet-stream", "upload", nil, bytes.NewReader(data)); err != nil {
+		return retry, err
+	}
+	return s.call("files.completeUploadExternal", map[string]interface{}{
+		"files":      []map[string]string{{"id": reserved.FileID, "title": title}},
+		"channel_id": s.Channel,
+		"blocks":     blocks,
+	}, nil)
+}
+
+// call calls a Web API method, with args either form encoded, if they're
+// url.Values, or as JSON, decoding the result into result if it's not nil.
+func (s *Slack) call(method string, args interface{}, result interface{}) (bool, error) {
+	var (
+		body        io.Reader
+		contentType string
+	)
+	if form, ok := args.(url.Values); ok {
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	} else {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return false, err
+		}
+		body, contentType = bytes.NewReader(data), "application/json; charset=utf-8"
+	}
+	req, err := http.NewRequest(http.MethodPost, slackAPI+method, body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("%s failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return true, fmt.Errorf("%s failed: %v", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return true, fmt.Errorf("%s failed: %s", method, resp.Status)
+	}
+	if !status.OK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 || status.Error == "ratelimited"
+		return retry, fmt.Errorf("%s failed: %s", method, status.Error)
+	}
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			return false, fmt.Errorf("%s failed: %v", method, err)
+		}
+	}
+	return false, nil
+}
+
+// post posts either v as JSON, or body, to u, which isn't a Web API method,
+// so only the status of the response says whether it succeeded.
+func (s *Slack) post(u, contentType, desc string, v interface{}, body io.Reader) (bool, error) {
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false, err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := s.client.Post(u, contentType, body)
+	if err != nil {
+		// the error includes the URL, which is a secret
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return true, fmt.Errorf("%s failed: %v", desc, err)
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+		return retry, fmt.Errorf("%s failed: %s: %s", desc, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
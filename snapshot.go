@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Snapshotter saves JPEG crops of the regions in which motion was detected.
+// Crops are encoded and written in the background.
+type Snapshotter struct {
+	// Dir is the directory snapshots are saved to.
+	Dir string
+	// Padding is added around each region's bounding rectangle.
+	Padding int
+	// MinSize is the minimum width and height of a crop, after padding and
+	// clamping to the image; smaller crops are skipped.
+	MinSize int
+	// Tag, if set, distinguishes the camera's snapshots from other cameras'.
+	Tag string
+
+	wg sync.WaitGroup
+}
+
+// Save crops each detection from img, which should be free of any markup, and
+// saves the crops as "event_<id>_<tag>_<n>.jpg", or "event_<Tag>_<id>_..." if
+// Tag is set. It returns the filenames of the crops that will be saved.
+func (s *Snapshotter) Save(img gocv.Mat, detections []Detection, ev *MotionEvent, tag string) []string {
+	var (
+		bounds    = image.Rect(0, 0, img.Cols(), img.Rows())
+		prefix    = "event"
+		filenames []string
+	)
+	if s.Tag != "" {
+		prefix += "_" + s.Tag
+	}
+	for _, d := range detections {
+		r := d.Rect.Inset(-s.Padding).Intersect(bounds)
+		if r.Dx() < s.MinSize || r.Dy() < s.MinSize {
+			continue
+		}
+		region := img.Region(r)
+		crop := region.Clone()
+		region.Close()
+
+		filename := OutputPath(s.Dir, fmt.Sprintf("%s_%d_%s_%d.jpg", prefix, ev.ID, tag, len(filenames)+1))
+		filenames = append(filenames, filename)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer crop.Close()
+			if !gocv.IMWrite(filename, crop) {
+				logError("Saving snapshot failed", "camera", ev.Camera, "event_id", ev.ID, "path", filename)
+			}
+		}()
+	}
+	return filenames
+}
+
+// Wait waits for all snapshots being saved in the background.
+func (s *Snapshotter) Wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// sshTimeout is how long connecting to an SSH server may take.
+	sshTimeout = 30 * time.Second
+	// defaultSSHKey is the default of -ssh-key, which unlike a key given
+	// explicitly, needn't exist.
+	defaultSSHKey = "~/.ssh/id_ed25519"
+)
+
+// SSHTarget uploads to a directory on a server over SSH. Files are
+// transferred with the SCP protocol, which needs scp on the server, as most
+// SSH servers have, including those only meant for SFTP.
+type SSHTarget struct {
+	// Addr is the server's address, as host:port.
+	Addr string
+	// Dir is the directory keys are relative to.
+	Dir string
+
+	config *ssh.ClientConfig
+}
+
+// NewSSHTarget creates an SSHTarget uploading to the given
+// sftp://[user[:password]@]host[:port]/dir URL. It authenticates with the
+// password in the URL, if any, or -ssh-password, and with -ssh-key, and
+// verifies the server's key against -ssh-known-hosts unless
+// -ssh-insecure-ignore-host-key is set.
+func NewSSHTarget(u *url.URL) (*SSHTarget, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	var auth []ssh.AuthMethod
+	if keyFile := expandHome(*sshKey); keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil && *sshKey != defaultSSHKey {
+			return nil, err
+		}
+		if err == nil {
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %v", keyFile, err)
+			}
+			auth = append(auth, ssh.PublicKeys(signer))
+		}
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		password = *sshPassword
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no password or key to authenticate with")
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !*sshInsecure {
+		var err error
+		if hostKey, err = knownhosts.New(expandHome(*sshKnownHosts)); err != nil {
+			return nil, fmt.Errorf("reading known hosts: %v", err)
+		}
+	}
+
+	return &SSHTarget{
+		Addr: addr,
+		Dir:  u.Path,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+			Timeout:         sshTimeout,
+		},
+	}, nil
+}
+
+// URL returns the sftp:// URL of key, without credentials.
+func (t *SSHTarget) URL(key string) string {
+	return "sftp://" + t.config.User + "@" + t.Addr + path.Join("/", t.Dir, key)
+}
+
+// Put uploads a file to key, creating its directory if needed.
+func (t *SSHTarget) Put(filename, key string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	client, err := ssh.Dial("tcp", t.Addr, t.config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	remote := path.Join(t.Dir, key)
+	if t.Dir == "" {
+		remote = key
+	}
+	dir, name := path.Split(remote)
+	if dir == "" {
+		dir = "."
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	out, err := session.CombinedOutput("mkdir -p " + shellQuote(dir))
+	session.Close()
+	if err != nil {
+		return fmt.Errorf("creating %s failed: %v: %s", dir, err, strings.TrimSpace(string(out)))
+	}
+
+	if session, err = client.NewSession(); err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(stdout)
+	if err := session.Start("scp -qt " + shellQuote(dir)); err != nil {
+		return err
+	}
+	// the sink acknowledges each step with a 0 byte, or reports an error
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C0644 %d %s\n", info.Size(), name); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	if _, err := io.Copy(stdin, f); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	stdin.Close()
+	return session.Wait()
+}
+
+// scpAck reads an SCP acknowledgement, returning the error it reports, if any.
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// expandHome expands a leading ~ in a path to the user's home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
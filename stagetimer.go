@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stageSamples is the number of most recent samples each stage's average is
+// computed over.
+const stageSamples = 60
+
+// StageTimer measures rolling average durations of named stages of a loop. A
+// nil *StageTimer is valid and does nothing, so timing can be disabled without
+// changing call sites. It is safe for concurrent use.
+type StageTimer struct {
+	mu     sync.Mutex
+	stages map[string]*stage
+	order  []string
+}
+
+type stage struct {
+	start   time.Time
+	samples [stageSamples]time.Duration
+	writes  int
+	total   time.Duration
+}
+
+// NewStageTimer creates an empty StageTimer.
+func NewStageTimer() *StageTimer {
+	return &StageTimer{
+		stages: make(map[string]*stage),
+	}
+}
+
+// Start marks the beginning of the named stage.
+func (t *StageTimer) Start(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stages[name]
+	if !ok {
+		s = &stage{}
+		t.stages[name] = s
+		t.order = append(t.order, name)
+	}
+	s.start = now
+}
+
+// Stop marks the end of the named stage, recording its duration since the
+// matching call to Start.
+func (t *StageTimer) Stop(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stages[name]
+	if !ok || s.start.IsZero() {
+		return
+	}
+	d := now.Sub(s.start)
+	s.start = time.Time{}
+
+	i := s.writes % len(s.samples)
+	s.total += d - s.samples[i]
+	s.samples[i] = d
+	s.writes++
+}
+
+// Averages returns the rolling average duration of each stage.
+func (t *StageTimer) Averages() map[string]time.Duration {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	avgs := make(map[string]time.Duration, len(t.stages))
+	for name, s := range t.stages {
+		avgs[name] = s.average()
+	}
+	return avgs
+}
+
+// String formats the rolling averages of all stages, in the order they were
+// first started, e.g. "read=3.1ms detect=12.0ms".
+func (t *StageTimer) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sb strings.Builder
+	for i, name := range t.order {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		avg := t.stages[name].average()
+		fmt.Fprintf(&sb, "%s=%0.1fms", name, avg.Seconds()*1000)
+	}
+	return sb.String()
+}
+
+func (s *stage) average() time.Duration {
+	n := s.writes
+	if n > len(s.samples) {
+		n = len(s.samples)
+	}
+	if n == 0 {
+		return 0
+	}
+	return s.total / time.Duration(n)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SetupStatsHandler logs a Snapshot of the cameras on SIGUSR1.
+func SetupStatsHandler(cams []*Camera) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			LogStats(cams)
+		}
+	}()
+}
@@ -0,0 +1,5 @@
+package main
+
+// SetupStatsHandler does nothing, as Windows has no SIGUSR1; the same stats
+// are served by /api/status?format=text.
+func SetupStatsHandler(cams []*Camera) {}
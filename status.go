@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// processStart is when the program started, for its uptime.
+var processStart = time.Now()
+
+// DetectorParams are a camera's current detection parameters.
+type DetectorParams struct {
+	Threshold    float32 `json:"threshold"`
+	DilateSize   int     `json:"dilate_size"`
+	MinArea      float64 `json:"min_area"`
+	DrawContours bool    `json:"draw_contours"`
+	DrawRects    bool    `json:"draw_rects"`
+}
+
+// CameraState is a camera's state as of its latest frame. It drives the HUD's
+// status line, and is served by /api/status, for deployments without a
+// window to show the HUD in.
+type CameraState struct {
+	Camera string `json:"camera"`
+	// Tagged is whether the camera's name is shown on the HUD, as it is when
+	// there are several cameras.
+	Tagged bool `json:"-"`
+	Width  int  `json:"width"`
+	Height int  `json:"height"`
+	// FPS is the measured frame rate, averaged over the FPS counter's window,
+	// FPSInstant that over the last few frames, and FPSNominal the rate the
+	// camera reported or was calibrated to.
+	FPS        float64 `json:"fps"`
+	FPSInstant float64 `json:"fps_instant"`
+	FPSNominal float64 `json:"fps_nominal"`
+	// FrameP95Ms is the 95th percentile time taken to process a frame.
+	FrameP95Ms float64 `json:"frame_p95_ms"`
+
+	DetectionEnabled bool           `json:"detection_enabled"`
+	Armed            bool           `json:"armed"`
+	Detector         DetectorParams `json:"detector"`
+	// Selected is the detection parameter adjusted from the keyboard: a
+	// (minimum area), d (dilate size) or t (threshold).
+	Selected string `json:"selected"`
+
+	// DrawLive and DrawRecord are whether detections are marked up in the
+	// live view and recordings, HUDLive and HUDRecord whether the HUD is
+	// drawn on them, HUD how much of it is, and Rotate how far frames are
+	// rotated, in degrees.
+	DrawLive   bool   `json:"draw_live"`
+	DrawRecord bool   `json:"draw_record"`
+	HUDLive    bool   `json:"hud_live"`
+	HUDRecord  bool   `json:"hud_record"`
+	HUD        string `json:"hud"`
+	Rotate     int    `json:"rotate"`
+
+	// BufferFill is how full the pre-roll buffer is, from 0 to 1, and
+	// BufferSeconds the time between its oldest and newest frames.
+	BufferFill    float64 `json:"buffer_fill"`
+	BufferSeconds float64 `json:"buffer_seconds"`
+	// BufferFrames is the number of frames in the pre-roll buffer, and
+	// BufferBytes an estimate of the memory they take, at 3 bytes per pixel.
+	BufferFrames int   `json:"buffer_frames"`
+	BufferBytes  int64 `json:"buffer_bytes"`
+
+	// LastFrame is when the latest frame was captured, LastEvent when the
+	// latest event started, if there's been one, and Events the number of
+	// events started.
+	LastFrame *time.Time `json:"last_frame,omitempty"`
+	LastEvent *time.Time `json:"last_event,omitempty"`
+	Events    int64      `json:"events"`
+	// Frames is the number of frames processed, over UptimeSeconds.
+	Frames        int64   `json:"frames"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+
+	// Status is the message at the end of the status line, such as "Ready"
+	// or "Motion detected".
+	Status string `json:"status"`
+
+	// StagesMs is the average time spent in each stage of the capture loop,
+	// with -stage-timing, and Stages the same formatted in the order of the
+	// stages. They're only filled in by Snapshot.
+	StagesMs map[string]float64 `json:"stages_ms,omitempty"`
+	Stages   string             `json:"-"`
+}
+
+// Line returns the HUD status line for the state, including the frames
+// processed and uptime if verbose is set.
+func (s *CameraState) Line(verbose bool) string {
+	msg := s.Status
+	if verbose {
+		uptime := time.Duration(s.UptimeSeconds * float64(time.Second))
+		msg = fmt.Sprintf("[frames=%d up=%v] %s", s.Frames, uptime.Truncate(time.Second), msg)
+	}
+	if s.Tagged {
+		msg = fmt.Sprintf("[%s] %s", s.Camera, msg)
+	}
+	return fmt.Sprintf(
+		"[%dx%d @ %0.1f/%0.1ffps (max %0.0f) p95=%0.0fms] [a=%v d=%v t=%v (%s)]: %s",
+		s.Width, s.Height,
+		s.FPSInstant, s.FPS, s.FPSNominal,
+		s.FrameP95Ms,
+		s.Detector.MinArea, s.Detector.DilateSize, s.Detector.Threshold,
+		s.Selected,
+		msg,
+	)
+}
+
+// StatusReport is the body of /api/status responses, and what SIGUSR1 dumps
+// to the log.
+type StatusReport struct {
+	Version       string        `json:"version"`
+	Time          time.Time     `json:"time"`
+	UptimeSeconds float64       `json:"uptime_seconds"`
+	Cameras       []CameraState `json:"cameras"`
+	Runtime       RuntimeStats  `json:"runtime"`
+}
+
+// QueueStats are the number of notifications or uploads waiting in an
+// OutboundQueue, and the age of the oldest.
+type QueueStats struct {
+	Depth            int     `json:"depth"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
+
+// RuntimeStats are statistics of the program as a whole.
+type RuntimeStats struct {
+	Goroutines int `json:"goroutines"`
+	// HeapBytes is the memory allocated on the heap, and SysBytes all the
+	// memory obtained from the OS, which doesn't include Mats, allocated by
+	// OpenCV.
+	HeapBytes uint64 `json:"heap_bytes"`
+	SysBytes  uint64 `json:"sys_bytes"`
+	GCs       uint32 `json:"gcs"`
+
+	Detections    int64            `json:"detections"`
+	Reconnects    int64            `json:"reconnects"`
+	FramesDropped map[string]int64 `json:"frames_dropped"`
+
+	Queues                  map[string]QueueStats `json:"queues"`
+	NotificationsFailed     map[string]int64      `json:"notifications_failed"`
+	NotificationsSuppressed map[string]int64      `json:"notifications_suppressed"`
+}
+
+// Snapshot returns the state of each camera as of its latest frame, with its
+// stage timings, and statistics of the program as a whole.
+func Snapshot(cams []*Camera) StatusReport {
+	report := StatusReport{
+		Version:       version,
+		Time:          time.Now().UTC(),
+		UptimeSeconds: time.Since(processStart).Seconds(),
+	}
+	for _, c := range cams {
+		s := c.LatestState()
+		if avgs := c.Stages.Averages(); len(avgs) > 0 {
+			s.StagesMs = make(map[string]float64, len(avgs))
+			for name, avg := range avgs {
+				s.StagesMs[name] = avg.Seconds() * 1000
+			}
+			s.Stages = c.Stages.String()
+		}
+		report.Cameras = append(report.Cameras, s)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	report.Runtime = RuntimeStats{
+		Goroutines:              runtime.NumGoroutine(),
+		HeapBytes:               mem.HeapAlloc,
+		SysBytes:                mem.Sys,
+		GCs:                     mem.NumGC,
+		Detections:              detections.Value(),
+		Reconnects:              reconnects.Value(),
+		FramesDropped:           drops.Drops(),
+		Queues:                  make(map[string]QueueStats),
+		NotificationsFailed:     notifyFailures.Drops(),
+		NotificationsSuppressed: notifySuppressed.Drops(),
+	}
+	names, depths, ages := outboundStats()
+	for _, name := range names {
+		report.Runtime.Queues[name] = QueueStats{Depth: depths[name], OldestAgeSeconds: ages[name].Seconds()}
+	}
+	return report
+}
+
+// formatCounts formats counts by name, such as frames dropped by reason, as
+// "a=1 b=2", or "none".
+func formatCounts(counts map[string]int64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	var parts []string
+	for _, name := range sortedKeys(counts) {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// WriteStats writes the report as text, a line at a time.
+func (r *StatusReport) WriteStats(w io.Writer) {
+	rt := r.Runtime
+	uptime := time.Duration(r.UptimeSeconds * float64(time.Second)).Truncate(time.Second)
+	fmt.Fprintf(w, "motiondetect %s, up %v, %d goroutines, heap %0.1fMB, %0.1fMB from the OS, %d GCs\n",
+		r.Version, uptime, rt.Goroutines, float64(rt.HeapBytes)/(1<<20), float64(rt.SysBytes)/(1<<20), rt.GCs)
+	for i := range r.Cameras {
+		s := &r.Cameras[i]
+		fmt.Fprintf(w, "%s: %d frames, %0.1f/%0.1ffps (nominal %0.1f), p95 %0.0fms, %d events, detection %s, %s\n",
+			s.Camera, s.Frames, s.FPSInstant, s.FPS, s.FPSNominal, s.FrameP95Ms, s.Events,
+			onOff(s.DetectionEnabled), s.Status)
+		fmt.Fprintf(w, "%s: buffer %d frames (%0.0f%%, %0.1fs, about %0.1fMB); a=%v d=%v t=%v\n",
+			s.Camera, s.BufferFrames, s.BufferFill*100, s.BufferSeconds, float64(s.BufferBytes)/(1<<20),
+			s.Detector.MinArea, s.Detector.DilateSize, s.Detector.Threshold)
+		if s.Stages != "" {
+			fmt.Fprintf(w, "%s: stages %s\n", s.Camera, s.Stages)
+		}
+	}
+	fmt.Fprintf(w, "detections %d, reconnects %d, frames dropped: %s\n", rt.Detections, rt.Reconnects, formatCounts(rt.FramesDropped))
+	queues := make([]string, 0, len(rt.Queues))
+	for name := range rt.Queues {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+	for i, name := range queues {
+		q := rt.Queues[name]
+		queues[i] = fmt.Sprintf("%s=%d (oldest %v)", name, q.Depth, time.Duration(q.OldestAgeSeconds*float64(time.Second)).Truncate(time.Second))
+	}
+	if len(queues) == 0 {
+		queues = []string{"none"}
+	}
+	fmt.Fprintf(w, "notifications queued: %s; failed: %s; suppressed: %s\n",
+		strings.Join(queues, " "), formatCounts(rt.NotificationsFailed), formatCounts(rt.NotificationsSuppressed))
+}
+
+// LogStats logs a Snapshot of the cameras, a line at a time.
+func LogStats(cams []*Camera) {
+	report := Snapshot(cams)
+	var buf bytes.Buffer
+	report.WriteStats(&buf)
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		logInfo("Stats", "stats", sc.Text())
+	}
+}
+
+// HandleStatus registers /api/status with http.DefaultServeMux, which returns
+// a Snapshot of the cameras, or, with ?format=text, the same as SIGUSR1 dumps
+// to the log.
+func HandleStatus(cams []*Camera) {
+	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		report := Snapshot(cams)
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			report.WriteStats(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
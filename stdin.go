@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"gocv.io/x/gocv"
+)
+
+// RawSource reads raw 8-bit BGR frames ("bgr24" rawvideo) of a fixed size
+// from a stream, such as stdin, e.g. as written by
+//
+//	ffmpeg -i input -f rawvideo -pix_fmt bgr24 -
+//
+// The stream carries no frame size or rate, so they must be given.
+type RawSource struct {
+	r      io.Reader
+	width  int
+	height int
+	fps    float64
+
+	buf   []byte
+	frame gocv.Mat
+	read  int64
+}
+
+// NewRawSource creates a RawSource reading width x height frames from r, at a
+// nominal rate of fps.
+func NewRawSource(r io.Reader, width, height int, fps float64) (*RawSource, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+	buf := make([]byte, width*height*3)
+	// frame shares buf, so each frame only has to be read into buf
+	frame, err := gocv.NewMatFromBytes(height, width, gocv.MatTypeCV8UC3, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &RawSource{r: r, width: width, height: height, fps: fps, buf: buf, frame: frame}, nil
+}
+
+// Read reads the next frame into m, returning false at the end of the stream,
+// or if it fails. A partial frame at the end of the stream means that the
+// frame size or pixel format doesn't match the input, and is reported as such.
+func (s *RawSource) Read(m *gocv.Mat) bool {
+	n, err := io.ReadFull(s.r, s.buf)
+	s.read += int64(n)
+	switch err {
+	case nil:
+	case io.EOF:
+		return false
+	case io.ErrUnexpectedEOF:
+		logError("Input ended with a partial frame; check that the input is bgr24 of the given size",
+			"source", "stdin", "bytes", n, "frame_bytes", len(s.buf), "total_bytes", s.read, "width", s.width, "height", s.height)
+		return false
+	default:
+		logError("Reading frame failed", "source", "stdin", "error", err)
+		return false
+	}
+	s.frame.CopyTo(m)
+	return true
+}
+
+// Get returns the frame size or nominal frame rate, and 0 for any other
+// property.
+func (s *RawSource) Get(prop gocv.VideoCaptureProperties) float64 {
+	switch prop {
+	case gocv.VideoCaptureFrameWidth:
+		return float64(s.width)
+	case gocv.VideoCaptureFrameHeight:
+		return float64(s.height)
+	case gocv.VideoCaptureFPS:
+		return s.fps
+	}
+	return 0
+}
+
+// Close releases the frame buffer. The stream itself is left open.
+func (s *RawSource) Close() error {
+	return s.frame.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"gocv.io/x/gocv"
+)
+
+// stdoutQueue is the number of frames that may be waiting to be written to
+// stdout before frames start being dropped.
+const stdoutQueue = 10
+
+// FrameStreamer writes frames to a stream, such as stdout, in the background,
+// either as raw BGR pixels ("rawvideo") or as concatenated JPEGs ("mjpeg"). If
+// the consumer can't keep up, frames are dropped rather than blocking the
+// caller.
+type FrameStreamer struct {
+	format string
+	w      io.Writer
+	frames chan gocv.Mat
+	done   chan struct{}
+	err    error
+
+	announced bool
+}
+
+// NewFrameStreamer creates a FrameStreamer writing frames in the given format
+// to w.
+func NewFrameStreamer(w io.Writer, format string) (*FrameStreamer, error) {
+	switch format {
+	case "rawvideo", "mjpeg":
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected rawvideo or mjpeg", format)
+	}
+	s := &FrameStreamer{
+		format: format,
+		w:      w,
+		frames: make(chan gocv.Mat, stdoutQueue),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write queues a copy of the given 8-bit BGR frame to be written. If the queue
+// is full, or writing has failed, the frame is dropped. The format and
+// dimensions of the stream are logged when the first frame is written.
+func (s *FrameStreamer) Write(img gocv.Mat) {
+	if !s.announced {
+		pixFmt := "bgr24"
+		if s.format == "mjpeg" {
+			pixFmt = "yuvj420p"
+		}
+		logInfo("Writing frames to stdout", "format", s.format, "pix_fmt", pixFmt, "width", img.Cols(), "height", img.Rows())
+		s.announced = true
+	}
+	m := img.Clone()
+	select {
+	case s.frames <- m:
+	default:
+		m.Close()
+		drops.Drop("stdout")
+	}
+}
+
+// Close waits for queued frames to be written.
+func (s *FrameStreamer) Close() {
+	close(s.frames)
+	<-s.done
+}
+
+func (s *FrameStreamer) run() {
+	defer close(s.done)
+	for img := range s.frames {
+		if s.err == nil {
+			if s.err = s.write(img); s.err != nil {
+				logError("Writing frames to stdout failed, dropping the rest", "error", s.err)
+			}
+		} else {
+			drops.Drop("stdout")
+		}
+		img.Close()
+	}
+}
+
+func (s *FrameStreamer) write(img gocv.Mat) error {
+	if s.format == "rawvideo" {
+		_, err := s.w.Write(img.ToBytes())
+		return err
+	}
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+	if err != nil {
+		return err
+	}
+	defer buf.Close()
+	_, err = s.w.Write(buf.GetBytes())
+	return err
+}
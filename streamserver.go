@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// mjpegBoundary separates the parts of an MJPEG stream.
+const mjpegBoundary = "motiondetectframe"
+
+// MJPEGStreamer serves the live view to browsers as an MJPEG stream. Frames
+// are encoded in the background, and only while someone is watching; clients
+// that can't keep up miss frames rather than holding up the others, or the
+// camera.
+type MJPEGStreamer struct {
+	// Quality is the JPEG quality of the stream, from 0 to 100.
+	Quality int
+	// Scale, if between 0 and 1, scales frames down before they're encoded.
+	Scale float64
+
+	frames chan gocv.Mat
+	done   chan struct{}
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+// NewMJPEGStreamer creates an MJPEGStreamer encoding frames with the given
+// quality and scale.
+func NewMJPEGStreamer(quality int, scale float64) *MJPEGStreamer {
+	s := &MJPEGStreamer{
+		Quality: quality,
+		Scale:   scale,
+		frames:  make(chan gocv.Mat, 1),
+		done:    make(chan struct{}),
+		clients: make(map[chan []byte]struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Watching returns whether any clients are connected.
+func (s *MJPEGStreamer) Watching() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients) > 0
+}
+
+// Write queues a copy of the given frame to be sent to every client. If no
+// clients are connected, it does nothing, and if the previous frame is still
+// being encoded, the frame is dropped.
+func (s *MJPEGStreamer) Write(img gocv.Mat) {
+	if !s.Watching() {
+		return
+	}
+	m := img.Clone()
+	select {
+	case s.frames <- m:
+	default:
+		m.Close()
+		drops.Drop("mjpeg")
+	}
+}
+
+// Close stops encoding frames, and disconnects all clients.
+func (s *MJPEGStreamer) Close() {
+	close(s.frames)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		close(ch)
+		delete(s.clients, ch)
+	}
+}
+
+func (s *MJPEGStreamer) run() {
+	defer close(s.done)
+	for img := range s.frames {
+		if s.Scale > 0 && s.Scale < 1 {
+			gocv.Resize(img, &img, image.Point{}, s.Scale, s.Scale, gocv.InterpolationArea)
+		}
+		buf, err := gocv.IMEncodeWithParams(gocv.JPEGFileExt, img, []int{gocv.IMWriteJpegQuality, s.Quality})
+		img.Close()
+		if err != nil {
+			logError("Encoding MJPEG frame failed", "error", err)
+			continue
+		}
+		// the bytes are shared by all the clients, so copy them out of the
+		// encoder's buffer
+		frame := append([]byte(nil), buf.GetBytes()...)
+		buf.Close()
+		s.broadcast(frame)
+	}
+}
+
+// broadcast sends frame to every client that has finished sending the last
+// one, and drops it for the rest.
+func (s *MJPEGStreamer) broadcast(frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		select {
+		case ch <- frame:
+		default:
+			drops.Drop("mjpeg")
+		}
+	}
+}
+
+// ServeHTTP streams frames to the client as multipart/x-mixed-replace, until
+// it disconnects or the streamer is closed.
+func (s *MJPEGStreamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case frame, ok := <-ch:
+			if !ok {
+				return
+			}
+			_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = io.WriteString(w, "\r\n")
+			}
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// HandleStream registers s at /stream with http.DefaultServeMux.
+func HandleStream(s *MJPEGStreamer) {
+	http.Handle("/stream", s)
+}
+
+// HandleSnapshot registers /snapshot.jpg with http.DefaultServeMux, serving
+// the camera's latest live view as a JPEG, encoded on request. The query
+// parameter width scales it down, and clean=1 serves the frame before any
+// markup instead, if the camera keeps it (see Camera.Feed).
+func HandleSnapshot(c *Camera, quality int) {
+	http.HandleFunc("/snapshot.jpg", func(w http.ResponseWriter, r *http.Request) {
+		width := 0
+		if v := r.URL.Query().Get("width"); v != "" {
+			var err error
+			if width, err = strconv.Atoi(v); err != nil || width <= 0 {
+				http.Error(w, "invalid width", http.StatusBadRequest)
+				return
+			}
+		}
+		img := gocv.NewMat()
+		defer img.Close()
+		var ok bool
+		if r.URL.Query().Get("clean") == "1" {
+			if !c.Feed {
+				http.Error(w, "clean frames aren't kept", http.StatusNotFound)
+				return
+			}
+			_, ok = c.Frame(&img, -1)
+		} else {
+			_, ok = c.View(&img, -1)
+		}
+		if !ok {
+			http.Error(w, "no frame captured yet", http.StatusServiceUnavailable)
+			return
+		}
+		if width > 0 && width < img.Cols() {
+			scale := float64(width) / float64(img.Cols())
+			gocv.Resize(img, &img, image.Point{}, scale, scale, gocv.InterpolationArea)
+		}
+		buf, err := gocv.IMEncodeWithParams(gocv.JPEGFileExt, img, []int{gocv.IMWriteJpegQuality, quality})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer buf.Close()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.GetBytes())
+	})
+}
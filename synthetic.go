@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// syntheticObject is a shape that moves back and forth across a synthetic
+// source's frames.
+type syntheticObject struct {
+	// Circle draws a circle rather than a square.
+	Circle bool
+	// Size is the width and height in pixels.
+	Size int
+	// Speed is in pixels per second.
+	Speed float64
+	// Move and Every, if set, make the object only move for Move at the end
+	// of every period of Every, and stay still otherwise.
+	Move, Every time.Duration
+}
+
+// travelled returns how far the object has moved by time t since the start.
+func (o syntheticObject) travelled(t time.Duration) float64 {
+	if o.Every <= 0 {
+		return o.Speed * t.Seconds()
+	}
+	periods := t / o.Every
+	moved := time.Duration(periods) * o.Move
+	if into := t % o.Every; into > o.Every-o.Move {
+		moved += into - (o.Every - o.Move)
+	}
+	return o.Speed * moved.Seconds()
+}
+
+// parseSyntheticObjects parses a comma-separated list of objects, each given
+// as shape:size:speed[:move/every], e.g. "rect:60:120:3s/20s" for a 60 pixel
+// square moving at 120 pixels per second for 3s every 20s.
+func parseSyntheticObjects(s string) ([]syntheticObject, error) {
+	var objects []syntheticObject
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid object %q, expected shape:size:speed[:move/every]", spec)
+		}
+		var o syntheticObject
+		switch parts[0] {
+		case "rect":
+		case "circle":
+			o.Circle = true
+		default:
+			return nil, fmt.Errorf("unknown shape %q, expected rect or circle", parts[0])
+		}
+		var err error
+		if o.Size, err = strconv.Atoi(parts[1]); err != nil || o.Size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", parts[1])
+		}
+		if o.Speed, err = strconv.ParseFloat(parts[2], 64); err != nil || o.Speed < 0 {
+			return nil, fmt.Errorf("invalid speed %q", parts[2])
+		}
+		if len(parts) == 4 {
+			sched := strings.Split(parts[3], "/")
+			if len(sched) != 2 {
+				return nil, fmt.Errorf("invalid schedule %q, expected move/every", parts[3])
+			}
+			if o.Move, err = time.ParseDuration(sched[0]); err != nil {
+				return nil, err
+			}
+			if o.Every, err = time.ParseDuration(sched[1]); err != nil {
+				return nil, err
+			}
+			if o.Move <= 0 || o.Every < o.Move {
+				return nil, fmt.Errorf("invalid schedule %q: must move for a positive time no longer than the period", parts[3])
+			}
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// SyntheticSource generates frames of objects moving over a plain background,
+// for trying things out, and testing, without a camera. Each object moves
+// back and forth in its own horizontal lane. Frames are generated at a fixed
+// rate, and their content depends only on their position in the sequence, so
+// runs are repeatable.
+type SyntheticSource struct {
+	width      int
+	height     int
+	fps        float64
+	background color.RGBA
+	objects    []syntheticObject
+	// frames is the number of frames to generate, or 0 for no limit.
+	frames int
+	// paced generates frames in real time, rather than as fast as possible.
+	paced bool
+
+	n     int
+	start time.Time
+}
+
+// NewSyntheticSource creates a SyntheticSource of the given size and rate.
+func NewSyntheticSource(width, height int, fps float64, background color.RGBA, objects []syntheticObject, duration time.Duration, paced bool) (*SyntheticSource, error) {
+	if width <= 0 || height <= 0 || fps <= 0 {
+		return nil, fmt.Errorf("invalid size %dx%d @ %vfps", width, height, fps)
+	}
+	return &SyntheticSource{
+		width:      width,
+		height:     height,
+		fps:        fps,
+		background: background,
+		objects:    objects,
+		frames:     int(duration.Seconds() * fps),
+		paced:      paced,
+	}, nil
+}
+
+// elapsed returns the time of the nth frame since the first.
+func (s *SyntheticSource) elapsed(n int) time.Duration {
+	return time.Duration(float64(n) / s.fps * float64(time.Second))
+}
+
+// Read generates the next frame into m, waiting for its time if paced. It
+// returns false once the set number of frames have been generated.
+func (s *SyntheticSource) Read(m *gocv.Mat) bool {
+	if s.frames > 0 && s.n >= s.frames {
+		return false
+	}
+	if s.n == 0 {
+		s.start = time.Now()
+	}
+	t := s.elapsed(s.n)
+	if s.paced {
+		time.Sleep(time.Until(s.start.Add(t)))
+	}
+
+	if m.Empty() || m.Cols() != s.width || m.Rows() != s.height || m.Type() != gocv.MatTypeCV8UC3 {
+		m.Close()
+		*m = gocv.NewMatWithSize(s.height, s.width, gocv.MatTypeCV8UC3)
+	}
+	bg := s.background
+	m.SetTo(gocv.NewScalar(float64(bg.B), float64(bg.G), float64(bg.R), 0))
+
+	lane := s.height / (len(s.objects) + 1)
+	for i, o := range s.objects {
+		// bounce between the edges
+		span := float64(s.width - o.Size)
+		x := 0.0
+		if span > 0 {
+			x = o.travelled(t)
+			for x > 2*span {
+				x -= 2 * span
+			}
+			if x > span {
+				x = 2*span - x
+			}
+		}
+		topLeft := image.Pt(int(x), lane*(i+1)-o.Size/2)
+		r := image.Rectangle{Min: topLeft, Max: topLeft.Add(image.Pt(o.Size, o.Size))}
+		c := color.RGBA{255, 255, 255, 0}
+		if o.Circle {
+			gocv.Circle(m, r.Min.Add(image.Pt(o.Size/2, o.Size/2)), o.Size/2, c, -1)
+		} else {
+			gocv.Rectangle(m, r, c, -1)
+		}
+	}
+	s.n++
+	return true
+}
+
+// Get returns the frame size and rate, or the time of the last frame for
+// VideoCapturePosMsec, and 0 for any other property.
+func (s *SyntheticSource) Get(prop gocv.VideoCaptureProperties) float64 {
+	switch prop {
+	case gocv.VideoCaptureFrameWidth:
+		return float64(s.width)
+	case gocv.VideoCaptureFrameHeight:
+		return float64(s.height)
+	case gocv.VideoCaptureFPS:
+		return s.fps
+	case gocv.VideoCapturePosMsec:
+		if s.n == 0 {
+			return 0
+		}
+		return s.elapsed(s.n-1).Seconds() * 1000
+	case gocv.VideoCaptureFrameCount:
+		return float64(s.frames)
+	}
+	return 0
+}
+
+// Close does nothing, as there's nothing to release.
+func (s *SyntheticSource) Close() error {
+	return nil
+}
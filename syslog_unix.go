@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log/syslog"
+)
+
+// syslogSink writes lines to the local syslog daemon, at the priority of their
+// level.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (logSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "motiondetect")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) WriteLine(level LogLevel, line []byte) error {
+	switch level {
+	case LevelDebug:
+		return s.w.Debug(string(line))
+	case LevelWarn:
+		return s.w.Warning(string(line))
+	case LevelError:
+		return s.w.Err(string(line))
+	default:
+		return s.w.Info(string(line))
+	}
+}
+
+func (s *syslogSink) Timestamps() bool { return false }
+
+func (s *syslogSink) Close() error { return s.w.Close() }
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+)
+
+// newSyslogSink fails, as there's no syslog on Windows.
+func newSyslogSink() (logSink, error) {
+	return nil, errors.New("syslog not available on windows")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// telegramAPI is the base URL of the Telegram bot API.
+	telegramAPI = "https://api.telegram.org/bot"
+	// telegramMaxUpload is the largest file bots may upload.
+	telegramMaxUpload = 50 << 20
+	// telegramAttempts is how many times sending a message is attempted.
+	// Snapshots and clips are saved in the background, so the first attempts
+	// may find they don't exist yet.
+	telegramAttempts = 6
+	// telegramTimeout is how long each attempt may take, which is long enough
+	// to upload a clip.
+	telegramTimeout = 2 * time.Minute
+)
+
+// Telegram sends events to a Telegram chat through a bot, using a
+// NotifyQueue. When an event starts it sends a summary, with the event's
+// snapshot if it has one. If OnEnd is set, it also sends the clip when the
+// event ends, or its thumbnail and path if the clip is too big to upload. At
+// most one start is sent per camera per cooldown. A nil *Telegram does
+// nothing.
+type Telegram struct {
+	// Token is the bot's token.
+	Token string
+	// ChatID identifies the chat to send to.
+	ChatID string
+	// OnEnd also sends clips when events end.
+	OnEnd bool
+
+	client   *http.Client
+	queue    *NotifyQueue
+	cooldown eventCooldown
+}
+
+// NewTelegram creates a Telegram sending to the given chat with the bot with
+// the given token, and at most one event per camera per cooldown.
+func NewTelegram(token, chatID string, cooldown time.Duration) *Telegram {
+	return &Telegram{
+		Token:    token,
+		ChatID:   chatID,
+		client:   &http.Client{Timeout: telegramTimeout},
+		queue:    NewNotifyQueue("telegram", telegramAttempts),
+		cooldown: eventCooldown{Cooldown: cooldown},
+	}
+}
+
+// Notify queues a message about the event, in the given phase. It never
+// blocks.
+func (t *Telegram) Notify(ev *MotionEvent, phase string) {
+	if t == nil {
+		return
+	}
+	desc := fmt.Sprintf("event %d", ev.ID)
+	if phase == PhaseStart {
+		if !t.cooldown.Start(ev) {
+			return
+		}
+		caption := fmt.Sprintf("Motion on %s at %s", ev.Camera, ev.Start.Format("15:04:05"))
+		if len(ev.Zones) > 0 {
+			caption += " in " + strings.Join(ev.Zones, ", ")
+		}
+		caption += suppressedNote(ev.Suppressed)
+		snapshot := ev.Snapshot
+		t.queue.Send(desc, func() (bool, error) {
+			if snapshot == "" {
+				return t.send("sendMessage", map[string]string{"text": caption}, "", "")
+			}
+			return t.send("sendPhoto", map[string]string{"caption": caption}, "photo", snapshot)
+		})
+		return
+	}
+
+	if !t.cooldown.End(ev) || !t.OnEnd || ev.Clip == "" {
+		return
+	}
+	var (
+		clip      = ev.Clip
+		thumbnail = ev.Thumbnail
+		caption   = fmt.Sprintf("Motion on %s for %v", ev.Camera, ev.Duration().Round(time.Second))
+	)
+	t.queue.Send(desc+" clip", func() (bool, error) {
+		info, err := os.Stat(clip)
+		if err != nil {
+			// the clip may still be being saved
+			return true, err
+		}
+		if info.Size() <= telegramMaxUpload {
+			return t.send("sendVideo", map[string]string{"caption": caption}, "video", clip)
+		}
+		caption += fmt.Sprintf("\nThe clip is too big to send (%0.0fMB): %s", float64(info.Size())/(1<<20), clip)
+		if thumbnail == "" {
+			return t.send("sendMessage", map[string]string{"text": caption}, "", "")
+		}
+		return t.send("sendPhoto", map[string]string{"caption": caption}, "photo", thumbnail)
+	})
+}
+
+// NotifyDigest queues a message summarizing d. It never blocks.
+func (t *Telegram) NotifyDigest(d *Digest) {
+	if t == nil {
+		return
+	}
+	text := "Motion: " + d.Summary()
+	t.queue.Send("digest", func() (bool, error) {
+		return t.send("sendMessage", map[string]string{"text": text}, "", "")
+	})
+}
+
+// Close waits for queued messages to be sent.
+func (t *Telegram) Close() {
+	if t == nil {
+		return
+	}
+	t.queue.Close()
+}
+
+// send calls the given bot API method once with the given fields, uploading
+// the named file as field fileField if set, and returns whether it's worth
+// retrying if it fails.
+func (t *Telegram) send(method string, fields map[string]string, fileField, filename string) (bool, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", t.ChatID)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if fileField != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			// it may still be being saved
+			return true, err
+		}
+		defer f.Close()
+		part, err := mw.CreateFormFile(fileField, filepath.Base(filename))
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(part, f); err != nil {
+			return true, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return false, err
+	}
+
+	resp, err := t.client.Post(telegramAPI+t.Token+"/"+method, mw.FormDataContentType(), &body)
+	if err != nil {
+		// the error includes the URL, and so the token
+		return true, fmt.Errorf("%s failed: %v", method, strings.Replace(err.Error(), t.Token, "<token>", -1))
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return true, fmt.Errorf("%s failed: %s", method, resp.Status)
+	}
+	if !result.OK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+		return retry, fmt.Errorf("%s failed: %s", method, result.Description)
+	}
+	return false, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputTemplate is a parsed output filename template. Templates may contain
+// strftime-style directives, which are expanded using the event's start time,
+// and the following named placeholders:
+//
+//	{camera}    the camera name
+//	{seq}       the event sequence number
+//	{part}      the segment number within the event, starting from 1
+//	{duration}  the event duration in whole seconds
+//
+// For example, "events/%Y%m%d/%H%M%S_{camera}.mp4". Templates of messages
+// about events, parsed by ParseEventTemplate, may also contain:
+//
+//	{phase}     the phase of the event: start or end
+//	{area}      the event's peak area of motion so far, in pixels
+type OutputTemplate struct {
+	text  string
+	parts []templatePart
+}
+
+// TemplateFields are the values substituted into an OutputTemplate.
+type TemplateFields struct {
+	Camera   string
+	Seq      int
+	Part     int
+	Start    time.Time
+	Duration time.Duration
+	// Phase and PeakArea are only used by event templates.
+	Phase    string
+	PeakArea float64
+}
+
+type templatePart struct {
+	literal     string
+	directive   byte
+	placeholder string
+}
+
+var templatePlaceholders = map[string]bool{
+	"camera":   true,
+	"seq":      true,
+	"part":     true,
+	"duration": true,
+}
+
+// eventPlaceholders are the placeholders of event templates, in addition to
+// templatePlaceholders.
+var eventPlaceholders = map[string]bool{
+	"phase": true,
+	"area":  true,
+}
+
+// ParseOutputTemplate parses the given template, returning an error if it
+// contains unknown directives or placeholders.
+func ParseOutputTemplate(text string) (*OutputTemplate, error) {
+	return parseTemplate(text, false)
+}
+
+// ParseEventTemplate parses the template of a message about an event, which
+// may also contain {phase} and {area}.
+func ParseEventTemplate(text string) (*OutputTemplate, error) {
+	return parseTemplate(text, true)
+}
+
+func parseTemplate(text string, event bool) (*OutputTemplate, error) {
+	if text == "" {
+		return nil, fmt.Errorf("empty template")
+	}
+	t := &OutputTemplate{text: text}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '%':
+			if i+1 >= len(text) {
+				return nil, fmt.Errorf("template %q ends with %%", text)
+			}
+			i++
+			d := text[i]
+			if d == '%' {
+				lit.WriteByte('%')
+				continue
+			}
+			if !strings.ContainsRune("YymdHMSjbaZs", rune(d)) {
+				return nil, fmt.Errorf("template %q has unknown directive %%%c", text, d)
+			}
+			flush()
+			t.parts = append(t.parts, templatePart{directive: d})
+		case '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("template %q has unterminated {", text)
+			}
+			name := text[i+1 : i+end]
+			if !templatePlaceholders[name] && !(event && eventPlaceholders[name]) {
+				return nil, fmt.Errorf("template %q has unknown placeholder {%s}", text, name)
+			}
+			flush()
+			t.parts = append(t.parts, templatePart{placeholder: name})
+			i += end
+		case '}':
+			return nil, fmt.Errorf("template %q has unmatched }", text)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return t, nil
+}
+
+// String returns the unexpanded template.
+func (t *OutputTemplate) String() string {
+	return t.text
+}
+
+// Has returns whether the template contains the named placeholder.
+func (t *OutputTemplate) Has(placeholder string) bool {
+	for _, p := range t.parts {
+		if p.placeholder == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// WithCamera returns the template, with "_{camera}" added before the extension
+// if it doesn't already contain {camera}, so that each camera gets its own
+// files.
+func (t *OutputTemplate) WithCamera() (*OutputTemplate, error) {
+	if t.Has("camera") {
+		return t, nil
+	}
+	ext := filepath.Ext(t.text)
+	return ParseOutputTemplate(strings.TrimSuffix(t.text, ext) + "_{camera}" + ext)
+}
+
+// Expand returns the template with all directives and placeholders replaced
+// by the given values.
+func (t *OutputTemplate) Expand(f TemplateFields) string {
+	var sb strings.Builder
+	for _, p := range t.parts {
+		switch {
+		case p.directive != 0:
+			sb.WriteString(strftime(p.directive, f.Start))
+		case p.placeholder != "":
+			switch p.placeholder {
+			case "camera":
+				sb.WriteString(sanitizeFilename(f.Camera))
+			case "seq":
+				sb.WriteString(strconv.Itoa(f.Seq))
+			case "part":
+				sb.WriteString(strconv.Itoa(f.Part))
+			case "duration":
+				sb.WriteString(strconv.Itoa(int(f.Duration.Seconds())))
+			case "phase":
+				sb.WriteString(f.Phase)
+			case "area":
+				sb.WriteString(strconv.Itoa(int(f.PeakArea)))
+			}
+		default:
+			sb.WriteString(p.literal)
+		}
+	}
+	return sb.String()
+}
+
+func strftime(d byte, t time.Time) string {
+	switch d {
+	case 'Y':
+		return t.Format("2006")
+	case 'y':
+		return t.Format("06")
+	case 'm':
+		return t.Format("01")
+	case 'd':
+		return t.Format("02")
+	case 'H':
+		return t.Format("15")
+	case 'M':
+		return t.Format("04")
+	case 'S':
+		return t.Format("05")
+	case 'j':
+		return fmt.Sprintf("%03d", t.YearDay())
+	case 'b':
+		return t.Format("Jan")
+	case 'a':
+		return t.Format("Mon")
+	case 'Z':
+		return t.Format("MST")
+	case 's':
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return ""
+}
+
+// sanitizeFilename replaces characters that are unsafe in a filename (such as
+// the slashes and colons in a device path or URL) with underscores.
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-' || r == '_' || r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
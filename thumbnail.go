@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// ThumbnailPath returns the path of the thumbnail for the given clip, which is
+// the clip's path with a ".jpg" extension.
+func ThumbnailPath(clip string) string {
+	return strings.TrimSuffix(clip, filepath.Ext(clip)) + ".jpg"
+}
+
+// Thumbnailer generates poster thumbnails for saved clips.
+type Thumbnailer struct {
+	// Width is the width of thumbnails; their height preserves the aspect
+	// ratio of the clip. Frames narrower than Width aren't scaled up.
+	Width int
+	// Overlay burns the clip's start time and duration into the thumbnail.
+	Overlay bool
+}
+
+// Generate reads the given frame of the clip and saves it, scaled down, to the
+// clip's ThumbnailPath. start and duration are used for the overlay.
+func (t *Thumbnailer) Generate(clip string, frame int, start time.Time, duration time.Duration) error {
+	vc, err := gocv.VideoCaptureFile(clip)
+	if err != nil {
+		return fmt.Errorf("opening %s failed: %w", clip, err)
+	}
+	defer vc.Close()
+
+	vc.Set(gocv.VideoCapturePosFrames, float64(frame))
+	img := gocv.NewMat()
+	defer img.Close()
+	if !vc.Read(&img) || img.Empty() {
+		return fmt.Errorf("reading frame %d of %s failed", frame, clip)
+	}
+
+	if t.Width > 0 && img.Cols() > t.Width {
+		height := img.Rows() * t.Width / img.Cols()
+		gocv.Resize(img, &img, image.Pt(t.Width, height), 0, 0, gocv.InterpolationArea)
+	}
+	if t.Overlay {
+		s := fmt.Sprintf("%s (%v)", start.Format("2006-01-02 15:04:05"), duration.Round(time.Second))
+		gocv.PutText(&img, s, image.Pt(5, img.Rows()-8), gocv.FontHersheyPlain, 1, green, 1)
+	}
+
+	filename := ThumbnailPath(clip)
+	if !gocv.IMWrite(filename, img) {
+		return fmt.Errorf("writing %s failed", filename)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+)
+
+// DefaultOSCArgs are the event fields sent as OSC arguments by default.
+const DefaultOSCArgs = "camera,seq,active,area"
+
+// oscArgTypes are the event fields that may be sent as OSC arguments, and
+// their OSC type tags.
+var oscArgTypes = map[string]byte{
+	"camera":   's',
+	"seq":      'i',
+	"phase":    's',
+	"active":   'i',
+	"area":     'f',
+	"duration": 'i',
+	"time":     'i',
+	"zones":    's',
+}
+
+// UDPTrigger sends a UDP packet to each of its targets as each event starts
+// and ends, for lighting, AV and relay controllers. The packet is either an
+// OSC message, to an address expanded from an event template, with event
+// fields as its arguments, or a raw payload expanded from an event template.
+// Packets are sent without waiting for, or expecting, any reply. A nil
+// *UDPTrigger does nothing.
+type UDPTrigger struct {
+	// Targets are the addresses packets are sent to.
+	Targets []*net.UDPAddr
+	// OSCAddress, if set, is the address pattern of OSC messages, such as
+	// "/motion/{camera}".
+	OSCAddress *OutputTemplate
+	// OSCArgs are the event fields sent as the messages' arguments, each a
+	// key of oscArgTypes.
+	OSCArgs []string
+	// Payload is the raw payload sent if OSCAddress isn't set.
+	Payload *OutputTemplate
+
+	conn *net.UDPConn
+}
+
+// NewUDPTrigger creates a UDPTrigger sending to targets, a comma-separated
+// list of host:port addresses, either OSC messages to oscAddress with the
+// comma-separated oscArgs, or, if oscAddress is empty, payload.
+func NewUDPTrigger(targets, oscAddress, oscArgs, payload string) (*UDPTrigger, error) {
+	t := &UDPTrigger{}
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			return nil, err
+		}
+		t.Targets = append(t.Targets, addr)
+	}
+	if len(t.Targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	var err error
+	switch {
+	case oscAddress != "":
+		if !strings.HasPrefix(oscAddress, "/") {
+			return nil, fmt.Errorf("OSC address %q must start with /", oscAddress)
+		}
+		if t.OSCAddress, err = ParseEventTemplate(oscAddress); err != nil {
+			return nil, err
+		}
+		for _, arg := range strings.Split(oscArgs, ",") {
+			arg = strings.TrimSpace(arg)
+			if arg == "" {
+				continue
+			}
+			if _, ok := oscArgTypes[arg]; !ok {
+				return nil, fmt.Errorf("unknown OSC argument %q", arg)
+			}
+			t.OSCArgs = append(t.OSCArgs, arg)
+		}
+	case payload != "":
+		if t.Payload, err = ParseEventTemplate(payload); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("either an OSC address or a payload is needed")
+	}
+	if t.conn, err = net.ListenUDP("udp", nil); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Notify sends the packet for the event, in the given phase, to each target.
+// It never blocks.
+func (t *UDPTrigger) Notify(ev *MotionEvent, phase string) {
+	if t == nil {
+		return
+	}
+	fields := TemplateFields{
+		Camera:   ev.Camera,
+		Seq:      ev.ID,
+		Part:     1,
+		Start:    ev.Start,
+		Phase:    phase,
+		PeakArea: ev.PeakArea,
+	}
+	if !ev.End.IsZero() {
+		fields.Duration = ev.End.Sub(ev.Start)
+	}
+	var packet []byte
+	if t.OSCAddress != nil {
+		packet = t.oscMessage(ev, fields)
+	} else {
+		packet = []byte(t.Payload.Expand(fields))
+	}
+	for _, addr := range t.Targets {
+		// UDP sends don't wait for the receiver, so this can't block
+		if _, err := t.conn.WriteToUDP(packet, addr); err != nil {
+			logError("Sending trigger failed", "camera", ev.Camera, "event_id", ev.ID, "target", addr, "error", err)
+			notifyFailures.Drop("udp")
+		}
+	}
+}
+
+// Close closes the socket packets are sent from.
+func (t *UDPTrigger) Close() {
+	if t == nil {
+		return
+	}
+	t.conn.Close()
+}
+
+// oscMessage encodes the OSC message for an event.
+func (t *UDPTrigger) oscMessage(ev *MotionEvent, f TemplateFields) []byte {
+	var buf bytes.Buffer
+	writeOSCString(&buf, t.OSCAddress.Expand(f))
+	tags := []byte{','}
+	for _, arg := range t.OSCArgs {
+		tags = append(tags, oscArgTypes[arg])
+	}
+	writeOSCString(&buf, string(tags))
+	for _, arg := range t.OSCArgs {
+		switch arg {
+		case "camera":
+			writeOSCString(&buf, ev.Camera)
+		case "seq":
+			binary.Write(&buf, binary.BigEndian, int32(ev.ID))
+		case "phase":
+			writeOSCString(&buf, f.Phase)
+		case "active":
+			active := int32(0)
+			if f.Phase == PhaseStart {
+				active = 1
+			}
+			binary.Write(&buf, binary.BigEndian, active)
+		case "area":
+			binary.Write(&buf, binary.BigEndian, math.Float32bits(float32(ev.PeakArea)))
+		case "duration":
+			binary.Write(&buf, binary.BigEndian, int32(f.Duration.Seconds()))
+		case "time":
+			binary.Write(&buf, binary.BigEndian, int32(ev.Start.Unix()))
+		case "zones":
+			writeOSCString(&buf, strings.Join(ev.Zones, ","))
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeOSCString writes an OSC string: null terminated, and padded with nulls
+// to a multiple of 4 bytes.
+func writeOSCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.Write(make([]byte, 4-len(s)%4))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// keyBinding is one or more keys, and what pressing them does, as listed by
+// the help overlay, so that it's always up to date.
+type keyBinding struct {
+	Keys []rune
+	// Label, if set, is shown for the keys instead of the keys themselves.
+	Label string
+	Help  string
+	// Value, if set, returns the current value of the setting the keys
+	// change, from the focused camera's state.
+	Value func(s *CameraState) string
+	// Multi is set for keys that only do anything with several cameras.
+	Multi bool
+
+	// UI handles the keys in the UI, or, if it's nil, Camera handles them on
+	// the focused camera's capture loop.
+	UI     func(u *UI, k rune)
+	Camera func(c *Camera, k rune)
+}
+
+// keyBindings are the keys handled by the UI and cameras.
+var keyBindings = []keyBinding{
+	{Keys: []rune{'h', '?'}, Help: "show or hide this help", UI: func(u *UI, k rune) {
+		u.help = !u.help
+	}},
+	{Keys: []rune{'f'}, Help: "toggle fullscreen", UI: func(u *UI, k rune) {
+		u.display.SetFullscreen(!u.display.Fullscreen)
+	}},
+	{Keys: []rune{'\t'}, Help: "focus the next camera", Multi: true, UI: func(u *UI, k rune) {
+		u.focus = (u.focus + 1) % len(u.cams)
+	}},
+	{Keys: []rune("123456789"), Label: "1-9", Help: "focus that camera", Multi: true, UI: func(u *UI, k rune) {
+		if i := int(k - '1'); i < len(u.cams) {
+			u.focus = i
+		}
+	}},
+	{Keys: []rune{3}, Help: "quit", UI: func(u *UI, k rune) {
+		Quit()
+	}},
+
+	{Keys: []rune{'m'}, Help: "toggle motion detection", Value: func(s *CameraState) string {
+		return onOff(s.DetectionEnabled)
+	}, Camera: func(c *Camera, k rune) {
+		c.DetectionEnabled = !c.DetectionEnabled
+	}},
+	{Keys: []rune{'a'}, Help: "select the minimum area of motion", Value: func(s *CameraState) string {
+		return fmt.Sprint(s.Detector.MinArea)
+	}, Camera: func(c *Camera, k rune) {
+		c.FieldChanged = k
+	}},
+	{Keys: []rune{'d'}, Help: "select the dilate size", Value: func(s *CameraState) string {
+		return fmt.Sprint(s.Detector.DilateSize)
+	}, Camera: func(c *Camera, k rune) {
+		c.FieldChanged = k
+	}},
+	{Keys: []rune{'t'}, Help: "select the threshold", Value: func(s *CameraState) string {
+		return fmt.Sprint(s.Detector.Threshold)
+	}, Camera: func(c *Camera, k rune) {
+		c.FieldChanged = k
+	}},
+	{Keys: []rune{'-', '='}, Help: "decrease or increase the selected setting", Value: func(s *CameraState) string {
+		return s.Selected
+	}, Camera: func(c *Camera, k rune) {
+		if k == '-' {
+			c.adjustSelected(-1)
+		} else {
+			c.adjustSelected(1)
+		}
+	}},
+	{Keys: []rune{'c'}, Help: "toggle drawing contours", Value: func(s *CameraState) string {
+		return onOff(s.Detector.DrawContours)
+	}, Camera: func(c *Camera, k rune) {
+		c.Detector.DrawContours = !c.Detector.DrawContours
+	}},
+	{Keys: []rune{'r'}, Help: "toggle drawing rectangles", Value: func(s *CameraState) string {
+		return onOff(s.Detector.DrawRects)
+	}, Camera: func(c *Camera, k rune) {
+		c.Detector.DrawRects = !c.Detector.DrawRects
+	}},
+	{Keys: []rune{'l'}, Help: "toggle marking up detections in the live view", Value: func(s *CameraState) string {
+		return onOff(s.DrawLive)
+	}, Camera: func(c *Camera, k rune) {
+		c.DrawLive = !c.DrawLive
+	}},
+	{Keys: []rune{'k'}, Help: "toggle marking up detections in recordings", Value: func(s *CameraState) string {
+		return onOff(s.DrawRecord)
+	}, Camera: func(c *Camera, k rune) {
+		c.DrawRecord = !c.DrawRecord
+	}},
+	{Keys: []rune{'L'}, Help: "toggle the HUD in the live view", Value: func(s *CameraState) string {
+		return onOff(s.HUDLive)
+	}, Camera: func(c *Camera, k rune) {
+		c.HUDLive = !c.HUDLive
+	}},
+	{Keys: []rune{'K'}, Help: "toggle the HUD in recordings", Value: func(s *CameraState) string {
+		return onOff(s.HUDRecord)
+	}, Camera: func(c *Camera, k rune) {
+		c.HUDRecord = !c.HUDRecord
+	}},
+	{Keys: []rune{'o'}, Help: "cycle the HUD through full, minimal and off", Value: func(s *CameraState) string {
+		return s.HUD
+	}, Camera: func(c *Camera, k rune) {
+		c.cycleHUD()
+	}},
+	{Keys: []rune{'s'}, Help: "save the buffer", Camera: func(c *Camera, k rune) {
+		c.saveBuffer()
+	}},
+	{Keys: []rune{'v'}, Help: "start or stop a manual recording", Camera: func(c *Camera, k rune) {
+		c.toggleManual()
+	}},
+	{Keys: []rune{'R'}, Help: "rotate frames by 90 degrees", Value: func(s *CameraState) string {
+		return fmt.Sprint(s.Rotate)
+	}, Camera: func(c *Camera, k rune) {
+		c.rotate((c.Rotate + 90) % 360)
+	}},
+	{Keys: []rune{'[', ']'}, Help: "decrease or increase the exposure of devices", Camera: func(c *Camera, k rune) {
+		dir := 1.0
+		if k == '[' {
+			dir = -1
+		}
+		c.nudgeExposure(dir * *exposureStep)
+	}},
+	{Keys: []rune{'z'}, Help: "reset the FPS counter", Camera: func(c *Camera, k rune) {
+		c.FPS.Reset()
+	}},
+}
+
+// lookupKey returns the binding of k, or nil if it isn't bound.
+func lookupKey(k rune) *keyBinding {
+	for i := range keyBindings {
+		for _, bk := range keyBindings[i].Keys {
+			if bk == k {
+				return &keyBindings[i]
+			}
+		}
+	}
+	return nil
+}
+
+// keyName returns how k is shown in the help overlay.
+func keyName(k rune) string {
+	switch k {
+	case '\t':
+		return "Tab"
+	case 3:
+		return "Ctrl+C"
+	}
+	return string(k)
+}
+
+// helpLine is a line of the help overlay: the keys, and what they do.
+type helpLine struct {
+	keys, help string
+}
+
+// UI shows each camera's latest view, in a window each or tiled in one, and
+// handles key presses, passing those bound to cameras to the focused one.
+type UI struct {
+	display *Display
+	cams    []*Camera
+	focus   int
+	// help is set while the help overlay is shown.
+	help bool
+}
+
+// runUI runs the UI until Quit is called or all cameras have stopped, starting
+// fullscreen if set, letterboxed to screen, if it isn't empty. It must run on
+// the main goroutine.
+func runUI(cams []*Camera, names []string, fullscreen bool, screen image.Point) {
+	u := &UI{display: NewDisplay(names, *view == "tile"), cams: cams}
+	defer u.display.Close()
+	u.display.Screen = screen
+	u.display.SetFullscreen(fullscreen)
+	views := make([]gocv.Mat, len(cams))
+	seqs := make([]int, len(cams))
+	for i := range views {
+		views[i] = gocv.NewMat()
+		defer views[i].Close()
+	}
+	for !Quitting() {
+		running, changed := false, false
+		for i, c := range cams {
+			var ok bool
+			if seqs[i], ok = c.View(&views[i], seqs[i]); ok {
+				changed = true
+			}
+			if !c.Stopped() {
+				running = true
+			}
+		}
+		if !running {
+			break
+		}
+		if k := u.display.PollKey(); k >= 0 {
+			u.handleKey(rune(k))
+			// the help overlay may have been shown or hidden
+			changed = true
+		}
+		if changed {
+			var help []helpLine
+			if u.help {
+				help = u.helpLines()
+			}
+			u.display.Show(views, u.focus, help)
+		}
+	}
+}
+
+// handleKey handles a key press, as bound in keyBindings. While the help
+// overlay is shown, any key hides it.
+func (u *UI) handleKey(k rune) {
+	if u.help {
+		u.help = false
+		return
+	}
+	b := lookupKey(k)
+	switch {
+	case b == nil || (b.Multi && len(u.cams) == 1):
+	case b.UI != nil:
+		b.UI(u, k)
+	case b.Camera != nil:
+		u.cams[u.focus].Key(k)
+	}
+}
+
+// helpLines returns the lines of the help overlay: the bindings that do
+// anything, with the focused camera's current settings.
+func (u *UI) helpLines() []helpLine {
+	c := u.cams[u.focus]
+	s := c.LatestState()
+	lines := []helpLine{{"", "Keys (" + c.Name + ")"}}
+	for _, b := range keyBindings {
+		if b.Multi && len(u.cams) == 1 {
+			continue
+		}
+		keys := b.Label
+		if keys == "" {
+			names := make([]string, len(b.Keys))
+			for i, k := range b.Keys {
+				names[i] = keyName(k)
+			}
+			keys = strings.Join(names, " ")
+		}
+		help := b.Help
+		if b.Value != nil {
+			if v := b.Value(&s); v != "" {
+				help += ": " + v
+			}
+		}
+		lines = append(lines, helpLine{keys, help})
+	}
+	return lines
+}
+
+// helpColor is the color of the help overlay's text.
+var helpColor = color.RGBA{255, 255, 255, 0}
+
+// drawHelp draws the help overlay, a darkened panel listing lines, in the
+// top left of the region r of img.
+func drawHelp(img *gocv.Mat, r image.Rectangle, lines []helpLine) {
+	const (
+		lineHeight = 20
+		keysWidth  = 80
+		margin     = 10
+	)
+	panel := image.Rect(0, 0, 460, len(lines)*lineHeight+2*margin).Add(r.Min.Add(image.Pt(margin, margin)))
+	if panel = panel.Intersect(r); panel.Empty() {
+		return
+	}
+	roi := img.Region(panel)
+	gocv.AddWeighted(roi, 0.3, roi, 0, 0, &roi)
+	roi.Close()
+	for i, l := range lines {
+		y := panel.Min.Y + margin + (i+1)*lineHeight - 5
+		gocv.PutText(img, l.keys, image.Pt(panel.Min.X+margin, y), gocv.FontHersheyPlain, 1.1, helpColor, 1)
+		gocv.PutText(img, l.help, image.Pt(panel.Min.X+margin+keysWidth, y), gocv.FontHersheyPlain, 1.1, helpColor, 1)
+	}
+}
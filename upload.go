@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadBackoff is the wait before the first retry of an upload, which
+// doubles with each retry after.
+const uploadBackoff = 2 * time.Second
+
+// DefaultUploadKey is the default template of the keys files are uploaded to.
+const DefaultUploadKey = "{camera}/{date}/{event_id}{ext}"
+
+// pendingUpload is a file waiting to be uploaded, as queued in the journal.
+type pendingUpload struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+}
+
+// UploadTarget is somewhere files can be uploaded to, by key.
+type UploadTarget interface {
+	// Put uploads the named file to key, replacing anything already there.
+	Put(filename, key string) error
+	// URL describes where key is uploaded to, for logs.
+	URL(key string) string
+}
+
+// Uploader uploads saved recordings, with their sidecars, to an UploadTarget
+// in the background, using a durable OutboundQueue, so that uploads that
+// fail, or are interrupted by the program exiting, are retried, with
+// backoff, until they're older than TTL, even across restarts. A nil
+// *Uploader does nothing.
+type Uploader struct {
+	// Target is where files are uploaded to.
+	Target UploadTarget
+	// KeyTemplate names each recording's key, with {camera}, {date}
+	// (YYYY-MM-DD), {event_id}, {name} (its filename without the extension)
+	// and {ext} replaced. Sidecars get the same key with their own suffix.
+	KeyTemplate string
+	// DeleteLocal deletes each file once it's been uploaded.
+	DeleteLocal bool
+	// Journal is the file the queue of uploads is journalled to.
+	Journal string
+	// TTL is how old an upload may get before it's given up on; 0 is never.
+	TTL time.Duration
+	// Pending is the pending list of uploads left by earlier versions, which
+	// is moved to the journal.
+	Pending string
+
+	queue *OutboundQueue
+}
+
+// Start opens the journal, and starts uploading what's left in it, and
+// anything added by Upload, with the given number of concurrent uploads. The
+// fields must be set first.
+func (u *Uploader) Start(concurrency int) error {
+	q, err := OpenOutboundQueue("upload", u.Journal, u.TTL, uploadBackoff, u.upload)
+	if err != nil {
+		return err
+	}
+	u.queue = q
+	data, err := ioutil.ReadFile(u.Pending)
+	if err == nil {
+		var pending []pendingUpload
+		if err := json.Unmarshal(data, &pending); err != nil {
+			return fmt.Errorf("reading pending uploads from %s: %v", u.Pending, err)
+		}
+		for _, p := range pending {
+			q.Add(p.File, p)
+		}
+		if err := os.Remove(u.Pending); err != nil {
+			return err
+		}
+		logInfo("Moved pending uploads to the journal", "uploads", len(pending), "from", u.Pending, "path", u.Journal)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	q.Start(concurrency)
+	return nil
+}
+
+// Upload queues the recording of the given event, saved as clip, to be
+// uploaded, along with its chapters and thumbnail, if they exist. start is
+// when the recording started.
+func (u *Uploader) Upload(clip string, camera string, eventID int, start time.Time) {
+	if u == nil {
+		return
+	}
+	ext := filepath.Ext(clip)
+	key := strings.NewReplacer(
+		"{camera}", camera,
+		"{date}", start.Format("2006-01-02"),
+		"{event_id}", fmt.Sprint(eventID),
+		"{name}", strings.TrimSuffix(filepath.Base(clip), ext),
+		"{ext}", ext,
+	).Replace(u.KeyTemplate)
+	files := []pendingUpload{{clip, key}}
+	for _, sidecar := range []string{ChaptersPath(clip), ThumbnailPath(clip)} {
+		if _, err := os.Stat(sidecar); err == nil {
+			suffix := strings.TrimPrefix(sidecar, strings.TrimSuffix(clip, ext))
+			files = append(files, pendingUpload{sidecar, strings.TrimSuffix(key, ext) + suffix})
+		}
+	}
+	for _, p := range files {
+		u.queue.Add(p.File, p)
+	}
+}
+
+// Close waits for the uploads in progress. Any still queued are left in the
+// journal for the next startup.
+func (u *Uploader) Close() {
+	if u == nil {
+		return
+	}
+	u.queue.Close()
+}
+
+// upload makes a single attempt at an upload, queued as data, deleting the
+// file afterwards if DeleteLocal is set. Files that no longer exist aren't
+// retried.
+func (u *Uploader) upload(desc string, data json.RawMessage) (bool, error) {
+	var p pendingUpload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return false, err
+	}
+	if err := u.Target.Put(p.File, p.Key); err != nil {
+		return !os.IsNotExist(err), err
+	}
+	logInfo("Uploaded", "path", p.File, "url", u.Target.URL(p.Key))
+	if u.DeleteLocal {
+		if err := os.Remove(p.File); err != nil {
+			logError("Deleting uploaded file failed", "path", p.File, "error", err)
+		}
+	}
+	return false, nil
+}
+
+// ParseUploadTarget parses the URL of an upload target, either
+// s3://bucket[/prefix], configured by -s3-*, or
+// sftp://[user[:password]@]host[:port]/dir (or scp://...), configured by
+// -ssh-*.
+func ParseUploadTarget(target string) (UploadTarget, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host or bucket")
+	}
+	switch u.Scheme {
+	case "s3":
+		t := &S3Target{
+			Endpoint:  *s3Endpoint,
+			Bucket:    u.Host,
+			Prefix:    strings.TrimPrefix(u.Path, "/"),
+			Region:    *s3Region,
+			AccessKey: *s3AccessKey,
+			SecretKey: *s3SecretKey,
+		}
+		if t.Prefix != "" && !strings.HasSuffix(t.Prefix, "/") {
+			t.Prefix += "/"
+		}
+		if t.AccessKey == "" {
+			t.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if t.SecretKey == "" {
+			t.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		return t, nil
+	case "sftp", "scp":
+		return NewSSHTarget(u)
+	}
+	return nil, fmt.Errorf("unknown scheme %q, expected s3, sftp or scp", u.Scheme)
+}
@@ -0,0 +1,89 @@
+package main
+
+import "expvar"
+
+// Counters fed by the capture loop. They're only visible through expvar once
+// PublishExpvars is called, but are always safe to update.
+var (
+	detections = new(expvar.Int)
+	bufferFill = new(expvar.Float)
+	reconnects = new(expvar.Int)
+
+	// notifyFailures counts event notifications dropped or given up on, by
+	// notifier.
+	notifyFailures = NewDropCounter()
+	// notifySuppressed counts event notifications suppressed by Governors'
+	// limits, by notifier.
+	notifySuppressed = NewDropCounter()
+	// outboundExpired counts the items OutboundQueues dropped for being older
+	// than their TTL, by queue.
+	outboundExpired = NewDropCounter()
+	// clockSteps counts the times the wall clock stepped, by direction.
+	clockSteps = NewDropCounter()
+)
+
+// PublishExpvars registers the program's counters with expvar under the
+// "motiondetect." prefix. The FPS and stage timings are those of the first
+// of the given cameras, and "motiondetect.cameras" has the FPS of each.
+func PublishExpvars(cams []*Camera) {
+	primary := cams[0]
+	expvar.Publish("motiondetect.fps", expvar.Func(func() interface{} {
+		return primary.FPS.FPS()
+	}))
+	expvar.Publish("motiondetect.fps_instant", expvar.Func(func() interface{} {
+		return primary.FPS.Instant()
+	}))
+	expvar.Publish("motiondetect.frames_total", expvar.Func(func() interface{} {
+		return primary.FPS.TotalFrames()
+	}))
+	expvar.Publish("motiondetect.cameras", expvar.Func(func() interface{} {
+		m := make(map[string]interface{})
+		for _, c := range cams {
+			m[c.Name] = map[string]interface{}{
+				"fps":          c.FPS.FPS(),
+				"frames_total": c.FPS.TotalFrames(),
+			}
+		}
+		return m
+	}))
+	expvar.Publish("motiondetect.detections", detections)
+	expvar.Publish("motiondetect.buffer_fill", bufferFill)
+	expvar.Publish("motiondetect.reconnects", reconnects)
+	expvar.Publish("motiondetect.drops", expvar.Func(func() interface{} {
+		return drops.Drops()
+	}))
+	expvar.Publish("motiondetect.notifications_failed", expvar.Func(func() interface{} {
+		return notifyFailures.Drops()
+	}))
+	expvar.Publish("motiondetect.notifications_suppressed", expvar.Func(func() interface{} {
+		return notifySuppressed.Drops()
+	}))
+	expvar.Publish("motiondetect.outbound_queues", expvar.Func(func() interface{} {
+		names, depths, ages := outboundStats()
+		m := make(map[string]interface{})
+		for _, name := range names {
+			m[name] = map[string]interface{}{
+				"depth":              depths[name],
+				"oldest_age_seconds": ages[name].Seconds(),
+				"expired":            outboundExpired.Drops()[name],
+			}
+		}
+		return m
+	}))
+	expvar.Publish("motiondetect.clock", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"status": hostClock.Status(),
+			"steps":  clockSteps.Drops(),
+		}
+	}))
+	expvar.Publish("motiondetect.frame_histogram", expvar.Func(func() interface{} {
+		return frameTimer.Histogram()
+	}))
+	expvar.Publish("motiondetect.stage_ms", expvar.Func(func() interface{} {
+		ms := make(map[string]float64)
+		for name, avg := range primary.Stages.Averages() {
+			ms[name] = avg.Seconds() * 1000
+		}
+		return ms
+	}))
+}
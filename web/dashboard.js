@@ -0,0 +1,144 @@
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+const fields = ['threshold', 'min_area', 'dilate_size'];
+const statuses = {};
+let camera = '';
+
+function configURL() {
+  return '/api/config' + (camera ? '?camera=' + encodeURIComponent(camera) : '');
+}
+
+function showConfig(cfg) {
+  for (const f of fields) {
+    if (cfg[f] !== undefined) {
+      $(f).value = cfg[f];
+      document.querySelector('output[for=' + f + ']').textContent = cfg[f];
+    }
+  }
+  $('detection_enabled').checked = !!cfg.detection_enabled;
+}
+
+async function loadConfig() {
+  const resp = await fetch(configURL());
+  if (resp.ok) {
+    showConfig(await resp.json());
+  }
+}
+
+async function patchConfig(change) {
+  $('settings-error').textContent = '';
+  const resp = await fetch(configURL(), {
+    method: 'PATCH',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify(change),
+  });
+  const body = await resp.json();
+  if (!resp.ok) {
+    $('settings-error').textContent = body.error ||
+      Object.entries(body.errors || {}).map(([k, v]) => k + ' ' + v).join(', ');
+    loadConfig();
+    return;
+  }
+  showConfig(body);
+}
+
+for (const f of fields) {
+  const input = $(f);
+  input.addEventListener('input', () => {
+    document.querySelector('output[for=' + f + ']').textContent = input.value;
+  });
+  input.addEventListener('change', () => patchConfig({[f]: Number(input.value)}));
+}
+$('detection_enabled').addEventListener('change', (e) => patchConfig({detection_enabled: e.target.checked}));
+$('camera').addEventListener('change', (e) => {
+  camera = e.target.value;
+  loadConfig();
+});
+
+function showStatus() {
+  const names = Object.keys(statuses).sort();
+  const select = $('camera');
+  if (select.options.length !== names.length) {
+    select.innerHTML = '';
+    for (const name of names) {
+      select.add(new Option(name, name, false, name === camera));
+    }
+  }
+  $('camera-row').hidden = names.length < 2;
+  $('status').innerHTML = '';
+  for (const name of names) {
+    const s = statuses[name];
+    const line = document.createElement('div');
+    line.textContent = name + ': ' + s.fps.toFixed(1) + ' fps, score ' + Math.round(s.score) +
+      (s.motion ? ', motion' : '') + (s.armed === false ? ' (disarmed)' : '');
+    line.className = s.motion ? 'motion' : '';
+    $('status').appendChild(line);
+  }
+}
+
+function duration(ev) {
+  const secs = Math.round(ev.duration_seconds);
+  return secs < 60 ? secs + 's' : Math.floor(secs / 60) + 'm' + (secs % 60) + 's';
+}
+
+async function loadEvents() {
+  const resp = await fetch('/api/events');
+  if (!resp.ok) {
+    return;
+  }
+  const list = $('event-list');
+  list.innerHTML = '';
+  for (const ev of await resp.json()) {
+    const li = document.createElement('li');
+    const image = ev.thumbnail_url || ev.snapshot_url;
+    let media = document.createElement(image ? 'img' : 'div');
+    if (image) {
+      media.src = image;
+      media.alt = 'event ' + ev.id;
+      media.loading = 'lazy';
+    }
+    if (ev.clip_url && ev.end) {
+      const a = document.createElement('a');
+      a.href = ev.clip_url;
+      a.appendChild(media);
+      media = a;
+    }
+    li.appendChild(media);
+    const info = document.createElement('div');
+    info.className = 'info';
+    info.textContent = ev.camera + ' ' + new Date(ev.start).toLocaleString() + ', ' +
+      (ev.end ? duration(ev) : 'in progress') + ', area ' + Math.round(ev.peak_area);
+    li.appendChild(info);
+    list.appendChild(li);
+  }
+}
+
+function connect() {
+  const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/api/events/ws');
+  ws.onopen = () => {
+    $('connection').textContent = 'connected';
+    $('connection').className = 'badge ok';
+  };
+  ws.onmessage = (msg) => {
+    const m = JSON.parse(msg.data);
+    if (m.type === 'status') {
+      statuses[m.status.camera] = m.status;
+      if (!camera) {
+        camera = m.status.camera;
+      }
+      showStatus();
+    } else if (m.type === 'event') {
+      loadEvents();
+    }
+  };
+  ws.onclose = () => {
+    $('connection').textContent = 'disconnected';
+    $('connection').className = 'badge';
+    setTimeout(connect, 2000);
+  };
+}
+
+loadConfig();
+loadEvents();
+connect();
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// webhookAttempts is how many times posting an event is attempted.
+	webhookAttempts = 4
+	// webhookSignature is the header signing the body of each request.
+	webhookSignature = "X-Motiondetect-Signature"
+)
+
+// Webhook posts events to a URL as JSON EventPayloads, in the background,
+// using a NotifyQueue, or, once Persist is called, a durable OutboundQueue. A
+// nil *Webhook does nothing.
+type Webhook struct {
+	// URL is where events are posted.
+	URL string
+	// Secret, if set, is used to sign the body of each request with
+	// HMAC-SHA256, sent hex-encoded in the X-Motiondetect-Signature header as
+	// "sha256=<signature>".
+	Secret string
+	// OnEnd also posts events when they end, not just when they start.
+	OnEnd bool
+
+	client   *http.Client
+	queue    *NotifyQueue
+	outbound *OutboundQueue
+}
+
+// NewWebhook creates a Webhook posting to url, with each attempt timing out
+// after timeout.
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Secret: secret,
+		client: &http.Client{Timeout: timeout},
+		queue:  NewNotifyQueue("webhook", webhookAttempts),
+	}
+}
+
+// Persist makes the Webhook queue events in a journal at path, retrying each
+// with backoff, across restarts, until it's older than ttl, rather than
+// giving up after webhookAttempts.
+func (w *Webhook) Persist(path string, ttl time.Duration) error {
+	q, err := OpenOutboundQueue("webhook", path, ttl, notifyBackoff, func(desc string, data json.RawMessage) (bool, error) {
+		return w.post(data)
+	})
+	if err != nil {
+		return err
+	}
+	q.Drain = true
+	q.Start(1)
+	w.outbound = q
+	return nil
+}
+
+// Notify queues the event, in the given phase, to be posted. It never blocks.
+func (w *Webhook) Notify(ev *MotionEvent, phase string) {
+	if w == nil || (phase == PhaseEnd && !w.OnEnd) {
+		return
+	}
+	p := ev.Payload(phase)
+	body, err := json.Marshal(p)
+	if err != nil {
+		logError("Encoding event for webhook failed", "camera", p.Camera, "event_id", p.ID, "error", err)
+		return
+	}
+	w.send(fmt.Sprintf("event %d", p.ID), body)
+}
+
+// DigestPayload is the JSON body posted for a Digest, with Phase "digest".
+type DigestPayload struct {
+	Phase   string `json:"phase"`
+	Summary string `json:"summary"`
+	*Digest
+}
+
+// NotifyDigest queues d to be posted. It never blocks.
+func (w *Webhook) NotifyDigest(d *Digest) {
+	if w == nil {
+		return
+	}
+	body, err := json.Marshal(DigestPayload{"digest", d.Summary(), d})
+	if err != nil {
+		logError("Encoding digest for webhook failed", "error", err)
+		return
+	}
+	w.send("digest", body)
+}
+
+// send queues body to be posted.
+func (w *Webhook) send(desc string, body []byte) {
+	if w.outbound != nil {
+		w.outbound.Add(desc, json.RawMessage(body))
+		return
+	}
+	w.queue.Send(desc, func() (bool, error) {
+		return w.post(body)
+	})
+}
+
+// Close waits for queued events to be posted.
+func (w *Webhook) Close() {
+	if w == nil {
+		return
+	}
+	w.outbound.Close()
+	w.queue.Close()
+}
+
+// post posts body once, returning whether it's worth retrying if it fails.
+// Only client errors aren't.
+func (w *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignature, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode/100 != 4, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// webrtcQueue is the number of frames that may be waiting for ffmpeg
+	// before frames start being dropped.
+	webrtcQueue = 2
+	// webrtcViewerQueue is the number of frames that may be waiting to be
+	// sent to a viewer before frames start being dropped for it.
+	webrtcViewerQueue = 30
+	// webrtcIdleTimeout is how long ffmpeg is kept running without frames
+	// after the last viewer disconnects.
+	webrtcIdleTimeout = 2 * time.Second
+	// webrtcMaxBackoff is the longest wait before restarting ffmpeg after it
+	// has exited.
+	webrtcMaxBackoff = 30 * time.Second
+	// webrtcMaxOffer is the largest SDP offer accepted.
+	webrtcMaxOffer = 64 << 10
+)
+
+// errWebRTCUnavailable is returned when the binary was built without WebRTC
+// support, which takes the webrtc build tag.
+var errWebRTCUnavailable = errors.New("WebRTC support not built in: rebuild with -tags webrtc")
+
+// h264Frame is an encoded frame: an H.264 access unit in Annex B format.
+type h264Frame struct {
+	Data     []byte
+	Keyframe bool
+	Duration time.Duration
+}
+
+// h264Viewer is a subscriber to an H264Encoder's frames.
+type h264Viewer struct {
+	frames chan h264Frame
+	// started is set once the viewer has been sent a keyframe, before which
+	// it's sent nothing, as it couldn't decode anything
+	started bool
+}
+
+// H264Encoder encodes the live view to H.264 for WebRTC viewers, with an
+// ffmpeg process tuned for latency, shared by all the viewers. ffmpeg is only
+// run while anyone is viewing, and is restarted if it exits unexpectedly.
+type H264Encoder struct {
+	FPS float64
+
+	frames chan hlsFrame
+	done   chan struct{}
+
+	mu      sync.Mutex
+	viewers map[*h264Viewer]bool
+}
+
+// NewH264Encoder creates an H264Encoder and starts it in the background.
+func NewH264Encoder(fps float64) (*H264Encoder, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	e := &H264Encoder{
+		FPS:     fps,
+		frames:  make(chan hlsFrame, webrtcQueue),
+		done:    make(chan struct{}),
+		viewers: make(map[*h264Viewer]bool),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Subscribe adds a viewer, returning the channel its frames are sent to,
+// starting with a keyframe, and a function removing it, after which the
+// channel is closed. ffmpeg is stopped shortly after the last viewer is
+// removed.
+func (e *H264Encoder) Subscribe() (<-chan h264Frame, func()) {
+	v := &h264Viewer{frames: make(chan h264Frame, webrtcViewerQueue)}
+	e.mu.Lock()
+	e.viewers[v] = true
+	e.mu.Unlock()
+	var once sync.Once
+	return v.frames, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if e.viewers[v] {
+				delete(e.viewers, v)
+				close(v.frames)
+			}
+		})
+	}
+}
+
+// Viewers returns the number of viewers.
+func (e *H264Encoder) Viewers() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.viewers)
+}
+
+// Write queues a copy of the given 8-bit BGR frame to be encoded. If nobody
+// is viewing, it does nothing, and if the queue is full, the frame is
+// dropped.
+func (e *H264Encoder) Write(img gocv.Mat) {
+	if img.Type() != gocv.MatTypeCV8UC3 || e.Viewers() == 0 {
+		return
+	}
+	f := hlsFrame{img.ToBytes(), img.Cols(), img.Rows()}
+	select {
+	case e.frames <- f:
+	default:
+		drops.Drop("webrtc")
+	}
+}
+
+// Close stops the encoder, and disconnects all viewers.
+func (e *H264Encoder) Close() {
+	close(e.frames)
+	<-e.done
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for v := range e.viewers {
+		delete(e.viewers, v)
+		close(v.frames)
+	}
+}
+
+func (e *H264Encoder) run() {
+	defer close(e.done)
+
+	backoff := time.Second
+	for {
+		first, ok := <-e.frames
+		if !ok {
+			return
+		}
+		started := time.Now()
+		err := e.encode(first)
+		if err == nil {
+			return
+		}
+		if err == errRTSPIdle {
+			// wait for the next viewer
+			backoff = time.Second
+			continue
+		}
+		if time.Since(started) > webrtcMaxBackoff {
+			backoff = time.Second
+		}
+		logError("WebRTC encoder failed, restarting", "retry_in", backoff, "error", err)
+
+		// keep draining frames while waiting, so that the queue doesn't hold
+		// on to stale ones
+		timer := time.NewTimer(backoff)
+	wait:
+		for {
+			select {
+			case _, ok := <-e.frames:
+				if !ok {
+					timer.Stop()
+					return
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		if backoff *= 2; backoff > webrtcMaxBackoff {
+			backoff = webrtcMaxBackoff
+		}
+	}
+}
+
+// encode runs ffmpeg, starting with the given frame, until it fails, nobody
+// is viewing, in which case errRTSPIdle is returned, or the frames channel is
+// closed, in which case nil is returned. Frames that don't match the
+// dimensions of the first are skipped.
+func (e *H264Encoder) encode(first hlsFrame) error {
+	// a keyframe every second, so that new viewers don't wait long
+	gop := int(e.FPS)
+	if gop < 1 {
+		gop = 1
+	}
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "bgr24",
+		"-s", fmt.Sprintf("%dx%d", first.width, first.height),
+		"-r", strconv.FormatFloat(e.FPS, 'f', 2, 64),
+		"-i", "-",
+		// constrained baseline, which every browser can decode, without
+		// B-frames or lookahead, so that each frame is output as soon as it's
+		// encoded
+		"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+		"-profile:v", "baseline", "-level", "3.1",
+		"-g", strconv.Itoa(gop),
+		"-x264-params", "repeat-headers=1:aud=1",
+		"-f", "h264", "-",
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		// Wait closes stdout, so it must all be read first
+		e.distribute(stdout)
+		exited <- cmd.Wait()
+	}()
+	stop := func() {
+		stdin.Close()
+		select {
+		case <-exited:
+		case <-time.After(hlsStopTimeout):
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	idle := time.NewTimer(webrtcIdleTimeout)
+	defer idle.Stop()
+	f := first
+	for {
+		if f.width == first.width && f.height == first.height {
+			if _, err := stdin.Write(f.data); err != nil {
+				cmd.Process.Kill()
+				return fmt.Errorf("ffmpeg: %v", waitErr(<-exited, err))
+			}
+		}
+		var ok bool
+		select {
+		case f, ok = <-e.frames:
+			if !ok {
+				stop()
+				return nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(webrtcIdleTimeout)
+		case <-idle.C:
+			// Write stops queueing frames once nobody is viewing
+			stop()
+			return errRTSPIdle
+		case err := <-exited:
+			return fmt.Errorf("ffmpeg: %v", waitErr(err, io.ErrUnexpectedEOF))
+		}
+	}
+}
+
+// distribute reads an H.264 Annex B stream from r, sending each access unit
+// to the viewers as it's completed, until r is exhausted.
+func (e *H264Encoder) distribute(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 8*1024*1024)
+	sc.Split(splitNALUnits)
+	var (
+		au       []byte
+		keyframe bool
+		last     time.Time
+	)
+	send := func() {
+		if len(au) == 0 {
+			return
+		}
+		now := time.Now()
+		d := time.Duration(float64(time.Second) / e.FPS)
+		if !last.IsZero() {
+			d = now.Sub(last)
+		}
+		last = now
+		e.send(h264Frame{Data: au, Keyframe: keyframe, Duration: d})
+	}
+	for sc.Scan() {
+		nal := sc.Bytes()
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1f {
+		case 9:
+			// an access unit delimiter starts each frame
+			send()
+			au, keyframe = nil, false
+			continue
+		case 5:
+			keyframe = true
+		}
+		au = append(au, 0, 0, 0, 1)
+		au = append(au, nal...)
+	}
+	send()
+	// read whatever's left, so that ffmpeg isn't blocked writing it
+	io.Copy(ioutil.Discard, r)
+}
+
+// send queues a frame to be sent to each viewer, starting with a keyframe,
+// dropping it for viewers that are too far behind.
+func (e *H264Encoder) send(f h264Frame) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for v := range e.viewers {
+		if !v.started && !f.Keyframe {
+			continue
+		}
+		v.started = true
+		select {
+		case v.frames <- f:
+		default:
+			drops.Drop("webrtc")
+		}
+	}
+}
+
+// webrtcSDP is a session description, as exchanged with the browser.
+type webrtcSDP struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// HandleWebRTC registers the WebRTC signaling endpoint, /api/webrtc, with
+// http.DefaultServeMux. Viewers POST an SDP offer, receiving video only, and
+// get an answer with all its ICE candidates, so there's no need for trickle
+// ICE. iceServers are the STUN or TURN servers used to find candidates, if
+// the viewers aren't on the same network.
+func HandleWebRTC(enc *H264Encoder, iceServers []string) {
+	http.HandleFunc("/api/webrtc", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var offer webrtcSDP
+		if err := json.NewDecoder(io.LimitReader(r.Body, webrtcMaxOffer)).Decode(&offer); err != nil || offer.Type != "offer" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected an SDP offer"})
+			return
+		}
+		answer, err := newWebRTCPeer(offer, enc, iceServers)
+		switch {
+		case err == errWebRTCUnavailable:
+			writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		case err != nil:
+			logError("WebRTC negotiation failed", "client", r.RemoteAddr, "error", err)
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			logInfo("WebRTC viewer connecting", "client", r.RemoteAddr)
+			writeJSON(w, http.StatusOK, answer)
+		}
+	})
+}
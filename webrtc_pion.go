@@ -0,0 +1,88 @@
+//go:build webrtc
+// +build webrtc
+
+package main
+
+import (
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// webrtcAvailable is whether the binary was built with WebRTC support.
+const webrtcAvailable = true
+
+// newWebRTCPeer answers a viewer's offer with a peer connection sending the
+// encoder's frames, until the connection fails or is closed.
+func newWebRTCPeer(offer webrtcSDP, enc *H264Encoder, iceServers []string) (*webrtcSDP, error) {
+	config := webrtc.Configuration{}
+	if len(iceServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: iceServers}}
+	}
+	pc, err := webrtc.NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	}, "video", "motiondetect")
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	// RTCP must be read for interceptors, such as NACK, to work
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	frames, unsubscribe := enc.Subscribe()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed, webrtc.PeerConnectionStateDisconnected:
+			unsubscribe()
+			pc.Close()
+		}
+	})
+	go func() {
+		for f := range frames {
+			if err := track.WriteSample(media.Sample{Data: f.Data, Duration: f.Duration}); err != nil {
+				logError("Sending WebRTC frame failed", "error", err)
+				break
+			}
+		}
+		// the encoder was closed, or sending failed
+		unsubscribe()
+		pc.Close()
+	}()
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		unsubscribe()
+		pc.Close()
+		return nil, err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		unsubscribe()
+		pc.Close()
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		unsubscribe()
+		pc.Close()
+		return nil, err
+	}
+	<-gathered
+	return &webrtcSDP{Type: "answer", SDP: pc.LocalDescription().SDP}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !webrtc
+// +build !webrtc
+
+package main
+
+// webrtcAvailable is whether the binary was built with WebRTC support.
+const webrtcAvailable = false
+
+// newWebRTCPeer fails, as the binary was built without WebRTC support.
+func newWebRTCPeer(offer webrtcSDP, enc *H264Encoder, iceServers []string) (*webrtcSDP, error) {
+	return nil, errWebRTCUnavailable
+}